@@ -29,7 +29,7 @@ func main() {
 	authUserStore := NewInMemoryUserStore()
 	sessionStore := NewInMemorySessionStore()
 	// Initialize auth service
-	authService := lucia.NewAuthService[*User](authUserStore, sessionStore)
+	authService := lucia.NewAuthService[*User, string](authUserStore, sessionStore)
 
 	// Initialize Google OAuth provider
 	googleProvider := lucia.NewGoogleProvider(
@@ -43,8 +43,18 @@ func main() {
 	)
 	authService.RegisterProvider("google", googleProvider)
 
-	// Initialize auth middleware
-	authMiddleware := lucia.NewAuthMiddleware(authService)
+	// Initialize auth middleware, an expired session is treated as none and RequireAuth sends
+	// browsers without a session to the login page
+	authMiddleware := lucia.NewAuthMiddleware(authService,
+		lucia.WithInvalidSession(lucia.IgnoreInvalidSession),
+		lucia.WithLoginURL("/login/google"),
+	)
+
+	// Login, callback and logout routes, the Google redirect URI is /login/google/callback
+	authHandlers := lucia.NewAuthHandlers(authService, lucia.HandlersConfig{
+		SuccessURL:     "/api/profile",
+		AuthURLOptions: []lucia.AuthURLOption{lucia.WithOfflineAccess()},
+	})
 
 	app := fiber.New(fiber.Config{
 		ErrorHandler: errors.ErrorHandler,
@@ -57,13 +67,14 @@ func main() {
 
 	// Apply session middleware to all routes
 	app.Use(authMiddleware.SessionMiddleware())
+	authHandlers.Mount(app)
 
 	// Protected routes
 	api := app.Group("/api")
 	api.Use(authMiddleware.RequireAuth())
 
 	api.Get("/profile", func(c *fiber.Ctx) error {
-		session := lucia.GetSession(c)
+		session := lucia.GetSession[string](c)
 		return c.JSON(fiber.Map{
 			"message":    "Protected route",
 			"user_id":    session.UserID,
@@ -73,12 +84,8 @@ func main() {
 
 	// New route to get user info
 	api.Get("/user", func(c *fiber.Ctx) error {
-		session := lucia.GetSession(c)
-		userId, err := session.UserIDToString()
-		if err != nil {
-			return err
-		}
-		user, err := authUserStore.GetUserByID(c.Context(), userId)
+		session := lucia.GetSession[string](c)
+		user, err := authUserStore.GetUserByID(c.Context(), session.UserID)
 		if err != nil {
 			return err
 		}
@@ -90,53 +97,6 @@ func main() {
 		})
 	})
 
-	// Google OAuth routes
-	app.Get("/login/google", func(c *fiber.Ctx) error {
-		authURL, state, err := authService.GetAuthURL("google")
-		if err != nil {
-			return err
-		}
-		c.Cookie(&fiber.Cookie{
-			Name:     "oauth_state",
-			Value:    state,
-			HTTPOnly: true,
-			Secure:   true,
-		})
-		return c.Redirect(authURL)
-	})
-
-	app.Get("/login/google/callback", func(c *fiber.Ctx) error {
-		state := c.Cookies("oauth_state")
-		if state == "" || state != c.Query("state") {
-			return errors.ErrUnauthorized("Invalid state")
-		}
-
-		code := c.Query("code")
-		if code == "" {
-			return errors.ErrBadRequest("Missing code")
-		}
-
-		session, err := authService.HandleCallback(c.Context(), "google", code)
-		if err != nil {
-			return err
-		}
-
-		lucia.SetSessionCookie(c, session)
-		return c.Redirect("/api/profile")
-	})
-
-	// Logout route
-	app.Post("/logout", func(c *fiber.Ctx) error {
-		session := lucia.GetSession(c)
-		if session != nil {
-			if err := authService.DeleteSession(c.Context(), session.ID); err != nil {
-				return err
-			}
-		}
-		lucia.ClearSessionCookie(c)
-		return c.SendString("Logged out successfully")
-	})
-
 	app.Listen(":3000")
 }
 
@@ -199,17 +159,17 @@ func (s *InMemoryUserStore) GetUserByID(ctx context.Context, userID string) (*Us
 
 // InMemorySessionStore is a simple in-memory implementation of SessionStore for testing
 type InMemorySessionStore struct {
-	sessions map[string]*lucia.Session
+	sessions map[string]*lucia.Session[string]
 	mu       sync.RWMutex
 }
 
 func NewInMemorySessionStore() *InMemorySessionStore {
 	return &InMemorySessionStore{
-		sessions: make(map[string]*lucia.Session),
+		sessions: make(map[string]*lucia.Session[string]),
 	}
 }
 
-func (s *InMemorySessionStore) CreateSession(ctx context.Context, session *lucia.Session) error {
+func (s *InMemorySessionStore) CreateSession(ctx context.Context, session *lucia.Session[string]) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -221,7 +181,7 @@ func (s *InMemorySessionStore) CreateSession(ctx context.Context, session *lucia
 	return nil
 }
 
-func (s *InMemorySessionStore) GetSession(ctx context.Context, sessionID string) (*lucia.Session, error) {
+func (s *InMemorySessionStore) GetSession(ctx context.Context, sessionID string) (*lucia.Session[string], error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -240,6 +200,18 @@ func (s *InMemorySessionStore) GetSession(ctx context.Context, sessionID string)
 	return session, nil
 }
 
+func (s *InMemorySessionStore) UpdateSession(ctx context.Context, session *lucia.Session[string]) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.sessions[session.ID]; !exists {
+		return errors.ErrNotFound("Session not found")
+	}
+
+	s.sessions[session.ID] = session
+	return nil
+}
+
 func (s *InMemorySessionStore) DeleteSession(ctx context.Context, sessionID string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
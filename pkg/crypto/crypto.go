@@ -0,0 +1,323 @@
+// Package crypto encrypts secrets at rest with AES-256-GCM envelope encryption.
+// Every value is sealed with its own random data key, which is in turn sealed with a key of a Keyring and
+// stored next to it with that key's ID, so keys can be rotated without decrypting the data itself.
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+)
+
+const (
+	// KeySize is the size of keyring keys, AES-256
+	KeySize = 32
+
+	formatVersion  byte = 1
+	nonceSize           = 12
+	tagSize             = 16
+	wrappedKeySize      = nonceSize + KeySize + tagSize
+)
+
+// Keyring holds the keys data keys are sealed with. The primary key seals new values, the others only open
+// values sealed before a rotation.
+type Keyring struct {
+	mu      sync.RWMutex
+	keys    map[string]cipher.AEAD
+	primary string
+}
+
+// NewKeyring creates a keyring from 32 byte keys by ID, primaryID must be one of them
+func NewKeyring(primaryID string, keys map[string][]byte) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+	for id, key := range keys {
+		if err := k.AddKey(id, key); err != nil {
+			return nil, err
+		}
+	}
+	if err := k.SetPrimary(primaryID); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// ParseKeyring loads a keyring from configuration of the form "id:base64key,id:base64key".
+// The first key is the primary one, so rotating means prepending a new key and keeping the old ones
+// until Rewrap has moved every value over.
+func ParseKeyring(config string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+	for i, entry := range strings.Split(config, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok {
+			return nil, errors.NewLuciaError("ConfigurationError", "Keyring entries must be id:base64key")
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.NewLuciaError("ConfigurationError", "Keyring key "+id+" is not valid base64").WithCause(err)
+		}
+		if err := k.AddKey(id, key); err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			k.primary = id
+		}
+	}
+	return k, nil
+}
+
+// KeyringFromEnv loads a keyring from the environment variable name, see ParseKeyring
+func KeyringFromEnv(name string) (*Keyring, error) {
+	config := os.Getenv(name)
+	if config == "" {
+		return nil, errors.NewLuciaError("ConfigurationError", "Environment variable "+name+" is not set")
+	}
+	return ParseKeyring(config)
+}
+
+// GenerateKey returns a random key for a keyring, base64 encoded as ParseKeyring expects
+func GenerateKey() (string, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", errors.NewLuciaError("EncryptionError", "Failed to generate key").WithCause(err)
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// AddKey adds a key, e.g. the next primary key before switching to it with SetPrimary.
+// An ID can only be added once, replacing its key would make the values it sealed unreadable.
+func (k *Keyring) AddKey(id string, key []byte) error {
+	if id == "" || len(id) > 255 || strings.ContainsAny(id, ":,") {
+		return errors.NewLuciaError("ConfigurationError", "Key IDs must be 1 to 255 bytes without ':' or ','")
+	}
+	if len(key) != KeySize {
+		return errors.NewLuciaError("ConfigurationError", "Keyring key "+id+" must be 32 bytes")
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.keys[id]; ok {
+		return errors.NewLuciaError("ConfigurationError", "Keyring key "+id+" is defined more than once")
+	}
+	k.keys[id] = aead
+	return nil
+}
+
+// SetPrimary makes the key id seal new values
+func (k *Keyring) SetPrimary(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.keys[id]; !ok {
+		return errors.NewLuciaError("ConfigurationError", "Unknown primary key "+id)
+	}
+	k.primary = id
+	return nil
+}
+
+// PrimaryKeyID returns the ID of the key sealing new values
+func (k *Keyring) PrimaryKeyID() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.primary
+}
+
+// AssociatedData encodes the context of a value, e.g. its table, column and row ID, for Encrypt.
+// Every part is length prefixed, so ("ab", "c") and ("a", "bc") differ.
+func AssociatedData(parts ...string) []byte {
+	var out []byte
+	for _, part := range parts {
+		out = binary.BigEndian.AppendUint32(out, uint32(len(part)))
+		out = append(out, part...)
+	}
+	return out
+}
+
+// Encrypt seals plaintext under a new data key. associatedData is authenticated but not encrypted, it binds
+// the ciphertext to its context (e.g. a table, column and row ID) and must be passed again to Decrypt.
+func (k *Keyring) Encrypt(plaintext, associatedData []byte) ([]byte, error) {
+	k.mu.RLock()
+	keyID, kek := k.primary, k.keys[k.primary]
+	k.mu.RUnlock()
+
+	dataKey := make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, errors.NewLuciaError("EncryptionError", "Failed to generate data key").WithCause(err)
+	}
+	header := encodeHeader(keyID)
+	wrappedKey, err := seal(kek, dataKey, header)
+	if err != nil {
+		return nil, err
+	}
+	dek, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	data, err := seal(dek, plaintext, associatedData)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(header)+len(wrappedKey)+len(data))
+	out = append(out, header...)
+	out = append(out, wrappedKey...)
+	return append(out, data...), nil
+}
+
+// Decrypt opens a value sealed by Encrypt with any key of the keyring
+func (k *Keyring) Decrypt(ciphertext, associatedData []byte) ([]byte, error) {
+	keyID, header, wrappedKey, data, err := splitCiphertext(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	dek, err := k.openDataKey(keyID, header, wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := open(dek, data, associatedData)
+	if err != nil {
+		return nil, err
+	}
+	return plaintext, nil
+}
+
+// KeyID returns the ID of the key a value was sealed with
+func KeyID(ciphertext []byte) (string, error) {
+	keyID, _, _, _, err := splitCiphertext(ciphertext)
+	return keyID, err
+}
+
+// NeedsRewrap reports whether a value was sealed with another key than the primary one
+func (k *Keyring) NeedsRewrap(ciphertext []byte) bool {
+	keyID, err := KeyID(ciphertext)
+	return err == nil && keyID != k.PrimaryKeyID()
+}
+
+// Rewrap seals the data key of a value with the primary key, the data itself is left untouched.
+// Run it over stored values after a rotation, then drop the old key once none needs it anymore.
+func (k *Keyring) Rewrap(ciphertext []byte) ([]byte, error) {
+	keyID, header, wrappedKey, data, err := splitCiphertext(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	k.mu.RLock()
+	primaryID, primary := k.primary, k.keys[k.primary]
+	kek, ok := k.keys[keyID]
+	k.mu.RUnlock()
+	if keyID == primaryID {
+		return ciphertext, nil
+	}
+	if !ok {
+		return nil, errors.NewLuciaError("DecryptionError", "Unknown encryption key "+keyID)
+	}
+	dataKey, err := open(kek, wrappedKey, header)
+	if err != nil {
+		return nil, err
+	}
+
+	newHeader := encodeHeader(primaryID)
+	newWrappedKey, err := seal(primary, dataKey, newHeader)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(newHeader)+len(newWrappedKey)+len(data))
+	out = append(out, newHeader...)
+	out = append(out, newWrappedKey...)
+	return append(out, data...), nil
+}
+
+// EncryptString seals a string and encodes it as base64, for text columns and configuration.
+// associatedData is passed to Encrypt.
+func (k *Keyring) EncryptString(plaintext string, associatedData []byte) (string, error) {
+	ciphertext, err := k.Encrypt([]byte(plaintext), associatedData)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// DecryptString opens a value sealed by EncryptString with the same associatedData
+func (k *Keyring) DecryptString(encoded string, associatedData []byte) (string, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", errors.NewLuciaError("DecryptionError", "Encrypted value is not valid base64").WithCause(err)
+	}
+	plaintext, err := k.Decrypt(ciphertext, associatedData)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func (k *Keyring) openDataKey(keyID string, header, wrappedKey []byte) (cipher.AEAD, error) {
+	k.mu.RLock()
+	kek, ok := k.keys[keyID]
+	k.mu.RUnlock()
+	if !ok {
+		return nil, errors.NewLuciaError("DecryptionError", "Unknown encryption key "+keyID)
+	}
+	dataKey, err := open(kek, wrappedKey, header)
+	if err != nil {
+		return nil, err
+	}
+	return newAEAD(dataKey)
+}
+
+// encodeHeader returns the version and key ID prefix of a ciphertext, it is authenticated with the data key
+func encodeHeader(keyID string) []byte {
+	header := make([]byte, 0, 2+len(keyID))
+	header = append(header, formatVersion, byte(len(keyID)))
+	return append(header, keyID...)
+}
+
+// splitCiphertext parses version | key ID length | key ID | wrapped data key | data nonce and ciphertext
+func splitCiphertext(ciphertext []byte) (keyID string, header, wrappedKey, data []byte, err error) {
+	if len(ciphertext) < 2 || ciphertext[0] != formatVersion {
+		return "", nil, nil, nil, errors.NewLuciaError("DecryptionError", "Unsupported ciphertext format")
+	}
+	headerSize := 2 + int(ciphertext[1])
+	if len(ciphertext) < headerSize+wrappedKeySize+nonceSize+tagSize {
+		return "", nil, nil, nil, errors.NewLuciaError("DecryptionError", "Ciphertext is truncated")
+	}
+	header = ciphertext[:headerSize]
+	wrappedKey = ciphertext[headerSize : headerSize+wrappedKeySize]
+	data = ciphertext[headerSize+wrappedKeySize:]
+	return string(header[2:]), header, wrappedKey, data, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.NewLuciaError("EncryptionError", "Invalid AES key").WithCause(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.NewLuciaError("EncryptionError", "Failed to initialize AES-GCM").WithCause(err)
+	}
+	return aead, nil
+}
+
+// seal encrypts plaintext under a random nonce, which is prepended to the result
+func seal(aead cipher.AEAD, plaintext, associatedData []byte) ([]byte, error) {
+	nonce := make([]byte, nonceSize, nonceSize+len(plaintext)+tagSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.NewLuciaError("EncryptionError", "Failed to generate nonce").WithCause(err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, associatedData), nil
+}
+
+func open(aead cipher.AEAD, sealed, associatedData []byte) ([]byte, error) {
+	plaintext, err := aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], associatedData)
+	if err != nil {
+		return nil, errors.NewLuciaError("DecryptionError", "Failed to decrypt value").WithCause(err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,217 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+func newTestKeyring(t *testing.T, ids ...string) *Keyring {
+	t.Helper()
+	config := ""
+	for i, id := range ids {
+		key, err := GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 {
+			config += ","
+		}
+		config += id + ":" + key
+	}
+	k, err := ParseKeyring(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	k := newTestKeyring(t, "k1")
+	ad := AssociatedData("auth_provider_tokens", "access_token", "user-1", "github")
+	ciphertext, err := k.Encrypt([]byte("secret"), ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flip := func(i int) []byte {
+		tampered := bytes.Clone(ciphertext)
+		tampered[i] ^= 1
+		return tampered
+	}
+	tests := []struct {
+		name       string
+		ciphertext []byte
+		ad         []byte
+		wantErr    bool
+	}{
+		{name: "same associated data", ciphertext: ciphertext, ad: ad},
+		{name: "other row", ciphertext: ciphertext, ad: AssociatedData("auth_provider_tokens", "access_token", "user-2", "github"), wantErr: true},
+		{name: "other column", ciphertext: ciphertext, ad: AssociatedData("auth_provider_tokens", "refresh_token", "user-1", "github"), wantErr: true},
+		{name: "no associated data", ciphertext: ciphertext, wantErr: true},
+		{name: "tampered key ID", ciphertext: flip(2), ad: ad, wantErr: true},
+		{name: "tampered data key", ciphertext: flip(len(ciphertext) - 40), ad: ad, wantErr: true},
+		{name: "tampered data", ciphertext: flip(len(ciphertext) - 1), ad: ad, wantErr: true},
+		{name: "truncated", ciphertext: ciphertext[:10], ad: ad, wantErr: true},
+		{name: "empty", ciphertext: nil, ad: ad, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plaintext, err := k.Decrypt(tt.ciphertext, tt.ad)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decrypt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && string(plaintext) != "secret" {
+				t.Errorf("Decrypt() = %q, want %q", plaintext, "secret")
+			}
+		})
+	}
+}
+
+func TestAssociatedDataIsUnambiguous(t *testing.T) {
+	if bytes.Equal(AssociatedData("ab", "c"), AssociatedData("a", "bc")) {
+		t.Error(`AssociatedData("ab", "c") equals AssociatedData("a", "bc")`)
+	}
+}
+
+func rawKey(t *testing.T) []byte {
+	t.Helper()
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := base64.StdEncoding.DecodeString(key)
+	return raw
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey, newKey := rawKey(t), rawKey(t)
+	old, err := NewKeyring("old", map[string][]byte{"old": oldKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := old.EncryptString("secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotating adds the new primary key and keeps the old one until every value is rewrapped
+	rotated, err := NewKeyring("new", map[string][]byte{"new": newKey, "old": oldKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetDefaultKeyring(rotated)
+	defer SetDefaultKeyring(nil)
+
+	if plaintext, err := rotated.DecryptString(encoded, nil); err != nil || plaintext != "secret" {
+		t.Fatalf("DecryptString() with the old key = %q, %v", plaintext, err)
+	}
+	ciphertext, _ := base64.StdEncoding.DecodeString(encoded)
+	if !rotated.NeedsRewrap(ciphertext) {
+		t.Error("NeedsRewrap() = false for a value of the old key")
+	}
+
+	rewrapped, ok, err := RewrapString(encoded)
+	if err != nil || !ok {
+		t.Fatalf("RewrapString() = %v, %v", ok, err)
+	}
+	if _, ok, err := RewrapString(rewrapped); err != nil || ok {
+		t.Errorf("RewrapString() of a rewrapped value = %v, %v, want no change", ok, err)
+	}
+
+	// Once every value is rewrapped the old key can be dropped
+	current, err := NewKeyring("new", map[string][]byte{"new": newKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plaintext, err := current.DecryptString(rewrapped, nil); err != nil || plaintext != "secret" {
+		t.Errorf("DecryptString() after rewrap = %q, %v", plaintext, err)
+	}
+	if _, err := current.DecryptString(encoded, nil); err == nil {
+		t.Error("DecryptString() of the old value without the old key succeeded")
+	}
+}
+
+func TestRewrapKeepsAssociatedData(t *testing.T) {
+	k := newTestKeyring(t, "old")
+	ad := AssociatedData("table", "column", "row")
+	ciphertext, err := k.Encrypt([]byte("secret"), ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.AddKey("new", rawKey(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.AddKey("new", rawKey(t)); err == nil {
+		t.Error("AddKey() replaced an existing key")
+	}
+	if err := k.SetPrimary("new"); err != nil {
+		t.Fatal(err)
+	}
+	rewrapped, err := k.Rewrap(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := KeyID(rewrapped); id != "new" {
+		t.Errorf("KeyID() = %q, want %q", id, "new")
+	}
+	if _, err := k.Decrypt(rewrapped, nil); err == nil {
+		t.Error("Decrypt() of a rewrapped value without its associated data succeeded")
+	}
+	if plaintext, err := k.Decrypt(rewrapped, ad); err != nil || string(plaintext) != "secret" {
+		t.Errorf("Decrypt() = %q, %v", plaintext, err)
+	}
+}
+
+func TestParseKeyring(t *testing.T) {
+	key, _ := GenerateKey()
+	other, _ := GenerateKey()
+	short := base64.StdEncoding.EncodeToString([]byte("short"))
+
+	tests := []struct {
+		name        string
+		config      string
+		wantPrimary string
+		wantErr     bool
+	}{
+		{name: "single key", config: "k1:" + key, wantPrimary: "k1"},
+		{name: "first key is primary", config: "k2:" + other + ", k1:" + key, wantPrimary: "k2"},
+		{name: "duplicate ID", config: "k1:" + key + ",k1:" + other, wantErr: true},
+		{name: "duplicate ID with the same key", config: "k1:" + key + ",k1:" + key, wantErr: true},
+		{name: "missing separator", config: key, wantErr: true},
+		{name: "invalid base64", config: "k1:not base64", wantErr: true},
+		{name: "short key", config: "k1:" + short, wantErr: true},
+		{name: "empty ID", config: ":" + key, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := ParseKeyring(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseKeyring() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && k.PrimaryKeyID() != tt.wantPrimary {
+				t.Errorf("PrimaryKeyID() = %q, want %q", k.PrimaryKeyID(), tt.wantPrimary)
+			}
+		})
+	}
+}
+
+func TestEncryptedString(t *testing.T) {
+	SetDefaultKeyring(newTestKeyring(t, "k1"))
+	defer SetDefaultKeyring(nil)
+
+	value, err := EncryptedString("secret").Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value == "secret" {
+		t.Fatal("Value() stored the plaintext")
+	}
+	var scanned EncryptedString
+	if err := scanned.Scan(value); err != nil || scanned != "secret" {
+		t.Errorf("Scan() = %q, %v", scanned, err)
+	}
+	if err := scanned.Scan(nil); err != nil || scanned != "" {
+		t.Errorf("Scan(nil) = %q, %v", scanned, err)
+	}
+}
@@ -0,0 +1,152 @@
+package crypto
+
+import (
+	"database/sql/driver"
+	"encoding/base64"
+	"sync"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+)
+
+var (
+	defaultMu      sync.RWMutex
+	defaultKeyring *Keyring
+)
+
+// SetDefaultKeyring sets the keyring EncryptedString and EncryptedBytes use, call it once at startup
+func SetDefaultKeyring(k *Keyring) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultKeyring = k
+}
+
+// DefaultKeyring returns the keyring set with SetDefaultKeyring, nil if none was
+func DefaultKeyring() *Keyring {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultKeyring
+}
+
+func requireKeyring(errorType string) (*Keyring, error) {
+	k := DefaultKeyring()
+	if k == nil {
+		return nil, errors.NewLuciaError(errorType, "No default keyring configured, see crypto.SetDefaultKeyring")
+	}
+	return k, nil
+}
+
+// EncryptedString is a string stored encrypted with the default keyring, base64 encoded in a TEXT column.
+// It is the plaintext everywhere but in the database, NULL scans as "".
+// The ciphertext is not bound to its row, anyone able to write the table can copy it to another row. Secrets
+// of a user should be sealed with Keyring.EncryptString and AssociatedData naming the row instead.
+type EncryptedString string
+
+// Value encrypts the string for the database
+func (s EncryptedString) Value() (driver.Value, error) {
+	k, err := requireKeyring("EncryptionError")
+	if err != nil {
+		return nil, err
+	}
+	return k.EncryptString(string(s), nil)
+}
+
+// Scan decrypts a value read from the database
+func (s *EncryptedString) Scan(src any) error {
+	encoded, ok, err := scanCiphertext(src)
+	if err != nil || !ok {
+		*s = ""
+		return err
+	}
+	k, err := requireKeyring("DecryptionError")
+	if err != nil {
+		return err
+	}
+	plaintext, err := k.DecryptString(encoded, nil)
+	if err != nil {
+		return err
+	}
+	*s = EncryptedString(plaintext)
+	return nil
+}
+
+// EncryptedBytes is a byte slice stored encrypted with the default keyring in a BYTEA column, NULL scans as nil.
+// Like EncryptedString it is not bound to its row.
+type EncryptedBytes []byte
+
+// Value encrypts the bytes for the database, nil is stored as NULL
+func (b EncryptedBytes) Value() (driver.Value, error) {
+	if b == nil {
+		return nil, nil
+	}
+	k, err := requireKeyring("EncryptionError")
+	if err != nil {
+		return nil, err
+	}
+	return k.Encrypt(b, nil)
+}
+
+// Scan decrypts a value read from the database
+func (b *EncryptedBytes) Scan(src any) error {
+	if src == nil {
+		*b = nil
+		return nil
+	}
+	var ciphertext []byte
+	switch v := src.(type) {
+	case []byte:
+		ciphertext = v
+	case string:
+		ciphertext = []byte(v)
+	default:
+		return errors.NewLuciaError("DecryptionError", "Unsupported type for EncryptedBytes")
+	}
+	k, err := requireKeyring("DecryptionError")
+	if err != nil {
+		return err
+	}
+	plaintext, err := k.Decrypt(ciphertext, nil)
+	if err != nil {
+		return err
+	}
+	*b = plaintext
+	return nil
+}
+
+// RewrapString moves a value stored by EncryptedString to the primary key of the default keyring.
+// ok is false when the value already uses it, so a rotation job only writes back the rows that changed.
+func RewrapString(encoded string) (rewrapped string, ok bool, err error) {
+	k, err := requireKeyring("EncryptionError")
+	if err != nil {
+		return "", false, err
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", false, errors.NewLuciaError("DecryptionError", "Encrypted value is not valid base64").WithCause(err)
+	}
+	keyID, err := KeyID(ciphertext)
+	if err != nil {
+		return "", false, err
+	}
+	if keyID == k.PrimaryKeyID() {
+		return encoded, false, nil
+	}
+	ciphertext, err = k.Rewrap(ciphertext)
+	if err != nil {
+		return "", false, err
+	}
+	return base64.StdEncoding.EncodeToString(ciphertext), true, nil
+}
+
+// scanCiphertext reads the base64 text of an EncryptedString column, ok is false for NULL
+func scanCiphertext(src any) (string, bool, error) {
+	switch v := src.(type) {
+	case nil:
+		return "", false, nil
+	case string:
+		return v, true, nil
+	case []byte:
+		return string(v), true, nil
+	default:
+		return "", false, errors.NewLuciaError("DecryptionError", "Unsupported type for EncryptedString")
+	}
+}
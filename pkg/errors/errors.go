@@ -1,21 +1,63 @@
 package errors
 
 import (
+	"encoding/json"
+	stderrors "errors"
 	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/pkg/errors"
 )
 
 // ApiError represents custom API errors
 type ApiError struct {
 	Type    string
 	Message string
+	// RetryAfter is sent as the Retry-After header when set, it is used by TooManyRequests errors
+	RetryAfter time.Duration
+	// Op, Provider and StatusCode give context for logs, they are never sent to clients
+	Op         string
+	Provider   string
+	StatusCode int
+	// Cause is the underlying error, it is reachable through errors.Is and errors.As
+	Cause error
 }
 
 // Error implements the error interface
 func (e ApiError) Error() string {
-	return fmt.Sprintf("%s: %s", e.Type, e.Message)
+	return describe(fmt.Sprintf("%s: %s", e.Type, e.Message), e.Op, e.Provider, e.StatusCode, e.Cause)
+}
+
+// Unwrap returns the underlying cause
+func (e ApiError) Unwrap() error {
+	return e.Cause
+}
+
+// WithCause returns a copy of the error wrapping cause
+func (e ApiError) WithCause(cause error) ApiError {
+	e.Cause = cause
+	return e
+}
+
+// WithOp returns a copy of the error tagged with the operation that failed
+func (e ApiError) WithOp(op string) ApiError {
+	e.Op = op
+	return e
+}
+
+// WithProvider returns a copy of the error tagged with the OAuth provider involved
+func (e ApiError) WithProvider(provider string) ApiError {
+	e.Provider = provider
+	return e
+}
+
+// WithStatusCode returns a copy of the error tagged with an upstream HTTP status code
+func (e ApiError) WithStatusCode(statusCode int) ApiError {
+	e.StatusCode = statusCode
+	return e
 }
 
 // NewApiError creates a new ApiError
@@ -36,15 +78,55 @@ var (
 	ErrServiceUnavailable = func(msg string) ApiError { return NewApiError("ServiceUnavailable", msg) }
 )
 
+// ErrTooManyRequests creates a TooManyRequests error telling the client when it may retry
+func ErrTooManyRequests(msg string, retryAfter time.Duration) ApiError {
+	return ApiError{Type: "TooManyRequests", Message: msg, RetryAfter: retryAfter}
+}
+
 // LuciaError represents errors from the Lucia authentication library
 type LuciaError struct {
 	Type    string
 	Message string
+	// Op, Provider and StatusCode give context for logs, they are never sent to clients
+	Op         string
+	Provider   string
+	StatusCode int
+	// Cause is the underlying error, it is reachable through errors.Is and errors.As
+	Cause error
 }
 
 // Error implements the error interface
 func (e LuciaError) Error() string {
-	return fmt.Sprintf("Lucia error - %s: %s", e.Type, e.Message)
+	return describe(fmt.Sprintf("Lucia error - %s: %s", e.Type, e.Message), e.Op, e.Provider, e.StatusCode, e.Cause)
+}
+
+// Unwrap returns the underlying cause
+func (e LuciaError) Unwrap() error {
+	return e.Cause
+}
+
+// WithCause returns a copy of the error wrapping cause
+func (e LuciaError) WithCause(cause error) LuciaError {
+	e.Cause = cause
+	return e
+}
+
+// WithOp returns a copy of the error tagged with the operation that failed
+func (e LuciaError) WithOp(op string) LuciaError {
+	e.Op = op
+	return e
+}
+
+// WithProvider returns a copy of the error tagged with the OAuth provider involved
+func (e LuciaError) WithProvider(provider string) LuciaError {
+	e.Provider = provider
+	return e
+}
+
+// WithStatusCode returns a copy of the error tagged with an upstream HTTP status code
+func (e LuciaError) WithStatusCode(statusCode int) LuciaError {
+	e.StatusCode = statusCode
+	return e
 }
 
 // NewLuciaError creates a new LuciaError
@@ -52,15 +134,32 @@ func NewLuciaError(errType string, message string) LuciaError {
 	return LuciaError{Type: errType, Message: message}
 }
 
+// describe appends the optional context and cause of an error to its base message
+func describe(base, op, provider string, statusCode int, cause error) string {
+	var fields []string
+	if op != "" {
+		fields = append(fields, "op="+op)
+	}
+	if provider != "" {
+		fields = append(fields, "provider="+provider)
+	}
+	if statusCode != 0 {
+		fields = append(fields, "status="+strconv.Itoa(statusCode))
+	}
+	if len(fields) > 0 {
+		base += " (" + strings.Join(fields, " ") + ")"
+	}
+	if cause != nil {
+		base += ": " + cause.Error()
+	}
+	return base
+}
+
 // ErrorHandler is a custom error handler for Fiber
 func ErrorHandler(c *fiber.Ctx, err error) error {
-	code := fiber.StatusInternalServerError
-	message := "Internal Server Error"
-
-	if e, ok := errors.Cause(err).(ApiError); ok {
-		code, message = handleApiError(e)
-	} else if le, ok := errors.Cause(err).(LuciaError); ok {
-		code, message = handleLuciaError(le)
+	code, message := statusAndMessage(err)
+	if retryAfter := retryAfterSeconds(err); retryAfter != "" {
+		c.Set(fiber.HeaderRetryAfter, retryAfter)
 	}
 
 	return c.Status(code).JSON(fiber.Map{
@@ -68,6 +167,58 @@ func ErrorHandler(c *fiber.Ctx, err error) error {
 	})
 }
 
+// HTTPErrorHandler writes err as a JSON response, it is the net/http counterpart of ErrorHandler
+func HTTPErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	code, message := statusAndMessage(err)
+	if retryAfter := retryAfterSeconds(err); retryAfter != "" {
+		w.Header().Set("Retry-After", retryAfter)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": message,
+	})
+}
+
+// statusAndMessage determines the HTTP status code and the client-safe message for any error.
+// The outermost ApiError or LuciaError wins, causes wrapped inside it never reach the client.
+func statusAndMessage(err error) (int, string) {
+	switch e := typedError(err).(type) {
+	case ApiError:
+		return handleApiError(e)
+	case LuciaError:
+		return handleLuciaError(e)
+	}
+	return fiber.StatusInternalServerError, "Internal Server Error"
+}
+
+// typedError returns the outermost ApiError or LuciaError in the chain of err, or nil
+func typedError(err error) error {
+	for err != nil {
+		switch err.(type) {
+		case ApiError, LuciaError:
+			return err
+		}
+		if cause, ok := err.(interface{ Cause() error }); ok {
+			err = cause.Cause()
+		} else {
+			err = stderrors.Unwrap(err)
+		}
+	}
+	return nil
+}
+
+// retryAfterSeconds returns the Retry-After header value for err, rounded up to whole seconds
+func retryAfterSeconds(err error) string {
+	e, ok := typedError(err).(ApiError)
+	if !ok || e.RetryAfter <= 0 {
+		return ""
+	}
+	seconds := int64((e.RetryAfter + time.Second - 1) / time.Second)
+	return strconv.FormatInt(seconds, 10)
+}
+
 // handleApiError determines the appropriate HTTP status code and message for ApiErrors
 func handleApiError(e ApiError) (int, string) {
 	switch e.Type {
@@ -85,6 +236,8 @@ func handleApiError(e ApiError) (int, string) {
 		return fiber.StatusConflict, e.Message
 	case "ServiceUnavailable":
 		return fiber.StatusServiceUnavailable, e.Message
+	case "TooManyRequests":
+		return fiber.StatusTooManyRequests, e.Message
 	default:
 		return fiber.StatusInternalServerError, e.Message
 	}
@@ -99,71 +252,98 @@ func handleLuciaError(le LuciaError) (int, string) {
 		return fiber.StatusNotFound, le.Message
 	case "InvalidSessionId":
 		return fiber.StatusBadRequest, le.Message
-	case "SessionExpired", "InvalidCredentials", "InvalidToken", "TokenExpired":
+	case "SessionExpired", "SessionRevoked", "InvalidCredentials", "InvalidToken", "TokenExpired", "InvalidSAMLResponse":
 		return fiber.StatusUnauthorized, le.Message
+	case "SessionStoreUnavailable":
+		return fiber.StatusServiceUnavailable, le.Message
 	case "DuplicateUserError":
 		return fiber.StatusConflict, le.Message
+	case "InvalidRequest", "InvalidGrant", "UnsupportedGrantType", "AuthorizationPending", "SlowDown", "AccessDenied", "ExpiredToken":
+		return fiber.StatusBadRequest, le.Message
+	case "InvalidClient":
+		return fiber.StatusUnauthorized, le.Message
 	default:
 		return fiber.StatusInternalServerError, le.Message
 	}
 }
 
+// asApiError returns the first ApiError in the chain of err, following Unwrap through LuciaErrors and
+// other wrappers
+func asApiError(err error) (ApiError, bool) {
+	var e ApiError
+	ok := stderrors.As(err, &e)
+	return e, ok
+}
+
+// asLuciaError returns the first LuciaError in the chain of err
+func asLuciaError(err error) (LuciaError, bool) {
+	var e LuciaError
+	ok := stderrors.As(err, &e)
+	return e, ok
+}
+
 func IsParseError(err error) bool {
-	e, ok := errors.Cause(err).(ApiError)
+	e, ok := asApiError(err)
 	return ok && e.Type == "ParseError"
 }
 
 // IsUnexpectedError checks if the error is an UnexpectedError
 func IsUnexpectedError(err error) bool {
-	e, ok := errors.Cause(err).(ApiError)
+	e, ok := asApiError(err)
 	return ok && e.Type == "UnexpectedError"
 }
 
 // IsDatabaseError checks if the error is a DatabaseError
 func IsDatabaseError(err error) bool {
-	e, ok := errors.Cause(err).(ApiError)
+	e, ok := asApiError(err)
 	return ok && e.Type == "DatabaseError"
 }
 
 // IsNotFound checks if the error is a NotFound error
 func IsNotFound(err error) bool {
-	e, ok := errors.Cause(err).(ApiError)
+	e, ok := asApiError(err)
 	return ok && e.Type == "NotFound"
 }
 
 // IsBadRequest checks if the error is a BadRequest error
 func IsBadRequest(err error) bool {
-	e, ok := errors.Cause(err).(ApiError)
+	e, ok := asApiError(err)
 	return ok && e.Type == "BadRequest"
 }
 
 // IsForbidden checks if the error is a Forbidden error
 func IsForbidden(err error) bool {
-	e, ok := errors.Cause(err).(ApiError)
+	e, ok := asApiError(err)
 	return ok && e.Type == "Forbidden"
 }
 
 // IsUnauthorized checks if the error is an Unauthorized error
 func IsUnauthorized(err error) bool {
-	e, ok := errors.Cause(err).(ApiError)
+	e, ok := asApiError(err)
 	return ok && e.Type == "Unauthorized"
 }
 
 // IsConflict checks if the error is a Conflict error
 func IsConflict(err error) bool {
-	e, ok := errors.Cause(err).(ApiError)
+	e, ok := asApiError(err)
 	return ok && e.Type == "Conflict"
 }
 
 // IsServiceUnavailable checks if the error is a ServiceUnavailable error
 func IsServiceUnavailable(err error) bool {
-	e, ok := errors.Cause(err).(ApiError)
+	e, ok := asApiError(err)
 	return ok && e.Type == "ServiceUnavailable"
 }
 
+// IsTooManyRequests checks if the error is a TooManyRequests error
+func IsTooManyRequests(err error) bool {
+	e, ok := asApiError(err)
+	return ok && e.Type == "TooManyRequests"
+}
+
 // IsLuciaError checks if the error is a LuciaError
 func IsLuciaError(err error) bool {
-	_, ok := errors.Cause(err).(LuciaError)
+	_, ok := asLuciaError(err)
 	return ok
 }
 
@@ -171,24 +351,24 @@ func IsLuciaError(err error) bool {
 
 // IsLuciaDatabaseError checks if the error is a Lucia database-related error
 func IsLuciaDatabaseError(err error) bool {
-	le, ok := errors.Cause(err).(LuciaError)
+	le, ok := asLuciaError(err)
 	return ok && (le.Type == "DatabaseConnectionError" || le.Type == "DatabaseQueryError")
 }
 
 // IsLuciaSessionError checks if the error is a Lucia session-related error
 func IsLuciaSessionError(err error) bool {
-	le, ok := errors.Cause(err).(LuciaError)
-	return ok && (le.Type == "UserSessionNotFound" || le.Type == "InvalidSessionId" || le.Type == "SessionExpired")
+	le, ok := asLuciaError(err)
+	return ok && (le.Type == "UserSessionNotFound" || le.Type == "InvalidSessionId" || le.Type == "SessionExpired" || le.Type == "SessionRevoked")
 }
 
 // IsLuciaAuthError checks if the error is a Lucia authentication-related error
 func IsLuciaAuthError(err error) bool {
-	le, ok := errors.Cause(err).(LuciaError)
+	le, ok := asLuciaError(err)
 	return ok && (le.Type == "InvalidCredentials" || le.Type == "InvalidToken" || le.Type == "TokenExpired")
 }
 
 // IsLuciaDuplicateUserError checks if the error is a Lucia duplicate user error
 func IsLuciaDuplicateUserError(err error) bool {
-	le, ok := errors.Cause(err).(LuciaError)
+	le, ok := asLuciaError(err)
 	return ok && le.Type == "DuplicateUserError"
 }
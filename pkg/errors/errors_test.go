@@ -0,0 +1,38 @@
+package errors
+
+import (
+	stderrors "errors"
+	"fmt"
+	"testing"
+)
+
+func TestIsHelpersFollowWrappedCauses(t *testing.T) {
+	notFound := ErrNotFound("Session not found")
+
+	tests := []struct {
+		name  string
+		err   error
+		check func(error) bool
+		want  bool
+	}{
+		{name: "api error", err: notFound, check: IsNotFound, want: true},
+		{name: "wrapped by fmt", err: fmt.Errorf("get session: %w", notFound), check: IsNotFound, want: true},
+		{name: "wrapped by a lucia error", err: NewLuciaError("SessionDeletionFailed", "Failed to delete session").WithCause(notFound), check: IsNotFound, want: true},
+		{name: "wrapped twice", err: fmt.Errorf("logout: %w", NewLuciaError("SessionDeletionFailed", "Failed to delete session").WithCause(notFound)), check: IsNotFound, want: true},
+		{name: "outer api error decides", err: ErrUnexpected("Failed").WithCause(notFound), check: IsNotFound, want: false},
+		{name: "other type", err: ErrConflict("Conflict"), check: IsNotFound, want: false},
+		{name: "plain error", err: stderrors.New("boom"), check: IsNotFound, want: false},
+		{name: "nil", err: nil, check: IsNotFound, want: false},
+		{name: "lucia error wrapped by an api error", err: ErrUnauthorized("Denied").WithCause(NewLuciaError("SessionExpired", "Session expired")), check: IsLuciaSessionError, want: true},
+		{name: "lucia error wrapped by fmt", err: fmt.Errorf("login: %w", NewLuciaError("DuplicateUserError", "Duplicate")), check: IsLuciaDuplicateUserError, want: true},
+		{name: "any lucia error", err: fmt.Errorf("login: %w", NewLuciaError("InvalidToken", "Invalid")), check: IsLuciaError, want: true},
+		{name: "too many requests", err: fmt.Errorf("login: %w", NewApiError("TooManyRequests", "Slow down")), check: IsTooManyRequests, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.check(tt.err); got != tt.want {
+				t.Errorf("got %v, want %v for %v", got, tt.want, tt.err)
+			}
+		})
+	}
+}
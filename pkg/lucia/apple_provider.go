@@ -0,0 +1,271 @@
+package lucia
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/oauth2"
+)
+
+// appleClientSecretTTL is how long a generated client secret is valid, Apple accepts up to six months
+const appleClientSecretTTL = time.Hour
+
+// AppleConfig configures an AppleProvider
+type AppleConfig struct {
+	// ClientID is the Services ID for web logins, or the bundle ID for native apps
+	ClientID    string
+	TeamID      string
+	KeyID       string
+	RedirectURI string
+	// PrivateKey is the PEM-encoded .p8 key downloaded from the Apple developer portal
+	PrivateKey []byte
+	// Scopes defaults to name and email
+	Scopes []string
+}
+
+// AppleProvider implements Sign in with Apple.
+// Apple posts the callback (response_mode=form_post), so the callback route must accept POST, the state cookie
+// must be SameSite=None, and the form must be attached with WithCallbackForm to receive the user's name.
+type AppleProvider struct {
+	cfg       AppleConfig
+	config    *oauth2.Config
+	key       *ecdsa.PrivateKey
+	opts      providerOptions
+	issuer    string
+	jwks      *jwksCache
+	revokeURL string
+
+	mu           sync.Mutex
+	clientSecret string
+	secretExpiry time.Time
+}
+
+func NewAppleProvider(cfg AppleConfig, opts ...ProviderOption) (*AppleProvider, error) {
+	key, err := parseAppleKey(cfg.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"name", "email"}
+	}
+
+	o := newProviderOptions(opts)
+	base := strings.TrimSuffix(or(o.baseURL, "https://appleid.apple.com"), "/")
+
+	return &AppleProvider{
+		cfg: cfg,
+		config: &oauth2.Config{
+			ClientID:    cfg.ClientID,
+			RedirectURL: cfg.RedirectURI,
+			Scopes:      cfg.Scopes,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:   or(o.authURL, base+"/auth/authorize"),
+				TokenURL:  or(o.tokenURL, base+"/auth/token"),
+				AuthStyle: oauth2.AuthStyleInParams,
+			},
+		},
+		key:       key,
+		opts:      o,
+		issuer:    or(o.issuer, "https://appleid.apple.com"),
+		jwks:      newJWKSCache(or(o.jwksURL, base+"/auth/keys"), o.httpClient),
+		revokeURL: or(o.revokeURL, base+"/auth/revoke"),
+	}, nil
+}
+
+// parseAppleKey decodes the PKCS#8 EC P-256 key of a .p8 file
+func parseAppleKey(pemBytes []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.NewLuciaError("ConfigurationError", "Apple private key is not PEM encoded")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.NewLuciaError("ConfigurationError", "Failed to parse Apple private key").WithCause(err)
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.NewLuciaError("ConfigurationError", "Apple private key is not an EC key")
+	}
+	return key, nil
+}
+
+// ClientSecret returns the ES256 JWT Apple expects as client_secret, regenerating it shortly before it expires
+func (p *AppleProvider) ClientSecret() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if p.clientSecret != "" && now.Add(5*time.Minute).Before(p.secretExpiry) {
+		return p.clientSecret, nil
+	}
+
+	expiry := now.Add(appleClientSecretTTL)
+	secret, err := signJWT(p.key, p.cfg.KeyID, map[string]interface{}{
+		"iss": p.cfg.TeamID,
+		"iat": now.Unix(),
+		"exp": expiry.Unix(),
+		"aud": p.issuer,
+		"sub": p.cfg.ClientID,
+	})
+	if err != nil {
+		return "", err
+	}
+	p.clientSecret = secret
+	p.secretExpiry = expiry
+	return secret, nil
+}
+
+// configWithSecret returns a copy of the OAuth config carrying a valid client secret
+func (p *AppleProvider) configWithSecret() (*oauth2.Config, error) {
+	secret, err := p.ClientSecret()
+	if err != nil {
+		return nil, err
+	}
+	cfg := *p.config
+	cfg.ClientSecret = secret
+	return &cfg, nil
+}
+
+// GetAuthURL builds the authorization URL, Apple always returns a refresh token and ignores prompt and hints
+func (p *AppleProvider) GetAuthURL(state string, opts ...AuthURLOption) string {
+	return newAuthURLOptions(opts).authCodeURL(p.config, state, oauth2.SetAuthURLParam("response_mode", "form_post"))
+}
+
+func (p *AppleProvider) ExchangeCode(ctx context.Context, code string) (*OAuthToken, error) {
+	cfg, err := p.configWithSecret()
+	if err != nil {
+		return nil, err
+	}
+	token, err := cfg.Exchange(p.opts.oauth2Context(ctx), code)
+	if err != nil {
+		return nil, errors.ErrUnauthorized("Failed to exchange code").WithProvider("apple").WithCause(err)
+	}
+
+	idToken, _ := token.Extra("id_token").(string)
+	return &OAuthToken{
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		ExpiresIn:    token.Expiry.Unix(),
+		IDToken:      idToken,
+	}, nil
+}
+
+// appleBool decodes the boolean claims Apple sends either as JSON booleans or as "true"/"false" strings
+type appleBool bool
+
+func (b *appleBool) UnmarshalJSON(data []byte) error {
+	*b = appleBool(strings.Trim(string(data), `"`) == "true")
+	return nil
+}
+
+type appleClaims struct {
+	Email          string    `json:"email"`
+	EmailVerified  appleBool `json:"email_verified"`
+	IsPrivateEmail appleBool `json:"is_private_email"`
+}
+
+// appleUser is the JSON sent in the "user" form field of the callback, only on the first login
+type appleUser struct {
+	Name struct {
+		FirstName string `json:"firstName"`
+		LastName  string `json:"lastName"`
+	} `json:"name"`
+	Email string `json:"email"`
+}
+
+func (p *AppleProvider) GetUserInfo(ctx context.Context, token *OAuthToken) (*UserInfo, error) {
+	if token.IDToken == "" {
+		return nil, errors.NewLuciaError("InvalidToken", "Apple did not return an ID token")
+	}
+
+	var claims appleClaims
+	registered, err := verifyJWT(token.IDToken, func(header jwtHeader) (crypto.PublicKey, error) {
+		return p.jwks.key(ctx, header.Kid)
+	}, &claims)
+	if err != nil {
+		return nil, err
+	}
+	if err := registered.validate(p.issuer, p.cfg.ClientID); err != nil {
+		return nil, err
+	}
+
+	userInfo := &UserInfo{
+		ID:                registered.Subject,
+		Email:             claims.Email,
+		EmailVerified:     bool(claims.EmailVerified),
+		Provider:          "apple",
+		PrivateRelayEmail: bool(claims.IsPrivateEmail) || strings.HasSuffix(claims.Email, "@privaterelay.appleid.com"),
+		AuthTime:          registered.AuthTime,
+		AuthMethods:       registered.AuthMethods,
+		Raw:               registered.Raw,
+		Token:             token,
+	}
+
+	// Apple only sends the name once, in the callback form of the very first login
+	if raw := callbackFormFromContext(ctx).Get("user"); raw != "" {
+		var user appleUser
+		if err := json.Unmarshal([]byte(raw), &user); err == nil {
+			userInfo.Name = strings.TrimSpace(user.Name.FirstName + " " + user.Name.LastName)
+		}
+	}
+
+	return userInfo, nil
+}
+
+func (p *AppleProvider) RefreshToken(ctx context.Context, refreshToken string) (*OAuthToken, error) {
+	cfg, err := p.configWithSecret()
+	if err != nil {
+		return nil, err
+	}
+	newToken, err := cfg.TokenSource(p.opts.oauth2Context(ctx), &oauth2.Token{RefreshToken: refreshToken}).Token()
+	if err != nil {
+		return nil, errors.ErrUnauthorized("Failed to refresh token").WithProvider("apple").WithCause(err)
+	}
+
+	idToken, _ := newToken.Extra("id_token").(string)
+	return &OAuthToken{
+		AccessToken:  newToken.AccessToken,
+		RefreshToken: or(newToken.RefreshToken, refreshToken),
+		ExpiresIn:    newToken.Expiry.Unix(),
+		IDToken:      idToken,
+	}, nil
+}
+
+// RevokeToken revokes the tokens of the user, Apple requires it when an account is deleted
+func (p *AppleProvider) RevokeToken(ctx context.Context, token *OAuthToken) error {
+	secret, err := p.ClientSecret()
+	if err != nil {
+		return err
+	}
+	form := url.Values{
+		"client_id":       {p.cfg.ClientID},
+		"client_secret":   {secret},
+		"token":           {token.AccessToken},
+		"token_type_hint": {"access_token"},
+	}
+	if token.RefreshToken != "" {
+		form.Set("token", token.RefreshToken)
+		form.Set("token_type_hint", "refresh_token")
+	}
+	return revokeToken(ctx, p.opts.httpClient, p.revokeURL, "apple", form)
+}
+
+// FormPostCallback reads a form_post OAuth callback such as Apple's, returning the code, the state and a
+// context carrying the posted form to pass to HandleCallback
+func FormPostCallback(c *fiber.Ctx) (code, state string, ctx context.Context) {
+	form := url.Values{}
+	c.Request().PostArgs().VisitAll(func(key, value []byte) {
+		form.Add(string(key), string(value))
+	})
+	return c.FormValue("code"), c.FormValue("state"), WithCallbackForm(c.UserContext(), form)
+}
@@ -0,0 +1,411 @@
+package lucia
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// appleServer is a fake Sign in with Apple serving the token, keys and revocation endpoints
+type appleServer struct {
+	*httptest.Server
+	t *testing.T
+	// clientKey is the private key of the .p8 file, the server checks client secrets against it
+	clientKey *ecdsa.PrivateKey
+	signer    *ecdsa.PrivateKey
+
+	mu sync.Mutex
+	// claims are the ID token claims returned for an authorization code
+	claims map[string]map[string]interface{}
+	// revokeStatus and revokeBody answer the next revocation, 200 when zero
+	revokeStatus int
+	revokeBody   string
+	revoked      []url.Values
+}
+
+func newAppleServer(t *testing.T) *appleServer {
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &appleServer{t: t, clientKey: clientKey, signer: signer, claims: make(map[string]map[string]interface{})}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /auth/token", s.token)
+	mux.HandleFunc("GET /auth/keys", func(w http.ResponseWriter, r *http.Request) {
+		jwk, _ := NewJWK("apple-key", &signer.PublicKey)
+		json.NewEncoder(w).Encode(JWKSet{Keys: []JWK{jwk}})
+	})
+	mux.HandleFunc("POST /auth/revoke", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if err := s.checkClientSecret(r.PostForm); err != nil {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.revoked = append(s.revoked, r.PostForm)
+		if s.revokeStatus != 0 {
+			http.Error(w, s.revokeBody, s.revokeStatus)
+		}
+	})
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// provider returns an AppleProvider pointed at the server
+func (s *appleServer) provider() *AppleProvider {
+	der, err := x509.MarshalPKCS8PrivateKey(s.clientKey)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	p, err := NewAppleProvider(AppleConfig{
+		ClientID:    "com.example.web",
+		TeamID:      "TEAM123456",
+		KeyID:       "KEY1234567",
+		RedirectURI: "https://app.example.com/login/apple/callback",
+		PrivateKey:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}),
+	}, WithBaseURL(s.URL), WithHTTPClient(s.Client()))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return p
+}
+
+// authorize registers the ID token claims of the user, merged over the defaults, and returns the code
+func (s *appleServer) authorize(code string, claims map[string]interface{}) string {
+	now := time.Now()
+	c := map[string]interface{}{
+		"iss": "https://appleid.apple.com",
+		"aud": "com.example.web",
+		"sub": "001234.abcdef",
+		"iat": now.Unix(),
+		"exp": now.Add(10 * time.Minute).Unix(),
+	}
+	for k, v := range claims {
+		c[k] = v
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.claims[code] = c
+	return code
+}
+
+// checkClientSecret verifies that the client secret of form is an ES256 JWT of the .p8 key
+func (s *appleServer) checkClientSecret(form url.Values) error {
+	_, err := verifyJWT(form.Get("client_secret"), func(header jwtHeader) (crypto.PublicKey, error) {
+		return &s.clientKey.PublicKey, nil
+	}, nil)
+	return err
+}
+
+func (s *appleServer) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	if r.PostForm.Get("client_id") != "com.example.web" || s.checkClientSecret(r.PostForm) != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_client"}`))
+		return
+	}
+	s.mu.Lock()
+	claims, ok := s.claims[r.PostForm.Get("code")]
+	s.mu.Unlock()
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+	idToken, err := signJWT(s.signer, "apple-key", claims)
+	if err != nil {
+		s.t.Error(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":  "apple-access",
+		"token_type":    "bearer",
+		"expires_in":    3600,
+		"refresh_token": "apple-refresh",
+		"id_token":      idToken,
+	})
+}
+
+func TestParseAppleKey(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecDER, _ := x509.MarshalPKCS8PrivateKey(ecKey)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsaDER, _ := x509.MarshalPKCS8PrivateKey(rsaKey)
+	sec1DER, _ := x509.MarshalECPrivateKey(ecKey)
+
+	tests := []struct {
+		name    string
+		pem     []byte
+		wantErr bool
+	}{
+		{name: "PKCS#8 EC key", pem: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: ecDER})},
+		{name: "not PEM", pem: []byte("MIGTAgEAMBMGByqGSM49AgEGCCqGSM49AwEHBHkwdwIBAQQg"), wantErr: true},
+		{name: "SEC 1 EC key", pem: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1DER}), wantErr: true},
+		{name: "RSA key", pem: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: rsaDER}), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := parseAppleKey(tt.pem)
+			if tt.wantErr {
+				if errorType(err) != "ConfigurationError" {
+					t.Errorf("parseAppleKey() error = %v, want ConfigurationError", err)
+				}
+				return
+			}
+			if err != nil || !key.Equal(ecKey) {
+				t.Errorf("parseAppleKey() = %v, %v, want the encoded key", key, err)
+			}
+		})
+	}
+}
+
+func TestAppleClientSecret(t *testing.T) {
+	server := newAppleServer(t)
+	p := server.provider()
+
+	secret, err := p.ClientSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var header jwtHeader
+	registered, err := verifyJWT(secret, func(h jwtHeader) (crypto.PublicKey, error) {
+		header = h
+		return &server.clientKey.PublicKey, nil
+	}, nil)
+	if err != nil {
+		t.Fatalf("client secret does not verify: %v", err)
+	}
+	if header.Alg != "ES256" || header.Kid != "KEY1234567" {
+		t.Errorf("header = %+v, want ES256 with the key ID", header)
+	}
+	if registered.Issuer != "TEAM123456" || registered.Subject != "com.example.web" || !registered.Audience.contains("https://appleid.apple.com") {
+		t.Errorf("claims = %+v, want the team as issuer, the client as subject and Apple as audience", registered)
+	}
+	if ttl := time.Until(time.Unix(registered.ExpiresAt, 0)); ttl <= 55*time.Minute || ttl > appleClientSecretTTL {
+		t.Errorf("secret expires in %v, want %v", ttl, appleClientSecretTTL)
+	}
+
+	if again, _ := p.ClientSecret(); again != secret {
+		t.Error("ClientSecret() signed a new secret while the previous one was valid")
+	}
+	p.secretExpiry = time.Now().Add(4 * time.Minute)
+	if renewed, _ := p.ClientSecret(); renewed == secret {
+		t.Error("ClientSecret() reused a secret about to expire")
+	}
+}
+
+func TestAppleBool(t *testing.T) {
+	tests := []struct {
+		json string
+		want appleBool
+	}{
+		{json: `true`, want: true},
+		{json: `"true"`, want: true},
+		{json: `false`, want: false},
+		{json: `"false"`, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.json, func(t *testing.T) {
+			var claims appleClaims
+			if err := json.Unmarshal([]byte(`{"email_verified":`+tt.json+`}`), &claims); err != nil {
+				t.Fatal(err)
+			}
+			if claims.EmailVerified != tt.want {
+				t.Errorf("email_verified %s = %v, want %v", tt.json, claims.EmailVerified, tt.want)
+			}
+		})
+	}
+}
+
+func TestAppleFormPostLogin(t *testing.T) {
+	tests := []struct {
+		name         string
+		claims       map[string]interface{}
+		user         string
+		wantName     string
+		wantVerified bool
+		wantRelay    bool
+	}{
+		{
+			name:         "first login with the user form",
+			claims:       map[string]interface{}{"email": "jane@example.com", "email_verified": "true"},
+			user:         `{"name":{"firstName":"Jane","lastName":"Appleseed"},"email":"jane@example.com"}`,
+			wantName:     "Jane Appleseed",
+			wantVerified: true,
+		},
+		{
+			name:   "returning login without the user form",
+			claims: map[string]interface{}{"email": "jane@example.com", "email_verified": false},
+		},
+		{
+			name:         "private relay flagged by Apple",
+			claims:       map[string]interface{}{"email": "x7y2@example.com", "email_verified": true, "is_private_email": "true"},
+			wantVerified: true,
+			wantRelay:    true,
+		},
+		{
+			name:         "private relay detected by domain",
+			claims:       map[string]interface{}{"email": "x7y2@privaterelay.appleid.com", "email_verified": "true"},
+			wantVerified: true,
+			wantRelay:    true,
+		},
+		{
+			name:         "malformed user form is ignored",
+			claims:       map[string]interface{}{"email": "jane@example.com", "email_verified": "true"},
+			user:         `{"name":`,
+			wantVerified: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newAppleServer(t)
+			p := server.provider()
+			code := server.authorize("code-1", tt.claims)
+
+			var userInfo *UserInfo
+			app := fiber.New()
+			app.Post("/login/apple/callback", func(c *fiber.Ctx) error {
+				code, state, ctx := FormPostCallback(c)
+				if state != "state-1" {
+					t.Errorf("state = %q, want state-1", state)
+				}
+				token, err := p.ExchangeCode(ctx, code)
+				if err != nil {
+					return err
+				}
+				userInfo, err = p.GetUserInfo(ctx, token)
+				return err
+			})
+
+			form := url.Values{"code": {code}, "state": {"state-1"}}
+			if tt.user != "" {
+				form.Set("user", tt.user)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/login/apple/callback", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != http.StatusOK || userInfo == nil {
+				t.Fatalf("callback status = %d, want %d", resp.StatusCode, http.StatusOK)
+			}
+
+			if userInfo.ID != "001234.abcdef" || userInfo.Provider != "apple" || userInfo.Email != tt.claims["email"] {
+				t.Errorf("user info = %+v, want the subject and email of the ID token", userInfo)
+			}
+			if userInfo.Name != tt.wantName || userInfo.EmailVerified != tt.wantVerified || userInfo.PrivateRelayEmail != tt.wantRelay {
+				t.Errorf("name %q, verified %v, relay %v, want %q, %v, %v", userInfo.Name, userInfo.EmailVerified,
+					userInfo.PrivateRelayEmail, tt.wantName, tt.wantVerified, tt.wantRelay)
+			}
+			if userInfo.Token.RefreshToken != "apple-refresh" {
+				t.Errorf("refresh token = %q, want apple-refresh", userInfo.Token.RefreshToken)
+			}
+		})
+	}
+}
+
+func TestAppleGetUserInfoRejectsForeignTokens(t *testing.T) {
+	server := newAppleServer(t)
+	p := server.provider()
+	ctx := context.Background()
+
+	for name, claims := range map[string]map[string]interface{}{
+		"other audience": {"aud": "com.example.other"},
+		"other issuer":   {"iss": "https://issuer.example.com"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			token, err := p.ExchangeCode(ctx, server.authorize(name, claims))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := p.GetUserInfo(ctx, token); errorType(err) != "InvalidToken" {
+				t.Errorf("GetUserInfo() error = %v, want InvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestAppleRevokeToken(t *testing.T) {
+	tests := []struct {
+		name      string
+		token     *OAuthToken
+		status    int
+		body      string
+		wantToken string
+		wantHint  string
+		wantErr   bool
+	}{
+		{
+			name:      "refresh token preferred",
+			token:     &OAuthToken{AccessToken: "apple-access", RefreshToken: "apple-refresh"},
+			wantToken: "apple-refresh",
+			wantHint:  "refresh_token",
+		},
+		{
+			name:      "access token only",
+			token:     &OAuthToken{AccessToken: "apple-access"},
+			wantToken: "apple-access",
+			wantHint:  "access_token",
+		},
+		{
+			name:      "token already revoked",
+			token:     &OAuthToken{AccessToken: "apple-access", RefreshToken: "apple-refresh"},
+			status:    http.StatusBadRequest,
+			body:      `{"error":"invalid_token"}`,
+			wantToken: "apple-refresh",
+			wantHint:  "refresh_token",
+		},
+		{
+			name:      "server error",
+			token:     &OAuthToken{AccessToken: "apple-access"},
+			status:    http.StatusServiceUnavailable,
+			body:      "unavailable",
+			wantToken: "apple-access",
+			wantHint:  "access_token",
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newAppleServer(t)
+			server.revokeStatus, server.revokeBody = tt.status, tt.body
+
+			err := server.provider().RevokeToken(context.Background(), tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RevokeToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(server.revoked) != 1 {
+				t.Fatalf("%d revocation requests, want 1", len(server.revoked))
+			}
+			form := server.revoked[0]
+			if form.Get("client_id") != "com.example.web" || form.Get("token") != tt.wantToken || form.Get("token_type_hint") != tt.wantHint {
+				t.Errorf("revocation form = %v, want token %s with hint %s", form, tt.wantToken, tt.wantHint)
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package lucia
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+)
+
+// AuditEventType identifies the kind of security event recorded in the audit trail
+type AuditEventType string
+
+const (
+	AuditLogin          AuditEventType = "login"
+	AuditLoginFailed    AuditEventType = "login_failed"
+	AuditUserCreated    AuditEventType = "user_created"
+	AuditSessionCreated AuditEventType = "session_created"
+	AuditSessionRevoked AuditEventType = "session_revoked"
+	AuditLogout         AuditEventType = "logout"
+	AuditTokenRefreshed AuditEventType = "token_refreshed"
+
+	AuditImpersonationStarted AuditEventType = "impersonation_started"
+	AuditImpersonationEnded   AuditEventType = "impersonation_ended"
+)
+
+// AuditEvent is a single append-only record of the audit trail
+type AuditEvent struct {
+	ID     string         `json:"id"`
+	Type   AuditEventType `json:"type"`
+	UserID string         `json:"user_id,omitempty"`
+	// ActorID is the impersonator when the event happened in an impersonation session
+	ActorID string `json:"actor_id,omitempty"`
+	// SessionID is the AuditSessionID of the session, never the session ID itself which is a bearer credential
+	SessionID  string            `json:"session_id,omitempty"`
+	Provider   string            `json:"provider,omitempty"`
+	IP         string            `json:"ip,omitempty"`
+	UserAgent  string            `json:"user_agent,omitempty"`
+	ErrorType  string            `json:"error_type,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	OccurredAt time.Time         `json:"occurred_at"`
+}
+
+// AuditSink persists audit events, implementations must never update or delete written events
+type AuditSink interface {
+	Write(ctx context.Context, event AuditEvent) error
+}
+
+// AuditFilter selects audit events, zero fields are ignored
+type AuditFilter struct {
+	UserID  string
+	ActorID string
+	Types   []AuditEventType
+	From    time.Time
+	To      time.Time
+	// Limit caps the number of events returned, newest first, 0 means no limit
+	Limit int
+}
+
+// AuditQuerier reads audit events back, sinks that can be queried implement it
+type AuditQuerier interface {
+	Query(ctx context.Context, filter AuditFilter) ([]AuditEvent, error)
+}
+
+// Matches reports whether the event passes the filter
+func (f AuditFilter) Matches(event AuditEvent) bool {
+	if f.UserID != "" && event.UserID != f.UserID {
+		return false
+	}
+	if f.ActorID != "" && event.ActorID != f.ActorID {
+		return false
+	}
+	if !f.From.IsZero() && event.OccurredAt.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !event.OccurredAt.Before(f.To) {
+		return false
+	}
+	if len(f.Types) > 0 {
+		for _, t := range f.Types {
+			if t == event.Type {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// SetAuditSink records logins, failed callbacks, session lifecycle and token refreshes to sink.
+// Sink failures never block authentication, they are passed to onError which may be nil.
+func (s *AuthService[U, ID]) SetAuditSink(sink AuditSink, onError func(err error)) {
+	actor := func(session *Session[ID]) string {
+		if session == nil || session.ImpersonatorID == nil {
+			return ""
+		}
+		return s.codec.Encode(*session.ImpersonatorID)
+	}
+	write := func(ctx context.Context, event AuditEvent) {
+		meta := RequestMetaFromContext(ctx)
+		event.ID = GenerateID()
+		event.SessionID = AuditSessionID(event.SessionID)
+		event.IP = meta.IP
+		event.UserAgent = meta.UserAgent
+		event.OccurredAt = time.Now().UTC()
+		if err := sink.Write(ctx, event); err != nil && onError != nil {
+			onError(err)
+		}
+	}
+
+	s.hooks.OnLogin(func(ctx context.Context, e LoginEvent[U, ID]) {
+		write(ctx, AuditEvent{
+			Type:      AuditLogin,
+			UserID:    s.codec.Encode(e.User.GetID()),
+			SessionID: e.Session.ID,
+			Provider:  e.Provider,
+		})
+	})
+	s.hooks.OnLoginFailed(func(ctx context.Context, e LoginFailedEvent) {
+		event := AuditEvent{
+			Type:      AuditLoginFailed,
+			Provider:  e.Provider,
+			ErrorType: errorType(e.Err),
+			Error:     e.Err.Error(),
+		}
+		if e.UserInfo != nil {
+			event.Metadata = map[string]string{"provider_user_id": e.UserInfo.ID}
+		}
+		write(ctx, event)
+	})
+	s.hooks.OnUserCreated(func(ctx context.Context, e UserCreatedEvent[U]) {
+		write(ctx, AuditEvent{
+			Type:     AuditUserCreated,
+			UserID:   s.codec.Encode(e.User.GetID()),
+			Provider: e.Provider,
+		})
+	})
+	s.hooks.OnSessionCreated(func(ctx context.Context, e SessionEvent[ID]) {
+		write(ctx, AuditEvent{
+			Type:      AuditSessionCreated,
+			UserID:    s.codec.Encode(e.Session.UserID),
+			ActorID:   actor(e.Session),
+			SessionID: e.Session.ID,
+		})
+	})
+	s.hooks.OnSessionRevoked(func(ctx context.Context, e SessionRevokedEvent[ID]) {
+		event := AuditEvent{
+			Type:      AuditSessionRevoked,
+			SessionID: e.SessionID,
+		}
+		if e.Reason == RevokeReasonLogout {
+			event.Type = AuditLogout
+		} else if e.Reason != "" {
+			event.Metadata = map[string]string{"reason": e.Reason}
+		}
+		if e.Session != nil {
+			event.UserID = s.codec.Encode(e.Session.UserID)
+			event.ActorID = actor(e.Session)
+		}
+		write(ctx, event)
+	})
+	s.hooks.OnImpersonationStarted(func(ctx context.Context, e ImpersonationEvent[ID]) {
+		event := AuditEvent{
+			Type:      AuditImpersonationStarted,
+			UserID:    s.codec.Encode(e.Session.UserID),
+			ActorID:   s.codec.Encode(e.ActorID),
+			SessionID: e.Session.ID,
+			Metadata:  map[string]string{"parent_session_id": AuditSessionID(e.Session.ParentSessionID)},
+		}
+		if e.Reason != "" {
+			event.Metadata["reason"] = e.Reason
+		}
+		write(ctx, event)
+	})
+	s.hooks.OnImpersonationEnded(func(ctx context.Context, e ImpersonationEvent[ID]) {
+		write(ctx, AuditEvent{
+			Type:      AuditImpersonationEnded,
+			UserID:    s.codec.Encode(e.Session.UserID),
+			ActorID:   s.codec.Encode(e.ActorID),
+			SessionID: e.Session.ID,
+		})
+	})
+	s.hooks.OnProviderTokenRefreshed(func(ctx context.Context, e TokenRefreshedEvent) {
+		write(ctx, AuditEvent{
+			Type:     AuditTokenRefreshed,
+			Provider: e.Provider,
+		})
+	})
+}
+
+// AuditSessionID returns how a session is identified in the audit trail: the SHA-256 of its ID, so that the
+// events of a session can be correlated without the trail holding credentials. Empty IDs stay empty.
+func AuditSessionID(sessionID string) string {
+	if sessionID == "" {
+		return ""
+	}
+	return hashToken(sessionID)
+}
+
+// errorType returns the type of the outermost LuciaError or ApiError wrapped by err, or "UnexpectedError" for anything else
+func errorType(err error) string {
+	for ; err != nil; err = stderrors.Unwrap(err) {
+		switch e := err.(type) {
+		case errors.LuciaError:
+			return e.Type
+		case errors.ApiError:
+			return e.Type
+		}
+	}
+	return "UnexpectedError"
+}
+
+// FileAuditSink appends audit events as JSON lines to a file
+type FileAuditSink struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// NewFileAuditSink opens (or creates) the JSON-lines audit file at path in append-only mode
+func NewFileAuditSink(path string) (*FileAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, errors.ErrUnexpected("Failed to open audit log").WithCause(err)
+	}
+	return &FileAuditSink{path: path, file: file}, nil
+}
+
+func (s *FileAuditSink) Write(ctx context.Context, event AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return errors.ErrUnexpected("Failed to encode audit event").WithCause(err)
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(line); err != nil {
+		return errors.ErrUnexpected("Failed to write audit event").WithCause(err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return errors.ErrUnexpected("Failed to sync audit log").WithCause(err)
+	}
+	return nil
+}
+
+// Query scans the whole file, it is meant for small installations and exports rather than hot paths
+func (s *FileAuditSink) Query(ctx context.Context, filter AuditFilter) ([]AuditEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, errors.ErrUnexpected("Failed to open audit log").WithCause(err)
+	}
+	defer file.Close()
+
+	var events []AuditEvent
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var event AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, errors.ErrParse("Failed to decode audit event").WithCause(err)
+		}
+		if filter.Matches(event) {
+			events = append(events, event)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.ErrUnexpected("Failed to read audit log").WithCause(err)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].OccurredAt.After(events[j].OccurredAt)
+	})
+	if filter.Limit > 0 && len(events) > filter.Limit {
+		events = events[:filter.Limit]
+	}
+	return events, nil
+}
+
+// Close closes the underlying file
+func (s *FileAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
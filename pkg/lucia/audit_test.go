@@ -0,0 +1,72 @@
+package lucia
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditTrailHoldsNoSessionIDs(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := NewFileAuditSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	service := newTestService()
+	service.SetAuditSink(sink, func(err error) { t.Error(err) })
+	service.SetImpersonationPolicy(func(ctx context.Context, actorID, targetID string) error { return nil })
+
+	actor, err := service.HandleIdentity(ctx, "saml", &UserInfo{ID: "admin", Provider: "saml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := service.HandleIdentity(ctx, "saml", &UserInfo{ID: "user", Provider: "saml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	impersonation, err := service.Impersonate(ctx, actor.ID, target.UserID, ImpersonationOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.EndImpersonation(ctx, impersonation.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Logout(ctx, actor.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sessionID := range []string{actor.ID, target.ID, impersonation.ID} {
+		if strings.Contains(string(raw), sessionID) {
+			t.Errorf("audit trail contains the session ID %s", sessionID)
+		}
+	}
+
+	events, err := sink.Query(ctx, AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var started bool
+	for _, event := range events {
+		if event.Type == AuditImpersonationStarted {
+			started = true
+			if event.SessionID != AuditSessionID(impersonation.ID) {
+				t.Errorf("SessionID = %q, want AuditSessionID of the impersonation session", event.SessionID)
+			}
+			if event.Metadata["parent_session_id"] != AuditSessionID(actor.ID) {
+				t.Errorf("parent_session_id = %q, want AuditSessionID of the actor session", event.Metadata["parent_session_id"])
+			}
+		}
+	}
+	if !started {
+		t.Error("no impersonation_started event")
+	}
+}
@@ -0,0 +1,161 @@
+package lucia
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// OpenID Connect prompt values, providers ignore the ones they do not support
+const (
+	PromptNone          = "none"
+	PromptLogin         = "login"
+	PromptConsent       = "consent"
+	PromptSelectAccount = "select_account"
+)
+
+// authURLOptions holds the per-request parameters of an authorization URL
+type authURLOptions struct {
+	scopes    []string
+	prompt    string
+	loginHint string
+	offline   bool
+	domain    string
+	maxAge    string
+	params    map[string]string
+}
+
+// AuthURLOption customizes a single authorization URL
+type AuthURLOption func(*authURLOptions)
+
+// WithScopes requests scopes on top of the ones the provider is configured with, for incremental authorization
+func WithScopes(scopes ...string) AuthURLOption {
+	return func(o *authURLOptions) {
+		o.scopes = append(o.scopes, scopes...)
+	}
+}
+
+// WithPrompt sets the prompt mode, e.g. PromptConsent or PromptSelectAccount
+func WithPrompt(prompt string) AuthURLOption {
+	return func(o *authURLOptions) {
+		o.prompt = prompt
+	}
+}
+
+// WithLoginHint pre-fills the account the user signs in with, usually an email address
+func WithLoginHint(hint string) AuthURLOption {
+	return func(o *authURLOptions) {
+		o.loginHint = hint
+	}
+}
+
+// WithMaxAge asks the provider to authenticate the user again unless they did so within maxAge,
+// providers report the time of that authentication in the auth_time claim
+func WithMaxAge(maxAge time.Duration) AuthURLOption {
+	return func(o *authURLOptions) {
+		o.maxAge = strconv.FormatInt(int64(maxAge/time.Second), 10)
+	}
+}
+
+// WithOfflineAccess asks for a refresh token. Google only returns one the first time the user consents,
+// combine it with WithPrompt(PromptConsent) to get a new one for a user who already granted access.
+func WithOfflineAccess() AuthURLOption {
+	return func(o *authURLOptions) {
+		o.offline = true
+	}
+}
+
+// WithDomainHint restricts or pre-selects the account domain, hd for Google and domain_hint for Microsoft.
+// It is only a hint, the domain must still be checked on the returned UserInfo.
+func WithDomainHint(domain string) AuthURLOption {
+	return func(o *authURLOptions) {
+		o.domain = domain
+	}
+}
+
+// reservedAuthParams are the parameters WithAuthParam cannot set: they carry the CSRF and PKCE protections, the
+// client identity and the way the callback is answered, which only the provider and its options decide
+var reservedAuthParams = map[string]bool{
+	"client_id":             true,
+	"client_secret":         true,
+	"redirect_uri":          true,
+	"response_type":         true,
+	"response_mode":         true,
+	"state":                 true,
+	"nonce":                 true,
+	"scope":                 true,
+	"code_challenge":        true,
+	"code_challenge_method": true,
+}
+
+// WithAuthParam adds an arbitrary query parameter, it overrides the optional parameters set by the provider.
+// Reserved parameters such as state, redirect_uri, client_id, response_type, scope (see WithScopes) and the
+// PKCE code_challenge are ignored.
+func WithAuthParam(key, value string) AuthURLOption {
+	return func(o *authURLOptions) {
+		if o.params == nil {
+			o.params = make(map[string]string)
+		}
+		o.params[key] = value
+	}
+}
+
+// extraParams returns the parameters of WithAuthParam, without the reserved ones
+func (o authURLOptions) extraParams() map[string]string {
+	params := make(map[string]string, len(o.params))
+	for key, value := range o.params {
+		if !reservedAuthParams[strings.ToLower(key)] {
+			params[key] = value
+		}
+	}
+	return params
+}
+
+func newAuthURLOptions(opts []AuthURLOption) authURLOptions {
+	var o authURLOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// mergeScopes returns base followed by the extra scopes it does not already contain
+func mergeScopes(base, extra []string) []string {
+	merged := append([]string(nil), base...)
+	for _, scope := range extra {
+		found := false
+		for _, s := range merged {
+			if s == scope {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, scope)
+		}
+	}
+	return merged
+}
+
+// authCodeURL builds the authorization URL of an oauth2.Config based provider.
+// params carries the provider specific parameters, the generic and extra ones are added after them.
+func (o authURLOptions) authCodeURL(config *oauth2.Config, state string, params ...oauth2.AuthCodeOption) string {
+	cfg := *config
+	cfg.Scopes = mergeScopes(config.Scopes, o.scopes)
+
+	if o.prompt != "" {
+		params = append(params, oauth2.SetAuthURLParam("prompt", o.prompt))
+	}
+	if o.loginHint != "" {
+		params = append(params, oauth2.SetAuthURLParam("login_hint", o.loginHint))
+	}
+	if o.maxAge != "" {
+		params = append(params, oauth2.SetAuthURLParam("max_age", o.maxAge))
+	}
+	for key, value := range o.extraParams() {
+		params = append(params, oauth2.SetAuthURLParam(key, value))
+	}
+	return cfg.AuthCodeURL(state, params...)
+}
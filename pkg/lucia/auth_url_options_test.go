@@ -0,0 +1,46 @@
+package lucia
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestWithAuthParamIgnoresReservedParams(t *testing.T) {
+	const redirectURI = "https://app.example/callback"
+	providers := map[string]OAuthProvider{
+		"github": NewGitHubProvider("client", "secret", redirectURI),
+		"google": NewGoogleProvider("client", "secret", redirectURI, []string{"openid"}),
+	}
+
+	tests := []struct {
+		key     string
+		value   string
+		allowed bool
+	}{
+		{key: "state", value: "attacker-state"},
+		{key: "STATE", value: "attacker-state"},
+		{key: "redirect_uri", value: "https://evil.example/callback"},
+		{key: "client_id", value: "other-client"},
+		{key: "response_type", value: "token"},
+		{key: "code_challenge", value: "attacker-challenge"},
+		{key: "scope", value: "repo"},
+		{key: "allow_signup", value: "false", allowed: true},
+	}
+	for name, provider := range providers {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.key, func(t *testing.T) {
+				authURL, err := url.Parse(provider.GetAuthURL("the-state", WithAuthParam(tt.key, tt.value)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				query := authURL.Query()
+				if got := query.Get(tt.key); (got == tt.value) != tt.allowed {
+					t.Errorf("%s = %q, allowed %v", tt.key, got, tt.allowed)
+				}
+				if query.Get("state") != "the-state" || query.Get("redirect_uri") != redirectURI || query.Get("client_id") != "client" {
+					t.Errorf("protected parameters were changed: %s", authURL)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,533 @@
+package lucia
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	stderrors "errors"
+	"html/template"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// DeviceCodeGrantType is the grant_type of RFC 8628 token requests
+const DeviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// userCodeAlphabet has no vowels, so user codes never spell words, and no look-alike characters
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+// DeviceAuthorizationStatus is the state of a device authorization
+type DeviceAuthorizationStatus string
+
+const (
+	DevicePending  DeviceAuthorizationStatus = "pending"
+	DeviceApproved DeviceAuthorizationStatus = "approved"
+	DeviceDenied   DeviceAuthorizationStatus = "denied"
+	// DeviceIssued means a session was issued, the device code cannot be used again
+	DeviceIssued DeviceAuthorizationStatus = "issued"
+)
+
+// DeviceAuthorization is a pending RFC 8628 login, the device code itself is only stored hashed
+type DeviceAuthorization struct {
+	DeviceCodeHash string
+	UserCode       string
+	ClientID       string
+	Scopes         []string
+	Status         DeviceAuthorizationStatus
+	// UserID is the encoded ID (see IDCodec) of the user who approved the authorization
+	UserID string
+	// AuthTime, AuthProvider and MFA are those of the approving session, the device session inherits them
+	AuthTime     int64
+	AuthProvider string
+	MFA          bool
+	Interval     time.Duration
+	LastPolledAt time.Time
+	ExpiresAt    time.Time
+}
+
+// DeviceStore persists device authorizations
+type DeviceStore interface {
+	CreateDeviceAuthorization(ctx context.Context, auth *DeviceAuthorization) error
+	GetDeviceAuthorizationByUserCode(ctx context.Context, userCode string) (*DeviceAuthorization, error)
+	// UpdateDeviceAuthorization applies fn atomically to the authorization with the given device code hash,
+	// nothing is saved if fn returns an error
+	UpdateDeviceAuthorization(ctx context.Context, deviceCodeHash string, fn func(auth *DeviceAuthorization) error) error
+	DeleteDeviceAuthorization(ctx context.Context, deviceCodeHash string) error
+}
+
+// DeviceAuthorizationResponse is the RFC 8628 device authorization response
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval,omitempty"`
+}
+
+// DeviceFlowConfig configures a DeviceFlow
+type DeviceFlowConfig struct {
+	// VerificationURI is the absolute URL of the page mounted with VerificationHandler
+	VerificationURI string
+	// LoginURL is where the verification page sends users without a session, with a return_to parameter.
+	// Without it they get a 401.
+	LoginURL string
+	// ClientIDs restricts the clients allowed to start a device login, empty allows any client ID
+	ClientIDs []string
+	// ExpiresIn defaults to 10 minutes
+	ExpiresIn time.Duration
+	// Interval is the minimum polling interval, it defaults to 5 seconds
+	Interval time.Duration
+	// CSRFKey keys the verification form tokens. It defaults to a random key, which only works while a single
+	// instance serves the verification page.
+	CSRFKey []byte
+}
+
+// DeviceFlow is the authorization server side of the OAuth 2.0 Device Authorization Grant (RFC 8628).
+// An approved device receives a session ID it sends as a bearer token, which the session middleware accepts.
+type DeviceFlow[U AuthUser[ID], ID UserID] struct {
+	service *AuthService[U, ID]
+	store   DeviceStore
+	cfg     DeviceFlowConfig
+}
+
+func NewDeviceFlow[U AuthUser[ID], ID UserID](service *AuthService[U, ID], store DeviceStore, cfg DeviceFlowConfig) *DeviceFlow[U, ID] {
+	if cfg.ExpiresIn <= 0 {
+		cfg.ExpiresIn = 10 * time.Minute
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 5 * time.Second
+	}
+	if len(cfg.CSRFKey) == 0 {
+		cfg.CSRFKey = make([]byte, 32)
+		rand.Read(cfg.CSRFKey)
+	}
+	return &DeviceFlow[U, ID]{
+		service: service,
+		store:   store,
+		cfg:     cfg,
+	}
+}
+
+// Authorize starts a device login for clientID
+func (f *DeviceFlow[U, ID]) Authorize(ctx context.Context, clientID string, scopes []string) (*DeviceAuthorizationResponse, error) {
+	if clientID == "" {
+		return nil, errors.NewLuciaError("InvalidRequest", "Missing client_id")
+	}
+	if !f.allowedClient(clientID) {
+		return nil, errors.NewLuciaError("InvalidClient", "Unknown client")
+	}
+
+	deviceCode := GenerateID() + GenerateID()
+	userCode := generateUserCode()
+	auth := &DeviceAuthorization{
+		DeviceCodeHash: hashToken(deviceCode),
+		UserCode:       userCode,
+		ClientID:       clientID,
+		Scopes:         scopes,
+		Status:         DevicePending,
+		Interval:       f.cfg.Interval,
+		ExpiresAt:      time.Now().Add(f.cfg.ExpiresIn),
+	}
+	if err := f.store.CreateDeviceAuthorization(ctx, auth); err != nil {
+		return nil, errors.NewLuciaError("DatabaseError", "Failed to create device authorization").WithCause(err).WithOp("CreateDeviceAuthorization")
+	}
+
+	return &DeviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationURI:         f.cfg.VerificationURI,
+		VerificationURIComplete: f.cfg.VerificationURI + "?" + url.Values{"user_code": {userCode}}.Encode(),
+		ExpiresIn:               int64(f.cfg.ExpiresIn.Seconds()),
+		Interval:                int64(f.cfg.Interval.Seconds()),
+	}, nil
+}
+
+func (f *DeviceFlow[U, ID]) allowedClient(clientID string) bool {
+	if len(f.cfg.ClientIDs) == 0 {
+		return true
+	}
+	for _, id := range f.cfg.ClientIDs {
+		if id == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// Lookup returns the pending authorization of a user code, for the verification page to show what is being approved
+func (f *DeviceFlow[U, ID]) Lookup(ctx context.Context, userCode string) (*DeviceAuthorization, error) {
+	auth, err := f.store.GetDeviceAuthorizationByUserCode(ctx, normalizeUserCode(userCode))
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, errors.NewLuciaError("InvalidGrant", "Unknown code").WithCause(err)
+		}
+		return nil, errors.NewLuciaError("DatabaseError", "Failed to fetch device authorization").WithCause(err).WithOp("GetDeviceAuthorizationByUserCode")
+	}
+	if time.Now().After(auth.ExpiresAt) {
+		return nil, errors.NewLuciaError("ExpiredToken", "The code has expired")
+	}
+	if auth.Status != DevicePending {
+		return nil, errors.NewLuciaError("InvalidGrant", "The code has already been used")
+	}
+	return auth, nil
+}
+
+// Approve grants the device holding userCode a session for the user of session, authenticated as session was.
+// Impersonation and restricted sessions cannot approve devices, see checkDelegation.
+func (f *DeviceFlow[U, ID]) Approve(ctx context.Context, userCode string, session *Session[ID]) error {
+	if err := checkDelegation(session); err != nil {
+		return err
+	}
+	userID := f.service.codec.Encode(session.UserID)
+	return f.decide(ctx, userCode, func(auth *DeviceAuthorization) {
+		auth.Status = DeviceApproved
+		auth.UserID = userID
+		auth.AuthTime = session.AuthTime
+		auth.AuthProvider = session.AuthProvider
+		auth.MFA = session.MFA
+	})
+}
+
+// Deny rejects the device holding userCode, its next poll gets access_denied
+func (f *DeviceFlow[U, ID]) Deny(ctx context.Context, userCode string) error {
+	return f.decide(ctx, userCode, func(auth *DeviceAuthorization) {
+		auth.Status = DeviceDenied
+	})
+}
+
+func (f *DeviceFlow[U, ID]) decide(ctx context.Context, userCode string, apply func(auth *DeviceAuthorization)) error {
+	auth, err := f.Lookup(ctx, userCode)
+	if err != nil {
+		return err
+	}
+	err = f.store.UpdateDeviceAuthorization(ctx, auth.DeviceCodeHash, func(auth *DeviceAuthorization) error {
+		// Checked again under the store lock, the device may have been decided concurrently
+		if auth.Status != DevicePending {
+			return errors.NewLuciaError("InvalidGrant", "The code has already been used")
+		}
+		apply(auth)
+		return nil
+	})
+	if err != nil {
+		if errors.IsLuciaError(err) {
+			return err
+		}
+		return errors.NewLuciaError("DatabaseError", "Failed to update device authorization").WithCause(err).WithOp("UpdateDeviceAuthorization")
+	}
+	return nil
+}
+
+// Poll exchanges an approved device code for a session. Until then it returns the RFC 8628 errors
+// AuthorizationPending, SlowDown (the interval grows by 5 seconds), AccessDenied and ExpiredToken.
+func (f *DeviceFlow[U, ID]) Poll(ctx context.Context, clientID, deviceCode string) (*Session[ID], error) {
+	if deviceCode == "" {
+		return nil, errors.NewLuciaError("InvalidRequest", "Missing device_code")
+	}
+
+	now := time.Now()
+	hash := hashToken(deviceCode)
+	var outcome error
+	var approved DeviceAuthorization
+	err := f.store.UpdateDeviceAuthorization(ctx, hash, func(auth *DeviceAuthorization) error {
+		if auth.ClientID != clientID {
+			return errors.NewLuciaError("InvalidGrant", "The device code was issued to another client")
+		}
+		if now.After(auth.ExpiresAt) {
+			return errors.NewLuciaError("ExpiredToken", "The device code has expired")
+		}
+
+		switch auth.Status {
+		case DeviceDenied:
+			return errors.NewLuciaError("AccessDenied", "The user denied the request")
+		case DeviceIssued:
+			return errors.NewLuciaError("InvalidGrant", "The device code has already been used")
+		case DeviceApproved:
+			// Marking the code as issued under the lock makes sure a single poll gets the session
+			auth.Status = DeviceIssued
+			approved = *auth
+			return nil
+		}
+
+		// The slow_down outcome must still be saved, so it is reported after the update
+		if !auth.LastPolledAt.IsZero() && now.Sub(auth.LastPolledAt) < auth.Interval {
+			auth.Interval += 5 * time.Second
+			outcome = errors.NewLuciaError("SlowDown", "Polling too fast")
+		} else {
+			outcome = errors.NewLuciaError("AuthorizationPending", "The user has not approved the request yet")
+		}
+		auth.LastPolledAt = now
+		return nil
+	})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, errors.NewLuciaError("InvalidGrant", "Unknown device code").WithCause(err)
+		}
+		if errors.IsLuciaError(err) {
+			return nil, err
+		}
+		return nil, errors.NewLuciaError("DatabaseError", "Failed to update device authorization").WithCause(err).WithOp("UpdateDeviceAuthorization")
+	}
+	if outcome != nil {
+		return nil, outcome
+	}
+
+	// The authorization has served its purpose, a failed delete only leaves an issued record behind
+	_ = f.store.DeleteDeviceAuthorization(ctx, hash)
+
+	id, err := f.service.codec.Decode(approved.UserID)
+	if err != nil {
+		return nil, errors.NewLuciaError("UnexpectedError", "Invalid user ID on device authorization").WithCause(err)
+	}
+	// The device is only as authenticated as the session that approved it, and only gets the scopes it asked for
+	return f.service.storeSession(ctx, &Session[ID]{
+		ID:           GenerateID(),
+		UserID:       id,
+		ExpiresAt:    time.Now().Add(24 * time.Hour).Unix(),
+		Scopes:       approved.Scopes,
+		AuthTime:     approved.AuthTime,
+		AuthProvider: approved.AuthProvider,
+		MFA:          approved.MFA,
+	})
+}
+
+// AuthorizationHandler serves the device authorization endpoint (POST client_id and scope)
+func (f *DeviceFlow[U, ID]) AuthorizationHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		resp, err := f.Authorize(c.UserContext(), c.FormValue("client_id"), strings.Fields(c.FormValue("scope")))
+		if err != nil {
+			return writeOAuthError(c, err)
+		}
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		return c.JSON(resp)
+	}
+}
+
+// TokenHandler serves the polling token endpoint, the access token is a session ID to send as a bearer token
+func (f *DeviceFlow[U, ID]) TokenHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.FormValue("grant_type") != DeviceCodeGrantType {
+			return writeOAuthError(c, errors.NewLuciaError("UnsupportedGrantType", "Unsupported grant_type"))
+		}
+		session, err := f.Poll(c.UserContext(), c.FormValue("client_id"), c.FormValue("device_code"))
+		if err != nil {
+			return writeOAuthError(c, err)
+		}
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		return c.JSON(fiber.Map{
+			"access_token": session.ID,
+			"token_type":   "Bearer",
+			"expires_in":   session.ExpiresAt - time.Now().Unix(),
+		})
+	}
+}
+
+// VerificationHandler serves the page where a signed-in user enters the user code and approves or denies
+// the device. Mount it with GET and POST behind SessionMiddleware.
+func (f *DeviceFlow[U, ID]) VerificationHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		session := GetSession[ID](c)
+		if session == nil {
+			if f.cfg.LoginURL == "" {
+				return errors.ErrUnauthorized("Authentication required")
+			}
+			return c.Redirect(f.cfg.LoginURL + "?" + url.Values{"return_to": {c.OriginalURL()}}.Encode())
+		}
+
+		page := devicePage{UserCode: c.FormValue("user_code"), CSRF: deviceCSRFToken(f.cfg.CSRFKey, session.ID)}
+		if c.Method() == fiber.MethodPost {
+			if subtle.ConstantTimeCompare([]byte(c.FormValue("csrf")), []byte(page.CSRF)) != 1 {
+				return errors.ErrForbidden("Invalid CSRF token")
+			}
+			var err error
+			switch c.FormValue("action") {
+			case "approve":
+				err = f.Approve(c.UserContext(), page.UserCode, session)
+				page.Done = "Device approved, you can return to your device."
+			case "deny":
+				err = f.Deny(c.UserContext(), page.UserCode)
+				page.Done = "Device denied."
+			default:
+				err = errors.NewLuciaError("InvalidRequest", "Unknown action")
+			}
+			if err != nil {
+				page.Done, page.Error = "", deviceErrorMessage(err)
+			}
+		} else if page.UserCode != "" {
+			auth, err := f.Lookup(c.UserContext(), page.UserCode)
+			if err != nil {
+				page.Error = deviceErrorMessage(err)
+			} else {
+				page.ClientID, page.Scopes = auth.ClientID, auth.Scopes
+			}
+		}
+
+		var buf strings.Builder
+		if err := devicePageTemplate.Execute(&buf, page); err != nil {
+			return errors.ErrUnexpected("Failed to render verification page").WithCause(err)
+		}
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		c.Set("X-Frame-Options", "DENY")
+		c.Type("html")
+		return c.SendString(buf.String())
+	}
+}
+
+// deviceErrorMessage keeps store failures out of the page
+func deviceErrorMessage(err error) string {
+	var luciaErr errors.LuciaError
+	if stderrors.As(err, &luciaErr) {
+		if _, ok := oauthErrorCodes[luciaErr.Type]; ok {
+			return luciaErr.Message
+		}
+	}
+	return "Something went wrong, please try again."
+}
+
+// deviceCSRFToken derives the verification form token from the session ID, which cross-site pages cannot read.
+// It is keyed so that the token reveals nothing usable about the session ID.
+func deviceCSRFToken(key []byte, sessionID string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("lucia-device-verification:" + sessionID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+type devicePage struct {
+	UserCode string
+	CSRF     string
+	ClientID string
+	Scopes   []string
+	Error    string
+	Done     string
+}
+
+var devicePageTemplate = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Connect a device</title></head>
+<body>
+<h1>Connect a device</h1>
+{{if .Done}}<p>{{.Done}}</p>{{else}}
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+{{if .ClientID}}<p><strong>{{.ClientID}}</strong> is requesting access{{if .Scopes}} to: {{range $i, $s := .Scopes}}{{if $i}}, {{end}}{{$s}}{{end}}{{end}}.</p>{{end}}
+<form method="post">
+<input type="hidden" name="csrf" value="{{.CSRF}}">
+<label>Code shown on your device <input name="user_code" value="{{.UserCode}}" autocomplete="off" autofocus></label>
+<button name="action" value="approve">Approve</button>
+<button name="action" value="deny">Deny</button>
+</form>
+{{end}}
+</body>
+</html>`))
+
+// generateUserCode returns an eight character code formatted as XXXX-XXXX
+func generateUserCode() string {
+	code := make([]byte, 0, 9)
+	b := make([]byte, 1)
+	for len(code) < 9 {
+		if len(code) == 4 {
+			code = append(code, '-')
+			continue
+		}
+		rand.Read(b)
+		// Reject the values that would bias the modulo
+		if int(b[0]) >= 256-256%len(userCodeAlphabet) {
+			continue
+		}
+		code = append(code, userCodeAlphabet[int(b[0])%len(userCodeAlphabet)])
+	}
+	return string(code)
+}
+
+// normalizeUserCode accepts user codes typed in lower case, with or without the dash
+func normalizeUserCode(userCode string) string {
+	var code []byte
+	for i := 0; i < len(userCode); i++ {
+		c := upper(userCode[i])
+		if strings.IndexByte(userCodeAlphabet, c) >= 0 {
+			code = append(code, c)
+		}
+	}
+	if len(code) != 8 {
+		return string(code)
+	}
+	return string(code[:4]) + "-" + string(code[4:])
+}
+
+// hashToken returns the hex SHA-256 of a bearer secret, so stores never hold usable secrets
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// MemoryDeviceStore is an in-process DeviceStore, expired authorizations are evicted on create
+type MemoryDeviceStore struct {
+	mu    sync.Mutex
+	auths map[string]*DeviceAuthorization
+}
+
+func NewMemoryDeviceStore() *MemoryDeviceStore {
+	return &MemoryDeviceStore{auths: make(map[string]*DeviceAuthorization)}
+}
+
+func (s *MemoryDeviceStore) CreateDeviceAuthorization(ctx context.Context, auth *DeviceAuthorization) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for hash, a := range s.auths {
+		if now.After(a.ExpiresAt) {
+			delete(s.auths, hash)
+		}
+	}
+	for _, a := range s.auths {
+		if a.UserCode == auth.UserCode {
+			return errors.ErrConflict("User code already exists")
+		}
+	}
+	stored := *auth
+	s.auths[auth.DeviceCodeHash] = &stored
+	return nil
+}
+
+func (s *MemoryDeviceStore) GetDeviceAuthorizationByUserCode(ctx context.Context, userCode string) (*DeviceAuthorization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range s.auths {
+		if a.UserCode == userCode {
+			auth := *a
+			return &auth, nil
+		}
+	}
+	return nil, errors.ErrNotFound("Device authorization not found")
+}
+
+func (s *MemoryDeviceStore) UpdateDeviceAuthorization(ctx context.Context, deviceCodeHash string, fn func(auth *DeviceAuthorization) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.auths[deviceCodeHash]
+	if !ok {
+		return errors.ErrNotFound("Device authorization not found")
+	}
+	updated := *a
+	if err := fn(&updated); err != nil {
+		return err
+	}
+	s.auths[deviceCodeHash] = &updated
+	return nil
+}
+
+func (s *MemoryDeviceStore) DeleteDeviceAuthorization(ctx context.Context, deviceCodeHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.auths, deviceCodeHash)
+	return nil
+}
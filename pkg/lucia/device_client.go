@@ -0,0 +1,134 @@
+package lucia
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+)
+
+// DeviceClient is the device side of the OAuth 2.0 Device Authorization Grant, for CLIs logging in to a
+// DeviceFlow or any other RFC 8628 authorization server
+type DeviceClient struct {
+	ClientID               string
+	DeviceAuthorizationURL string
+	TokenURL               string
+	// HTTPClient defaults to http.DefaultClient
+	HTTPClient *http.Client
+}
+
+// Start requests a device and user code, show the user code and verification URI to the user and then call Poll
+func (c *DeviceClient) Start(ctx context.Context, scopes ...string) (*DeviceAuthorizationResponse, error) {
+	values := url.Values{"client_id": {c.ClientID}}
+	if len(scopes) > 0 {
+		values.Set("scope", strings.Join(scopes, " "))
+	}
+
+	var resp DeviceAuthorizationResponse
+	if err := c.post(ctx, c.DeviceAuthorizationURL, values, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Poll waits until the user approves or denies the device, honoring the polling interval and slow_down.
+// It returns AccessDenied, ExpiredToken or the error of ctx when the login does not complete.
+func (c *DeviceClient) Poll(ctx context.Context, auth *DeviceAuthorizationResponse) (*OAuthToken, error) {
+	interval := time.Duration(auth.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(auth.ExpiresIn) * time.Second)
+
+	values := url.Values{
+		"grant_type":  {DeviceCodeGrantType},
+		"device_code": {auth.DeviceCode},
+		"client_id":   {c.ClientID},
+	}
+	for {
+		if auth.ExpiresIn > 0 && time.Now().Add(interval).After(deadline) {
+			return nil, errors.NewLuciaError("ExpiredToken", "The device code has expired")
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+
+		var resp struct {
+			AccessToken  string `json:"access_token"`
+			RefreshToken string `json:"refresh_token"`
+			ExpiresIn    int64  `json:"expires_in"`
+			IDToken      string `json:"id_token"`
+		}
+		err := c.post(ctx, c.TokenURL, values, &resp)
+		switch {
+		case err == nil:
+			token := &OAuthToken{
+				AccessToken:  resp.AccessToken,
+				RefreshToken: resp.RefreshToken,
+				IDToken:      resp.IDToken,
+			}
+			if resp.ExpiresIn > 0 {
+				token.ExpiresIn = time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second).Unix()
+			}
+			return token, nil
+		case errorType(err) == "AuthorizationPending":
+		case errorType(err) == "SlowDown":
+			interval += 5 * time.Second
+		default:
+			return nil, err
+		}
+	}
+}
+
+// post sends a form to an OAuth endpoint and decodes the JSON response into out, OAuth error responses
+// become the matching LuciaError
+func (c *DeviceClient) post(ctx context.Context, endpoint string, values url.Values, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(values.Encode()))
+	if err != nil {
+		return errors.ErrUnexpected("Failed to create request").WithCause(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return errors.ErrUnexpected("Failed to reach the authorization server").WithCause(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return errors.ErrUnexpected("Failed to read response").WithCause(err)
+	}
+
+	// Some servers, GitHub among them, answer pending polls with a 200 carrying the error
+	var oauthErr struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if json.Unmarshal(body, &oauthErr) == nil && oauthErr.Error != "" {
+		return oauthError(oauthErr.Error, oauthErr.ErrorDescription).WithStatusCode(resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.ErrUnexpected("Unexpected response from the authorization server").WithStatusCode(resp.StatusCode)
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return errors.ErrUnexpected("Failed to decode response").WithCause(err)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package lucia
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDeviceApproval(t *testing.T) {
+	ctx := context.Background()
+	authTime := time.Now().Add(-2 * time.Hour).Unix()
+	actorID := "admin"
+
+	tests := []struct {
+		name     string
+		approver Session[string]
+		wantErr  string
+	}{
+		{
+			name:     "user session",
+			approver: Session[string]{ID: "s1", UserID: "user", AuthTime: authTime, AuthProvider: "google", MFA: true},
+		},
+		{
+			name:     "impersonation session",
+			approver: Session[string]{ID: "s2", UserID: "user", ImpersonatorID: &actorID, ParentSessionID: "s0", Scopes: []string{ImpersonationScope}},
+			wantErr:  "AccessDenied",
+		},
+		{
+			name:     "restricted session",
+			approver: Session[string]{ID: "s3", UserID: "user", Scopes: []string{"read"}},
+			wantErr:  "AccessDenied",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flow := NewDeviceFlow(newTestService(), NewMemoryDeviceStore(), DeviceFlowConfig{Interval: time.Nanosecond})
+			resp, err := flow.Authorize(ctx, "tv", []string{"media:read"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = flow.Approve(ctx, resp.UserCode, &tt.approver)
+			if tt.wantErr != "" {
+				if errorType(err) != tt.wantErr {
+					t.Fatalf("Approve() error = %v, want %s", err, tt.wantErr)
+				}
+				if _, err := flow.Poll(ctx, "tv", resp.DeviceCode); errorType(err) != "AuthorizationPending" {
+					t.Errorf("Poll() after a refused approval = %v, want AuthorizationPending", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			session, err := flow.Poll(ctx, "tv", resp.DeviceCode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if session.UserID != tt.approver.UserID {
+				t.Errorf("UserID = %q, want %q", session.UserID, tt.approver.UserID)
+			}
+			if session.AuthTime != authTime || session.AuthProvider != "google" || !session.MFA {
+				t.Errorf("device session authentication = %d %q %v, want the approving session's", session.AuthTime, session.AuthProvider, session.MFA)
+			}
+			if session.AuthenticatedWithin(time.Hour) {
+				t.Error("a device approved from a stale session passes a recent authentication check")
+			}
+			if len(session.Scopes) != 1 || session.Scopes[0] != "media:read" {
+				t.Errorf("Scopes = %v, want the requested scopes", session.Scopes)
+			}
+			if _, err := flow.Poll(ctx, "tv", resp.DeviceCode); err == nil {
+				t.Error("the device code was accepted twice")
+			}
+		})
+	}
+}
+
+func TestDeviceCSRFTokenIsKeyed(t *testing.T) {
+	a := deviceCSRFToken([]byte("key-a"), "session")
+	if a != deviceCSRFToken([]byte("key-a"), "session") {
+		t.Error("the token is not stable for a key and session")
+	}
+	if a == deviceCSRFToken([]byte("key-b"), "session") {
+		t.Error("the token does not depend on the key")
+	}
+	if a == deviceCSRFToken([]byte("key-a"), "other-session") {
+		t.Error("the token does not depend on the session")
+	}
+}
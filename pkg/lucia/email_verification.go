@@ -0,0 +1,148 @@
+package lucia
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// EmailVerification is a pending proof that a user controls an email address, the token itself is only stored hashed
+type EmailVerification struct {
+	TokenHash string
+	// UserID is the encoded ID (see IDCodec) of the user
+	UserID    string
+	Email     string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// EmailVerificationStore persists pending email verifications
+type EmailVerificationStore interface {
+	CreateEmailVerification(ctx context.Context, verification *EmailVerification) error
+	// ConsumeEmailVerification deletes and returns the verification, so a token can only be used once
+	ConsumeEmailVerification(ctx context.Context, tokenHash string) (*EmailVerification, error)
+}
+
+// EmailVerifier verifies email addresses providers did not vouch for, by sending the user a single use token.
+// Sending the email is up to the application, OnEmailVerified hooks receive the verified addresses.
+// It runs after signup and its result never reaches SignupPolicy: under RequireVerifiedEmail or AllowedDomains
+// an identity whose provider does not vouch for the email is rejected before any user exists to verify it.
+// Use it with policies that accept unverified emails, e.g. to gate features on a verified address.
+type EmailVerifier[U AuthUser[ID], ID UserID] struct {
+	service *AuthService[U, ID]
+	store   EmailVerificationStore
+	ttl     time.Duration
+}
+
+// NewEmailVerifier creates an EmailVerifier whose tokens are valid for ttl, 24 hours if zero
+func NewEmailVerifier[U AuthUser[ID], ID UserID](service *AuthService[U, ID], store EmailVerificationStore, ttl time.Duration) *EmailVerifier[U, ID] {
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	return &EmailVerifier[U, ID]{
+		service: service,
+		store:   store,
+		ttl:     ttl,
+	}
+}
+
+// CreateToken starts the verification of email for userID, the token is only returned here and goes into
+// the link emailed to the address
+func (v *EmailVerifier[U, ID]) CreateToken(ctx context.Context, userID ID, email string) (string, *EmailVerification, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if emailDomain(email) == "" {
+		return "", nil, errors.ErrBadRequest("Invalid email address")
+	}
+
+	token := GenerateID() + GenerateID()
+	verification := &EmailVerification{
+		TokenHash: hashToken(token),
+		UserID:    v.service.codec.Encode(userID),
+		Email:     email,
+		ExpiresAt: time.Now().Add(v.ttl),
+		CreatedAt: time.Now(),
+	}
+	if err := v.store.CreateEmailVerification(ctx, verification); err != nil {
+		return "", nil, err
+	}
+	return token, verification, nil
+}
+
+// Verify redeems a token and runs the OnEmailVerified hooks
+func (v *EmailVerifier[U, ID]) Verify(ctx context.Context, token string) (*EmailVerifiedEvent[ID], error) {
+	verification, err := v.store.ConsumeEmailVerification(ctx, hashToken(token))
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, errors.ErrNotFound("Verification token not found")
+		}
+		return nil, err
+	}
+	if time.Now().After(verification.ExpiresAt) {
+		return nil, errors.ErrBadRequest("Verification token has expired")
+	}
+	userID, err := v.service.codec.Decode(verification.UserID)
+	if err != nil {
+		return nil, errors.NewLuciaError("UnexpectedError", "Invalid user ID on email verification").WithCause(err)
+	}
+
+	event := EmailVerifiedEvent[ID]{UserID: userID, Email: verification.Email}
+	runAfter(ctx, &v.service.hooks.mu, &v.service.hooks.onEmailVerified, event)
+	return &event, nil
+}
+
+// VerifyHandler redeems the token form field of a POST and answers the verified email as JSON.
+// Email scanners follow links, so the emailed link should open a page posting the token rather than
+// redeeming it on GET.
+func (v *EmailVerifier[U, ID]) VerifyHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token := c.FormValue("token")
+		if token == "" {
+			return errors.ErrBadRequest("Missing token")
+		}
+		event, err := v.Verify(c.UserContext(), token)
+		if err != nil {
+			return err
+		}
+		return c.JSON(fiber.Map{"email": event.Email})
+	}
+}
+
+// MemoryEmailVerificationStore is an in-process EmailVerificationStore, expired verifications are evicted on create
+type MemoryEmailVerificationStore struct {
+	mu            sync.Mutex
+	verifications map[string]*EmailVerification
+}
+
+func NewMemoryEmailVerificationStore() *MemoryEmailVerificationStore {
+	return &MemoryEmailVerificationStore{verifications: make(map[string]*EmailVerification)}
+}
+
+func (s *MemoryEmailVerificationStore) CreateEmailVerification(ctx context.Context, verification *EmailVerification) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for hash, v := range s.verifications {
+		if now.After(v.ExpiresAt) {
+			delete(s.verifications, hash)
+		}
+	}
+	stored := *verification
+	s.verifications[verification.TokenHash] = &stored
+	return nil
+}
+
+func (s *MemoryEmailVerificationStore) ConsumeEmailVerification(ctx context.Context, tokenHash string) (*EmailVerification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.verifications[tokenHash]
+	if !ok {
+		return nil, errors.ErrNotFound("Email verification not found")
+	}
+	delete(s.verifications, tokenHash)
+	return v, nil
+}
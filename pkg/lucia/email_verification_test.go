@@ -0,0 +1,103 @@
+package lucia
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+)
+
+// prefixCodec stores string IDs with a prefix, standing in for an application specific codec
+type prefixCodec struct{}
+
+func (prefixCodec) Encode(id string) string { return "usr_" + id }
+
+func (prefixCodec) Decode(s string) (string, error) { return s[len("usr_"):], nil }
+
+func TestSetIDCodecIsSharedByHelpers(t *testing.T) {
+	service := newTestService()
+	service.SetIDCodec(prefixCodec{})
+	verifier := NewEmailVerifier(service, NewMemoryEmailVerificationStore(), 0)
+
+	_, verification, err := verifier.CreateToken(context.Background(), "42", "user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verification.UserID != "usr_42" {
+		t.Errorf("UserID = %q, want the service codec encoding %q", verification.UserID, "usr_42")
+	}
+}
+
+func TestEmailVerifier(t *testing.T) {
+	tests := []struct {
+		name string
+		// before runs between the creation and the redemption of the token
+		before    func(store *MemoryEmailVerificationStore, token string)
+		wantCheck func(err error) bool
+	}{
+		{name: "valid token"},
+		{
+			name: "expired token",
+			before: func(store *MemoryEmailVerificationStore, token string) {
+				store.verifications[hashToken(token)].ExpiresAt = time.Now().Add(-time.Second)
+			},
+			wantCheck: errors.IsBadRequest,
+		},
+		{
+			name:      "unknown token",
+			before:    func(store *MemoryEmailVerificationStore, token string) { delete(store.verifications, hashToken(token)) },
+			wantCheck: errors.IsNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			service := newTestService()
+			store := NewMemoryEmailVerificationStore()
+			verifier := NewEmailVerifier(service, store, time.Hour)
+			var verified []EmailVerifiedEvent[string]
+			service.Hooks().OnEmailVerified(func(_ context.Context, e EmailVerifiedEvent[string]) { verified = append(verified, e) })
+
+			token, verification, err := verifier.CreateToken(ctx, "42", " Jane@Example.com ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if verification.Email != "jane@example.com" || verification.TokenHash == token {
+				t.Errorf("verification = %+v, want the normalized email and a hashed token", verification)
+			}
+			if tt.before != nil {
+				tt.before(store, token)
+			}
+
+			event, err := verifier.Verify(ctx, token)
+			if tt.wantCheck != nil {
+				if !tt.wantCheck(err) {
+					t.Errorf("Verify() error = %v", err)
+				}
+				if len(verified) != 0 {
+					t.Errorf("OnEmailVerified ran for a rejected token: %v", verified)
+				}
+				return
+			}
+			if err != nil || event.UserID != "42" || event.Email != "jane@example.com" {
+				t.Fatalf("Verify() = %+v, %v, want user 42 and the email", event, err)
+			}
+			if len(verified) != 1 || verified[0] != *event {
+				t.Errorf("OnEmailVerified events = %v, want the verification", verified)
+			}
+
+			// Tokens are single use
+			if _, err := verifier.Verify(ctx, token); !errors.IsNotFound(err) {
+				t.Errorf("second Verify() error = %v, want NotFound", err)
+			}
+		})
+	}
+}
+
+func TestEmailVerifierRejectsInvalidEmail(t *testing.T) {
+	verifier := NewEmailVerifier(newTestService(), NewMemoryEmailVerificationStore(), 0)
+	if _, _, err := verifier.CreateToken(context.Background(), "42", "not-an-email"); !errors.IsBadRequest(err) {
+		t.Errorf("CreateToken() error = %v, want BadRequest", err)
+	}
+}
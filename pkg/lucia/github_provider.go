@@ -1,9 +1,11 @@
 package lucia
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"strings"
@@ -16,23 +18,54 @@ type GitHubProvider struct {
 	clientID     string
 	clientSecret string
 	redirectURI  string
+	authURL      string
+	tokenURL     string
+	apiURL       string
+	httpClient   *http.Client
 }
 
-func NewGitHubProvider(clientID, clientSecret, redirectURI string) *GitHubProvider {
+// NewGitHubProvider creates a GitHub provider, use WithBaseURL to point it at a GitHub Enterprise Server
+func NewGitHubProvider(clientID, clientSecret, redirectURI string, opts ...ProviderOption) *GitHubProvider {
+	o := newProviderOptions(opts)
+
+	base, api := "https://github.com", "https://api.github.com"
+	if o.baseURL != "" {
+		// GitHub Enterprise Server serves the REST API under /api/v3 of the same host
+		base = strings.TrimSuffix(o.baseURL, "/")
+		api = base + "/api/v3"
+	}
+
 	return &GitHubProvider{
 		clientID:     clientID,
 		clientSecret: clientSecret,
 		redirectURI:  redirectURI,
+		authURL:      or(o.authURL, base+"/login/oauth/authorize"),
+		tokenURL:     or(o.tokenURL, base+"/login/oauth/access_token"),
+		apiURL:       strings.TrimSuffix(or(o.apiURL, api), "/"),
+		httpClient:   o.httpClient,
 	}
 }
 
-func (p *GitHubProvider) GetAuthURL(state string) string {
-	return "https://github.com/login/oauth/authorize?" + url.Values{
+// GetAuthURL builds the authorization URL, GitHub has no offline access, domain hint nor max_age and ignores them.
+// It cannot force a new authentication either, prompt=login is dropped.
+func (p *GitHubProvider) GetAuthURL(state string, opts ...AuthURLOption) string {
+	o := newAuthURLOptions(opts)
+	values := url.Values{
 		"client_id":    {p.clientID},
 		"redirect_uri": {p.redirectURI},
 		"state":        {state},
-		"scope":        {"user:email"},
-	}.Encode()
+		"scope":        {strings.Join(mergeScopes([]string{"user:email"}, o.scopes), " ")},
+	}
+	if o.prompt != "" && o.prompt != PromptLogin {
+		values.Set("prompt", o.prompt)
+	}
+	if o.loginHint != "" {
+		values.Set("login", o.loginHint)
+	}
+	for key, value := range o.extraParams() {
+		values.Set(key, value)
+	}
+	return p.authURL + "?" + values.Encode()
 }
 
 func (p *GitHubProvider) ExchangeCode(ctx context.Context, code string) (*OAuthToken, error) {
@@ -43,32 +76,52 @@ func (p *GitHubProvider) ExchangeCode(ctx context.Context, code string) (*OAuthT
 		"redirect_uri":  {p.redirectURI},
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", "https://github.com/login/oauth/access_token", strings.NewReader(values.Encode()))
+	req, err := http.NewRequestWithContext(ctx, "POST", p.tokenURL, strings.NewReader(values.Encode()))
 	if err != nil {
-		return nil, errors.ErrUnexpected(fmt.Sprintf("Failed to create request: %v", err))
+		return nil, errors.ErrUnexpected("Failed to create request").WithCause(err)
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Accept", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := p.httpClient.Do(req)
 	if err != nil {
-		return nil, errors.ErrUnexpected(fmt.Sprintf("Failed to exchange code: %v", err))
+		return nil, errors.ErrUnexpected("Failed to exchange code").WithCause(err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, errors.ErrUnauthorized(fmt.Sprintf("Failed to exchange code: status code %d", resp.StatusCode))
+		return nil, statusError("Failed to exchange code", "github", resp)
 	}
 
-	var token OAuthToken
-	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
-		return nil, errors.ErrUnexpected(fmt.Sprintf("Failed to decode token response: %v", err))
-	}
+	return decodeGitHubToken(resp, "Failed to exchange code")
+}
+
+// githubTokenResponse is the token endpoint response, GitHub answers failures such as an expired code with a 200
+type githubTokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
 
-	// GitHub tokens typically expire after 8 hours
-	token.ExpiresIn = time.Now().Add(8 * time.Hour).Unix()
+func decodeGitHubToken(resp *http.Response, message string) (*OAuthToken, error) {
+	var body githubTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, errors.ErrUnexpected("Failed to decode token response").WithCause(err)
+	}
+	if body.Error != "" {
+		return nil, errors.ErrUnauthorized(message).WithProvider("github").WithCause(fmt.Errorf("%s: %s", body.Error, body.ErrorDescription))
+	}
 
-	return &token, nil
+	token := &OAuthToken{AccessToken: body.AccessToken, RefreshToken: body.RefreshToken}
+	if body.ExpiresIn > 0 {
+		token.ExpiresIn = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second).Unix()
+	} else {
+		// GitHub tokens typically expire after 8 hours
+		token.ExpiresIn = time.Now().Add(8 * time.Hour).Unix()
+	}
+	return token, nil
 }
 
 func (p *GitHubProvider) GetUserInfo(ctx context.Context, token *OAuthToken) (*UserInfo, error) {
@@ -86,22 +139,26 @@ func (p *GitHubProvider) GetUserInfo(ctx context.Context, token *OAuthToken) (*U
 		}
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", "https://api.github.com/user", nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", p.apiURL+"/user", nil)
 	if err != nil {
-		return nil, errors.ErrUnexpected(fmt.Sprintf("Failed to create request: %v", err))
+		return nil, errors.ErrUnexpected("Failed to create request").WithCause(err)
 	}
 	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := p.httpClient.Do(req)
 	if err != nil {
-		return nil, errors.ErrUnexpected(fmt.Sprintf("Failed to get user info: %v", err))
+		return nil, errors.ErrUnexpected("Failed to get user info").WithCause(err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, errors.ErrUnauthorized(fmt.Sprintf("Failed to get user info: status code %d", resp.StatusCode))
+		return nil, statusError("Failed to get user info", "github", resp)
 	}
 
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.ErrUnexpected("Failed to read user info").WithCause(err)
+	}
 	var githubUser struct {
 		ID        int    `json:"id"`
 		Login     string `json:"login"`
@@ -109,8 +166,8 @@ func (p *GitHubProvider) GetUserInfo(ctx context.Context, token *OAuthToken) (*U
 		Name      string `json:"name"`
 		AvatarURL string `json:"avatar_url"`
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&githubUser); err != nil {
-		return nil, errors.ErrUnexpected(fmt.Sprintf("Failed to decode user info: %v", err))
+	if err := json.Unmarshal(raw, &githubUser); err != nil {
+		return nil, errors.ErrUnexpected("Failed to decode user info").WithCause(err)
 	}
 
 	userInfo := &UserInfo{
@@ -118,9 +175,20 @@ func (p *GitHubProvider) GetUserInfo(ctx context.Context, token *OAuthToken) (*U
 		Email:    githubUser.Email,
 		Name:     githubUser.Name,
 		Provider: "github",
+		Raw:      raw,
 		Token:    token, // Include the potentially refreshed token
 	}
 
+	// The profile email is empty for users keeping their address private and never says whether it is verified
+	emails, err := p.getEmails(ctx, token.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	if email, ok := primaryVerifiedEmail(emails); ok {
+		userInfo.Email = email
+		userInfo.EmailVerified = true
+	}
+
 	if githubUser.AvatarURL != "" {
 		userInfo.ProfilePicture = &githubUser.AvatarURL
 	}
@@ -128,6 +196,57 @@ func (p *GitHubProvider) GetUserInfo(ctx context.Context, token *OAuthToken) (*U
 	return userInfo, nil
 }
 
+type githubEmail struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+// getEmails lists the addresses of the user, tokens without the user:email scope get an empty list
+func (p *GitHubProvider) getEmails(ctx context.Context, accessToken string) ([]githubEmail, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.apiURL+"/user/emails", nil)
+	if err != nil {
+		return nil, errors.ErrUnexpected("Failed to create request").WithCause(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.ErrUnexpected("Failed to get user emails").WithCause(err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusForbidden, http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, statusError("Failed to get user emails", "github", resp)
+	}
+
+	var emails []githubEmail
+	if err := json.NewDecoder(resp.Body).Decode(&emails); err != nil {
+		return nil, errors.ErrUnexpected("Failed to decode user emails").WithCause(err)
+	}
+	return emails, nil
+}
+
+// primaryVerifiedEmail picks the primary address if it is verified, otherwise the first verified one
+func primaryVerifiedEmail(emails []githubEmail) (string, bool) {
+	for _, e := range emails {
+		if e.Primary && e.Verified {
+			return e.Email, true
+		}
+	}
+	for _, e := range emails {
+		if e.Verified {
+			return e.Email, true
+		}
+	}
+	return "", false
+}
+
 func (p *GitHubProvider) RefreshToken(ctx context.Context, refreshToken string) (*OAuthToken, error) {
 	values := url.Values{
 		"client_id":     {p.clientID},
@@ -136,27 +255,27 @@ func (p *GitHubProvider) RefreshToken(ctx context.Context, refreshToken string)
 		"grant_type":    {"refresh_token"},
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", "https://github.com/login/oauth/access_token", strings.NewReader(values.Encode()))
+	req, err := http.NewRequestWithContext(ctx, "POST", p.tokenURL, strings.NewReader(values.Encode()))
 	if err != nil {
-		return nil, errors.ErrUnexpected(fmt.Sprintf("Failed to create refresh request: %v", err))
+		return nil, errors.ErrUnexpected("Failed to create refresh request").WithCause(err)
 	}
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Accept", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := p.httpClient.Do(req)
 	if err != nil {
-		return nil, errors.ErrUnexpected(fmt.Sprintf("Failed to refresh token: %v", err))
+		return nil, errors.ErrUnexpected("Failed to refresh token").WithCause(err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, errors.ErrUnauthorized(fmt.Sprintf("Failed to refresh token: status code %d", resp.StatusCode))
+		return nil, statusError("Failed to refresh token", "github", resp)
 	}
 
-	var token OAuthToken
-	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
-		return nil, errors.ErrUnexpected(fmt.Sprintf("Failed to decode refresh token response: %v", err))
+	token, err := decodeGitHubToken(resp, "Failed to refresh token")
+	if err != nil {
+		return nil, err
 	}
 
 	// If GitHub doesn't provide a new refresh token, use the existing one
@@ -164,5 +283,59 @@ func (p *GitHubProvider) RefreshToken(ctx context.Context, refreshToken string)
 		token.RefreshToken = refreshToken
 	}
 
-	return &token, nil
+	return token, nil
+}
+
+// RevokeToken deletes the grant of the OAuth app for the user, which revokes every token of the app for them.
+// GitHub rejects expired access tokens, the user tokens of GitHub Apps expire after 8 hours, so a rejected token
+// is refreshed and the deletion retried. Only a rejected token without a refresh token, which never expires,
+// counts as revoked already.
+func (p *GitHubProvider) RevokeToken(ctx context.Context, token *OAuthToken) error {
+	rejected, err := p.deleteGrant(ctx, token.AccessToken)
+	if err != nil || !rejected || token.RefreshToken == "" {
+		return err
+	}
+
+	refreshed, err := p.RefreshToken(ctx, token.RefreshToken)
+	if err != nil {
+		return errors.ErrUnauthorized("Failed to refresh the rejected token to revoke its grant").WithProvider("github").WithCause(err)
+	}
+	rejected, err = p.deleteGrant(ctx, refreshed.AccessToken)
+	if err != nil {
+		return err
+	}
+	if rejected {
+		return errors.ErrUnauthorized("GitHub rejected the refreshed token").WithProvider("github").WithStatusCode(http.StatusUnprocessableEntity)
+	}
+	return nil
+}
+
+// deleteGrant deletes the grant of accessToken, rejected is true when GitHub does not accept the token
+func (p *GitHubProvider) deleteGrant(ctx context.Context, accessToken string) (rejected bool, err error) {
+	body, err := json.Marshal(map[string]string{"access_token": accessToken})
+	if err != nil {
+		return false, errors.ErrUnexpected("Failed to encode revocation request").WithCause(err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "DELETE", p.apiURL+"/applications/"+url.PathEscape(p.clientID)+"/grant", bytes.NewReader(body))
+	if err != nil {
+		return false, errors.ErrUnexpected("Failed to create revocation request").WithCause(err)
+	}
+	req.SetBasicAuth(p.clientID, p.clientSecret)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return false, errors.ErrUnexpected("Failed to revoke token").WithProvider("github").WithCause(err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		return false, nil
+	case http.StatusUnprocessableEntity:
+		return true, nil
+	default:
+		return false, statusError("Failed to revoke token", "github", resp)
+	}
 }
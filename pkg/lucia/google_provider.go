@@ -3,8 +3,10 @@ package lucia
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/Abraxas-365/toolkit/pkg/errors"
 	"golang.org/x/oauth2"
@@ -12,35 +14,75 @@ import (
 )
 
 type GoogleProvider struct {
-	config *oauth2.Config
+	config      *oauth2.Config
+	userInfoURL string
+	revokeURL   string
+	opts        providerOptions
 }
 
-func NewGoogleProvider(clientID, clientSecret, redirectURI string, scopes []string) *GoogleProvider {
+// NewGoogleProvider creates a Google provider, WithBaseURL serves every endpoint from a single host, e.g. an httptest server
+func NewGoogleProvider(clientID, clientSecret, redirectURI string, scopes []string, opts ...ProviderOption) *GoogleProvider {
+	o := newProviderOptions(opts)
+
+	endpoint := google.Endpoint
+	userInfoURL := "https://www.googleapis.com/oauth2/v2/userinfo"
+	revokeURL := "https://oauth2.googleapis.com/revoke"
+	if o.baseURL != "" {
+		base := strings.TrimSuffix(o.baseURL, "/")
+		endpoint = oauth2.Endpoint{AuthURL: base + "/o/oauth2/auth", TokenURL: base + "/token"}
+		userInfoURL = base + "/oauth2/v2/userinfo"
+		revokeURL = base + "/revoke"
+	}
+	endpoint.AuthURL = or(o.authURL, endpoint.AuthURL)
+	endpoint.TokenURL = or(o.tokenURL, endpoint.TokenURL)
+
 	return &GoogleProvider{
 		config: &oauth2.Config{
 			ClientID:     clientID,
 			ClientSecret: clientSecret,
 			RedirectURL:  redirectURI,
 			Scopes:       scopes,
-			Endpoint:     google.Endpoint,
+			Endpoint:     endpoint,
 		},
+		userInfoURL: or(o.userInfoURL, userInfoURL),
+		revokeURL:   or(o.revokeURL, revokeURL),
+		opts:        o,
 	}
 }
 
-func (p *GoogleProvider) GetAuthURL(state string) string {
-	return p.config.AuthCodeURL(state)
+func (p *GoogleProvider) GetAuthURL(state string, opts ...AuthURLOption) string {
+	o := newAuthURLOptions(opts)
+	if o.prompt == PromptLogin {
+		// Google has no prompt=login, max_age=0 forces the new authentication instead
+		o.prompt = ""
+		o.maxAge = "0"
+	}
+	var params []oauth2.AuthCodeOption
+	if o.offline {
+		params = append(params, oauth2.AccessTypeOffline)
+	}
+	if len(o.scopes) > 0 {
+		// Keep the scopes granted earlier in the new token
+		params = append(params, oauth2.SetAuthURLParam("include_granted_scopes", "true"))
+	}
+	if o.domain != "" {
+		params = append(params, oauth2.SetAuthURLParam("hd", o.domain))
+	}
+	return o.authCodeURL(p.config, state, params...)
 }
 
 func (p *GoogleProvider) ExchangeCode(ctx context.Context, code string) (*OAuthToken, error) {
-	token, err := p.config.Exchange(ctx, code)
+	token, err := p.config.Exchange(p.opts.oauth2Context(ctx), code)
 	if err != nil {
-		return nil, errors.ErrUnauthorized(fmt.Sprintf("Failed to exchange code: %v", err))
+		return nil, errors.ErrUnauthorized("Failed to exchange code").WithCause(err)
 	}
 
+	idToken, _ := token.Extra("id_token").(string)
 	return &OAuthToken{
 		AccessToken:  token.AccessToken,
 		RefreshToken: token.RefreshToken,
 		ExpiresIn:    token.Expiry.Unix(),
+		IDToken:      idToken,
 	}, nil
 }
 
@@ -59,36 +101,43 @@ func (p *GoogleProvider) GetUserInfo(ctx context.Context, token *OAuthToken) (*U
 		}
 	}
 
-	client := p.config.Client(ctx, &oauth2.Token{
+	client := p.config.Client(p.opts.oauth2Context(ctx), &oauth2.Token{
 		AccessToken: token.AccessToken,
 	})
 
-	resp, err := client.Get("https://www.googleapis.com/oauth2/v2/userinfo")
+	resp, err := client.Get(p.userInfoURL)
 	if err != nil {
-		return nil, errors.ErrUnauthorized(fmt.Sprintf("Failed to get user info: %v", err))
+		return nil, errors.ErrUnauthorized("Failed to get user info").WithCause(err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, errors.ErrUnauthorized(fmt.Sprintf("Failed to get user info: status code %d", resp.StatusCode))
+		return nil, statusError("Failed to get user info", "google", resp)
 	}
 
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.ErrUnexpected("Failed to read user info").WithCause(err)
+	}
 	var googleUser struct {
-		ID      string `json:"id"`
-		Email   string `json:"email"`
-		Name    string `json:"name"`
-		Picture string `json:"picture"`
+		ID            string `json:"id"`
+		Email         string `json:"email"`
+		VerifiedEmail bool   `json:"verified_email"`
+		Name          string `json:"name"`
+		Picture       string `json:"picture"`
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&googleUser); err != nil {
-		return nil, errors.ErrUnexpected(fmt.Sprintf("Failed to decode user info: %v", err))
+	if err := json.Unmarshal(raw, &googleUser); err != nil {
+		return nil, errors.ErrUnexpected("Failed to decode user info").WithCause(err)
 	}
 
 	userInfo := &UserInfo{
-		ID:       googleUser.ID,
-		Email:    googleUser.Email,
-		Name:     googleUser.Name,
-		Provider: "google",
-		Token:    token, // Include the potentially refreshed token
+		ID:            googleUser.ID,
+		Email:         googleUser.Email,
+		EmailVerified: googleUser.VerifiedEmail,
+		Name:          googleUser.Name,
+		Provider:      "google",
+		Raw:           raw,
+		Token:         token, // Include the potentially refreshed token
 	}
 
 	if googleUser.Picture != "" {
@@ -103,10 +152,10 @@ func (p *GoogleProvider) RefreshToken(ctx context.Context, refreshToken string)
 		RefreshToken: refreshToken,
 	}
 
-	tokenSource := p.config.TokenSource(ctx, token)
+	tokenSource := p.config.TokenSource(p.opts.oauth2Context(ctx), token)
 	newToken, err := tokenSource.Token()
 	if err != nil {
-		return nil, errors.ErrUnauthorized(fmt.Sprintf("Failed to refresh token: %v", err))
+		return nil, errors.ErrUnauthorized("Failed to refresh token").WithCause(err)
 	}
 
 	return &OAuthToken{
@@ -115,3 +164,10 @@ func (p *GoogleProvider) RefreshToken(ctx context.Context, refreshToken string)
 		ExpiresIn:    newToken.Expiry.Unix(),
 	}, nil
 }
+
+// RevokeToken revokes the grant at Google, the refresh token is preferred as revoking it always ends the grant
+func (p *GoogleProvider) RevokeToken(ctx context.Context, token *OAuthToken) error {
+	return revokeToken(ctx, p.opts.httpClient, p.revokeURL, "google", url.Values{
+		"token": {or(token.RefreshToken, token.AccessToken)},
+	})
+}
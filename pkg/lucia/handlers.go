@@ -0,0 +1,218 @@
+package lucia
+
+import (
+	"crypto/subtle"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// Cookies holding a pending OAuth login between LoginHandler and CallbackHandler
+const (
+	StateCookieName    = "oauth_state"
+	ReturnToCookieName = "oauth_return_to"
+)
+
+// HandlersConfig configures AuthHandlers
+type HandlersConfig struct {
+	// SuccessURL is where users land after logging in when the login did not carry a return_to,
+	// it defaults to "/"
+	SuccessURL string
+	// LogoutURL is where browsers land after logging out when the logout did not carry a return_to,
+	// it defaults to "/"
+	LogoutURL string
+	// AllowedOrigins lists the origins, e.g. "https://app.example.com", an absolute return_to may point to.
+	// Paths on this site are always allowed, anything else falls back to SuccessURL or LogoutURL.
+	AllowedOrigins []string
+	// AuthURLOptions are applied to every authorization URL, e.g. WithOfflineAccess()
+	AuthURLOptions []AuthURLOption
+}
+
+// AuthHandlers are the Fiber login, callback and logout routes of the OAuth providers of an AuthService
+type AuthHandlers[U AuthUser[ID], ID UserID] struct {
+	service *AuthService[U, ID]
+	cfg     HandlersConfig
+}
+
+func NewAuthHandlers[U AuthUser[ID], ID UserID](service *AuthService[U, ID], cfg HandlersConfig) *AuthHandlers[U, ID] {
+	if cfg.SuccessURL == "" {
+		cfg.SuccessURL = "/"
+	}
+	if cfg.LogoutURL == "" {
+		cfg.LogoutURL = "/"
+	}
+	return &AuthHandlers[U, ID]{service: service, cfg: cfg}
+}
+
+// Mount registers "/login/:provider", "/login/:provider/callback" and "/logout" on router.
+// The redirect URI of every provider must point to its callback route, which accepts both the query and
+// the form_post response modes.
+func (h *AuthHandlers[U, ID]) Mount(router fiber.Router) {
+	router.Get("/login/:provider", h.LoginHandler())
+	router.Get("/login/:provider/callback", h.CallbackHandler())
+	router.Post("/login/:provider/callback", h.CallbackHandler())
+	router.Post("/logout", h.LogoutHandler())
+}
+
+// LoginHandler redirects to the provider of the ":provider" route parameter, an allowed return_to query
+// parameter is where the user lands afterwards. The prompt and max_age query parameters, as sent by
+// RequireRecentAuth, are passed on to the provider.
+func (h *AuthHandlers[U, ID]) LoginHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		provider := c.Params("provider")
+		if _, ok := h.service.providers[provider]; !ok {
+			return errors.ErrNotFound("Unknown provider")
+		}
+		opts := append([]AuthURLOption(nil), h.cfg.AuthURLOptions...)
+		if prompt := c.Query("prompt"); prompt == PromptLogin || prompt == PromptSelectAccount {
+			opts = append(opts, WithPrompt(prompt))
+		}
+		if maxAge, err := strconv.Atoi(c.Query("max_age")); err == nil && maxAge >= 0 {
+			opts = append(opts, WithMaxAge(time.Duration(maxAge)*time.Second))
+		}
+		authURL, state, err := h.service.GetAuthURL(provider, opts...)
+		if err != nil {
+			return err
+		}
+
+		setLoginCookie(c, StateCookieName, state)
+		if returnTo := c.Query("return_to"); h.allowedReturnTo(returnTo) {
+			setLoginCookie(c, ReturnToCookieName, returnTo)
+		} else {
+			c.ClearCookie(ReturnToCookieName)
+		}
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		return c.Redirect(authURL)
+	}
+}
+
+// CallbackHandler completes the login started by LoginHandler, sets the session cookie and redirects to
+// the return_to of the login
+func (h *AuthHandlers[U, ID]) CallbackHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Fiber reuses the request buffers, the provider name outlives the request in the session
+		provider := utils.CopyString(c.Params("provider"))
+		expectedState := c.Cookies(StateCookieName)
+		returnTo := c.Cookies(ReturnToCookieName)
+		c.ClearCookie(StateCookieName, ReturnToCookieName)
+
+		code, state, ctx := c.Query("code"), c.Query("state"), c.UserContext()
+		if c.Method() == fiber.MethodPost {
+			code, state, ctx = FormPostCallback(c)
+		}
+		if expectedState == "" || subtle.ConstantTimeCompare([]byte(expectedState), []byte(state)) != 1 {
+			return errors.ErrUnauthorized("Invalid state")
+		}
+		if code == "" {
+			// The user declined or the provider failed, e.g. error=access_denied
+			if errorCode := c.FormValue("error"); errorCode != "" {
+				return oauthError(errorCode, or(c.FormValue("error_description"), "Authorization failed: "+errorCode))
+			}
+			return errors.ErrBadRequest("Missing code")
+		}
+
+		session, err := h.service.HandleCallback(ctx, provider, code)
+		if err != nil {
+			return err
+		}
+		// The new session replaces the one of a step-up or account switch, a failed delete only leaves the old
+		// one to expire. It is not a logout, the grant the new session was just given must not be revoked.
+		if previous := GetSession[ID](c); previous != nil {
+			_, _ = h.service.deleteSession(c.UserContext(), previous.ID, RevokeReasonReplaced)
+		}
+		SetSessionCookie(c, session)
+
+		if !h.allowedReturnTo(returnTo) {
+			returnTo = h.cfg.SuccessURL
+		}
+		return c.Redirect(returnTo)
+	}
+}
+
+// LogoutHandler ends the current session and clears its cookie. Browsers are redirected to an allowed
+// return_to or LogoutURL, API clients get a 204.
+func (h *AuthHandlers[U, ID]) LogoutHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if session := GetSession[ID](c); session != nil {
+			if err := h.service.Logout(c.UserContext(), session.ID); err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+		}
+		ClearSessionCookie(c)
+
+		if !isBrowserRequest(c.Get(fiber.HeaderAccept), c.Get(fiber.HeaderXRequestedWith)) {
+			return c.SendStatus(fiber.StatusNoContent)
+		}
+		returnTo := c.FormValue("return_to")
+		if !h.allowedReturnTo(returnTo) {
+			returnTo = h.cfg.LogoutURL
+		}
+		return c.Redirect(returnTo, fiber.StatusSeeOther)
+	}
+}
+
+// allowedReturnTo reports whether returnTo is a path on this site or an absolute URL on an allowed origin
+func (h *AuthHandlers[U, ID]) allowedReturnTo(returnTo string) bool {
+	if IsLocalPath(returnTo) {
+		return true
+	}
+	if returnTo == "" || hasUnsafeURLChars(returnTo) {
+		return false
+	}
+	u, err := url.Parse(returnTo)
+	if err != nil || u.User != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return false
+	}
+	origin := u.Scheme + "://" + u.Host
+	for _, allowed := range h.cfg.AllowedOrigins {
+		if strings.EqualFold(origin, strings.TrimSuffix(allowed, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// setLoginCookie stores a value of the pending login. Providers using form_post (Apple) post the callback
+// cross-site, so the cookie must be SameSite=None to come back with it.
+func setLoginCookie(c *fiber.Ctx, name, value string) {
+	c.Cookie(&fiber.Cookie{
+		Name:     name,
+		Value:    value,
+		Expires:  time.Now().Add(10 * time.Minute),
+		HTTPOnly: true,
+		Secure:   true,
+		SameSite: "None",
+	})
+}
+
+// IsLocalPath reports whether path is a path on this site, safe to redirect to. Protocol-relative URLs,
+// backslashes and control characters are rejected, browsers drop tabs and newlines so "/\t/evil.com"
+// would become "//evil.com".
+func IsLocalPath(path string) bool {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || hasUnsafeURLChars(path) {
+		return false
+	}
+	u, err := url.Parse(path)
+	return err == nil && u.Scheme == "" && u.Host == "" && u.User == nil
+}
+
+// hasUnsafeURLChars reports whether s has a backslash or a control character, which browsers normalize
+// differently than url.Parse
+func hasUnsafeURLChars(s string) bool {
+	for _, r := range s {
+		if r == '\\' || r < 0x20 || r == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+// isBrowserRequest reports whether a request is a browser navigation rather than an API or XHR call
+func isBrowserRequest(accept, requestedWith string) bool {
+	return strings.Contains(accept, "text/html") && !strings.EqualFold(requestedWith, "XMLHttpRequest")
+}
@@ -0,0 +1,144 @@
+package lucia
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestIsLocalPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{path: "/", want: true},
+		{path: "/dashboard?tab=1#top", want: true},
+		{path: "/a/b/../c", want: true},
+		{path: "/%2F%2Fevil.com", want: true},
+		{path: "", want: false},
+		{path: "dashboard", want: false},
+		{path: "//evil.com", want: false},
+		{path: "/\\evil.com", want: false},
+		{path: "/\t/evil.com", want: false},
+		{path: "/\r\n/evil.com", want: false},
+		{path: "/\x00/evil.com", want: false},
+		{path: "/\x7f", want: false},
+		{path: "https://evil.com", want: false},
+		{path: "javascript:alert(1)", want: false},
+	}
+	for _, tt := range tests {
+		if got := IsLocalPath(tt.path); got != tt.want {
+			t.Errorf("IsLocalPath(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestAllowedReturnTo(t *testing.T) {
+	h := NewAuthHandlers(newTestService(), HandlersConfig{AllowedOrigins: []string{"https://app.example.com/"}})
+	tests := []struct {
+		returnTo string
+		want     bool
+	}{
+		{returnTo: "/settings", want: true},
+		{returnTo: "https://app.example.com/settings", want: true},
+		{returnTo: "https://APP.example.com", want: true},
+		{returnTo: "https://evil.com", want: false},
+		{returnTo: "https://app.example.com.evil.com/", want: false},
+		{returnTo: "https://user@app.example.com/", want: false},
+		{returnTo: "https://app.example.com\t/", want: false},
+		{returnTo: "http://app.example.com/", want: false},
+		{returnTo: "/\t/evil.com", want: false},
+	}
+	for _, tt := range tests {
+		if got := h.allowedReturnTo(tt.returnTo); got != tt.want {
+			t.Errorf("allowedReturnTo(%q) = %v, want %v", tt.returnTo, got, tt.want)
+		}
+	}
+}
+
+// stubProvider logs in the user named by the authorization code
+type stubProvider struct{}
+
+func (stubProvider) GetAuthURL(state string, opts ...AuthURLOption) string {
+	return "https://provider.example.com/authorize?state=" + state
+}
+
+func (stubProvider) ExchangeCode(ctx context.Context, code string) (*OAuthToken, error) {
+	return &OAuthToken{AccessToken: code}, nil
+}
+
+func (stubProvider) GetUserInfo(ctx context.Context, token *OAuthToken) (*UserInfo, error) {
+	return &UserInfo{ID: token.AccessToken, Provider: "stub"}, nil
+}
+
+func (stubProvider) RefreshToken(ctx context.Context, refreshToken string) (*OAuthToken, error) {
+	return nil, errors.ErrBadRequest("Refresh not supported")
+}
+
+// withSession stands in for SessionMiddleware, before runs ahead of the handler
+func withSession(session *Session[string], before func()) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Locals("session", session)
+		if before != nil {
+			before()
+		}
+		return c.Next()
+	}
+}
+
+func TestLogoutOfConcurrentlyDeletedSession(t *testing.T) {
+	ctx := context.Background()
+	service := newTestService()
+	session, err := service.HandleIdentity(ctx, "saml", &UserInfo{ID: "user", Provider: "saml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewAuthHandlers(service, HandlersConfig{})
+	app := fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+	app.Post("/logout", withSession(session, func() { _ = service.DeleteSession(ctx, session.ID) }), h.LogoutHandler())
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodPost, "/logout", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+}
+
+func TestCallbackReplacesPreviousSession(t *testing.T) {
+	ctx := context.Background()
+	service := newTestService()
+	service.RegisterProvider("stub", stubProvider{})
+	previous, err := service.HandleIdentity(ctx, "saml", &UserInfo{ID: "user", Provider: "saml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reasons []string
+	service.Hooks().OnSessionRevoked(func(ctx context.Context, e SessionRevokedEvent[string]) {
+		reasons = append(reasons, e.Reason)
+	})
+
+	h := NewAuthHandlers(service, HandlersConfig{})
+	app := fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+	app.Get("/login/:provider/callback", withSession(previous, nil), h.CallbackHandler())
+	req := httptest.NewRequest(http.MethodGet, "/login/stub/callback?code=user&state=xyz", nil)
+	req.AddCookie(&http.Cookie{Name: StateCookieName, Value: "xyz"})
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusFound)
+	}
+	if len(reasons) != 1 || reasons[0] != RevokeReasonReplaced {
+		t.Errorf("revoke reasons = %v, want [%s]", reasons, RevokeReasonReplaced)
+	}
+	if _, err := service.GetSession(ctx, previous.ID); err == nil {
+		t.Error("previous session still valid")
+	}
+}
@@ -0,0 +1,98 @@
+package lucia
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+)
+
+// testUser is the user type of the tests of this package, luciatest cannot be used here as it imports lucia
+type testUser struct {
+	id string
+}
+
+func (u *testUser) GetID() string {
+	return u.id
+}
+
+type testUserStore struct {
+	mu    sync.Mutex
+	users map[string]*testUser
+}
+
+func newTestUserStore() *testUserStore {
+	return &testUserStore{users: make(map[string]*testUser)}
+}
+
+func (s *testUserStore) GetUserByProviderID(ctx context.Context, provider, providerID string) (*testUser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[provider+":"+providerID]
+	if !ok {
+		return nil, errors.ErrNotFound("User not found")
+	}
+	return user, nil
+}
+
+func (s *testUserStore) CreateUser(ctx context.Context, userInfo *UserInfo) (*testUser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user := &testUser{id: userInfo.Provider + ":" + userInfo.ID}
+	s.users[user.id] = user
+	return user, nil
+}
+
+type testSessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*Session[string]
+}
+
+func newTestSessionStore() *testSessionStore {
+	return &testSessionStore{sessions: make(map[string]*Session[string])}
+}
+
+func (s *testSessionStore) CreateSession(ctx context.Context, session *Session[string]) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := *session
+	s.sessions[session.ID] = &stored
+	return nil
+}
+
+func (s *testSessionStore) GetSession(ctx context.Context, sessionID string) (*Session[string], error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[sessionID]
+	if !ok {
+		return nil, errors.ErrNotFound("Session not found")
+	}
+	found := *session
+	return &found, nil
+}
+
+func (s *testSessionStore) UpdateSession(ctx context.Context, session *Session[string]) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sessions[session.ID]; !ok {
+		return errors.ErrNotFound("Session not found")
+	}
+	stored := *session
+	s.sessions[session.ID] = &stored
+	return nil
+}
+
+func (s *testSessionStore) DeleteSession(ctx context.Context, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sessions[sessionID]; !ok {
+		return errors.ErrNotFound("Session not found")
+	}
+	delete(s.sessions, sessionID)
+	return nil
+}
+
+// newTestService returns an AuthService on in-memory stores
+func newTestService() *AuthService[*testUser, string] {
+	return NewAuthService[*testUser, string](newTestUserStore(), newTestSessionStore())
+}
@@ -0,0 +1,216 @@
+package lucia
+
+import (
+	"context"
+	"sync"
+)
+
+// BeforeHook runs before an auth action, returning an error vetoes the action and is returned to the caller
+type BeforeHook[E any] func(ctx context.Context, event E) error
+
+// AfterHook runs once an auth action has completed
+type AfterHook[E any] func(ctx context.Context, event E)
+
+// UserCreatedEvent is emitted when a user is created from a provider identity
+type UserCreatedEvent[U any] struct {
+	Provider string
+	UserInfo *UserInfo
+	User     U
+}
+
+// LoginEvent is emitted around a successful OAuth login, Session is nil in before-hooks
+type LoginEvent[U AuthUser[ID], ID UserID] struct {
+	Provider string
+	UserInfo *UserInfo
+	User     U
+	Session  *Session[ID]
+	NewUser  bool
+}
+
+// LoginFailedEvent is emitted when an OAuth callback fails, UserInfo is nil if the provider never returned it
+type LoginFailedEvent struct {
+	Provider string
+	UserInfo *UserInfo
+	Err      error
+}
+
+// SessionEvent is emitted around session creation
+type SessionEvent[ID UserID] struct {
+	Session *Session[ID]
+}
+
+// Reasons a session is revoked for
+const (
+	RevokeReasonLogout             = "logout"
+	RevokeReasonRevoked            = "revoked"
+	RevokeReasonImpersonationEnded = "impersonation_ended"
+	// RevokeReasonReplaced is a session deleted because a new login of the same browser replaced it,
+	// e.g. after a step-up
+	RevokeReasonReplaced = "replaced"
+)
+
+// SessionRevokedEvent is emitted when a session is deleted by logout or revocation.
+// Session is nil if it could no longer be loaded before deletion, e.g. because it had expired.
+type SessionRevokedEvent[ID UserID] struct {
+	SessionID string
+	Session   *Session[ID]
+	Reason    string
+}
+
+// ImpersonationEvent is emitted when an impersonation starts or ends, Session is the impersonation session
+type ImpersonationEvent[ID UserID] struct {
+	ActorID ID
+	Session *Session[ID]
+	Reason  string
+}
+
+// TokenRefreshedEvent is emitted when a provider token is refreshed through the AuthService
+type TokenRefreshedEvent struct {
+	Provider string
+	Token    *OAuthToken
+}
+
+// ProviderTokenRevokedEvent is emitted after every attempt to revoke a stored provider token, Err is set
+// when the provider refused or could not be reached and the token was kept for a later attempt
+type ProviderTokenRevokedEvent[ID UserID] struct {
+	UserID   ID
+	Provider string
+	Err      error
+}
+
+// EmailVerifiedEvent is emitted when a user proves control of an email address through an EmailVerifier
+type EmailVerifiedEvent[ID UserID] struct {
+	UserID ID
+	Email  string
+}
+
+// Hooks holds the lifecycle hooks of an AuthService, registration is safe for concurrent use
+type Hooks[U AuthUser[ID], ID UserID] struct {
+	mu                       sync.RWMutex
+	beforeUserCreated        []BeforeHook[*UserInfo]
+	onUserCreated            []AfterHook[UserCreatedEvent[U]]
+	beforeLogin              []BeforeHook[LoginEvent[U, ID]]
+	onLogin                  []AfterHook[LoginEvent[U, ID]]
+	onLoginFailed            []AfterHook[LoginFailedEvent]
+	beforeSessionCreated     []BeforeHook[SessionEvent[ID]]
+	onSessionCreated         []AfterHook[SessionEvent[ID]]
+	onSessionRevoked         []AfterHook[SessionRevokedEvent[ID]]
+	onProviderTokenRefreshed []AfterHook[TokenRefreshedEvent]
+	onProviderTokenRevoked   []AfterHook[ProviderTokenRevokedEvent[ID]]
+	onImpersonationStarted   []AfterHook[ImpersonationEvent[ID]]
+	onImpersonationEnded     []AfterHook[ImpersonationEvent[ID]]
+	onEmailVerified          []AfterHook[EmailVerifiedEvent[ID]]
+}
+
+// BeforeUserCreated registers a hook that can veto the creation of a new user
+func (h *Hooks[U, ID]) BeforeUserCreated(hook BeforeHook[*UserInfo]) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.beforeUserCreated = append(h.beforeUserCreated, hook)
+}
+
+// OnUserCreated registers a hook that runs after a new user is created
+func (h *Hooks[U, ID]) OnUserCreated(hook AfterHook[UserCreatedEvent[U]]) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onUserCreated = append(h.onUserCreated, hook)
+}
+
+// BeforeLogin registers a hook that can veto a login once the user is known
+func (h *Hooks[U, ID]) BeforeLogin(hook BeforeHook[LoginEvent[U, ID]]) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.beforeLogin = append(h.beforeLogin, hook)
+}
+
+// OnLogin registers a hook that runs after a successful login
+func (h *Hooks[U, ID]) OnLogin(hook AfterHook[LoginEvent[U, ID]]) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onLogin = append(h.onLogin, hook)
+}
+
+// OnLoginFailed registers a hook that runs when an OAuth callback fails, including vetoed logins
+func (h *Hooks[U, ID]) OnLoginFailed(hook AfterHook[LoginFailedEvent]) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onLoginFailed = append(h.onLoginFailed, hook)
+}
+
+// BeforeSessionCreated registers a hook that can veto or amend a session before it is stored
+func (h *Hooks[U, ID]) BeforeSessionCreated(hook BeforeHook[SessionEvent[ID]]) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.beforeSessionCreated = append(h.beforeSessionCreated, hook)
+}
+
+// OnSessionCreated registers a hook that runs after a session is stored
+func (h *Hooks[U, ID]) OnSessionCreated(hook AfterHook[SessionEvent[ID]]) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onSessionCreated = append(h.onSessionCreated, hook)
+}
+
+// OnSessionRevoked registers a hook that runs after a session is deleted
+func (h *Hooks[U, ID]) OnSessionRevoked(hook AfterHook[SessionRevokedEvent[ID]]) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onSessionRevoked = append(h.onSessionRevoked, hook)
+}
+
+// OnProviderTokenRefreshed registers a hook that runs after AuthService.RefreshToken refreshes a token
+func (h *Hooks[U, ID]) OnProviderTokenRefreshed(hook AfterHook[TokenRefreshedEvent]) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onProviderTokenRefreshed = append(h.onProviderTokenRefreshed, hook)
+}
+
+// OnProviderTokenRevoked registers a hook that runs after a stored provider token is revoked or failed to be
+func (h *Hooks[U, ID]) OnProviderTokenRevoked(hook AfterHook[ProviderTokenRevokedEvent[ID]]) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onProviderTokenRevoked = append(h.onProviderTokenRevoked, hook)
+}
+
+// OnImpersonationStarted registers a hook that runs after an impersonation session is created
+func (h *Hooks[U, ID]) OnImpersonationStarted(hook AfterHook[ImpersonationEvent[ID]]) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onImpersonationStarted = append(h.onImpersonationStarted, hook)
+}
+
+// OnImpersonationEnded registers a hook that runs after EndImpersonation deletes an impersonation session
+func (h *Hooks[U, ID]) OnImpersonationEnded(hook AfterHook[ImpersonationEvent[ID]]) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onImpersonationEnded = append(h.onImpersonationEnded, hook)
+}
+
+// OnEmailVerified registers a hook that runs after EmailVerifier.Verify, e.g. to mark the email verified on the user
+func (h *Hooks[U, ID]) OnEmailVerified(hook AfterHook[EmailVerifiedEvent[ID]]) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onEmailVerified = append(h.onEmailVerified, hook)
+}
+
+// snapshot reads a hook slice under the read lock so hooks run without holding it
+func snapshot[T any](mu *sync.RWMutex, hooks *[]T) []T {
+	mu.RLock()
+	defer mu.RUnlock()
+	return *hooks
+}
+
+func runBefore[E any](ctx context.Context, mu *sync.RWMutex, hooks *[]BeforeHook[E], event E) error {
+	for _, hook := range snapshot(mu, hooks) {
+		if err := hook(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runAfter[E any](ctx context.Context, mu *sync.RWMutex, hooks *[]AfterHook[E], event E) {
+	for _, hook := range snapshot(mu, hooks) {
+		hook(ctx, event)
+	}
+}
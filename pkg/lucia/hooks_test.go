@@ -0,0 +1,201 @@
+package lucia
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+)
+
+func TestBeforeHookVeto(t *testing.T) {
+	veto := errors.ErrForbidden("vetoed")
+	tests := []struct {
+		name string
+		// existing logs the user in once before the hook is registered
+		existing bool
+		register func(h *Hooks[*testUser, string])
+		wantUser bool
+	}{
+		{
+			name: "before user created",
+			register: func(h *Hooks[*testUser, string]) {
+				h.BeforeUserCreated(func(context.Context, *UserInfo) error { return veto })
+			},
+		},
+		{
+			name: "before login of a new user",
+			register: func(h *Hooks[*testUser, string]) {
+				h.BeforeLogin(func(context.Context, LoginEvent[*testUser, string]) error { return veto })
+			},
+			wantUser: true,
+		},
+		{
+			name:     "before login of an existing user",
+			existing: true,
+			register: func(h *Hooks[*testUser, string]) {
+				h.BeforeLogin(func(_ context.Context, e LoginEvent[*testUser, string]) error {
+					if e.NewUser || e.Session != nil {
+						return fmt.Errorf("event = %+v, want an existing user and no session yet", e)
+					}
+					return veto
+				})
+			},
+			wantUser: true,
+		},
+		{
+			name: "before session created",
+			register: func(h *Hooks[*testUser, string]) {
+				h.BeforeSessionCreated(func(context.Context, SessionEvent[string]) error { return veto })
+			},
+			wantUser: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			users := newTestUserStore()
+			sessions := newTestSessionStore()
+			service := NewAuthService[*testUser, string](users, sessions)
+			service.RegisterProvider("stub", stubProvider{})
+			if tt.existing {
+				if _, err := service.HandleCallback(ctx, "stub", "alice"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			sessionsBefore := len(sessions.sessions)
+
+			var failed []error
+			service.Hooks().OnLoginFailed(func(_ context.Context, e LoginFailedEvent) { failed = append(failed, e.Err) })
+			loggedIn := false
+			service.Hooks().OnLogin(func(context.Context, LoginEvent[*testUser, string]) { loggedIn = true })
+			tt.register(service.Hooks())
+
+			session, err := service.HandleCallback(ctx, "stub", "alice")
+			if !stderrors.Is(err, veto) || session != nil {
+				t.Fatalf("HandleCallback() = %v, %v, want the veto", session, err)
+			}
+			if loggedIn {
+				t.Error("OnLogin ran for a vetoed login")
+			}
+			if len(failed) != 1 || !stderrors.Is(failed[0], veto) {
+				t.Errorf("OnLoginFailed errors = %v, want the veto", failed)
+			}
+			if len(sessions.sessions) != sessionsBefore {
+				t.Errorf("%d sessions stored, want %d", len(sessions.sessions), sessionsBefore)
+			}
+			if _, err := users.GetUserByProviderID(ctx, "stub", "alice"); (err == nil) != tt.wantUser {
+				t.Errorf("user created = %v, want %v", err == nil, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestAfterHookEvents(t *testing.T) {
+	ctx := context.Background()
+	service := newTestService()
+	service.RegisterProvider("stub", stubProvider{})
+
+	var events []string
+	var logins []LoginEvent[*testUser, string]
+	var revoked []SessionRevokedEvent[string]
+	hooks := service.Hooks()
+	hooks.OnUserCreated(func(_ context.Context, e UserCreatedEvent[*testUser]) {
+		events = append(events, "user_created:"+e.Provider+":"+e.UserInfo.ID)
+	})
+	hooks.OnSessionCreated(func(_ context.Context, e SessionEvent[string]) {
+		events = append(events, "session_created")
+	})
+	hooks.OnLogin(func(_ context.Context, e LoginEvent[*testUser, string]) {
+		events = append(events, "login")
+		logins = append(logins, e)
+	})
+	hooks.OnSessionRevoked(func(_ context.Context, e SessionRevokedEvent[string]) {
+		events = append(events, "session_revoked:"+e.Reason)
+		revoked = append(revoked, e)
+	})
+	hooks.OnLoginFailed(func(_ context.Context, e LoginFailedEvent) {
+		events = append(events, "login_failed:"+e.Provider)
+	})
+
+	first, err := service.HandleCallback(ctx, "stub", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := service.HandleCallback(ctx, "stub", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Logout(ctx, first.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.HandleCallback(ctx, "unknown", "alice"); err == nil {
+		t.Fatal("HandleCallback() with an unknown provider succeeded")
+	}
+
+	want := []string{
+		"user_created:stub:alice", "session_created", "login",
+		"session_created", "login",
+		"session_revoked:" + RevokeReasonLogout,
+		"login_failed:unknown",
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+	if len(logins) == 2 {
+		if !logins[0].NewUser || logins[0].Session.ID != first.ID || logins[0].UserInfo.ID != "alice" {
+			t.Errorf("first login event = %+v, want a new user with the first session", logins[0])
+		}
+		if logins[1].NewUser || logins[1].Session.ID != second.ID || logins[1].User != logins[0].User {
+			t.Errorf("second login event = %+v, want the same user with the second session", logins[1])
+		}
+	}
+	if len(revoked) == 1 && (revoked[0].SessionID != first.ID || revoked[0].Session == nil) {
+		t.Errorf("revoked event = %+v, want the first session", revoked[0])
+	}
+}
+
+func TestHooksConcurrentRegistration(t *testing.T) {
+	ctx := context.Background()
+	service := newTestService()
+	service.RegisterProvider("stub", stubProvider{})
+
+	var mu sync.Mutex
+	ran := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				service.Hooks().OnLogin(func(context.Context, LoginEvent[*testUser, string]) {
+					mu.Lock()
+					ran++
+					mu.Unlock()
+				})
+				service.Hooks().BeforeSessionCreated(func(context.Context, SessionEvent[string]) error { return nil })
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if _, err := service.HandleCallback(ctx, "stub", fmt.Sprintf("user-%d", i)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	before := ran
+	if _, err := service.HandleCallback(ctx, "stub", "last"); err != nil {
+		t.Fatal(err)
+	}
+	if got := ran - before; got != 8*20 {
+		t.Errorf("%d OnLogin hooks ran after registration finished, want %d", got, 8*20)
+	}
+}
@@ -0,0 +1,125 @@
+package lucia
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+)
+
+type sessionContextKey struct{}
+
+// ContextWithSession returns a copy of ctx carrying the session
+func ContextWithSession[ID UserID](ctx context.Context, session *Session[ID]) context.Context {
+	return context.WithValue(ctx, sessionContextKey{}, session)
+}
+
+// SessionFromContext retrieves the validated session from ctx, it returns nil if there is none
+func SessionFromContext[ID UserID](ctx context.Context) *Session[ID] {
+	session, ok := ctx.Value(sessionContextKey{}).(*Session[ID])
+	if !ok {
+		return nil
+	}
+	return session
+}
+
+// Handler is the framework-agnostic session middleware, it stores the validated session in the request context.
+// It plugs directly into net/http and chi (r.Use(am.Handler)), see SessionMiddleware for Fiber and luciaecho for Echo.
+func (am *AuthMiddleware[U, ID]) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(WithRequestMeta(r.Context(), requestMetaFromHTTP(r)))
+
+		var cookieValue string
+		if cookie, err := r.Cookie(SessionCookieName); err == nil {
+			cookieValue = cookie.Value
+		}
+		session, failure := am.authenticate(r.Context(), sessionRequest{
+			cookie:        cookieValue,
+			authorization: r.Header.Get("Authorization"),
+			accept:        r.Header.Get("Accept"),
+			requestedWith: r.Header.Get("X-Requested-With"),
+			requestURI:    r.URL.RequestURI(),
+		})
+		if session != nil {
+			next.ServeHTTP(w, r.WithContext(ContextWithSession(r.Context(), session)))
+			return
+		}
+
+		if failure.clearCookie {
+			ClearSessionCookieHTTP(w)
+		}
+		switch {
+		case failure.redirect != "":
+			http.Redirect(w, r, failure.redirect, http.StatusFound)
+		case failure.err != nil:
+			if failure.challenge != "" {
+				w.Header().Set("WWW-Authenticate", failure.challenge)
+			}
+			errors.HTTPErrorHandler(w, r, failure.err)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// RequireAuthHandler is the net/http counterpart of RequireAuth
+func (am *AuthMiddleware[U, ID]) RequireAuthHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if SessionFromContext[ID](r.Context()) == nil {
+			if am.opts.loginURL != "" && isBrowserRequest(r.Header.Get("Accept"), r.Header.Get("X-Requested-With")) {
+				http.Redirect(w, r, loginRedirect(am.opts.loginURL, r.URL.RequestURI(), nil), http.StatusFound)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", am.challenge("", ""))
+			errors.HTTPErrorHandler(w, r, errors.ErrUnauthorized("Authentication required"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireScopeHandler is the net/http counterpart of RequireScope
+func (am *AuthMiddleware[U, ID]) RequireScopeHandler(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			session := SessionFromContext[ID](r.Context())
+			if session == nil {
+				errors.HTTPErrorHandler(w, r, errors.ErrUnauthorized("Authentication required"))
+				return
+			}
+			if !session.HasScope(scope) {
+				errors.HTTPErrorHandler(w, r, errors.ErrForbidden("Insufficient scope"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// SetSessionCookieHTTP sets the session cookie on a net/http response
+func SetSessionCookieHTTP[ID UserID](w http.ResponseWriter, session *Session[ID]) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    session.ID,
+		Path:     "/",
+		Expires:  time.Unix(session.ExpiresAt, 0),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// ClearSessionCookieHTTP clears the session cookie on a net/http response
+func ClearSessionCookieHTTP(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    "",
+		Path:     "/",
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
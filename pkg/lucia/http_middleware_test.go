@@ -0,0 +1,198 @@
+package lucia
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// sessionMiddlewareCase is a request to a route behind the session middleware and RequireAuth
+type sessionMiddlewareCase struct {
+	name string
+	// prepare returns the request, given a live session
+	prepare       func(t *testing.T, service *AuthService[*testUser, string], session *Session[string]) *http.Request
+	mode          InvalidSessionMode
+	wantStatus    int
+	wantChallenge string
+	wantCleared   bool
+	wantLocation  string
+}
+
+func sessionMiddlewareCases() []sessionMiddlewareCase {
+	withCookie := func(r *http.Request, id string) *http.Request {
+		r.AddCookie(&http.Cookie{Name: SessionCookieName, Value: id})
+		return r
+	}
+	expire := func(t *testing.T, service *AuthService[*testUser, string], session *Session[string]) {
+		session.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+		if err := service.sessionStore.UpdateSession(context.Background(), session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	revoke := func(t *testing.T, service *AuthService[*testUser, string], session *Session[string]) {
+		if err := service.Logout(context.Background(), session.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return []sessionMiddlewareCase{
+		{
+			name: "no credential",
+			prepare: func(t *testing.T, service *AuthService[*testUser, string], session *Session[string]) *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/me", nil)
+			},
+			wantStatus:    http.StatusUnauthorized,
+			wantChallenge: "Bearer",
+		},
+		{
+			name: "cookie",
+			prepare: func(t *testing.T, service *AuthService[*testUser, string], session *Session[string]) *http.Request {
+				return withCookie(httptest.NewRequest(http.MethodGet, "/me", nil), session.ID)
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "bearer",
+			prepare: func(t *testing.T, service *AuthService[*testUser, string], session *Session[string]) *http.Request {
+				r := httptest.NewRequest(http.MethodGet, "/me", nil)
+				r.Header.Set("Authorization", "Bearer "+session.ID)
+				return r
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "expired cookie",
+			prepare: func(t *testing.T, service *AuthService[*testUser, string], session *Session[string]) *http.Request {
+				expire(t, service, session)
+				return withCookie(httptest.NewRequest(http.MethodGet, "/me", nil), session.ID)
+			},
+			wantStatus:    http.StatusUnauthorized,
+			wantChallenge: `error="invalid_token"`,
+			wantCleared:   true,
+		},
+		{
+			name: "expired bearer",
+			prepare: func(t *testing.T, service *AuthService[*testUser, string], session *Session[string]) *http.Request {
+				expire(t, service, session)
+				r := httptest.NewRequest(http.MethodGet, "/me", nil)
+				r.Header.Set("Authorization", "Bearer "+session.ID)
+				return r
+			},
+			wantStatus:    http.StatusUnauthorized,
+			wantChallenge: `error="invalid_token"`,
+		},
+		{
+			name: "revoked cookie",
+			prepare: func(t *testing.T, service *AuthService[*testUser, string], session *Session[string]) *http.Request {
+				revoke(t, service, session)
+				return withCookie(httptest.NewRequest(http.MethodGet, "/me", nil), session.ID)
+			},
+			wantStatus:    http.StatusUnauthorized,
+			wantChallenge: `error="invalid_token"`,
+			wantCleared:   true,
+		},
+		{
+			// Unknown bearer tokens may belong to another scheme, RequireAuth rejects the request
+			name: "revoked bearer",
+			prepare: func(t *testing.T, service *AuthService[*testUser, string], session *Session[string]) *http.Request {
+				revoke(t, service, session)
+				r := httptest.NewRequest(http.MethodGet, "/me", nil)
+				r.Header.Set("Authorization", "Bearer "+session.ID)
+				return r
+			},
+			wantStatus:    http.StatusUnauthorized,
+			wantChallenge: "Bearer",
+		},
+		{
+			name: "revoked cookie of a browser, redirect mode",
+			prepare: func(t *testing.T, service *AuthService[*testUser, string], session *Session[string]) *http.Request {
+				revoke(t, service, session)
+				r := withCookie(httptest.NewRequest(http.MethodGet, "/me", nil), session.ID)
+				r.Header.Set("Accept", "text/html")
+				return r
+			},
+			mode:         RedirectInvalidSession,
+			wantStatus:   http.StatusFound,
+			wantCleared:  true,
+			wantLocation: "/login?return_to=%2Fme",
+		},
+	}
+}
+
+// checkSessionMiddlewareResponse compares the answer of an adapter with the case
+func checkSessionMiddlewareResponse(t *testing.T, tt sessionMiddlewareCase, session *Session[string], resp *http.Response) {
+	t.Helper()
+	if resp.StatusCode != tt.wantStatus {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+	}
+	if tt.wantStatus == http.StatusOK {
+		if body, _ := io.ReadAll(resp.Body); string(body) != session.UserID {
+			t.Errorf("body = %q, want the user ID %q", body, session.UserID)
+		}
+	}
+	if challenge := resp.Header.Get("WWW-Authenticate"); !strings.Contains(challenge, tt.wantChallenge) || (tt.wantChallenge == "") != (challenge == "") {
+		t.Errorf("WWW-Authenticate = %q, want %q", challenge, tt.wantChallenge)
+	}
+	cleared := false
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == SessionCookieName && cookie.Value == "" {
+			cleared = true
+		}
+	}
+	if cleared != tt.wantCleared {
+		t.Errorf("cookie cleared = %v, want %v", cleared, tt.wantCleared)
+	}
+	if location := resp.Header.Get("Location"); location != tt.wantLocation {
+		t.Errorf("Location = %q, want %q", location, tt.wantLocation)
+	}
+}
+
+func TestSessionHandler(t *testing.T) {
+	for _, tt := range sessionMiddlewareCases() {
+		t.Run(tt.name, func(t *testing.T) {
+			service := newTestService()
+			session, err := service.HandleIdentity(context.Background(), "stub", &UserInfo{ID: "user", Provider: "stub"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			am := NewAuthMiddleware(service, WithInvalidSession(tt.mode), WithLoginURL("/login"))
+			handler := am.Handler(am.RequireAuthHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, SessionFromContext[string](r.Context()).UserID)
+			})))
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, tt.prepare(t, service, session))
+			checkSessionMiddlewareResponse(t, tt, session, rec.Result())
+		})
+	}
+}
+
+func TestSessionMiddlewareMatchesHandler(t *testing.T) {
+	for _, tt := range sessionMiddlewareCases() {
+		t.Run(tt.name, func(t *testing.T) {
+			service := newTestService()
+			session, err := service.HandleIdentity(context.Background(), "stub", &UserInfo{ID: "user", Provider: "stub"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			am := NewAuthMiddleware(service, WithInvalidSession(tt.mode), WithLoginURL("/login"))
+			app := fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+			app.Get("/me", am.SessionMiddleware(), am.RequireAuth(), func(c *fiber.Ctx) error {
+				return c.SendString(GetSession[string](c).UserID)
+			})
+
+			resp, err := app.Test(tt.prepare(t, service, session), -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSessionMiddlewareResponse(t, tt, session, resp)
+		})
+	}
+}
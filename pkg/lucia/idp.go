@@ -0,0 +1,783 @@
+package lucia
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Paths of the identity provider endpoints, relative to the issuer
+const (
+	idpAuthorizePath  = "/oauth/authorize"
+	idpTokenPath      = "/oauth/token"
+	idpUserInfoPath   = "/oauth/userinfo"
+	idpJWKSPath       = "/oauth/jwks"
+	idpIntrospectPath = "/oauth/introspect"
+	idpDiscoveryPath  = "/.well-known/openid-configuration"
+)
+
+// OAuthClient is an application registered with the IdentityProvider
+type OAuthClient struct {
+	ID   string
+	Name string
+	// SecretHash is the SHA-256 of the client secret, empty for public clients
+	SecretHash string
+	// RedirectURIs are matched exactly against the redirect_uri of authorization requests
+	RedirectURIs []string
+	// Public clients (SPAs, native apps) cannot keep a secret and authenticate with PKCE alone
+	Public    bool
+	CreatedAt time.Time
+}
+
+// allowsRedirect reports whether redirectURI is registered for the client
+func (c *OAuthClient) allowsRedirect(redirectURI string) bool {
+	for _, uri := range c.RedirectURIs {
+		if uri == redirectURI {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthorizationCode is an issued authorization code, the code itself is only stored hashed
+type AuthorizationCode struct {
+	CodeHash string
+	ClientID string
+	// UserID is the encoded ID (see IDCodec) of the user who authorized the client
+	UserID string
+	// SessionID is the session of the user on the identity provider, tokens die with it
+	SessionID     string
+	RedirectURI   string
+	Scopes        []string
+	Nonce         string
+	CodeChallenge string
+	ExpiresAt     time.Time
+}
+
+// IdentityProviderStore persists registered clients and authorization codes
+type IdentityProviderStore interface {
+	CreateClient(ctx context.Context, client *OAuthClient) error
+	GetClient(ctx context.Context, clientID string) (*OAuthClient, error)
+	DeleteClient(ctx context.Context, clientID string) error
+	CreateAuthorizationCode(ctx context.Context, code *AuthorizationCode) error
+	// ConsumeAuthorizationCode deletes and returns the code, so a code can only be redeemed once
+	ConsumeAuthorizationCode(ctx context.Context, codeHash string) (*AuthorizationCode, error)
+}
+
+// IdentityProviderConfig configures an IdentityProvider
+type IdentityProviderConfig struct {
+	// Issuer is the absolute URL the provider is mounted at, without trailing slash
+	Issuer string
+	// SigningKey signs ID and access tokens, an *rsa.PrivateKey (RS256) or P-256 *ecdsa.PrivateKey (ES256)
+	SigningKey crypto.Signer
+	KeyID      string
+	// PreviousKeys are still published and accepted after a key rotation, until the tokens they signed expire
+	PreviousKeys []JWK
+	// LoginURL is where users without a session are sent, with a return_to parameter. Without it they get a 401.
+	LoginURL string
+	// AccessTokenTTL defaults to 1 hour, it also applies to ID tokens
+	AccessTokenTTL time.Duration
+	// CodeTTL defaults to 1 minute
+	CodeTTL time.Duration
+	// SessionKey is a secret of at least 32 bytes, shared by every instance. Tokens never carry the ID of the
+	// session they were issued from, which is a bearer credential of the app: their sid claim is an HMAC of it
+	// under this key, and access tokens find their session through a reference encrypted with it. Changing the
+	// key ends the outstanding access tokens.
+	SessionKey []byte
+}
+
+// IdentityProvider is a minimal OAuth 2.0 and OpenID Connect authorization server for first-party apps,
+// backed by the sessions of an AuthService. It supports the authorization code flow with mandatory PKCE (S256),
+// and issues JWT access tokens (RFC 9068) and ID tokens that die with the session they were issued from.
+// Consent is not asked, every registered client is trusted. Clients renew tokens by sending the user
+// through the authorization endpoint again, with prompt=none to do it without interaction.
+type IdentityProvider[U AuthUser[ID], ID UserID] struct {
+	service *AuthService[U, ID]
+	store   IdentityProviderStore
+	cfg     IdentityProviderConfig
+	alg     string
+	sidKey  []byte
+	sealer  cipher.AEAD
+
+	mu     sync.RWMutex
+	claims func(ctx context.Context, userID ID, scopes []string) (map[string]interface{}, error)
+}
+
+func NewIdentityProvider[U AuthUser[ID], ID UserID](service *AuthService[U, ID], store IdentityProviderStore, cfg IdentityProviderConfig) (*IdentityProvider[U, ID], error) {
+	if cfg.Issuer == "" || cfg.SigningKey == nil {
+		return nil, errors.NewLuciaError("ConfigurationError", "The identity provider needs an issuer and a signing key")
+	}
+	var alg string
+	switch cfg.SigningKey.Public().(type) {
+	case *rsa.PublicKey:
+		alg = "RS256"
+	case *ecdsa.PublicKey:
+		alg = "ES256"
+	default:
+		return nil, errors.NewLuciaError("ConfigurationError", "Unsupported signing key type")
+	}
+	if len(cfg.SessionKey) < 32 {
+		return nil, errors.NewLuciaError("ConfigurationError", "The identity provider needs a session key of at least 32 bytes")
+	}
+	// Separate keys for the sid HMAC and the session reference encryption
+	block, err := aes.NewCipher(deriveKey(cfg.SessionKey, "lucia-idp-session-reference"))
+	if err != nil {
+		return nil, errors.NewLuciaError("ConfigurationError", "Invalid session key").WithCause(err)
+	}
+	sealer, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.NewLuciaError("ConfigurationError", "Invalid session key").WithCause(err)
+	}
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+	if cfg.AccessTokenTTL <= 0 {
+		cfg.AccessTokenTTL = time.Hour
+	}
+	if cfg.CodeTTL <= 0 {
+		cfg.CodeTTL = time.Minute
+	}
+	return &IdentityProvider[U, ID]{
+		service: service,
+		store:   store,
+		cfg:     cfg,
+		alg:     alg,
+		sidKey:  deriveKey(cfg.SessionKey, "lucia-idp-sid"),
+		sealer:  sealer,
+	}, nil
+}
+
+// deriveKey derives a 32 byte key for purpose from key
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// sid returns the sid claim of the tokens issued from a session, stable for the session and irreversible
+func (p *IdentityProvider[U, ID]) sid(sessionID string) string {
+	mac := hmac.New(sha256.New, p.sidKey)
+	mac.Write([]byte(sessionID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// sealSessionID encrypts the session ID carried by access tokens, so that they can find their session
+func (p *IdentityProvider[U, ID]) sealSessionID(sessionID string) string {
+	nonce := make([]byte, p.sealer.NonceSize())
+	rand.Read(nonce)
+	return base64.RawURLEncoding.EncodeToString(p.sealer.Seal(nonce, nonce, []byte(sessionID), nil))
+}
+
+func (p *IdentityProvider[U, ID]) openSessionID(sealed string) (string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil || len(raw) < p.sealer.NonceSize() {
+		return "", errors.NewLuciaError("InvalidToken", "Invalid session reference")
+	}
+	nonce, ciphertext := raw[:p.sealer.NonceSize()], raw[p.sealer.NonceSize():]
+	sessionID, err := p.sealer.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", errors.NewLuciaError("InvalidToken", "Invalid session reference")
+	}
+	return string(sessionID), nil
+}
+
+// SetClaims sets the function returning the profile claims (name, email...) of a user for the granted scopes,
+// they are added to ID tokens and userinfo responses. Without it only sub is returned.
+func (p *IdentityProvider[U, ID]) SetClaims(fn func(ctx context.Context, userID ID, scopes []string) (map[string]interface{}, error)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.claims = fn
+}
+
+// RegisterClient registers an application, returning it with its ID and, for confidential clients, the secret.
+// The secret is only stored hashed and cannot be retrieved later.
+func (p *IdentityProvider[U, ID]) RegisterClient(ctx context.Context, name string, redirectURIs []string, public bool) (*OAuthClient, string, error) {
+	if len(redirectURIs) == 0 {
+		return nil, "", errors.NewLuciaError("InvalidRequest", "At least one redirect URI is required")
+	}
+	for _, uri := range redirectURIs {
+		if err := validateRedirectURI(uri); err != nil {
+			return nil, "", err
+		}
+	}
+
+	client := &OAuthClient{
+		ID:           GenerateID(),
+		Name:         name,
+		RedirectURIs: redirectURIs,
+		Public:       public,
+		CreatedAt:    time.Now(),
+	}
+	var secret string
+	if !public {
+		secret = GenerateID() + GenerateID()
+		client.SecretHash = hashToken(secret)
+	}
+	if err := p.store.CreateClient(ctx, client); err != nil {
+		return nil, "", errors.NewLuciaError("DatabaseError", "Failed to register client").WithCause(err).WithOp("CreateClient")
+	}
+	return client, secret, nil
+}
+
+// validateRedirectURI only accepts absolute URIs without fragment, over HTTPS unless they point to the loopback
+func validateRedirectURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() || u.Fragment != "" {
+		return errors.NewLuciaError("InvalidRequest", "Invalid redirect URI "+uri)
+	}
+	host := u.Hostname()
+	if u.Scheme == "http" && host != "localhost" && host != "127.0.0.1" && host != "::1" {
+		return errors.NewLuciaError("InvalidRequest", "Redirect URIs must use HTTPS "+uri)
+	}
+	return nil
+}
+
+// DeleteClient removes a client, tokens it already holds stay valid until they expire
+func (p *IdentityProvider[U, ID]) DeleteClient(ctx context.Context, clientID string) error {
+	if err := p.store.DeleteClient(ctx, clientID); err != nil {
+		return errors.NewLuciaError("DatabaseError", "Failed to delete client").WithCause(err).WithOp("DeleteClient")
+	}
+	return nil
+}
+
+func (p *IdentityProvider[U, ID]) getClient(ctx context.Context, clientID string) (*OAuthClient, error) {
+	if clientID == "" {
+		return nil, errors.NewLuciaError("InvalidRequest", "Missing client_id")
+	}
+	client, err := p.store.GetClient(ctx, clientID)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, errors.NewLuciaError("InvalidClient", "Unknown client").WithCause(err)
+		}
+		return nil, errors.NewLuciaError("DatabaseError", "Failed to fetch client").WithCause(err).WithOp("GetClient")
+	}
+	return client, nil
+}
+
+// Mount registers every endpoint on router, which must be served at the issuer URL
+func (p *IdentityProvider[U, ID]) Mount(router fiber.Router) {
+	router.Get(idpDiscoveryPath, p.DiscoveryHandler())
+	router.Get(idpJWKSPath, p.JWKSHandler())
+	router.Get(idpAuthorizePath, p.AuthorizeHandler())
+	router.Post(idpTokenPath, p.TokenHandler())
+	router.Get(idpUserInfoPath, p.UserInfoHandler())
+	router.Post(idpUserInfoPath, p.UserInfoHandler())
+	router.Post(idpIntrospectPath, p.IntrospectionHandler())
+}
+
+// DiscoveryHandler serves the OpenID Connect discovery document
+func (p *IdentityProvider[U, ID]) DiscoveryHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{
+			"issuer":                                        p.cfg.Issuer,
+			"authorization_endpoint":                        p.cfg.Issuer + idpAuthorizePath,
+			"token_endpoint":                                p.cfg.Issuer + idpTokenPath,
+			"userinfo_endpoint":                             p.cfg.Issuer + idpUserInfoPath,
+			"jwks_uri":                                      p.cfg.Issuer + idpJWKSPath,
+			"introspection_endpoint":                        p.cfg.Issuer + idpIntrospectPath,
+			"response_types_supported":                      []string{"code"},
+			"grant_types_supported":                         []string{"authorization_code"},
+			"subject_types_supported":                       []string{"public"},
+			"id_token_signing_alg_values_supported":         []string{p.alg},
+			"scopes_supported":                              []string{"openid", "profile", "email"},
+			"token_endpoint_auth_methods_supported":         []string{"client_secret_basic", "client_secret_post", "none"},
+			"introspection_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+			"code_challenge_methods_supported":              []string{"S256"},
+			"claims_supported":                              []string{"iss", "sub", "aud", "exp", "iat", "nonce", "sid", "auth_time", "amr"},
+		})
+	}
+}
+
+// JWKSHandler serves the public keys tokens are signed with
+func (p *IdentityProvider[U, ID]) JWKSHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		current, err := NewJWK(p.cfg.KeyID, p.cfg.SigningKey.Public())
+		if err != nil {
+			return err
+		}
+		c.Set(fiber.HeaderCacheControl, "public, max-age=3600")
+		return c.JSON(JWKSet{Keys: append([]JWK{current}, p.cfg.PreviousKeys...)})
+	}
+}
+
+// AuthorizeHandler serves the authorization endpoint, mount it behind SessionMiddleware.
+// Errors are only redirected to the client once the client and its redirect URI are known to be valid.
+func (p *IdentityProvider[U, ID]) AuthorizeHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.UserContext()
+		client, err := p.getClient(ctx, c.Query("client_id"))
+		if err != nil {
+			return writeOAuthError(c, err)
+		}
+		// OpenID Connect requires redirect_uri, so it is never defaulted to the registered one
+		redirectURI := c.Query("redirect_uri")
+		if !client.allowsRedirect(redirectURI) {
+			return writeOAuthError(c, errors.NewLuciaError("InvalidRequest", "redirect_uri is not registered for the client"))
+		}
+
+		state := c.Query("state")
+		redirect := func(params url.Values) error {
+			if state != "" {
+				params.Set("state", state)
+			}
+			params.Set("iss", p.cfg.Issuer)
+			return c.Redirect(appendQuery(redirectURI, params))
+		}
+		redirectError := func(code, description string) error {
+			return redirect(url.Values{"error": {code}, "error_description": {description}})
+		}
+
+		if c.Query("response_type") != "code" {
+			return redirectError("unsupported_response_type", "Only the code response type is supported")
+		}
+		challenge := c.Query("code_challenge")
+		if challenge == "" || c.Query("code_challenge_method") != "S256" {
+			return redirectError("invalid_request", "PKCE with the S256 method is required")
+		}
+
+		session := GetSession[ID](c)
+		if session == nil {
+			if hasPrompt(c.Query("prompt"), PromptNone) {
+				return redirectError("login_required", "The user is not signed in")
+			}
+			if p.cfg.LoginURL == "" {
+				return errors.ErrUnauthorized("Authentication required")
+			}
+			return c.Redirect(appendQuery(p.cfg.LoginURL, url.Values{"return_to": {c.OriginalURL()}}))
+		}
+		if err := checkDelegation(session); err != nil {
+			return redirectError("access_denied", "This session cannot authorize clients")
+		}
+
+		code := GenerateID() + GenerateID()
+		err = p.store.CreateAuthorizationCode(ctx, &AuthorizationCode{
+			CodeHash:      hashToken(code),
+			ClientID:      client.ID,
+			UserID:        p.service.codec.Encode(session.UserID),
+			SessionID:     session.ID,
+			RedirectURI:   redirectURI,
+			Scopes:        strings.Fields(c.Query("scope")),
+			Nonce:         c.Query("nonce"),
+			CodeChallenge: challenge,
+			ExpiresAt:     time.Now().Add(p.cfg.CodeTTL),
+		})
+		if err != nil {
+			return redirectError("server_error", "Failed to issue the authorization code")
+		}
+		return redirect(url.Values{"code": {code}})
+	}
+}
+
+// hasPrompt reports whether the space separated prompt parameter contains value
+func hasPrompt(prompt, value string) bool {
+	for _, p := range strings.Fields(prompt) {
+		if p == value {
+			return true
+		}
+	}
+	return false
+}
+
+// appendQuery adds params to the query of rawURL, keeping the parameters it already has
+func appendQuery(rawURL string, params url.Values) string {
+	if strings.Contains(rawURL, "?") {
+		return rawURL + "&" + params.Encode()
+	}
+	return rawURL + "?" + params.Encode()
+}
+
+// TokenHandler serves the token endpoint for the authorization_code grant
+func (p *IdentityProvider[U, ID]) TokenHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.FormValue("grant_type") != "authorization_code" {
+			return writeOAuthError(c, errors.NewLuciaError("UnsupportedGrantType", "Unsupported grant_type"))
+		}
+		clientID, secret := clientCredentials(c)
+		tokens, err := p.Exchange(c.UserContext(), clientID, secret, c.FormValue("code"), c.FormValue("redirect_uri"), c.FormValue("code_verifier"))
+		if err != nil {
+			return writeOAuthError(c, err)
+		}
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		return c.JSON(tokens)
+	}
+}
+
+// clientCredentials reads client_secret_basic credentials, falling back to client_secret_post and public clients
+func clientCredentials(c *fiber.Ctx) (clientID, secret string) {
+	if auth := c.Get(fiber.HeaderAuthorization); len(auth) > 6 && strings.EqualFold(auth[:6], "Basic ") {
+		if raw, err := base64.StdEncoding.DecodeString(auth[6:]); err == nil {
+			if id, s, ok := strings.Cut(string(raw), ":"); ok {
+				// RFC 6749 form-encodes the credentials before the base64 encoding
+				id, _ = url.QueryUnescape(id)
+				s, _ = url.QueryUnescape(s)
+				return id, s
+			}
+		}
+	}
+	return c.FormValue("client_id"), c.FormValue("client_secret")
+}
+
+// TokenResponse is the token endpoint response
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	IDToken     string `json:"id_token,omitempty"`
+	Scope       string `json:"scope,omitempty"`
+}
+
+// Exchange redeems an authorization code for an access token and, when the openid scope was granted, an ID token
+func (p *IdentityProvider[U, ID]) Exchange(ctx context.Context, clientID, clientSecret, code, redirectURI, codeVerifier string) (*TokenResponse, error) {
+	client, err := p.getClient(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	if err := authenticateClient(client, clientSecret); err != nil {
+		return nil, err
+	}
+	if code == "" {
+		return nil, errors.NewLuciaError("InvalidRequest", "Missing code")
+	}
+
+	authCode, err := p.store.ConsumeAuthorizationCode(ctx, hashToken(code))
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, errors.NewLuciaError("InvalidGrant", "Invalid authorization code").WithCause(err)
+		}
+		return nil, errors.NewLuciaError("DatabaseError", "Failed to redeem authorization code").WithCause(err).WithOp("ConsumeAuthorizationCode")
+	}
+	if authCode.ClientID != client.ID || authCode.RedirectURI != redirectURI || time.Now().After(authCode.ExpiresAt) {
+		return nil, errors.NewLuciaError("InvalidGrant", "Invalid authorization code")
+	}
+	if !verifyPKCE(authCode.CodeChallenge, codeVerifier) {
+		return nil, errors.NewLuciaError("InvalidGrant", "Invalid code_verifier")
+	}
+
+	session, err := p.service.GetSession(ctx, authCode.SessionID)
+	if err != nil || session.IsExpired() {
+		return nil, errors.NewLuciaError("InvalidGrant", "The session has ended")
+	}
+	if err := checkDelegation(session); err != nil {
+		return nil, errors.NewLuciaError("InvalidGrant", "The session cannot grant access to clients").WithCause(err)
+	}
+	return p.issueTokens(ctx, client, authCode, session)
+}
+
+// authenticateClient checks the secret of confidential clients, public clients rely on PKCE
+func authenticateClient(client *OAuthClient, secret string) error {
+	if client.Public {
+		return nil
+	}
+	if secret == "" || subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(client.SecretHash)) != 1 {
+		return errors.NewLuciaError("InvalidClient", "Invalid client credentials")
+	}
+	return nil
+}
+
+// verifyPKCE checks an RFC 7636 S256 code verifier against its challenge
+func verifyPKCE(challenge, verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+func (p *IdentityProvider[U, ID]) issueTokens(ctx context.Context, client *OAuthClient, authCode *AuthorizationCode, session *Session[ID]) (*TokenResponse, error) {
+	now := time.Now()
+	expiresAt := now.Add(p.cfg.AccessTokenTTL)
+	// Tokens never outlive the session they were issued from
+	if sessionExpiry := time.Unix(session.ExpiresAt, 0); sessionExpiry.Before(expiresAt) {
+		expiresAt = sessionExpiry
+	}
+	scope := strings.Join(authCode.Scopes, " ")
+
+	accessToken, err := signTypedJWT(p.cfg.SigningKey, p.cfg.KeyID, "at+jwt", map[string]interface{}{
+		"iss":       p.cfg.Issuer,
+		"sub":       authCode.UserID,
+		"aud":       client.ID,
+		"client_id": client.ID,
+		"scope":     scope,
+		"sid":       p.sid(session.ID),
+		"ssr":       p.sealSessionID(session.ID),
+		"jti":       GenerateID(),
+		"iat":       now.Unix(),
+		"exp":       expiresAt.Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp := &TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(time.Until(expiresAt).Seconds()),
+		Scope:       scope,
+	}
+
+	if !containsScope(authCode.Scopes, "openid") {
+		return resp, nil
+	}
+	claims, err := p.userClaims(ctx, session.UserID, authCode.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	claims["iss"] = p.cfg.Issuer
+	claims["sub"] = authCode.UserID
+	claims["aud"] = client.ID
+	claims["azp"] = client.ID
+	claims["sid"] = p.sid(session.ID)
+	claims["iat"] = now.Unix()
+	claims["exp"] = expiresAt.Unix()
+	if authCode.Nonce != "" {
+		claims["nonce"] = authCode.Nonce
+	}
+	if session.AuthTime > 0 {
+		claims["auth_time"] = session.AuthTime
+	}
+	if session.MFA {
+		claims["amr"] = []string{AuthMethodMFA}
+	}
+	resp.IDToken, err = signJWT(p.cfg.SigningKey, p.cfg.KeyID, claims)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func containsScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// userClaims returns a fresh map of the profile claims of the user, empty when no claims function is set
+func (p *IdentityProvider[U, ID]) userClaims(ctx context.Context, userID ID, scopes []string) (map[string]interface{}, error) {
+	p.mu.RLock()
+	fn := p.claims
+	p.mu.RUnlock()
+
+	claims := make(map[string]interface{})
+	if fn == nil {
+		return claims, nil
+	}
+	extra, err := fn(ctx, userID, scopes)
+	if err != nil {
+		return nil, errors.NewLuciaError("UnexpectedError", "Failed to load user claims").WithCause(err)
+	}
+	for k, v := range extra {
+		claims[k] = v
+	}
+	return claims, nil
+}
+
+type accessTokenClaims struct {
+	ClientID string `json:"client_id"`
+	Scope    string `json:"scope"`
+	SID      string `json:"sid"`
+	// SessionRef is the encrypted ID of the session the token was issued from, see sealSessionID
+	SessionRef string `json:"ssr"`
+}
+
+// VerifyAccessToken checks an access token issued by this provider and that its session is still alive
+func (p *IdentityProvider[U, ID]) VerifyAccessToken(ctx context.Context, token string) (*Session[ID], []string, error) {
+	session, _, claims, err := p.verifyAccessToken(ctx, token)
+	if err != nil {
+		return nil, nil, err
+	}
+	return session, strings.Fields(claims.Scope), nil
+}
+
+func (p *IdentityProvider[U, ID]) verifyAccessToken(ctx context.Context, token string) (*Session[ID], *jwtClaims, *accessTokenClaims, error) {
+	var claims accessTokenClaims
+	registered, err := verifyJWT(token, func(header jwtHeader) (crypto.PublicKey, error) {
+		// ID tokens are signed with the same key, the type keeps them from being used as access tokens
+		if header.Typ != "at+jwt" {
+			return nil, errors.NewLuciaError("InvalidToken", "Not an access token")
+		}
+		return p.publicKey(header.Kid)
+	}, &claims)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if registered.Issuer != p.cfg.Issuer {
+		return nil, nil, nil, errors.NewLuciaError("InvalidToken", "Unexpected JWT issuer "+registered.Issuer)
+	}
+
+	sessionID, err := p.openSessionID(claims.SessionRef)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	session, err := p.service.GetSession(ctx, sessionID)
+	if err != nil || session.IsExpired() || p.service.codec.Encode(session.UserID) != registered.Subject {
+		return nil, nil, nil, errors.NewLuciaError("InvalidToken", "The session has ended")
+	}
+	return session, registered, &claims, nil
+}
+
+// IntrospectionHandler serves the RFC 7662 introspection endpoint for the access tokens of this provider,
+// for resource servers that cannot verify them locally. Callers authenticate as a confidential client.
+// Any token that is not an active access token of this provider, including ID tokens, is reported inactive.
+func (p *IdentityProvider[U, ID]) IntrospectionHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.UserContext()
+		clientID, secret := clientCredentials(c)
+		client, err := p.getClient(ctx, clientID)
+		if err == nil && client.Public {
+			err = errors.NewLuciaError("InvalidClient", "Public clients cannot introspect tokens")
+		}
+		if err == nil {
+			err = authenticateClient(client, secret)
+		}
+		if err != nil {
+			return writeOAuthError(c, err)
+		}
+		token := c.FormValue("token")
+		if token == "" {
+			return writeOAuthError(c, errors.NewLuciaError("InvalidRequest", "Missing token"))
+		}
+
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		session, registered, claims, err := p.verifyAccessToken(ctx, token)
+		if err != nil {
+			return c.JSON(fiber.Map{"active": false})
+		}
+		resp := fiber.Map{
+			"active":     true,
+			"scope":      claims.Scope,
+			"client_id":  claims.ClientID,
+			"token_type": "Bearer",
+			"exp":        registered.ExpiresAt,
+			"iat":        registered.IssuedAt,
+			"sub":        registered.Subject,
+			"aud":        registered.Audience,
+			"iss":        registered.Issuer,
+			"sid":        claims.SID,
+		}
+		if session.AuthTime > 0 {
+			resp["auth_time"] = session.AuthTime
+		}
+		return c.JSON(resp)
+	}
+}
+
+// publicKey returns the current or a previous verification key
+func (p *IdentityProvider[U, ID]) publicKey(kid string) (crypto.PublicKey, error) {
+	if kid == p.cfg.KeyID {
+		return p.cfg.SigningKey.Public(), nil
+	}
+	for _, k := range p.cfg.PreviousKeys {
+		if k.Kid == kid {
+			return k.PublicKey()
+		}
+	}
+	return nil, errors.NewLuciaError("InvalidToken", "Unknown JWT signing key "+kid)
+}
+
+// UserInfoHandler serves the OpenID Connect userinfo endpoint
+func (p *IdentityProvider[U, ID]) UserInfoHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token, _ := sessionCredential("", c.Get(fiber.HeaderAuthorization))
+		if token == "" {
+			c.Set(fiber.HeaderWWWAuthenticate, `Bearer realm="userinfo"`)
+			return errors.ErrUnauthorized("Missing access token")
+		}
+		session, scopes, err := p.VerifyAccessToken(c.UserContext(), token)
+		if err != nil {
+			c.Set(fiber.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+			return err
+		}
+
+		claims, err := p.userClaims(c.UserContext(), session.UserID, scopes)
+		if err != nil {
+			return err
+		}
+		claims["sub"] = p.service.codec.Encode(session.UserID)
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		return c.JSON(claims)
+	}
+}
+
+// MemoryIdentityProviderStore is an in-process IdentityProviderStore, registered clients are lost on restart
+type MemoryIdentityProviderStore struct {
+	mu      sync.Mutex
+	clients map[string]*OAuthClient
+	codes   map[string]*AuthorizationCode
+}
+
+func NewMemoryIdentityProviderStore() *MemoryIdentityProviderStore {
+	return &MemoryIdentityProviderStore{
+		clients: make(map[string]*OAuthClient),
+		codes:   make(map[string]*AuthorizationCode),
+	}
+}
+
+func (s *MemoryIdentityProviderStore) CreateClient(ctx context.Context, client *OAuthClient) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.clients[client.ID]; ok {
+		return errors.ErrConflict("Client already exists")
+	}
+	stored := *client
+	s.clients[client.ID] = &stored
+	return nil
+}
+
+func (s *MemoryIdentityProviderStore) GetClient(ctx context.Context, clientID string) (*OAuthClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	client, ok := s.clients[clientID]
+	if !ok {
+		return nil, errors.ErrNotFound("Client not found")
+	}
+	c := *client
+	return &c, nil
+}
+
+func (s *MemoryIdentityProviderStore) DeleteClient(ctx context.Context, clientID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clients, clientID)
+	return nil
+}
+
+func (s *MemoryIdentityProviderStore) CreateAuthorizationCode(ctx context.Context, code *AuthorizationCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for hash, c := range s.codes {
+		if now.After(c.ExpiresAt) {
+			delete(s.codes, hash)
+		}
+	}
+	stored := *code
+	s.codes[code.CodeHash] = &stored
+	return nil
+}
+
+func (s *MemoryIdentityProviderStore) ConsumeAuthorizationCode(ctx context.Context, codeHash string) (*AuthorizationCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	code, ok := s.codes[codeHash]
+	if !ok {
+		return nil, errors.ErrNotFound("Authorization code not found")
+	}
+	delete(s.codes, codeHash)
+	return code, nil
+}
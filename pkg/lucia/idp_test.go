@@ -0,0 +1,334 @@
+package lucia
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+const testRedirectURI = "https://app.example/callback"
+
+type idpTest struct {
+	t       *testing.T
+	service *AuthService[*testUser, string]
+	idp     *IdentityProvider[*testUser, string]
+	app     *fiber.App
+	client  *OAuthClient
+	secret  string
+	public  *OAuthClient
+}
+
+func newIdPTest(t *testing.T) *idpTest {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := newTestService()
+	idp, err := NewIdentityProvider(service, NewMemoryIdentityProviderStore(), IdentityProviderConfig{
+		Issuer:     "https://idp.example",
+		SigningKey: key,
+		KeyID:      "k1",
+		SessionKey: []byte("0123456789abcdef0123456789abcdef"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	client, secret, err := idp.RegisterClient(ctx, "app", []string{testRedirectURI}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, _, err := idp.RegisterClient(ctx, "spa", []string{testRedirectURI}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+	app.Use(NewAuthMiddleware(service).SessionMiddleware())
+	idp.Mount(app)
+	return &idpTest{t: t, service: service, idp: idp, app: app, client: client, secret: secret, public: public}
+}
+
+func (it *idpTest) login(userID string) *Session[string] {
+	it.t.Helper()
+	session, err := it.service.HandleIdentity(context.Background(), "saml", &UserInfo{ID: userID, Provider: "saml"})
+	if err != nil {
+		it.t.Fatal(err)
+	}
+	return session
+}
+
+func (it *idpTest) do(req *http.Request) (*http.Response, map[string]interface{}) {
+	it.t.Helper()
+	resp, err := it.app.Test(req, -1)
+	if err != nil {
+		it.t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	var decoded map[string]interface{}
+	_ = json.Unmarshal(body, &decoded)
+	return resp, decoded
+}
+
+// authorize runs the authorization endpoint for session and returns the redirect it answered with
+func (it *idpTest) authorize(session *Session[string], clientID, verifier string) *url.URL {
+	it.t.Helper()
+	sum := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"client_id":             {clientID},
+		"redirect_uri":          {testRedirectURI},
+		"response_type":         {"code"},
+		"scope":                 {"openid profile"},
+		"state":                 {"xyz"},
+		"nonce":                 {"n-0S6"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {"S256"},
+	}
+	req := httptest.NewRequest(http.MethodGet, idpAuthorizePath+"?"+query.Encode(), nil)
+	if session != nil {
+		req.AddCookie(&http.Cookie{Name: SessionCookieName, Value: session.ID})
+	}
+	resp, _ := it.do(req)
+	location, err := resp.Location()
+	if err != nil {
+		it.t.Fatalf("authorize answered %d without a redirect", resp.StatusCode)
+	}
+	return location
+}
+
+func (it *idpTest) token(form url.Values) (*http.Response, map[string]interface{}) {
+	it.t.Helper()
+	form.Set("grant_type", "authorization_code")
+	req := httptest.NewRequest(http.MethodPost, idpTokenPath, strings.NewReader(form.Encode()))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+	return it.do(req)
+}
+
+func (it *idpTest) introspect(token string) map[string]interface{} {
+	it.t.Helper()
+	req := httptest.NewRequest(http.MethodPost, idpIntrospectPath, strings.NewReader(url.Values{"token": {token}}.Encode()))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+	req.SetBasicAuth(it.client.ID, it.secret)
+	resp, body := it.do(req)
+	if resp.StatusCode != http.StatusOK {
+		it.t.Fatalf("introspection answered %d: %v", resp.StatusCode, body)
+	}
+	return body
+}
+
+// jwtPayload decodes the claims of a JWT without verifying it, as a relying party reading it would
+func jwtPayload(t *testing.T, token string) map[string]interface{} {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("malformed JWT %q", token)
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(raw, &claims); err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
+
+const testVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk-verifier"
+
+func TestIdentityProviderCodeFlow(t *testing.T) {
+	it := newIdPTest(t)
+	session := it.login("alice")
+
+	location := it.authorize(session, it.client.ID, testVerifier)
+	if location.Query().Get("state") != "xyz" || location.Query().Get("iss") != "https://idp.example" {
+		t.Fatalf("unexpected redirect %s", location)
+	}
+	code := location.Query().Get("code")
+
+	resp, tokens := it.token(url.Values{"code": {code}, "redirect_uri": {testRedirectURI}, "code_verifier": {testVerifier},
+		"client_id": {it.client.ID}, "client_secret": {it.secret}})
+	if resp.StatusCode != http.StatusOK || resp.Header.Get(fiber.HeaderCacheControl) != "no-store" {
+		t.Fatalf("token endpoint answered %d: %v", resp.StatusCode, tokens)
+	}
+	accessToken, _ := tokens["access_token"].(string)
+	idToken, _ := tokens["id_token"].(string)
+
+	idClaims := jwtPayload(t, idToken)
+	if idClaims["nonce"] != "n-0S6" || idClaims["aud"] != it.client.ID || idClaims["sub"] != session.UserID {
+		t.Errorf("unexpected ID token claims %v", idClaims)
+	}
+	for name, token := range map[string]string{"access token": accessToken, "ID token": idToken} {
+		raw, _ := base64.RawURLEncoding.DecodeString(strings.Split(token, ".")[1])
+		if strings.Contains(string(raw), session.ID) {
+			t.Errorf("the %s carries the session ID", name)
+		}
+		if sid := jwtPayload(t, token)["sid"]; sid != idClaims["sid"] || sid == "" {
+			t.Errorf("the %s sid %v is not the stable sid %v", name, sid, idClaims["sid"])
+		}
+	}
+	if _, err := it.service.GetSession(context.Background(), idClaims["sid"].(string)); err == nil {
+		t.Error("the sid claim is a usable session ID")
+	}
+
+	introspection := it.introspect(accessToken)
+	if introspection["active"] != true || introspection["sid"] != idClaims["sid"] || introspection["client_id"] != it.client.ID {
+		t.Errorf("unexpected introspection %v", introspection)
+	}
+	if strings.Contains(jsonString(t, introspection), session.ID) {
+		t.Error("the introspection response carries the session ID")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, idpUserInfoPath, nil)
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer "+accessToken)
+	if resp, body := it.do(req); resp.StatusCode != http.StatusOK || body["sub"] != session.UserID {
+		t.Errorf("userinfo answered %d: %v", resp.StatusCode, body)
+	}
+
+	if err := it.service.Logout(context.Background(), session.ID); err != nil {
+		t.Fatal(err)
+	}
+	if introspection := it.introspect(accessToken); introspection["active"] != false || len(introspection) != 1 {
+		t.Errorf("introspection after logout = %v, want only active false", introspection)
+	}
+}
+
+func jsonString(t *testing.T, v interface{}) string {
+	t.Helper()
+	raw, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(raw)
+}
+
+func TestIdentityProviderTokenEndpointErrors(t *testing.T) {
+	it := newIdPTest(t)
+	session := it.login("alice")
+
+	tests := []struct {
+		name      string
+		clientID  string
+		form      func(code string) url.Values
+		wantCode  int
+		wantError string
+	}{
+		{
+			name:     "wrong code verifier",
+			clientID: it.client.ID,
+			form: func(code string) url.Values {
+				return url.Values{"code": {code}, "redirect_uri": {testRedirectURI}, "code_verifier": {testVerifier + "-wrong"}}
+			},
+			wantCode: http.StatusBadRequest, wantError: "invalid_grant",
+		},
+		{
+			name:     "wrong redirect URI",
+			clientID: it.client.ID,
+			form: func(code string) url.Values {
+				return url.Values{"code": {code}, "redirect_uri": {"https://evil.example/callback"}, "code_verifier": {testVerifier}}
+			},
+			wantCode: http.StatusBadRequest, wantError: "invalid_grant",
+		},
+		{
+			name:     "wrong client secret",
+			clientID: it.client.ID,
+			form: func(code string) url.Values {
+				return url.Values{"code": {code}, "redirect_uri": {testRedirectURI}, "code_verifier": {testVerifier}, "client_secret": {"wrong"}}
+			},
+			wantCode: http.StatusUnauthorized, wantError: "invalid_client",
+		},
+		{
+			name:     "code of another client",
+			clientID: it.public.ID,
+			form: func(code string) url.Values {
+				return url.Values{"code": {code}, "redirect_uri": {testRedirectURI}, "code_verifier": {testVerifier}, "client_id": {it.client.ID}, "client_secret": {it.secret}}
+			},
+			wantCode: http.StatusBadRequest, wantError: "invalid_grant",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := it.authorize(session, tt.clientID, testVerifier).Query().Get("code")
+			form := tt.form(code)
+			if form.Get("client_id") == "" {
+				form.Set("client_id", tt.clientID)
+				if tt.clientID == it.client.ID && form.Get("client_secret") == "" {
+					form.Set("client_secret", it.secret)
+				}
+			}
+			resp, body := it.token(form)
+			if resp.StatusCode != tt.wantCode || body["error"] != tt.wantError {
+				t.Errorf("token endpoint answered %d %v, want %d %s", resp.StatusCode, body, tt.wantCode, tt.wantError)
+			}
+		})
+	}
+
+	t.Run("code redeemed twice", func(t *testing.T) {
+		code := it.authorize(session, it.public.ID, testVerifier).Query().Get("code")
+		form := url.Values{"code": {code}, "redirect_uri": {testRedirectURI}, "code_verifier": {testVerifier}, "client_id": {it.public.ID}}
+		if resp, body := it.token(form); resp.StatusCode != http.StatusOK {
+			t.Fatalf("first redemption answered %d: %v", resp.StatusCode, body)
+		}
+		if resp, body := it.token(form); body["error"] != "invalid_grant" {
+			t.Errorf("second redemption answered %d: %v", resp.StatusCode, body)
+		}
+	})
+}
+
+func TestIdentityProviderIntrospectionClients(t *testing.T) {
+	it := newIdPTest(t)
+
+	tests := []struct {
+		name     string
+		clientID string
+		secret   string
+		token    string
+		wantCode int
+	}{
+		{name: "public client", clientID: it.public.ID, token: "x", wantCode: http.StatusUnauthorized},
+		{name: "wrong secret", clientID: it.client.ID, secret: "wrong", token: "x", wantCode: http.StatusUnauthorized},
+		{name: "unknown client", clientID: "nobody", secret: "x", token: "x", wantCode: http.StatusUnauthorized},
+		{name: "missing token", clientID: it.client.ID, secret: it.secret, wantCode: http.StatusBadRequest},
+		{name: "garbage token", clientID: it.client.ID, secret: it.secret, token: "a.b.c", wantCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, idpIntrospectPath, strings.NewReader(url.Values{"token": {tt.token}}.Encode()))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+			req.SetBasicAuth(tt.clientID, tt.secret)
+			resp, body := it.do(req)
+			if resp.StatusCode != tt.wantCode {
+				t.Errorf("introspection answered %d %v, want %d", resp.StatusCode, body, tt.wantCode)
+			}
+			if tt.wantCode == http.StatusOK && body["active"] != false {
+				t.Errorf("introspection = %v, want inactive", body)
+			}
+		})
+	}
+}
+
+func TestNewIdentityProviderRequiresSessionKey(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, err := NewIdentityProvider(newTestService(), NewMemoryIdentityProviderStore(), IdentityProviderConfig{
+		Issuer:     "https://idp.example",
+		SigningKey: key,
+		SessionKey: []byte("too short"),
+	})
+	if errorType(err) != "ConfigurationError" {
+		t.Errorf("NewIdentityProvider() error = %v, want ConfigurationError", err)
+	}
+}
@@ -0,0 +1,242 @@
+package lucia
+
+import (
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+)
+
+// UserID is the set of identifier types a user and its sessions can be keyed by
+type UserID interface {
+	string | int64 | UUID | ULID
+}
+
+// IDCodec converts user IDs to and from their canonical string form, stores use it to persist sessions
+type IDCodec[ID UserID] interface {
+	Encode(id ID) string
+	Decode(s string) (ID, error)
+}
+
+// NewIDCodec returns the codec for the given ID type
+func NewIDCodec[ID UserID]() IDCodec[ID] {
+	return idCodec[ID]{}
+}
+
+type idCodec[ID UserID] struct{}
+
+func (idCodec[ID]) Encode(id ID) string {
+	switch v := any(id).(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case UUID:
+		return v.String()
+	case ULID:
+		return v.String()
+	}
+	return ""
+}
+
+func (idCodec[ID]) Decode(s string) (ID, error) {
+	var id ID
+	switch any(id).(type) {
+	case string:
+		return any(s).(ID), nil
+	case int64:
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return id, errors.ErrParse(fmt.Sprintf("UserID %q is not an int64", s))
+		}
+		return any(v).(ID), nil
+	case UUID:
+		v, err := ParseUUID(s)
+		if err != nil {
+			return id, err
+		}
+		return any(v).(ID), nil
+	case ULID:
+		v, err := ParseULID(s)
+		if err != nil {
+			return id, err
+		}
+		return any(v).(ID), nil
+	}
+	return id, errors.ErrParse(fmt.Sprintf("Unsupported UserID type %T", id))
+}
+
+// UUID is an RFC 4122 universally unique identifier
+type UUID [16]byte
+
+// NewUUID generates a random (version 4) UUID
+func NewUUID() UUID {
+	var u UUID
+	rand.Read(u[:])
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+	return u
+}
+
+// ParseUUID parses the canonical 8-4-4-4-12 hex form of a UUID
+func ParseUUID(s string) (UUID, error) {
+	var u UUID
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return u, errors.ErrParse(fmt.Sprintf("Invalid UUID %q", s))
+	}
+	// Each group is decoded into its own bytes, so a dash inside a group can never shorten the hex digits
+	groups := [5][4]int{{0, 8, 0, 4}, {9, 13, 4, 6}, {14, 18, 6, 8}, {19, 23, 8, 10}, {24, 36, 10, 16}}
+	for _, g := range groups {
+		if _, err := hex.Decode(u[g[2]:g[3]], []byte(s[g[0]:g[1]])); err != nil {
+			return UUID{}, errors.ErrParse(fmt.Sprintf("Invalid UUID %q", s))
+		}
+	}
+	return u, nil
+}
+
+// String returns the canonical 8-4-4-4-12 hex form of the UUID
+func (u UUID) String() string {
+	var buf [36]byte
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf[:])
+}
+
+// Value stores the UUID in its canonical form, which Postgres accepts for both UUID and TEXT columns
+func (u UUID) Value() (driver.Value, error) {
+	return u.String(), nil
+}
+
+// Scan reads a UUID from its canonical form or its 16 raw bytes
+func (u *UUID) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case string:
+		parsed, err := ParseUUID(v)
+		if err != nil {
+			return err
+		}
+		*u = parsed
+		return nil
+	case []byte:
+		if len(v) == len(u) {
+			copy(u[:], v)
+			return nil
+		}
+		parsed, err := ParseUUID(string(v))
+		if err != nil {
+			return err
+		}
+		*u = parsed
+		return nil
+	}
+	return errors.ErrParse(fmt.Sprintf("Cannot scan %T into a UUID", src))
+}
+
+// ULID is a lexicographically sortable identifier made of a millisecond timestamp and 80 random bits
+type ULID [16]byte
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewULID generates a ULID for the current time
+func NewULID() ULID {
+	var u ULID
+	ms := uint64(time.Now().UnixMilli())
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], ms)
+	copy(u[:6], ts[2:])
+	rand.Read(u[6:])
+	return u
+}
+
+// ParseULID parses the 26 character Crockford base32 form of a ULID
+func ParseULID(s string) (ULID, error) {
+	var u ULID
+	if len(s) != 26 || s[0] > '7' {
+		return u, errors.ErrParse(fmt.Sprintf("Invalid ULID %q", s))
+	}
+
+	// 26 chars * 5 bits = 130 bits, the two leading bits are always zero
+	var hi, lo uint64
+	for i := 0; i < len(s); i++ {
+		v := strings.IndexByte(crockford, upper(s[i]))
+		if v < 0 {
+			return u, errors.ErrParse(fmt.Sprintf("Invalid ULID %q", s))
+		}
+		hi = hi<<5 | lo>>59
+		lo = lo<<5 | uint64(v)
+	}
+	binary.BigEndian.PutUint64(u[:8], hi)
+	binary.BigEndian.PutUint64(u[8:], lo)
+	return u, nil
+}
+
+// String returns the 26 character Crockford base32 form of the ULID
+func (u ULID) String() string {
+	hi := binary.BigEndian.Uint64(u[:8])
+	lo := binary.BigEndian.Uint64(u[8:])
+
+	var buf [26]byte
+	for i := len(buf) - 1; i >= 0; i-- {
+		buf[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(buf[:])
+}
+
+// Time returns the timestamp encoded in the ULID
+func (u ULID) Time() time.Time {
+	var ts [8]byte
+	copy(ts[2:], u[:6])
+	return time.UnixMilli(int64(binary.BigEndian.Uint64(ts[:])))
+}
+
+// Value stores the ULID in its 26 character form
+func (u ULID) Value() (driver.Value, error) {
+	return u.String(), nil
+}
+
+// Scan reads a ULID from its 26 character form or its 16 raw bytes
+func (u *ULID) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case string:
+		parsed, err := ParseULID(v)
+		if err != nil {
+			return err
+		}
+		*u = parsed
+		return nil
+	case []byte:
+		if len(v) == len(u) {
+			copy(u[:], v)
+			return nil
+		}
+		parsed, err := ParseULID(string(v))
+		if err != nil {
+			return err
+		}
+		*u = parsed
+		return nil
+	}
+	return errors.ErrParse(fmt.Sprintf("Cannot scan %T into a ULID", src))
+}
+
+func upper(c byte) byte {
+	if c >= 'a' && c <= 'z' {
+		return c - ('a' - 'A')
+	}
+	return c
+}
@@ -0,0 +1,103 @@
+package lucia
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseUUID(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		wantErr bool
+	}{
+		{name: "canonical", s: "01234567-89ab-cdef-0123-456789abcdef"},
+		{name: "upper case", s: "01234567-89AB-CDEF-0123-456789ABCDEF"},
+		{name: "extra dash inside a group", s: "01234567-89ab-cdef-0123-4567-9abcdef", wantErr: true},
+		{name: "dashes in place of two digits", s: "01234567-89ab-cdef-0123-45-67-9abcde", wantErr: true},
+		{name: "dash moved", s: "0123456-789ab-cdef-0123-456789abcdef", wantErr: true},
+		{name: "non hex digit", s: "0123456g-89ab-cdef-0123-456789abcdef", wantErr: true},
+		{name: "no dashes", s: "0123456789abcdef0123456789abcdef", wantErr: true},
+		{name: "braces", s: "{01234567-89ab-cdef-0123-456789abcde}", wantErr: true},
+		{name: "empty", s: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := ParseUUID(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseUUID(%q) error = %v, wantErr %v", tt.s, err, tt.wantErr)
+			}
+			if !tt.wantErr && !strings.EqualFold(u.String(), tt.s) {
+				t.Errorf("ParseUUID(%q) = %s", tt.s, u)
+			}
+		})
+	}
+}
+
+func TestUUIDScanValue(t *testing.T) {
+	id := NewUUID()
+
+	tests := []struct {
+		name    string
+		src     interface{}
+		want    UUID
+		wantErr bool
+	}{
+		{name: "canonical string", src: id.String(), want: id},
+		{name: "canonical bytes", src: []byte(id.String()), want: id},
+		{name: "raw bytes", src: id[:], want: id},
+		{name: "invalid string", src: "not-a-uuid", wantErr: true},
+		{name: "unsupported type", src: int64(1), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got UUID
+			err := got.Scan(tt.src)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Scan() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("Scan() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	value, err := id.Value()
+	if err != nil || value != id.String() {
+		t.Errorf("Value() = %v, %v, want %s", value, err, id)
+	}
+}
+
+func TestULIDScanValue(t *testing.T) {
+	id := NewULID()
+
+	tests := []struct {
+		name    string
+		src     interface{}
+		want    ULID
+		wantErr bool
+	}{
+		{name: "string", src: id.String(), want: id},
+		{name: "text bytes", src: []byte(id.String()), want: id},
+		{name: "raw bytes", src: id[:], want: id},
+		{name: "invalid string", src: "not-a-ulid", wantErr: true},
+		{name: "unsupported type", src: 1.5, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got ULID
+			err := got.Scan(tt.src)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Scan() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("Scan() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	value, err := id.Value()
+	if err != nil || value != id.String() {
+		t.Errorf("Value() = %v, %v, want %s", value, err, id)
+	}
+}
@@ -0,0 +1,120 @@
+package lucia
+
+import (
+	"context"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+)
+
+// ImpersonationScope is granted to every impersonation session, so handlers can tell them apart
+const ImpersonationScope = "impersonation"
+
+// ImpersonationOptions configures an impersonation session
+type ImpersonationOptions struct {
+	// Scopes restricts the session on top of ImpersonationScope, which is always granted, so an impersonation
+	// session is never unrestricted. Guard sensitive routes with RequireScope. Impersonation sessions can neither
+	// approve devices nor authorize identity provider clients, see checkDelegation.
+	Scopes []string
+	// Duration defaults to 30 minutes, the session never outlives the impersonator's own session
+	Duration time.Duration
+	// Reason is recorded by the audit trail, e.g. a support ticket number
+	Reason string
+}
+
+// checkDelegation refuses to let session grant credentials to a device or another client. Those credentials
+// would be plain sessions of the user, so impersonation and restricted sessions would escape their limits.
+func checkDelegation[ID UserID](session *Session[ID]) error {
+	if session.IsImpersonation() {
+		return errors.NewLuciaError("AccessDenied", "Impersonation sessions cannot grant access to other clients")
+	}
+	if len(session.Scopes) > 0 {
+		return errors.NewLuciaError("AccessDenied", "Restricted sessions cannot grant access to other clients")
+	}
+	return nil
+}
+
+// SetImpersonationPolicy sets the check deciding whether actorID may impersonate targetID.
+// Impersonation is refused until a policy is set.
+func (s *AuthService[U, ID]) SetImpersonationPolicy(policy func(ctx context.Context, actorID, targetID ID) error) {
+	s.impersonationPolicy = policy
+}
+
+// Impersonate starts a session acting as targetID on behalf of the user of actorSessionID.
+// The actor's session is kept, EndImpersonation returns to it.
+func (s *AuthService[U, ID]) Impersonate(ctx context.Context, actorSessionID string, targetID ID, opts ImpersonationOptions) (*Session[ID], error) {
+	actorSession, err := s.GetSession(ctx, actorSessionID)
+	if err != nil {
+		return nil, err
+	}
+	if actorSession.IsExpired() {
+		return nil, errors.NewLuciaError("SessionExpired", "Session expired")
+	}
+	if actorSession.IsImpersonation() {
+		return nil, errors.ErrForbidden("Impersonation sessions cannot impersonate")
+	}
+	if actorSession.UserID == targetID {
+		return nil, errors.ErrBadRequest("Users cannot impersonate themselves")
+	}
+	if s.impersonationPolicy == nil {
+		return nil, errors.ErrForbidden("Impersonation is not enabled")
+	}
+	if err := s.impersonationPolicy(ctx, actorSession.UserID, targetID); err != nil {
+		return nil, err
+	}
+
+	duration := opts.Duration
+	if duration <= 0 {
+		duration = 30 * time.Minute
+	}
+	expiresAt := time.Now().Add(duration).Unix()
+	if expiresAt > actorSession.ExpiresAt {
+		expiresAt = actorSession.ExpiresAt
+	}
+
+	actorID := actorSession.UserID
+	session, err := s.storeSession(ctx, &Session[ID]{
+		ID:              GenerateID(),
+		UserID:          targetID,
+		ExpiresAt:       expiresAt,
+		ImpersonatorID:  &actorID,
+		ParentSessionID: actorSession.ID,
+		Scopes:          mergeScopes([]string{ImpersonationScope}, opts.Scopes),
+		// The impersonator is the one authenticated, step-up checks apply to them
+		AuthTime:     actorSession.AuthTime,
+		AuthProvider: actorSession.AuthProvider,
+		MFA:          actorSession.MFA,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	runAfter(ctx, &s.hooks.mu, &s.hooks.onImpersonationStarted, ImpersonationEvent[ID]{
+		ActorID: actorID,
+		Session: session,
+		Reason:  opts.Reason,
+	})
+	return session, nil
+}
+
+// EndImpersonation deletes the impersonation session and returns the impersonator's own session,
+// which the caller sets back as the session cookie
+func (s *AuthService[U, ID]) EndImpersonation(ctx context.Context, sessionID string) (*Session[ID], error) {
+	session, err := s.GetSession(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if !session.IsImpersonation() {
+		return nil, errors.ErrBadRequest("Session is not an impersonation")
+	}
+
+	if _, err := s.deleteSession(ctx, sessionID, RevokeReasonImpersonationEnded); err != nil {
+		return nil, err
+	}
+	runAfter(ctx, &s.hooks.mu, &s.hooks.onImpersonationEnded, ImpersonationEvent[ID]{
+		ActorID: *session.ImpersonatorID,
+		Session: session,
+	})
+
+	return s.GetSession(ctx, session.ParentSessionID)
+}
@@ -0,0 +1,76 @@
+package lucia
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+)
+
+// TestImpersonationCannotEscape checks that an impersonation session cannot turn into a plain session of the
+// impersonated user through the paths that hand out new credentials
+func TestImpersonationCannotEscape(t *testing.T) {
+	ctx := context.Background()
+	it := newIdPTest(t)
+	it.service.SetImpersonationPolicy(func(ctx context.Context, actorID, targetID string) error { return nil })
+	actor := it.login("admin")
+	target := it.login("user")
+	impersonation, err := it.service.Impersonate(ctx, actor.ID, target.UserID, ImpersonationOptions{Scopes: []string{"support"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		escape func(session *Session[string]) error
+	}{
+		{
+			name: "device approval",
+			escape: func(session *Session[string]) error {
+				flow := NewDeviceFlow(it.service, NewMemoryDeviceStore(), DeviceFlowConfig{})
+				resp, err := flow.Authorize(ctx, "tv", nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return flow.Approve(ctx, resp.UserCode, session)
+			},
+		},
+		{
+			name: "identity provider authorization",
+			escape: func(session *Session[string]) error {
+				location := it.authorize(session, it.client.ID, testVerifier)
+				switch location.Query().Get("error") {
+				case "":
+					return nil
+				case "access_denied":
+					return errors.NewLuciaError("AccessDenied", location.Query().Get("error_description"))
+				}
+				return errors.NewLuciaError("UnexpectedError", location.String())
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.escape(impersonation); errorType(err) != "AccessDenied" {
+				t.Errorf("impersonation session: error = %v, want AccessDenied", err)
+			}
+			if err := tt.escape(actor); err != nil {
+				t.Errorf("impersonator's own session: error = %v, want none", err)
+			}
+		})
+	}
+
+	// A code issued before the session became unusable for delegation is refused at the token endpoint too
+	code := it.authorize(actor, it.client.ID, testVerifier).Query().Get("code")
+	stored, err := it.service.sessionStore.GetSession(ctx, actor.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored.Scopes = []string{"read"}
+	if err := it.service.sessionStore.UpdateSession(ctx, stored); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := it.idp.Exchange(ctx, it.client.ID, it.secret, code, testRedirectURI, testVerifier); errorType(err) != "InvalidGrant" {
+		t.Errorf("Exchange() error = %v, want InvalidGrant", err)
+	}
+}
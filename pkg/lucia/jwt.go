@@ -0,0 +1,347 @@
+package lucia
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+)
+
+// jwtLeeway is the clock skew tolerated when checking exp, nbf and iat
+const jwtLeeway = time.Minute
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// jwtClaims holds the registered claims checked by verifyJWT, providers decode their own claims separately
+type jwtClaims struct {
+	Issuer    string      `json:"iss"`
+	Subject   string      `json:"sub"`
+	Audience  jwtAudience `json:"aud"`
+	ExpiresAt int64       `json:"exp"`
+	NotBefore int64       `json:"nbf,omitempty"`
+	IssuedAt  int64       `json:"iat"`
+	Nonce     string      `json:"nonce,omitempty"`
+	// AuthTime and AuthMethods are the OpenID Connect auth_time and amr claims, when the provider sends them
+	AuthTime    int64    `json:"auth_time,omitempty"`
+	AuthMethods []string `json:"amr,omitempty"`
+	// Raw is the verified payload
+	Raw json.RawMessage `json:"-"`
+}
+
+// jwtAudience accepts both the string and the array form of the aud claim
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = jwtAudience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+func (a jwtAudience) contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// JWK is a single JSON Web Key, only the RSA and EC P-256 members are supported
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is the document served by a JWKS endpoint
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicKey decodes the key into an *rsa.PublicKey or *ecdsa.PublicKey
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, errors.ErrParse("Invalid RSA modulus in JWK").WithCause(err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, errors.ErrParse("Invalid RSA exponent in JWK").WithCause(err)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, errors.ErrParse("Unsupported JWK curve " + k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, errors.ErrParse("Invalid EC point in JWK").WithCause(err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, errors.ErrParse("Invalid EC point in JWK").WithCause(err)
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, errors.ErrParse("Unsupported JWK key type " + k.Kty)
+}
+
+// NewJWK encodes an RSA or EC P-256 public key as a JWK
+func NewJWK(kid string, key crypto.PublicKey) (JWK, error) {
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		return JWK{
+			Kty: "EC",
+			Kid: kid,
+			Use: "sig",
+			Alg: "ES256",
+			Crv: "P-256",
+			X:   base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, 32))),
+			Y:   base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, 32))),
+		}, nil
+	}
+	return JWK{}, errors.ErrParse("Unsupported public key type")
+}
+
+// signJWT signs claims with an RSA (RS256) or EC P-256 (ES256) private key
+func signJWT(key crypto.Signer, kid string, claims interface{}) (string, error) {
+	return signTypedJWT(key, kid, "JWT", claims)
+}
+
+// signTypedJWT is signJWT with an explicit typ header, e.g. at+jwt for access tokens (RFC 9068)
+func signTypedJWT(key crypto.Signer, kid, typ string, claims interface{}) (string, error) {
+	header := jwtHeader{Kid: kid, Typ: typ}
+	switch key.Public().(type) {
+	case *rsa.PublicKey:
+		header.Alg = "RS256"
+	case *ecdsa.PublicKey:
+		header.Alg = "ES256"
+	default:
+		return "", errors.ErrUnexpected("Unsupported signing key type")
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", errors.ErrUnexpected("Failed to encode JWT header").WithCause(err)
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", errors.ErrUnexpected("Failed to encode JWT claims").WithCause(err)
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	var signature []byte
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		// JWS uses the raw r||s form rather than ASN.1
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			return "", errors.ErrUnexpected("Failed to sign JWT").WithCause(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	default:
+		signature, err = key.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if err != nil {
+			return "", errors.ErrUnexpected("Failed to sign JWT").WithCause(err)
+		}
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// verifyJWT checks the signature of token with the key returned by keyFunc and decodes its payload into claims.
+// The registered claims are returned for the caller to validate issuer and audience.
+func verifyJWT(token string, keyFunc func(header jwtHeader) (crypto.PublicKey, error), claims interface{}) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.NewLuciaError("InvalidToken", "Malformed JWT")
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.NewLuciaError("InvalidToken", "Malformed JWT header").WithCause(err)
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, errors.NewLuciaError("InvalidToken", "Malformed JWT header").WithCause(err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.NewLuciaError("InvalidToken", "Malformed JWT signature").WithCause(err)
+	}
+
+	key, err := keyFunc(header)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch header.Alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) != nil {
+			return nil, errors.NewLuciaError("InvalidToken", "Invalid JWT signature")
+		}
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return nil, errors.NewLuciaError("InvalidToken", "Invalid JWT signature")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return nil, errors.NewLuciaError("InvalidToken", "Invalid JWT signature")
+		}
+	default:
+		// Never accept "none" or HMAC algorithms with public keys
+		return nil, errors.NewLuciaError("InvalidToken", "Unsupported JWT algorithm "+header.Alg)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.NewLuciaError("InvalidToken", "Malformed JWT payload").WithCause(err)
+	}
+	var registered jwtClaims
+	if err := json.Unmarshal(payload, &registered); err != nil {
+		return nil, errors.NewLuciaError("InvalidToken", "Malformed JWT claims").WithCause(err)
+	}
+	registered.Raw = payload
+	if claims != nil {
+		if err := json.Unmarshal(payload, claims); err != nil {
+			return nil, errors.NewLuciaError("InvalidToken", "Malformed JWT claims").WithCause(err)
+		}
+	}
+
+	now := time.Now()
+	if registered.ExpiresAt == 0 || now.After(time.Unix(registered.ExpiresAt, 0).Add(jwtLeeway)) {
+		return nil, errors.NewLuciaError("TokenExpired", "JWT has expired")
+	}
+	if registered.NotBefore != 0 && now.Add(jwtLeeway).Before(time.Unix(registered.NotBefore, 0)) {
+		return nil, errors.NewLuciaError("InvalidToken", "JWT is not valid yet")
+	}
+	return &registered, nil
+}
+
+// validate checks the issuer and audience of verified registered claims
+func (c *jwtClaims) validate(issuer, audience string) error {
+	if c.Issuer != issuer {
+		return errors.NewLuciaError("InvalidToken", "Unexpected JWT issuer "+c.Issuer)
+	}
+	if !c.Audience.contains(audience) {
+		return errors.NewLuciaError("InvalidToken", "Unexpected JWT audience")
+	}
+	return nil
+}
+
+// jwksCache fetches and caches the signing keys of an identity provider
+type jwksCache struct {
+	url        string
+	httpClient *http.Client
+	ttl        time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+func newJWKSCache(url string, httpClient *http.Client) *jwksCache {
+	return &jwksCache{url: url, httpClient: httpClient, ttl: time.Hour}
+}
+
+// key returns the public key for kid, refetching the key set when kid is unknown (keys rotate) at most once a minute
+func (c *jwksCache) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if key, ok := c.keys[kid]; ok && time.Since(c.fetchedAt) < c.ttl {
+		return key, nil
+	}
+	if c.keys == nil || time.Since(c.fetchedAt) > time.Minute {
+		if err := c.refresh(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if key, ok := c.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, errors.NewLuciaError("InvalidToken", "Unknown JWT signing key "+kid)
+}
+
+// refresh reloads the key set, it must be called with the lock held
+func (c *jwksCache) refresh(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.url, nil)
+	if err != nil {
+		return errors.ErrUnexpected("Failed to create JWKS request").WithCause(err)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.ErrUnexpected("Failed to fetch JWKS").WithCause(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return statusError("Failed to fetch JWKS", "", resp)
+	}
+
+	var set JWKSet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return errors.ErrUnexpected("Failed to decode JWKS").WithCause(err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+	c.keys = keys
+	c.fetchedAt = time.Now()
+	return nil
+}
@@ -0,0 +1,203 @@
+package lucia
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestVerifyJWT(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := map[string]crypto.PublicKey{"rsa": &rsaKey.PublicKey, "ec": &ecKey.PublicKey}
+	keyFunc := func(header jwtHeader) (crypto.PublicKey, error) {
+		return keys[header.Kid], nil
+	}
+
+	now := time.Now()
+	claims := func(mutate func(c map[string]interface{})) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss": "https://issuer.example.com",
+			"sub": "user",
+			"aud": "client",
+			"iat": now.Unix(),
+			"exp": now.Add(time.Hour).Unix(),
+		}
+		if mutate != nil {
+			mutate(c)
+		}
+		return c
+	}
+	sign := func(key crypto.Signer, kid string, c map[string]interface{}) string {
+		token, err := signJWT(key, kid, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	unsigned := func(alg string) string {
+		header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"` + alg + `","kid":"ec"}`))
+		payload, _ := json.Marshal(claims(nil))
+		return header + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+	}
+	retype := func(token, alg string) string {
+		parts := strings.Split(token, ".")
+		header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"` + alg + `","kid":"ec"}`))
+		return header + "." + parts[1] + "." + parts[2]
+	}
+	tamper := func(token string) string {
+		parts := strings.Split(token, ".")
+		payload, _ := json.Marshal(claims(func(c map[string]interface{}) { c["sub"] = "admin" }))
+		return parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		wantType string
+	}{
+		{name: "RS256", token: sign(rsaKey, "rsa", claims(nil))},
+		{name: "ES256", token: sign(ecKey, "ec", claims(nil))},
+		{name: "expired within leeway", token: sign(ecKey, "ec", claims(func(c map[string]interface{}) { c["exp"] = now.Add(-30 * time.Second).Unix() }))},
+		{name: "expired", token: sign(ecKey, "ec", claims(func(c map[string]interface{}) { c["exp"] = now.Add(-2 * time.Minute).Unix() })), wantType: "TokenExpired"},
+		{name: "no expiry", token: sign(ecKey, "ec", claims(func(c map[string]interface{}) { delete(c, "exp") })), wantType: "TokenExpired"},
+		{name: "not valid yet", token: sign(ecKey, "ec", claims(func(c map[string]interface{}) { c["nbf"] = now.Add(5 * time.Minute).Unix() })), wantType: "InvalidToken"},
+		{name: "tampered payload", token: tamper(sign(ecKey, "ec", claims(nil))), wantType: "InvalidToken"},
+		{name: "signed by another key", token: sign(otherKey, "ec", claims(nil)), wantType: "InvalidToken"},
+		{name: "RS256 header with an EC key", token: retype(sign(ecKey, "ec", claims(nil)), "RS256"), wantType: "InvalidToken"},
+		{name: "alg none", token: unsigned("none"), wantType: "InvalidToken"},
+		{name: "alg HS256", token: unsigned("HS256"), wantType: "InvalidToken"},
+		{name: "malformed", token: "not.a.jwt", wantType: "InvalidToken"},
+		{name: "two parts", token: "a.b", wantType: "InvalidToken"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registered, err := verifyJWT(tt.token, keyFunc, nil)
+			if tt.wantType != "" {
+				if errorType(err) != tt.wantType {
+					t.Fatalf("verifyJWT() error = %v, want %s", err, tt.wantType)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verifyJWT() error = %v", err)
+			}
+			if registered.Subject != "user" {
+				t.Errorf("sub = %q, want %q", registered.Subject, "user")
+			}
+		})
+	}
+}
+
+func TestJWTClaimsValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		payload  string
+		issuer   string
+		audience string
+		wantErr  bool
+	}{
+		{name: "string audience", payload: `{"iss":"https://issuer","aud":"client"}`, issuer: "https://issuer", audience: "client"},
+		{name: "audience list", payload: `{"iss":"https://issuer","aud":["other","client"]}`, issuer: "https://issuer", audience: "client"},
+		{name: "wrong audience", payload: `{"iss":"https://issuer","aud":"other"}`, issuer: "https://issuer", audience: "client", wantErr: true},
+		{name: "no audience", payload: `{"iss":"https://issuer"}`, issuer: "https://issuer", audience: "client", wantErr: true},
+		{name: "wrong issuer", payload: `{"iss":"https://evil","aud":"client"}`, issuer: "https://issuer", audience: "client", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var claims jwtClaims
+			if err := json.Unmarshal([]byte(tt.payload), &claims); err != nil {
+				t.Fatal(err)
+			}
+			if err := claims.validate(tt.issuer, tt.audience); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWKRoundTrip(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	for _, key := range []crypto.PublicKey{&rsaKey.PublicKey, &ecKey.PublicKey} {
+		jwk, err := NewJWK("kid", key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := jwk.PublicKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !decoded.(interface{ Equal(crypto.PublicKey) bool }).Equal(key) {
+			t.Errorf("%s JWK does not round trip", jwk.Kty)
+		}
+	}
+}
+
+func TestJWKSCache(t *testing.T) {
+	first, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	second, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	encryption, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	var fetches atomic.Int32
+	var rotated atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		jwk, _ := NewJWK("first", &first.PublicKey)
+		enc, _ := NewJWK("enc", &encryption.PublicKey)
+		enc.Use = "enc"
+		set := JWKSet{Keys: []JWK{jwk, enc}}
+		if rotated.Load() {
+			next, _ := NewJWK("second", &second.PublicKey)
+			set.Keys = append(set.Keys, next)
+		}
+		json.NewEncoder(w).Encode(set)
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	cache := newJWKSCache(server.URL, server.Client())
+	if key, err := cache.key(ctx, "first"); err != nil || !first.PublicKey.Equal(key) {
+		t.Fatalf("key(first) = %v, %v", key, err)
+	}
+	if _, err := cache.key(ctx, "enc"); errorType(err) != "InvalidToken" {
+		t.Errorf("key(enc) error = %v, want InvalidToken for an encryption key", err)
+	}
+
+	// An unknown kid refetches the set at most once a minute, so forged kids cannot hammer the provider
+	rotated.Store(true)
+	if _, err := cache.key(ctx, "second"); errorType(err) != "InvalidToken" {
+		t.Errorf("key(second) right after a fetch: error = %v, want InvalidToken", err)
+	}
+	if fetches.Load() != 1 {
+		t.Errorf("fetches = %d, want 1", fetches.Load())
+	}
+	cache.fetchedAt = time.Now().Add(-2 * time.Minute)
+	if key, err := cache.key(ctx, "second"); err != nil || !second.PublicKey.Equal(key) {
+		t.Errorf("key(second) after rotation = %v, %v", key, err)
+	}
+	if fetches.Load() != 2 {
+		t.Errorf("fetches = %d, want 2", fetches.Load())
+	}
+}
@@ -2,22 +2,35 @@ package lucia
 
 import (
 	"context"
+	"encoding/json"
 	"time"
-
-	"github.com/Abraxas-365/toolkit/pkg/errors"
 )
 
 type OAuthProvider interface {
-	GetAuthURL(state string) string
+	GetAuthURL(state string, opts ...AuthURLOption) string
 	ExchangeCode(ctx context.Context, code string) (*OAuthToken, error)
 	GetUserInfo(ctx context.Context, token *OAuthToken) (*UserInfo, error)
 	RefreshToken(ctx context.Context, refreshToken string) (*OAuthToken, error)
 }
 
+// TokenRevoker is implemented by the providers that can revoke their tokens. Revoking ends the grant of
+// the application, every token of the user at the provider stops working, not only the one passed.
+type TokenRevoker interface {
+	RevokeToken(ctx context.Context, token *OAuthToken) error
+}
+
+// MFAReporter is implemented by the providers that report the authentication methods of a login (the amr
+// claim). RequireMFA rejects sessions of other providers outright, a new login would not report MFA either.
+type MFAReporter interface {
+	ReportsMFA() bool
+}
+
 type OAuthToken struct {
 	AccessToken  string
 	RefreshToken string
 	ExpiresIn    int64
+	// IDToken is the raw OpenID Connect ID token, empty for providers that are not OIDC
+	IDToken string
 }
 
 func (t *OAuthToken) NeedsRefresh() bool {
@@ -47,49 +60,77 @@ func (t *OAuthToken) RefreshIfNeeded(ctx context.Context, provider OAuthProvider
 }
 
 type UserInfo struct {
-	ID             string
-	Email          string
+	ID    string
+	Email string
+	// EmailVerified is true only when the provider vouches that the user controls Email
+	EmailVerified  bool
 	Name           string
 	Provider       string
 	ProfilePicture *string
-	Token          *OAuthToken
+	// PrivateRelayEmail is true when Email is a relay address hiding the real one, as Sign in with Apple offers
+	PrivateRelayEmail bool
+	// Groups holds the directory groups of the user for providers that expose them
+	Groups []string
+	// AuthTime is when the user authenticated at the provider (the auth_time claim), 0 if it did not say
+	AuthTime int64
+	// AuthMethods are the authentication methods the provider reported (the amr claim), e.g. "pwd" and "mfa"
+	AuthMethods []string
+	// Raw is the profile as returned by the provider, the user info response or the ID token claims
+	Raw   json.RawMessage
+	Token *OAuthToken
 }
 
-type AuthUserStore[U AuthUser] interface {
+type AuthUserStore[U AuthUser[ID], ID UserID] interface {
 	GetUserByProviderID(ctx context.Context, provider, providerID string) (U, error)
 	CreateUser(ctx context.Context, userInfo *UserInfo) (U, error)
 }
 
-type SessionStore interface {
-	CreateSession(ctx context.Context, session *Session) error
-	GetSession(ctx context.Context, sessionID string) (*Session, error)
+type SessionStore[ID UserID] interface {
+	CreateSession(ctx context.Context, session *Session[ID]) error
+	GetSession(ctx context.Context, sessionID string) (*Session[ID], error)
+	// UpdateSession saves the mutable fields of an existing session, such as the active organization
+	UpdateSession(ctx context.Context, session *Session[ID]) error
 	DeleteSession(ctx context.Context, sessionID string) error
 }
 
-type Session struct {
+type Session[ID UserID] struct {
 	ID        string
-	UserID    interface{}
+	UserID    ID
 	ExpiresAt int64
+	// ImpersonatorID is the user acting as UserID, nil unless the session was started with Impersonate
+	ImpersonatorID *ID
+	// ParentSessionID is the impersonator's own session, the session ends with it
+	ParentSessionID string
+	// Scopes restricts what the session may do, empty means unrestricted
+	Scopes []string
+	// ActiveOrgID is the organization the user is working in, see Organizations.SetActiveOrganization
+	ActiveOrgID string
+	// AuthTime is when the user last authenticated, as a Unix time, see RequireRecentAuth
+	AuthTime int64
+	// AuthProvider is the provider the user authenticated with
+	AuthProvider string
+	// MFA is true when the provider reported a second factor for that authentication
+	MFA bool
 }
 
-func (s *Session) IsExpired() bool {
+// AuthMethodMFA is the amr value (RFC 8176) of an authentication with several factors
+const AuthMethodMFA = "mfa"
+
+func (s *Session[ID]) IsExpired() bool {
 	return s.ExpiresAt < time.Now().Unix()
 }
 
-func (s *Session) UserIDToString() (string, error) {
-	id, ok := s.UserID.(string)
-	if !ok {
-		return "", errors.ErrParse("UserID is not a string")
-	}
-	return id, nil
+// AuthenticatedWithin reports whether the user authenticated less than maxAge ago
+func (s *Session[ID]) AuthenticatedWithin(maxAge time.Duration) bool {
+	return s.AuthTime > 0 && time.Since(time.Unix(s.AuthTime, 0)) < maxAge
 }
 
-func (s *Session) UserIDToInt() (int, error) {
-	id, ok := s.UserID.(int)
-	if !ok {
-		return 0, errors.ErrParse("UserID is not an int")
-	}
-	return id, nil
+// IsImpersonation reports whether the session was started by another user with Impersonate
+func (s *Session[ID]) IsImpersonation() bool {
+	return s.ImpersonatorID != nil
 }
 
-//TODO: Add more like uuid,mongo primitive object id , etc
+// HasScope reports whether the session may use scope, unrestricted sessions have every scope
+func (s *Session[ID]) HasScope(scope string) bool {
+	return len(s.Scopes) == 0 || containsScope(s.Scopes, scope)
+}
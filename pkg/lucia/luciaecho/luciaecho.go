@@ -0,0 +1,48 @@
+package luciaecho
+
+import (
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/lucia"
+	"github.com/labstack/echo/v4"
+)
+
+// SessionMiddleware adapts the lucia session middleware to Echo
+func SessionMiddleware[U lucia.AuthUser[ID], ID lucia.UserID](am *lucia.AuthMiddleware[U, ID]) echo.MiddlewareFunc {
+	return echo.WrapMiddleware(am.Handler)
+}
+
+// RequireAuth adapts the lucia RequireAuth middleware to Echo
+func RequireAuth[U lucia.AuthUser[ID], ID lucia.UserID](am *lucia.AuthMiddleware[U, ID]) echo.MiddlewareFunc {
+	return echo.WrapMiddleware(am.RequireAuthHandler)
+}
+
+// RequireScope adapts the lucia RequireScope middleware to Echo
+func RequireScope[U lucia.AuthUser[ID], ID lucia.UserID](am *lucia.AuthMiddleware[U, ID], scope string) echo.MiddlewareFunc {
+	return echo.WrapMiddleware(am.RequireScopeHandler(scope))
+}
+
+// RequireRecentAuth adapts the lucia RequireRecentAuth middleware to Echo
+func RequireRecentAuth[U lucia.AuthUser[ID], ID lucia.UserID](am *lucia.AuthMiddleware[U, ID], maxAge time.Duration) echo.MiddlewareFunc {
+	return echo.WrapMiddleware(am.RequireRecentAuthHandler(maxAge))
+}
+
+// RequireMFA adapts the lucia RequireMFA middleware to Echo
+func RequireMFA[U lucia.AuthUser[ID], ID lucia.UserID](am *lucia.AuthMiddleware[U, ID]) echo.MiddlewareFunc {
+	return echo.WrapMiddleware(am.RequireMFAHandler)
+}
+
+// GetSession retrieves the validated session from the Echo context
+func GetSession[ID lucia.UserID](c echo.Context) *lucia.Session[ID] {
+	return lucia.SessionFromContext[ID](c.Request().Context())
+}
+
+// SetSessionCookie sets the session cookie
+func SetSessionCookie[ID lucia.UserID](c echo.Context, session *lucia.Session[ID]) {
+	lucia.SetSessionCookieHTTP(c.Response(), session)
+}
+
+// ClearSessionCookie clears the session cookie
+func ClearSessionCookie(c echo.Context) {
+	lucia.ClearSessionCookieHTTP(c.Response())
+}
@@ -0,0 +1,110 @@
+package luciaecho_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Abraxas-365/toolkit/pkg/lucia"
+	"github.com/Abraxas-365/toolkit/pkg/lucia/luciaecho"
+	"github.com/Abraxas-365/toolkit/pkg/lucia/luciatest"
+	"github.com/labstack/echo/v4"
+)
+
+func TestSessionMiddleware(t *testing.T) {
+	tests := []struct {
+		name string
+		// prepare returns the request, given a live session
+		prepare       func(t *testing.T, service *lucia.AuthService[*luciatest.User, string], sessions *luciatest.SessionStore[string], session *lucia.Session[string]) *http.Request
+		wantStatus    int
+		wantChallenge string
+	}{
+		{
+			name: "no credential",
+			prepare: func(t *testing.T, service *lucia.AuthService[*luciatest.User, string], sessions *luciatest.SessionStore[string], session *lucia.Session[string]) *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/me", nil)
+			},
+			wantStatus:    http.StatusUnauthorized,
+			wantChallenge: "Bearer",
+		},
+		{
+			name: "cookie",
+			prepare: func(t *testing.T, service *lucia.AuthService[*luciatest.User, string], sessions *luciatest.SessionStore[string], session *lucia.Session[string]) *http.Request {
+				r := httptest.NewRequest(http.MethodGet, "/me", nil)
+				r.AddCookie(luciatest.Cookie(session))
+				return r
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "bearer",
+			prepare: func(t *testing.T, service *lucia.AuthService[*luciatest.User, string], sessions *luciatest.SessionStore[string], session *lucia.Session[string]) *http.Request {
+				r := httptest.NewRequest(http.MethodGet, "/me", nil)
+				r.Header.Set(echo.HeaderAuthorization, "Bearer "+session.ID)
+				return r
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "expired",
+			prepare: func(t *testing.T, service *lucia.AuthService[*luciatest.User, string], sessions *luciatest.SessionStore[string], session *lucia.Session[string]) *http.Request {
+				sessions.Expire(session.ID)
+				r := httptest.NewRequest(http.MethodGet, "/me", nil)
+				r.AddCookie(luciatest.Cookie(session))
+				return r
+			},
+			wantStatus:    http.StatusUnauthorized,
+			wantChallenge: `error="invalid_token"`,
+		},
+		{
+			name: "revoked",
+			prepare: func(t *testing.T, service *lucia.AuthService[*luciatest.User, string], sessions *luciatest.SessionStore[string], session *lucia.Session[string]) *http.Request {
+				if err := service.Logout(context.Background(), session.ID); err != nil {
+					t.Fatal(err)
+				}
+				r := httptest.NewRequest(http.MethodGet, "/me", nil)
+				r.AddCookie(luciatest.Cookie(session))
+				return r
+			},
+			wantStatus:    http.StatusUnauthorized,
+			wantChallenge: `error="invalid_token"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			users := luciatest.NewUserStore()
+			sessions := luciatest.NewSessionStore[string]()
+			service := lucia.NewAuthService[*luciatest.User, string](users, sessions)
+			user, err := users.CreateUser(ctx, &lucia.UserInfo{ID: "1001", Provider: "fake"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			session, err := service.CreateSession(ctx, user)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			am := lucia.NewAuthMiddleware(service)
+			e := echo.New()
+			e.Use(luciaecho.SessionMiddleware(am))
+			e.GET("/me", func(c echo.Context) error {
+				return c.String(http.StatusOK, luciaecho.GetSession[string](c).UserID)
+			}, luciaecho.RequireAuth(am))
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, tt.prepare(t, service, sessions, session))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK && rec.Body.String() != user.ID {
+				t.Errorf("body = %q, want the user ID %q", rec.Body.String(), user.ID)
+			}
+			if challenge := rec.Header().Get(echo.HeaderWWWAuthenticate); !strings.Contains(challenge, tt.wantChallenge) {
+				t.Errorf("WWW-Authenticate = %q, want %q", challenge, tt.wantChallenge)
+			}
+		})
+	}
+}
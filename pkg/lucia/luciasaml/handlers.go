@@ -0,0 +1,117 @@
+package luciasaml
+
+import (
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/lucia"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequestCookieName holds the ID of the pending AuthnRequest between LoginHandler and ACSHandler
+const RequestCookieName = "saml_request"
+
+// HandlerConfig configures Handlers
+type HandlerConfig struct {
+	// SuccessURL is where users land after logging in when the login did not carry a return_to path,
+	// it defaults to "/"
+	SuccessURL string
+}
+
+// Handlers serves a ServiceProvider and logs its users into an AuthService
+type Handlers[U lucia.AuthUser[ID], ID lucia.UserID] struct {
+	service *lucia.AuthService[U, ID]
+	sp      *ServiceProvider
+	cfg     HandlerConfig
+}
+
+func NewHandlers[U lucia.AuthUser[ID], ID lucia.UserID](service *lucia.AuthService[U, ID], sp *ServiceProvider, cfg HandlerConfig) *Handlers[U, ID] {
+	if cfg.SuccessURL == "" {
+		cfg.SuccessURL = "/"
+	}
+	return &Handlers[U, ID]{service: service, sp: sp, cfg: cfg}
+}
+
+// Mount registers the metadata, login and assertion consumer endpoints on router,
+// the ACSURL of the ServiceProvider must point to "/acs" under it
+func (h *Handlers[U, ID]) Mount(router fiber.Router) {
+	router.Get("/metadata", h.MetadataHandler())
+	router.Get("/login", h.LoginHandler())
+	router.Post("/acs", h.ACSHandler())
+}
+
+// MetadataHandler serves the SP metadata
+func (h *Handlers[U, ID]) MetadataHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		metadata, err := h.sp.Metadata()
+		if err != nil {
+			return err
+		}
+		c.Set(fiber.HeaderContentType, "application/samlmetadata+xml")
+		return c.Send(metadata)
+	}
+}
+
+// LoginHandler starts a login at the identity provider, a relative return_to query parameter is where
+// the user lands afterwards. A prompt=login or max_age query parameter, as sent by lucia.RequireRecentAuth,
+// forces a new authentication.
+func (h *Handlers[U, ID]) LoginHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		relayState := c.Query("return_to")
+		if !lucia.IsLocalPath(relayState) || len(relayState) > 80 {
+			relayState = ""
+		}
+		var opts []AuthnRequestOption
+		if c.Query("prompt") == lucia.PromptLogin || c.Query("max_age") != "" {
+			opts = append(opts, ForceAuthn())
+		}
+		request, err := h.sp.NewAuthnRequest(relayState, opts...)
+		if err != nil {
+			return err
+		}
+
+		// The identity provider posts the response cross-site, a Lax cookie would not come back with it
+		c.Cookie(&fiber.Cookie{
+			Name:     RequestCookieName,
+			Value:    request.ID,
+			Expires:  time.Now().Add(10 * time.Minute),
+			HTTPOnly: true,
+			Secure:   true,
+			SameSite: "None",
+		})
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		if request.URL != "" {
+			return c.Redirect(request.URL)
+		}
+		c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+		return c.Send(request.Form)
+	}
+}
+
+// ACSHandler is the assertion consumer service, it verifies the response, logs the user in and sets
+// the session cookie
+func (h *Handlers[U, ID]) ACSHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		requestID := c.Cookies(RequestCookieName)
+		c.ClearCookie(RequestCookieName)
+
+		assertion, err := h.sp.ParseResponse(c.FormValue("SAMLResponse"), requestID)
+		if err != nil {
+			return err
+		}
+		userInfo, err := h.sp.UserInfo(assertion)
+		if err != nil {
+			return err
+		}
+		session, err := h.service.HandleIdentity(c.UserContext(), h.sp.Provider(), userInfo)
+		if err != nil {
+			return err
+		}
+		lucia.SetSessionCookie(c, session)
+
+		returnTo := c.FormValue("RelayState")
+		if !lucia.IsLocalPath(returnTo) {
+			returnTo = h.cfg.SuccessURL
+		}
+		return c.Redirect(returnTo)
+	}
+}
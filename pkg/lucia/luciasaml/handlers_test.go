@@ -0,0 +1,55 @@
+package luciasaml
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+	"github.com/Abraxas-365/toolkit/pkg/lucia/luciatest"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestACSHandlerRelayState(t *testing.T) {
+	idp := newTestIdP(t)
+
+	tests := []struct {
+		relayState string
+		want       string
+	}{
+		{relayState: "/settings", want: "/settings"},
+		{relayState: "", want: "/home"},
+		{relayState: "//evil.com", want: "/home"},
+		{relayState: "/\t/evil.com", want: "/home"},
+		{relayState: "/\\evil.com", want: "/home"},
+		{relayState: "https://evil.com", want: "/home"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.relayState, func(t *testing.T) {
+			sp := idp.serviceProvider(t, Config{AllowIdPInitiated: true})
+			h := NewHandlers(luciatest.NewService(), sp, HandlerConfig{SuccessURL: "/home"})
+			app := fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+			h.Mount(app)
+
+			form := url.Values{
+				"SAMLResponse": {encode(t, unsolicited(t, idp, time.Now()))},
+				"RelayState":   {tt.relayState},
+			}
+			req := httptest.NewRequest(http.MethodPost, "/acs", strings.NewReader(form.Encode()))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != http.StatusFound {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusFound)
+			}
+			if location := resp.Header.Get(fiber.HeaderLocation); location != tt.want {
+				t.Errorf("Location = %q, want %q", location, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,261 @@
+// Package luciasaml adds SAML 2.0 single sign-on to lucia, as a service provider of the Web Browser SSO profile.
+// Assertions are turned into a lucia.UserInfo and then follow the normal user and session flow.
+package luciasaml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"html/template"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+// signatureAlgorithmRSASHA256 is the SigAlg of signed HTTP-Redirect requests
+const signatureAlgorithmRSASHA256 = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+
+// Config configures a ServiceProvider
+type Config struct {
+	// EntityID identifies the service provider, it is usually the URL of its metadata
+	EntityID string
+	// ACSURL is the absolute URL of the assertion consumer service, see Handlers.ACSHandler
+	ACSURL string
+	// IdP is the identity provider, see ParseIdPMetadata and FetchIdPMetadata
+	IdP *IdPMetadata
+	// Provider is the provider name users are stored under, it defaults to "saml".
+	// Use one name per identity provider when there are several.
+	Provider string
+	// Binding sends AuthnRequests with BindingRedirect (the default) or BindingPOST
+	Binding string
+	// Certificate and Key sign AuthnRequests when set, some identity providers require it
+	Certificate *x509.Certificate
+	Key         *rsa.PrivateKey
+	// NameIDFormat is requested from the identity provider, empty lets it choose
+	NameIDFormat string
+	// Attributes maps assertion attributes to UserInfo fields
+	Attributes AttributeMap
+	// TrustEmail marks the email of assertions as verified. Only set it when the identity provider
+	// owns the email domains of its users, as corporate directories do.
+	TrustEmail bool
+	// AllowIdPInitiated accepts unsolicited responses started from the identity provider's portal.
+	// They cannot be tied to a login started by the browser, which makes login CSRF possible.
+	AllowIdPInitiated bool
+	// ClockSkew tolerated on assertion validity times, it defaults to 3 minutes
+	ClockSkew time.Duration
+}
+
+// ServiceProvider builds AuthnRequests and validates the responses of one identity provider
+type ServiceProvider struct {
+	cfg       Config
+	validator *dsig.ValidationContext
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func NewServiceProvider(cfg Config) (*ServiceProvider, error) {
+	if cfg.EntityID == "" || cfg.ACSURL == "" {
+		return nil, errors.NewLuciaError("ConfigurationError", "SAML EntityID and ACSURL are required")
+	}
+	if cfg.IdP == nil || len(cfg.IdP.Certificates) == 0 {
+		return nil, errors.NewLuciaError("ConfigurationError", "SAML IdP metadata with a signing certificate is required")
+	}
+	if (cfg.Key == nil) != (cfg.Certificate == nil) {
+		return nil, errors.NewLuciaError("ConfigurationError", "SAML Certificate and Key must be set together")
+	}
+	if cfg.Provider == "" {
+		cfg.Provider = "saml"
+	}
+	if cfg.Binding == "" {
+		cfg.Binding = BindingRedirect
+	}
+	if cfg.IdP.SSOURLs[cfg.Binding] == "" {
+		return nil, errors.NewLuciaError("ConfigurationError", "The SAML IdP does not support the configured binding")
+	}
+	if cfg.ClockSkew <= 0 {
+		cfg.ClockSkew = 3 * time.Minute
+	}
+	cfg.Attributes = cfg.Attributes.withDefaults()
+
+	return &ServiceProvider{
+		cfg:       cfg,
+		validator: dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{Roots: cfg.IdP.Certificates}),
+		seen:      make(map[string]time.Time),
+	}, nil
+}
+
+// Provider returns the provider name users of this identity provider are stored under
+func (sp *ServiceProvider) Provider() string {
+	return sp.cfg.Provider
+}
+
+// AuthnRequest is an authentication request ready to be sent to the identity provider
+type AuthnRequest struct {
+	// ID must be kept, e.g. in a cookie, and passed to ParseResponse to tie the response to this request
+	ID string
+	// URL is the identity provider URL to redirect to, set for the redirect binding
+	URL string
+	// Form is an HTML page posting the request to the identity provider, set for the POST binding
+	Form []byte
+}
+
+var postFormTemplate = template.Must(template.New("saml").Parse(`<!DOCTYPE html>
+<html>
+<body onload="document.forms[0].submit()">
+<form method="post" action="{{.URL}}">
+<input type="hidden" name="SAMLRequest" value="{{.Request}}">
+{{if .RelayState}}<input type="hidden" name="RelayState" value="{{.RelayState}}">{{end}}
+<noscript><button type="submit">Continue</button></noscript>
+</form>
+</body>
+</html>`))
+
+// AuthnRequestOption customizes a single AuthnRequest
+type AuthnRequestOption func(request *etree.Element)
+
+// ForceAuthn asks the identity provider to authenticate the user again instead of reusing its own session,
+// as lucia.RequireRecentAuth needs
+func ForceAuthn() AuthnRequestOption {
+	return func(request *etree.Element) {
+		request.CreateAttr("ForceAuthn", "true")
+	}
+}
+
+// NewAuthnRequest creates an AuthnRequest with the configured binding.
+// relayState comes back unchanged with the response, it is limited to 80 bytes.
+func (sp *ServiceProvider) NewAuthnRequest(relayState string, opts ...AuthnRequestOption) (*AuthnRequest, error) {
+	if len(relayState) > 80 {
+		return nil, errors.ErrBadRequest("RelayState is limited to 80 bytes")
+	}
+	id, err := newRequestID()
+	if err != nil {
+		return nil, err
+	}
+	destination := sp.cfg.IdP.SSOURLs[sp.cfg.Binding]
+	request := sp.authnRequestElement(id, destination, time.Now())
+	for _, opt := range opts {
+		opt(request)
+	}
+
+	if sp.cfg.Binding == BindingPOST {
+		return sp.postRequest(id, destination, request, relayState)
+	}
+	return sp.redirectRequest(id, destination, request, relayState)
+}
+
+func (sp *ServiceProvider) authnRequestElement(id, destination string, now time.Time) *etree.Element {
+	request := etree.NewElement("samlp:AuthnRequest")
+	request.CreateAttr("xmlns:samlp", NamespaceProtocol)
+	request.CreateAttr("xmlns:saml", NamespaceAssertion)
+	request.CreateAttr("ID", id)
+	request.CreateAttr("Version", "2.0")
+	request.CreateAttr("IssueInstant", now.UTC().Format(time.RFC3339))
+	request.CreateAttr("Destination", destination)
+	request.CreateAttr("AssertionConsumerServiceURL", sp.cfg.ACSURL)
+	request.CreateAttr("ProtocolBinding", BindingPOST)
+	request.CreateElement("saml:Issuer").SetText(sp.cfg.EntityID)
+
+	policy := request.CreateElement("samlp:NameIDPolicy")
+	policy.CreateAttr("AllowCreate", "true")
+	if sp.cfg.NameIDFormat != "" {
+		policy.CreateAttr("Format", sp.cfg.NameIDFormat)
+	}
+	return request
+}
+
+// redirectRequest encodes the request for the HTTP-Redirect binding, which signs the query string
+// instead of the XML
+func (sp *ServiceProvider) redirectRequest(id, destination string, request *etree.Element, relayState string) (*AuthnRequest, error) {
+	doc := etree.NewDocumentWithRoot(request)
+	data, err := doc.WriteToBytes()
+	if err != nil {
+		return nil, errors.NewLuciaError("UnexpectedError", "Failed to encode SAML request").WithCause(err)
+	}
+	var deflated bytes.Buffer
+	writer, _ := flate.NewWriter(&deflated, flate.BestCompression)
+	writer.Write(data)
+	writer.Close()
+
+	query := "SAMLRequest=" + url.QueryEscape(base64.StdEncoding.EncodeToString(deflated.Bytes()))
+	if relayState != "" {
+		query += "&RelayState=" + url.QueryEscape(relayState)
+	}
+	if sp.cfg.Key != nil {
+		query += "&SigAlg=" + url.QueryEscape(signatureAlgorithmRSASHA256)
+		signer, err := sp.signingContext()
+		if err != nil {
+			return nil, err
+		}
+		signature, err := signer.SignString(query)
+		if err != nil {
+			return nil, errors.NewLuciaError("UnexpectedError", "Failed to sign SAML request").WithCause(err)
+		}
+		query += "&Signature=" + url.QueryEscape(base64.StdEncoding.EncodeToString(signature))
+	}
+
+	separator := "?"
+	if strings.Contains(destination, "?") {
+		separator = "&"
+	}
+	return &AuthnRequest{ID: id, URL: destination + separator + query}, nil
+}
+
+// postRequest encodes the request for the HTTP-POST binding, signing the XML itself
+func (sp *ServiceProvider) postRequest(id, destination string, request *etree.Element, relayState string) (*AuthnRequest, error) {
+	if sp.cfg.Key != nil {
+		signer, err := sp.signingContext()
+		if err != nil {
+			return nil, err
+		}
+		signature, err := signer.ConstructSignature(request, true)
+		if err != nil {
+			return nil, errors.NewLuciaError("UnexpectedError", "Failed to sign SAML request").WithCause(err)
+		}
+		// The schema wants the signature right after the Issuer
+		request.InsertChildAt(1, signature)
+	}
+
+	data, err := etree.NewDocumentWithRoot(request).WriteToBytes()
+	if err != nil {
+		return nil, errors.NewLuciaError("UnexpectedError", "Failed to encode SAML request").WithCause(err)
+	}
+	var form bytes.Buffer
+	err = postFormTemplate.Execute(&form, map[string]string{
+		"URL":        destination,
+		"Request":    base64.StdEncoding.EncodeToString(data),
+		"RelayState": relayState,
+	})
+	if err != nil {
+		return nil, errors.NewLuciaError("UnexpectedError", "Failed to render SAML request form").WithCause(err)
+	}
+	return &AuthnRequest{ID: id, Form: form.Bytes()}, nil
+}
+
+func (sp *ServiceProvider) signingContext() (*dsig.SigningContext, error) {
+	signer, err := dsig.NewSigningContext(sp.cfg.Key, [][]byte{sp.cfg.Certificate.Raw})
+	if err != nil {
+		return nil, errors.NewLuciaError("ConfigurationError", "Invalid SAML signing key").WithCause(err)
+	}
+	signer.Prefix = "ds"
+	signer.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+	return signer, nil
+}
+
+// newRequestID returns an xs:ID, which must not start with a digit
+func newRequestID() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.NewLuciaError("UnexpectedError", "Failed to generate SAML request ID").WithCause(err)
+	}
+	return "_" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,194 @@
+package luciasaml
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+)
+
+// SAML namespaces, bindings and formats
+const (
+	NamespaceMetadata  = "urn:oasis:names:tc:SAML:2.0:metadata"
+	NamespaceAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+	NamespaceProtocol  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	namespaceDSig      = "http://www.w3.org/2000/09/xmldsig#"
+
+	BindingRedirect = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	BindingPOST     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+
+	NameIDFormatPersistent   = "urn:oasis:names:tc:SAML:2.0:nameid-format:persistent"
+	NameIDFormatEmailAddress = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+	NameIDFormatUnspecified  = "urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified"
+)
+
+// maxMetadataSize caps the IdP metadata document fetched by FetchIdPMetadata
+const maxMetadataSize = 1 << 20
+
+// IdPMetadata is what the service provider needs to know about an identity provider
+type IdPMetadata struct {
+	EntityID string
+	// SSOURLs maps the supported bindings to the single sign-on endpoint of the identity provider
+	SSOURLs map[string]string
+	// Certificates are the signing certificates, several during a key rollover
+	Certificates []*x509.Certificate
+}
+
+type entityDescriptor struct {
+	XMLName          xml.Name           `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	EntityID         string             `xml:"entityID,attr"`
+	IDPSSODescriptor []idpSSODescriptor `xml:"urn:oasis:names:tc:SAML:2.0:metadata IDPSSODescriptor"`
+}
+
+type idpSSODescriptor struct {
+	KeyDescriptors      []keyDescriptor `xml:"urn:oasis:names:tc:SAML:2.0:metadata KeyDescriptor"`
+	SingleSignOnService []endpoint      `xml:"urn:oasis:names:tc:SAML:2.0:metadata SingleSignOnService"`
+}
+
+type keyDescriptor struct {
+	Use          string   `xml:"use,attr"`
+	Certificates []string `xml:"http://www.w3.org/2000/09/xmldsig# KeyInfo>X509Data>X509Certificate"`
+}
+
+type endpoint struct {
+	Binding  string `xml:"Binding,attr"`
+	Location string `xml:"Location,attr"`
+}
+
+// ParseIdPMetadata parses the EntityDescriptor metadata document published by an identity provider
+func ParseIdPMetadata(data []byte) (*IdPMetadata, error) {
+	var descriptor entityDescriptor
+	if err := xml.Unmarshal(data, &descriptor); err != nil {
+		return nil, errors.NewLuciaError("ConfigurationError", "Invalid SAML IdP metadata").WithCause(err)
+	}
+	if descriptor.EntityID == "" || len(descriptor.IDPSSODescriptor) == 0 {
+		return nil, errors.NewLuciaError("ConfigurationError", "SAML metadata does not describe an identity provider")
+	}
+
+	metadata := &IdPMetadata{
+		EntityID: descriptor.EntityID,
+		SSOURLs:  make(map[string]string),
+	}
+	for _, idp := range descriptor.IDPSSODescriptor {
+		for _, sso := range idp.SingleSignOnService {
+			if _, ok := metadata.SSOURLs[sso.Binding]; !ok {
+				metadata.SSOURLs[sso.Binding] = sso.Location
+			}
+		}
+		for _, key := range idp.KeyDescriptors {
+			if key.Use != "" && key.Use != "signing" {
+				continue
+			}
+			for _, data := range key.Certificates {
+				cert, err := parseCertificate(data)
+				if err != nil {
+					return nil, errors.NewLuciaError("ConfigurationError", "Invalid certificate in SAML IdP metadata").WithCause(err)
+				}
+				metadata.Certificates = append(metadata.Certificates, cert)
+			}
+		}
+	}
+	if len(metadata.Certificates) == 0 {
+		return nil, errors.NewLuciaError("ConfigurationError", "SAML IdP metadata has no signing certificate")
+	}
+	if metadata.SSOURLs[BindingRedirect] == "" && metadata.SSOURLs[BindingPOST] == "" {
+		return nil, errors.NewLuciaError("ConfigurationError", "SAML IdP metadata has no supported single sign-on service")
+	}
+	return metadata, nil
+}
+
+// FetchIdPMetadata downloads and parses the metadata of an identity provider, a nil client uses http.DefaultClient
+func FetchIdPMetadata(ctx context.Context, client *http.Client, metadataURL string) (*IdPMetadata, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataURL, nil)
+	if err != nil {
+		return nil, errors.NewLuciaError("ConfigurationError", "Invalid SAML IdP metadata URL").WithCause(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.NewLuciaError("ConfigurationError", "Failed to fetch SAML IdP metadata").WithCause(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.NewLuciaError("ConfigurationError", "Failed to fetch SAML IdP metadata").WithStatusCode(resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxMetadataSize))
+	if err != nil {
+		return nil, errors.NewLuciaError("ConfigurationError", "Failed to read SAML IdP metadata").WithCause(err)
+	}
+	return ParseIdPMetadata(data)
+}
+
+func parseCertificate(data string) (*x509.Certificate, error) {
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(data), ""))
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+type spEntityDescriptor struct {
+	XMLName         xml.Name        `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	EntityID        string          `xml:"entityID,attr"`
+	SPSSODescriptor spSSODescriptor `xml:"SPSSODescriptor"`
+}
+
+type spSSODescriptor struct {
+	AuthnRequestsSigned        bool              `xml:"AuthnRequestsSigned,attr"`
+	WantAssertionsSigned       bool              `xml:"WantAssertionsSigned,attr"`
+	ProtocolSupportEnumeration string            `xml:"protocolSupportEnumeration,attr"`
+	KeyDescriptors             []spKeyDescriptor `xml:"KeyDescriptor,omitempty"`
+	NameIDFormat               string            `xml:"NameIDFormat,omitempty"`
+	AssertionConsumerService   spACS             `xml:"AssertionConsumerService"`
+}
+
+type spKeyDescriptor struct {
+	Use     string    `xml:"use,attr"`
+	KeyInfo spKeyInfo `xml:"http://www.w3.org/2000/09/xmldsig# KeyInfo"`
+}
+
+type spKeyInfo struct {
+	Certificate string `xml:"X509Data>X509Certificate"`
+}
+
+type spACS struct {
+	Binding  string `xml:"Binding,attr"`
+	Location string `xml:"Location,attr"`
+	Index    int    `xml:"index,attr"`
+}
+
+// Metadata returns the SP metadata document to register with identity providers
+func (sp *ServiceProvider) Metadata() ([]byte, error) {
+	descriptor := spEntityDescriptor{
+		EntityID: sp.cfg.EntityID,
+		SPSSODescriptor: spSSODescriptor{
+			AuthnRequestsSigned:        sp.cfg.Key != nil,
+			WantAssertionsSigned:       true,
+			ProtocolSupportEnumeration: NamespaceProtocol,
+			NameIDFormat:               sp.cfg.NameIDFormat,
+			AssertionConsumerService: spACS{
+				Binding:  BindingPOST,
+				Location: sp.cfg.ACSURL,
+			},
+		},
+	}
+	if sp.cfg.Certificate != nil {
+		descriptor.SPSSODescriptor.KeyDescriptors = []spKeyDescriptor{{
+			Use:     "signing",
+			KeyInfo: spKeyInfo{Certificate: base64.StdEncoding.EncodeToString(sp.cfg.Certificate.Raw)},
+		}}
+	}
+
+	data, err := xml.MarshalIndent(descriptor, "", "  ")
+	if err != nil {
+		return nil, errors.NewLuciaError("UnexpectedError", "Failed to encode SAML metadata").WithCause(err)
+	}
+	return append([]byte(xml.Header), data...), nil
+}
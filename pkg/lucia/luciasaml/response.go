@@ -0,0 +1,455 @@
+package luciasaml
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+	"github.com/Abraxas-365/toolkit/pkg/lucia"
+	"github.com/beevik/etree"
+	"github.com/russellhaering/goxmldsig/etreeutils"
+)
+
+const (
+	statusSuccess         = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	confirmationBearer    = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+	nameIDFormatTransient = "urn:oasis:names:tc:SAML:2.0:nameid-format:transient"
+)
+
+// Assertion holds the verified content of a SAML assertion
+type Assertion struct {
+	ID           string
+	NameID       string
+	NameIDFormat string
+	// SessionIndex identifies the session at the identity provider
+	SessionIndex string
+	AuthnInstant time.Time
+	Attributes   map[string][]string
+}
+
+// AttributeMap lists, per UserInfo field, the attribute names to read it from, the first present one wins.
+// Empty fields default to the names used by the common identity providers.
+type AttributeMap struct {
+	// ID reads the user ID from an attribute instead of the NameID, needed when the NameID is transient
+	ID        []string
+	Email     []string
+	Name      []string
+	FirstName []string
+	LastName  []string
+	Groups    []string
+}
+
+func (m AttributeMap) withDefaults() AttributeMap {
+	if len(m.Email) == 0 {
+		m.Email = []string{"email", "mail", "urn:oid:0.9.2342.19200300.100.1.3",
+			"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress"}
+	}
+	if len(m.Name) == 0 {
+		m.Name = []string{"name", "displayName", "urn:oid:2.16.840.1.113730.3.1.241",
+			"http://schemas.microsoft.com/identity/claims/displayname"}
+	}
+	if len(m.FirstName) == 0 {
+		m.FirstName = []string{"firstName", "givenName", "urn:oid:2.5.4.42",
+			"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/givenname"}
+	}
+	if len(m.LastName) == 0 {
+		m.LastName = []string{"lastName", "sn", "surname", "urn:oid:2.5.4.4",
+			"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/surname"}
+	}
+	if len(m.Groups) == 0 {
+		m.Groups = []string{"groups", "memberOf", "urn:oid:1.3.6.1.4.1.5923.1.5.1.1",
+			"http://schemas.microsoft.com/ws/2008/06/identity/claims/groups"}
+	}
+	return m
+}
+
+// ParseResponse verifies a base64 SAMLResponse posted to the assertion consumer service and returns its assertion.
+// requestIDs are the IDs of the AuthnRequests this browser started, without any the response must be
+// IdP-initiated, which AllowIdPInitiated has to permit.
+//
+// Only signed content is read: the signature is verified first and the claims are taken from the verified copy,
+// so elements wrapped around or next to the signed ones are never trusted.
+func (sp *ServiceProvider) ParseResponse(samlResponse string, requestIDs ...string) (*Assertion, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(samlResponse), ""))
+	if err != nil {
+		return nil, invalidResponse("Invalid SAML response encoding").WithCause(err)
+	}
+	if bytes.Contains(data, []byte("<!DOCTYPE")) || bytes.Contains(data, []byte("<!ENTITY")) {
+		return nil, invalidResponse("SAML responses must not contain a DTD")
+	}
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(data); err != nil {
+		return nil, invalidResponse("Invalid SAML response XML").WithCause(err)
+	}
+	root := doc.Root()
+	if root == nil || !is(root, NamespaceProtocol, "Response") {
+		return nil, invalidResponse("Not a SAML response")
+	}
+	if err := checkUniqueIDs(root); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	response := root
+	responseSigned := false
+	if hasSignature(root) {
+		if response, err = sp.verify(root); err != nil {
+			return nil, err
+		}
+		responseSigned = true
+	}
+	inResponseTo, err := sp.checkResponse(response, requestIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(children(response, NamespaceAssertion, "EncryptedAssertion")) > 0 {
+		return nil, invalidResponse("Encrypted SAML assertions are not supported")
+	}
+	assertions := children(response, NamespaceAssertion, "Assertion")
+	if len(assertions) != 1 {
+		return nil, invalidResponse("SAML response must contain exactly one assertion")
+	}
+	assertion := assertions[0]
+	if hasSignature(assertion) {
+		if assertion, err = sp.verify(assertion); err != nil {
+			return nil, err
+		}
+	} else if !responseSigned {
+		return nil, invalidResponse("SAML assertion is not signed")
+	}
+
+	result, expiresAt, err := sp.checkAssertion(assertion, inResponseTo, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := sp.markSeen(result.ID, expiresAt, now); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// verify checks the enveloped signature of el and returns the verified copy of el
+func (sp *ServiceProvider) verify(el *etree.Element) (*etree.Element, error) {
+	id := attr(el, "ID")
+	if id == "" {
+		return nil, invalidResponse("Signed SAML element has no ID")
+	}
+	signatures := children(el, namespaceDSig, "Signature")
+	if len(signatures) != 1 {
+		return nil, invalidResponse("SAML element must have exactly one signature")
+	}
+	// The signature must cover exactly this element, not a sibling or the whole document
+	var references []*etree.Element
+	for _, signedInfo := range children(signatures[0], namespaceDSig, "SignedInfo") {
+		references = append(references, children(signedInfo, namespaceDSig, "Reference")...)
+	}
+	if len(references) != 1 || attr(references[0], "URI") != "#"+id {
+		return nil, invalidResponse("SAML signature does not reference the signed element")
+	}
+
+	// Detach the element with the namespaces declared by its ancestors, they are part of the signed content
+	nsCtx, err := etreeutils.NSBuildParentContext(el)
+	if err != nil {
+		return nil, invalidResponse("Invalid SAML namespaces").WithCause(err)
+	}
+	detached, err := etreeutils.NSDetatch(nsCtx, el)
+	if err != nil {
+		return nil, invalidResponse("Invalid SAML namespaces").WithCause(err)
+	}
+	verified, err := sp.validator.Validate(detached)
+	if err != nil {
+		return nil, invalidResponse("Invalid SAML signature").WithCause(err)
+	}
+	if attr(verified, "ID") != id {
+		return nil, invalidResponse("SAML signature does not reference the signed element")
+	}
+	return verified, nil
+}
+
+// checkResponse checks the envelope of the response and returns the request it answers, "" if unsolicited
+func (sp *ServiceProvider) checkResponse(response *etree.Element, requestIDs []string) (string, error) {
+	if attr(response, "Version") != "2.0" {
+		return "", invalidResponse("Unsupported SAML version")
+	}
+	if destination := attr(response, "Destination"); destination != "" && destination != sp.cfg.ACSURL {
+		return "", invalidResponse("SAML response was sent to another destination")
+	}
+	if issuer := child(response, NamespaceAssertion, "Issuer"); issuer != nil && strings.TrimSpace(issuer.Text()) != sp.cfg.IdP.EntityID {
+		return "", invalidResponse("SAML response was issued by another identity provider")
+	}
+
+	status := child(response, NamespaceProtocol, "Status")
+	code := child(status, NamespaceProtocol, "StatusCode")
+	if code == nil || attr(code, "Value") != statusSuccess {
+		message := "SAML login failed"
+		if text := child(status, NamespaceProtocol, "StatusMessage"); text != nil && text.Text() != "" {
+			message += ": " + strings.TrimSpace(text.Text())
+		}
+		return "", invalidResponse(message)
+	}
+
+	inResponseTo := attr(response, "InResponseTo")
+	if err := sp.checkInResponseTo(inResponseTo, requestIDs); err != nil {
+		return "", err
+	}
+	return inResponseTo, nil
+}
+
+func (sp *ServiceProvider) checkInResponseTo(inResponseTo string, requestIDs []string) error {
+	if inResponseTo == "" {
+		if !sp.cfg.AllowIdPInitiated {
+			return invalidResponse("Unsolicited SAML responses are not allowed")
+		}
+		return nil
+	}
+	for _, id := range requestIDs {
+		if id != "" && id == inResponseTo {
+			return nil
+		}
+	}
+	return invalidResponse("SAML response does not answer a pending request")
+}
+
+// checkAssertion validates the conditions of a verified assertion and returns it with the time it stops being valid
+func (sp *ServiceProvider) checkAssertion(assertion *etree.Element, inResponseTo string, now time.Time) (*Assertion, time.Time, error) {
+	skew := sp.cfg.ClockSkew
+	result := &Assertion{
+		ID:         attr(assertion, "ID"),
+		Attributes: make(map[string][]string),
+	}
+	if issuer := child(assertion, NamespaceAssertion, "Issuer"); issuer == nil || strings.TrimSpace(issuer.Text()) != sp.cfg.IdP.EntityID {
+		return nil, time.Time{}, invalidResponse("SAML assertion was issued by another identity provider")
+	}
+
+	subject := child(assertion, NamespaceAssertion, "Subject")
+	nameID := child(subject, NamespaceAssertion, "NameID")
+	if nameID == nil || strings.TrimSpace(nameID.Text()) == "" {
+		return nil, time.Time{}, invalidResponse("SAML assertion has no subject")
+	}
+	result.NameID = strings.TrimSpace(nameID.Text())
+	result.NameIDFormat = attr(nameID, "Format")
+
+	var expiresAt time.Time
+	confirmed := false
+	for _, confirmation := range children(subject, NamespaceAssertion, "SubjectConfirmation") {
+		if attr(confirmation, "Method") != confirmationBearer {
+			continue
+		}
+		data := child(confirmation, NamespaceAssertion, "SubjectConfirmationData")
+		if data == nil || attr(data, "Recipient") != sp.cfg.ACSURL {
+			continue
+		}
+		if attr(data, "InResponseTo") != inResponseTo {
+			continue
+		}
+		notOnOrAfter, err := parseTime(attr(data, "NotOnOrAfter"))
+		if err != nil || notOnOrAfter.IsZero() || !now.Before(notOnOrAfter.Add(skew)) {
+			continue
+		}
+		confirmed = true
+		expiresAt = notOnOrAfter
+		break
+	}
+	if !confirmed {
+		return nil, time.Time{}, invalidResponse("SAML assertion subject is not confirmed for this service provider")
+	}
+
+	conditions := child(assertion, NamespaceAssertion, "Conditions")
+	if conditions != nil {
+		notBefore, err := parseTime(attr(conditions, "NotBefore"))
+		if err != nil || (!notBefore.IsZero() && now.Add(skew).Before(notBefore)) {
+			return nil, time.Time{}, invalidResponse("SAML assertion is not yet valid")
+		}
+		notOnOrAfter, err := parseTime(attr(conditions, "NotOnOrAfter"))
+		if err != nil || (!notOnOrAfter.IsZero() && !now.Before(notOnOrAfter.Add(skew))) {
+			return nil, time.Time{}, invalidResponse("SAML assertion has expired")
+		}
+		if !notOnOrAfter.IsZero() && notOnOrAfter.After(expiresAt) {
+			expiresAt = notOnOrAfter
+		}
+		// Every AudienceRestriction must name this service provider
+		for _, restriction := range children(conditions, NamespaceAssertion, "AudienceRestriction") {
+			allowed := false
+			for _, audience := range children(restriction, NamespaceAssertion, "Audience") {
+				if strings.TrimSpace(audience.Text()) == sp.cfg.EntityID {
+					allowed = true
+				}
+			}
+			if !allowed {
+				return nil, time.Time{}, invalidResponse("SAML assertion is intended for another audience")
+			}
+		}
+	}
+
+	if statement := child(assertion, NamespaceAssertion, "AuthnStatement"); statement != nil {
+		result.SessionIndex = attr(statement, "SessionIndex")
+		result.AuthnInstant, _ = parseTime(attr(statement, "AuthnInstant"))
+	}
+	for _, statement := range children(assertion, NamespaceAssertion, "AttributeStatement") {
+		for _, attribute := range children(statement, NamespaceAssertion, "Attribute") {
+			name := attr(attribute, "Name")
+			for _, value := range children(attribute, NamespaceAssertion, "AttributeValue") {
+				result.Attributes[name] = append(result.Attributes[name], strings.TrimSpace(value.Text()))
+			}
+		}
+	}
+	return result, expiresAt.Add(skew), nil
+}
+
+// markSeen rejects replayed assertions, it only protects a single process, which bearer assertions are
+// sent to within their short lifetime
+func (sp *ServiceProvider) markSeen(id string, expiresAt, now time.Time) error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	for seenID, until := range sp.seen {
+		if now.After(until) {
+			delete(sp.seen, seenID)
+		}
+	}
+	if _, ok := sp.seen[id]; ok {
+		return invalidResponse("SAML assertion has already been used")
+	}
+	sp.seen[id] = expiresAt
+	return nil
+}
+
+// UserInfo maps a verified assertion to the lucia.UserInfo of the provider
+func (sp *ServiceProvider) UserInfo(assertion *Assertion) (*lucia.UserInfo, error) {
+	attrs := sp.cfg.Attributes
+	id := assertion.NameID
+	if len(attrs.ID) > 0 {
+		id = firstAttribute(assertion.Attributes, attrs.ID)
+	} else if assertion.NameIDFormat == nameIDFormatTransient {
+		return nil, errors.NewLuciaError("ConfigurationError", "Transient SAML NameIDs need an ID attribute mapping")
+	}
+	if id == "" {
+		return nil, invalidResponse("SAML assertion has no user ID")
+	}
+
+	email := firstAttribute(assertion.Attributes, attrs.Email)
+	if email == "" && assertion.NameIDFormat == NameIDFormatEmailAddress {
+		email = assertion.NameID
+	}
+	name := firstAttribute(assertion.Attributes, attrs.Name)
+	if name == "" {
+		name = strings.TrimSpace(firstAttribute(assertion.Attributes, attrs.FirstName) + " " + firstAttribute(assertion.Attributes, attrs.LastName))
+	}
+	var groups []string
+	for _, attribute := range attrs.Groups {
+		if values, ok := assertion.Attributes[attribute]; ok {
+			groups = values
+			break
+		}
+	}
+
+	raw, err := json.Marshal(map[string]interface{}{
+		"name_id":        assertion.NameID,
+		"name_id_format": assertion.NameIDFormat,
+		"session_index":  assertion.SessionIndex,
+		"attributes":     assertion.Attributes,
+	})
+	if err != nil {
+		return nil, errors.NewLuciaError("UnexpectedError", "Failed to encode SAML profile").WithCause(err)
+	}
+
+	userInfo := &lucia.UserInfo{
+		ID:            id,
+		Email:         email,
+		EmailVerified: sp.cfg.TrustEmail && email != "",
+		Name:          name,
+		Provider:      sp.cfg.Provider,
+		Groups:        groups,
+		Raw:           raw,
+	}
+	if !assertion.AuthnInstant.IsZero() {
+		userInfo.AuthTime = assertion.AuthnInstant.Unix()
+	}
+	return userInfo, nil
+}
+
+func firstAttribute(attributes map[string][]string, names []string) string {
+	for _, name := range names {
+		if values := attributes[name]; len(values) > 0 && values[0] != "" {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// checkUniqueIDs rejects documents reusing an ID, signature wrapping attacks duplicate the signed element's ID
+func checkUniqueIDs(root *etree.Element) error {
+	ids := make(map[string]bool)
+	var walk func(el *etree.Element) error
+	walk = func(el *etree.Element) error {
+		if id := attr(el, "ID"); id != "" {
+			if ids[id] {
+				return invalidResponse("SAML response contains duplicate IDs")
+			}
+			ids[id] = true
+		}
+		for _, c := range el.ChildElements() {
+			if err := walk(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(root)
+}
+
+// attr returns the value of an unprefixed attribute, unlike SelectAttrValue it ignores namespaced look-alikes
+// such as x:ID
+func attr(el *etree.Element, key string) string {
+	for _, a := range el.Attr {
+		if a.Space == "" && a.Key == key {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func hasSignature(el *etree.Element) bool {
+	return len(children(el, namespaceDSig, "Signature")) > 0
+}
+
+func is(el *etree.Element, namespace, tag string) bool {
+	return el.Tag == tag && el.NamespaceURI() == namespace
+}
+
+// children returns the direct child elements of el with the given namespace and tag, el may be nil
+func children(el *etree.Element, namespace, tag string) []*etree.Element {
+	if el == nil {
+		return nil
+	}
+	var matches []*etree.Element
+	for _, c := range el.ChildElements() {
+		if is(c, namespace, tag) {
+			matches = append(matches, c)
+		}
+	}
+	return matches
+}
+
+// child returns the only direct child element with the given namespace and tag, nil if there is none or several
+func child(el *etree.Element, namespace, tag string) *etree.Element {
+	matches := children(el, namespace, tag)
+	if len(matches) != 1 {
+		return nil
+	}
+	return matches[0]
+}
+
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+func invalidResponse(message string) errors.LuciaError {
+	return errors.NewLuciaError("InvalidSAMLResponse", message)
+}
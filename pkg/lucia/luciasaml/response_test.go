@@ -0,0 +1,319 @@
+package luciasaml
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+const (
+	testIdPEntityID = "https://idp.example.com/metadata"
+	testSPEntityID  = "https://sp.example.com/metadata"
+	testACSURL      = "https://sp.example.com/saml/acs"
+	testRequestID   = "_request"
+)
+
+// testIdP signs responses with a locally generated certificate
+type testIdP struct {
+	key  *rsa.PrivateKey
+	cert *x509.Certificate
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testIdP{key: key, cert: cert}
+}
+
+func (idp *testIdP) serviceProvider(t *testing.T, cfg Config) *ServiceProvider {
+	t.Helper()
+	cfg.EntityID = testSPEntityID
+	cfg.ACSURL = testACSURL
+	cfg.IdP = &IdPMetadata{
+		EntityID:     testIdPEntityID,
+		SSOURLs:      map[string]string{BindingRedirect: "https://idp.example.com/sso"},
+		Certificates: []*x509.Certificate{idp.cert},
+	}
+	sp, err := NewServiceProvider(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sp
+}
+
+// sign returns a copy of el with an enveloped signature right after its Issuer
+func (idp *testIdP) sign(t *testing.T, el *etree.Element) *etree.Element {
+	t.Helper()
+	signer, err := dsig.NewSigningContext(idp.key, [][]byte{idp.cert.Raw})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer.Prefix = "ds"
+	signer.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+	signature, err := signer.ConstructSignature(el, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := el.Copy()
+	signed.InsertChildAt(1, signature)
+	return signed
+}
+
+// assertion returns an unsigned assertion for user answering testRequestID
+func assertion(id, user string, now time.Time) *etree.Element {
+	a := etree.NewElement("saml:Assertion")
+	a.CreateAttr("xmlns:saml", NamespaceAssertion)
+	a.CreateAttr("ID", id)
+	a.CreateAttr("Version", "2.0")
+	a.CreateAttr("IssueInstant", now.UTC().Format(time.RFC3339))
+	a.CreateElement("saml:Issuer").SetText(testIdPEntityID)
+
+	subject := a.CreateElement("saml:Subject")
+	subject.CreateElement("saml:NameID").SetText(user)
+	confirmation := subject.CreateElement("saml:SubjectConfirmation")
+	confirmation.CreateAttr("Method", confirmationBearer)
+	data := confirmation.CreateElement("saml:SubjectConfirmationData")
+	data.CreateAttr("Recipient", testACSURL)
+	data.CreateAttr("InResponseTo", testRequestID)
+	data.CreateAttr("NotOnOrAfter", now.Add(5*time.Minute).UTC().Format(time.RFC3339))
+
+	conditions := a.CreateElement("saml:Conditions")
+	conditions.CreateAttr("NotBefore", now.Add(-time.Minute).UTC().Format(time.RFC3339))
+	conditions.CreateAttr("NotOnOrAfter", now.Add(5*time.Minute).UTC().Format(time.RFC3339))
+	conditions.CreateElement("saml:AudienceRestriction").CreateElement("saml:Audience").SetText(testSPEntityID)
+
+	statement := a.CreateElement("saml:AuthnStatement")
+	statement.CreateAttr("AuthnInstant", now.UTC().Format(time.RFC3339))
+	statement.CreateAttr("SessionIndex", "_session")
+	return a
+}
+
+// response wraps the assertions in a successful response answering testRequestID
+func response(assertions ...*etree.Element) *etree.Element {
+	r := etree.NewElement("samlp:Response")
+	r.CreateAttr("xmlns:samlp", NamespaceProtocol)
+	r.CreateAttr("xmlns:saml", NamespaceAssertion)
+	r.CreateAttr("ID", "_response")
+	r.CreateAttr("Version", "2.0")
+	r.CreateAttr("IssueInstant", time.Now().UTC().Format(time.RFC3339))
+	r.CreateAttr("Destination", testACSURL)
+	r.CreateAttr("InResponseTo", testRequestID)
+	r.CreateElement("saml:Issuer").SetText(testIdPEntityID)
+	r.CreateElement("samlp:Status").CreateElement("samlp:StatusCode").CreateAttr("Value", statusSuccess)
+	for _, a := range assertions {
+		r.AddChild(a)
+	}
+	return r
+}
+
+func encode(t *testing.T, root *etree.Element) string {
+	t.Helper()
+	data, err := etree.NewDocumentWithRoot(root).WriteToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// unsolicited returns an IdP-initiated response, which answers no request
+func unsolicited(t *testing.T, idp *testIdP, now time.Time) *etree.Element {
+	a := assertion("_assertion", "alice", now)
+	a.FindElement("./Subject/SubjectConfirmation/SubjectConfirmationData").RemoveAttr("InResponseTo")
+	r := response(idp.sign(t, a))
+	r.RemoveAttr("InResponseTo")
+	return r
+}
+
+func nameID(el *etree.Element) *etree.Element {
+	return el.FindElement("./Subject/NameID")
+}
+
+func TestParseResponse(t *testing.T) {
+	idp := newTestIdP(t)
+	other := newTestIdP(t)
+	now := time.Now()
+	signed := idp.sign(t, assertion("_assertion", "alice", now))
+
+	tests := []struct {
+		name      string
+		response  func() *etree.Element
+		cfg       Config
+		requestID string
+		wantUser  string
+	}{
+		{
+			name:     "signed assertion",
+			response: func() *etree.Element { return response(signed.Copy()) },
+			wantUser: "alice",
+		},
+		{
+			name: "signed response",
+			response: func() *etree.Element {
+				return idp.sign(t, response(assertion("_assertion", "alice", now)))
+			},
+			wantUser: "alice",
+		},
+		{
+			name:     "unsigned",
+			response: func() *etree.Element { return response(assertion("_assertion", "alice", now)) },
+		},
+		{
+			name:     "signed by another certificate",
+			response: func() *etree.Element { return response(other.sign(t, assertion("_assertion", "alice", now))) },
+		},
+		{
+			name: "tampered after signing",
+			response: func() *etree.Element {
+				tampered := signed.Copy()
+				nameID(tampered).SetText("admin")
+				return response(tampered)
+			},
+		},
+		{
+			name: "wrapping: injected assertion next to the signed one",
+			response: func() *etree.Element {
+				return response(assertion("_evil", "admin", now), signed.Copy())
+			},
+		},
+		{
+			name: "wrapping: signed assertion hidden inside an injected one with the same ID",
+			response: func() *etree.Element {
+				evil := assertion("_assertion", "admin", now)
+				evil.AddChild(signed.Copy())
+				return response(evil)
+			},
+		},
+		{
+			name: "wrapping: signature moved onto an injected assertion",
+			response: func() *etree.Element {
+				evil := assertion("_evil", "admin", now)
+				evil.InsertChildAt(1, signed.SelectElement("Signature").Copy())
+				return response(evil)
+			},
+		},
+		{
+			name: "wrapping: signed response nested in an unsigned one",
+			response: func() *etree.Element {
+				inner := idp.sign(t, response(assertion("_assertion", "alice", now)))
+				outer := response(assertion("_evil", "admin", now))
+				outer.RemoveAttr("ID")
+				outer.CreateAttr("ID", "_outer")
+				outer.CreateElement("samlp:Extensions").AddChild(inner)
+				return outer
+			},
+		},
+		{
+			name: "duplicate IDs",
+			response: func() *etree.Element {
+				r := response(signed.Copy())
+				r.CreateElement("samlp:Extensions").CreateAttr("ID", "_assertion")
+				return r
+			},
+		},
+		{
+			name: "wrong audience",
+			response: func() *etree.Element {
+				a := assertion("_assertion", "alice", now)
+				a.FindElement("./Conditions/AudienceRestriction/Audience").SetText("https://other.example.com")
+				return response(idp.sign(t, a))
+			},
+		},
+		{
+			name: "expired",
+			response: func() *etree.Element {
+				return response(idp.sign(t, assertion("_assertion", "alice", now.Add(-time.Hour))))
+			},
+		},
+		{
+			name: "issued by another identity provider",
+			response: func() *etree.Element {
+				a := assertion("_assertion", "alice", now)
+				a.SelectElement("Issuer").SetText("https://evil.example.com")
+				return response(idp.sign(t, a))
+			},
+		},
+		{
+			name:      "answers another request",
+			response:  func() *etree.Element { return response(signed.Copy()) },
+			requestID: "_other",
+		},
+		{
+			name:     "unsolicited",
+			response: func() *etree.Element { return unsolicited(t, idp, now) },
+		},
+		{
+			name:     "unsolicited when allowed",
+			response: func() *etree.Element { return unsolicited(t, idp, now) },
+			cfg:      Config{AllowIdPInitiated: true},
+			wantUser: "alice",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sp := idp.serviceProvider(t, tt.cfg)
+			requestID := tt.requestID
+			if requestID == "" {
+				requestID = testRequestID
+			}
+			got, err := sp.ParseResponse(encode(t, tt.response()), requestID)
+			if tt.wantUser == "" {
+				if errorType(err) != "InvalidSAMLResponse" {
+					t.Fatalf("ParseResponse() = %+v, %v, want InvalidSAMLResponse", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseResponse() error = %v", err)
+			}
+			if got.NameID != tt.wantUser {
+				t.Errorf("NameID = %q, want %q", got.NameID, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestParseResponseRejectsReplay(t *testing.T) {
+	idp := newTestIdP(t)
+	sp := idp.serviceProvider(t, Config{})
+	samlResponse := encode(t, response(idp.sign(t, assertion("_assertion", "alice", time.Now()))))
+
+	if _, err := sp.ParseResponse(samlResponse, testRequestID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sp.ParseResponse(samlResponse, testRequestID); errorType(err) != "InvalidSAMLResponse" {
+		t.Errorf("replayed ParseResponse() error = %v, want InvalidSAMLResponse", err)
+	}
+}
+
+func errorType(err error) string {
+	if luciaErr, ok := err.(errors.LuciaError); ok {
+		return luciaErr.Type
+	}
+	return ""
+}
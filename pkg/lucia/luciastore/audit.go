@@ -0,0 +1,132 @@
+package luciastore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+	"github.com/Abraxas-365/toolkit/pkg/lucia"
+	"github.com/jmoiron/sqlx"
+)
+
+// AuditStore is a lucia.AuditSink and lucia.AuditQuerier backed by Postgres
+type AuditStore struct {
+	db *sqlx.DB
+}
+
+// NewAuditStore creates a new AuditStore from an existing sqlx.DB connection
+func NewAuditStore(db *sqlx.DB) *AuditStore {
+	return &AuditStore{db: db}
+}
+
+func (s *AuditStore) Write(ctx context.Context, event lucia.AuditEvent) error {
+	var metadata []byte
+	if len(event.Metadata) > 0 {
+		var err error
+		metadata, err = json.Marshal(event.Metadata)
+		if err != nil {
+			return errors.ErrUnexpected("Failed to encode audit metadata").WithCause(err)
+		}
+	}
+
+	query := `INSERT INTO auth_audit_log
+		(id, type, user_id, actor_id, session_id, provider, ip, user_agent, error_type, error, metadata, occurred_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`
+	_, err := s.db.ExecContext(ctx, query,
+		event.ID, string(event.Type), nullString(event.UserID), nullString(event.ActorID), nullString(event.SessionID), nullString(event.Provider),
+		nullString(event.IP), nullString(event.UserAgent), nullString(event.ErrorType), nullString(event.Error),
+		nullString(string(metadata)), event.OccurredAt,
+	)
+	if err != nil {
+		return errors.ErrDatabase("Failed to write audit event").WithCause(err)
+	}
+	return nil
+}
+
+func (s *AuditStore) Query(ctx context.Context, filter lucia.AuditFilter) ([]lucia.AuditEvent, error) {
+	var conditions []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if filter.UserID != "" {
+		conditions = append(conditions, "user_id = "+arg(filter.UserID))
+	}
+	if filter.ActorID != "" {
+		conditions = append(conditions, "actor_id = "+arg(filter.ActorID))
+	}
+	if len(filter.Types) > 0 {
+		types := make([]string, len(filter.Types))
+		for i, t := range filter.Types {
+			types[i] = arg(string(t))
+		}
+		conditions = append(conditions, "type IN ("+strings.Join(types, ", ")+")")
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "occurred_at >= "+arg(filter.From))
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "occurred_at < "+arg(filter.To))
+	}
+
+	query := `SELECT id, type, user_id, actor_id, session_id, provider, ip, user_agent, error_type, error, metadata, occurred_at FROM auth_audit_log`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY occurred_at DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT " + arg(filter.Limit)
+	}
+
+	type dbAuditEvent struct {
+		ID         string         `db:"id"`
+		Type       string         `db:"type"`
+		UserID     sql.NullString `db:"user_id"`
+		ActorID    sql.NullString `db:"actor_id"`
+		SessionID  sql.NullString `db:"session_id"`
+		Provider   sql.NullString `db:"provider"`
+		IP         sql.NullString `db:"ip"`
+		UserAgent  sql.NullString `db:"user_agent"`
+		ErrorType  sql.NullString `db:"error_type"`
+		Error      sql.NullString `db:"error"`
+		Metadata   []byte         `db:"metadata"`
+		OccurredAt sql.NullTime   `db:"occurred_at"`
+	}
+	var rows []dbAuditEvent
+	if err := s.db.SelectContext(ctx, &rows, query, args...); err != nil {
+		return nil, errors.ErrDatabase("Failed to query audit log").WithCause(err)
+	}
+
+	events := make([]lucia.AuditEvent, 0, len(rows))
+	for _, row := range rows {
+		event := lucia.AuditEvent{
+			ID:         row.ID,
+			Type:       lucia.AuditEventType(row.Type),
+			UserID:     row.UserID.String,
+			ActorID:    row.ActorID.String,
+			SessionID:  row.SessionID.String,
+			Provider:   row.Provider.String,
+			IP:         row.IP.String,
+			UserAgent:  row.UserAgent.String,
+			ErrorType:  row.ErrorType.String,
+			Error:      row.Error.String,
+			OccurredAt: row.OccurredAt.Time,
+		}
+		if len(row.Metadata) > 0 {
+			if err := json.Unmarshal(row.Metadata, &event.Metadata); err != nil {
+				return nil, errors.ErrParse("Failed to decode audit metadata").WithCause(err)
+			}
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,141 @@
+package luciastore
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+	"github.com/Abraxas-365/toolkit/pkg/lucia"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// DeviceStore is a lucia.DeviceStore backed by Postgres
+type DeviceStore struct {
+	db *sqlx.DB
+}
+
+// NewDeviceStore creates a new DeviceStore from an existing sqlx.DB connection
+func NewDeviceStore(db *sqlx.DB) *DeviceStore {
+	return &DeviceStore{db: db}
+}
+
+type dbDeviceAuthorization struct {
+	DeviceCodeHash  string         `db:"device_code_hash"`
+	UserCode        string         `db:"user_code"`
+	ClientID        string         `db:"client_id"`
+	Scopes          string         `db:"scopes"`
+	Status          string         `db:"status"`
+	UserID          sql.NullString `db:"user_id"`
+	AuthTime        sql.NullTime   `db:"auth_time"`
+	AuthProvider    string         `db:"auth_provider"`
+	MFA             bool           `db:"mfa"`
+	IntervalSeconds int64          `db:"interval_seconds"`
+	LastPolledAt    sql.NullTime   `db:"last_polled_at"`
+	ExpiresAt       time.Time      `db:"expires_at"`
+}
+
+func (d dbDeviceAuthorization) toDeviceAuthorization() *lucia.DeviceAuthorization {
+	auth := &lucia.DeviceAuthorization{
+		DeviceCodeHash: d.DeviceCodeHash,
+		UserCode:       d.UserCode,
+		ClientID:       d.ClientID,
+		Scopes:         strings.Fields(d.Scopes),
+		Status:         lucia.DeviceAuthorizationStatus(d.Status),
+		UserID:         d.UserID.String,
+		AuthProvider:   d.AuthProvider,
+		MFA:            d.MFA,
+		Interval:       time.Duration(d.IntervalSeconds) * time.Second,
+		LastPolledAt:   d.LastPolledAt.Time,
+		ExpiresAt:      d.ExpiresAt,
+	}
+	if d.AuthTime.Valid {
+		auth.AuthTime = d.AuthTime.Time.Unix()
+	}
+	return auth
+}
+
+const deviceColumns = `device_code_hash, user_code, client_id, scopes, status, user_id, auth_time, auth_provider, mfa,
+	interval_seconds, last_polled_at, expires_at`
+
+func (s *DeviceStore) CreateDeviceAuthorization(ctx context.Context, auth *lucia.DeviceAuthorization) error {
+	query := `INSERT INTO auth_device_authorizations (` + deviceColumns + `) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`
+	_, err := s.db.ExecContext(ctx, query,
+		auth.DeviceCodeHash, auth.UserCode, auth.ClientID, strings.Join(auth.Scopes, " "), string(auth.Status),
+		nullString(auth.UserID), nullUnix(auth.AuthTime), auth.AuthProvider, auth.MFA,
+		int64(auth.Interval/time.Second), nullTime(auth.LastPolledAt), auth.ExpiresAt)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "unique_violation" {
+			return errors.ErrConflict("Device authorization already exists")
+		}
+		return errors.ErrDatabase("Failed to create device authorization").WithCause(err)
+	}
+	return nil
+}
+
+func (s *DeviceStore) GetDeviceAuthorizationByUserCode(ctx context.Context, userCode string) (*lucia.DeviceAuthorization, error) {
+	var row dbDeviceAuthorization
+	query := `SELECT ` + deviceColumns + ` FROM auth_device_authorizations WHERE user_code = $1`
+	if err := s.db.GetContext(ctx, &row, query, userCode); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.ErrNotFound("Device authorization not found")
+		}
+		return nil, errors.ErrDatabase("Failed to get device authorization").WithCause(err)
+	}
+	return row.toDeviceAuthorization(), nil
+}
+
+func (s *DeviceStore) UpdateDeviceAuthorization(ctx context.Context, deviceCodeHash string, fn func(auth *lucia.DeviceAuthorization) error) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.ErrDatabase("Failed to begin transaction").WithCause(err)
+	}
+	defer tx.Rollback()
+
+	var row dbDeviceAuthorization
+	query := `SELECT ` + deviceColumns + ` FROM auth_device_authorizations WHERE device_code_hash = $1 FOR UPDATE`
+	if err := tx.GetContext(ctx, &row, query, deviceCodeHash); err != nil {
+		if err == sql.ErrNoRows {
+			return errors.ErrNotFound("Device authorization not found")
+		}
+		return errors.ErrDatabase("Failed to get device authorization").WithCause(err)
+	}
+
+	auth := row.toDeviceAuthorization()
+	if err := fn(auth); err != nil {
+		return err
+	}
+
+	query = `UPDATE auth_device_authorizations
+		SET status = $2, user_id = $3, auth_time = $4, auth_provider = $5, mfa = $6, interval_seconds = $7, last_polled_at = $8
+		WHERE device_code_hash = $1`
+	_, err = tx.ExecContext(ctx, query, deviceCodeHash, string(auth.Status), nullString(auth.UserID),
+		nullUnix(auth.AuthTime), auth.AuthProvider, auth.MFA, int64(auth.Interval/time.Second), nullTime(auth.LastPolledAt))
+	if err != nil {
+		return errors.ErrDatabase("Failed to update device authorization").WithCause(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.ErrDatabase("Failed to commit device authorization").WithCause(err)
+	}
+	return nil
+}
+
+func (s *DeviceStore) DeleteDeviceAuthorization(ctx context.Context, deviceCodeHash string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM auth_device_authorizations WHERE device_code_hash = $1`, deviceCodeHash)
+	if err != nil {
+		return errors.ErrDatabase("Failed to delete device authorization").WithCause(err)
+	}
+	return nil
+}
+
+// DeleteExpired removes the authorizations whose codes have expired
+func (s *DeviceStore) DeleteExpired(ctx context.Context) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM auth_device_authorizations WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, errors.ErrDatabase("Failed to delete expired device authorizations").WithCause(err)
+	}
+	return result.RowsAffected()
+}
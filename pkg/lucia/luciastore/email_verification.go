@@ -0,0 +1,66 @@
+package luciastore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+	"github.com/Abraxas-365/toolkit/pkg/lucia"
+	"github.com/jmoiron/sqlx"
+)
+
+// EmailVerificationStore is a lucia.EmailVerificationStore backed by Postgres
+type EmailVerificationStore struct {
+	db *sqlx.DB
+}
+
+// NewEmailVerificationStore creates a new EmailVerificationStore from an existing sqlx.DB connection
+func NewEmailVerificationStore(db *sqlx.DB) *EmailVerificationStore {
+	return &EmailVerificationStore{db: db}
+}
+
+func (s *EmailVerificationStore) CreateEmailVerification(ctx context.Context, verification *lucia.EmailVerification) error {
+	query := `INSERT INTO auth_email_verifications (token_hash, user_id, email, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5)`
+	_, err := s.db.ExecContext(ctx, query, verification.TokenHash, verification.UserID, verification.Email,
+		verification.ExpiresAt, verification.CreatedAt)
+	if err != nil {
+		return errors.ErrDatabase("Failed to create email verification").WithCause(err)
+	}
+	return nil
+}
+
+func (s *EmailVerificationStore) ConsumeEmailVerification(ctx context.Context, tokenHash string) (*lucia.EmailVerification, error) {
+	var row struct {
+		TokenHash string    `db:"token_hash"`
+		UserID    string    `db:"user_id"`
+		Email     string    `db:"email"`
+		ExpiresAt time.Time `db:"expires_at"`
+		CreatedAt time.Time `db:"created_at"`
+	}
+	query := `DELETE FROM auth_email_verifications WHERE token_hash = $1
+		RETURNING token_hash, user_id, email, expires_at, created_at`
+	if err := s.db.GetContext(ctx, &row, query, tokenHash); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.ErrNotFound("Email verification not found")
+		}
+		return nil, errors.ErrDatabase("Failed to consume email verification").WithCause(err)
+	}
+	return &lucia.EmailVerification{
+		TokenHash: row.TokenHash,
+		UserID:    row.UserID,
+		Email:     row.Email,
+		ExpiresAt: row.ExpiresAt,
+		CreatedAt: row.CreatedAt,
+	}, nil
+}
+
+// DeleteExpiredEmailVerifications removes the verifications that can no longer be redeemed
+func (s *EmailVerificationStore) DeleteExpiredEmailVerifications(ctx context.Context) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM auth_email_verifications WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, errors.ErrDatabase("Failed to delete expired email verifications").WithCause(err)
+	}
+	return result.RowsAffected()
+}
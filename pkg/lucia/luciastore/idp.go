@@ -0,0 +1,124 @@
+package luciastore
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+	"github.com/Abraxas-365/toolkit/pkg/lucia"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// IdentityProviderStore is a lucia.IdentityProviderStore backed by Postgres
+type IdentityProviderStore struct {
+	db *sqlx.DB
+}
+
+// NewIdentityProviderStore creates a new IdentityProviderStore from an existing sqlx.DB connection
+func NewIdentityProviderStore(db *sqlx.DB) *IdentityProviderStore {
+	return &IdentityProviderStore{db: db}
+}
+
+func (s *IdentityProviderStore) CreateClient(ctx context.Context, client *lucia.OAuthClient) error {
+	query := `INSERT INTO auth_oauth_clients (id, name, secret_hash, redirect_uris, public, created_at) VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := s.db.ExecContext(ctx, query, client.ID, client.Name, nullString(client.SecretHash), pq.Array(client.RedirectURIs), client.Public, client.CreatedAt)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "unique_violation" {
+			return errors.ErrConflict("Client already exists")
+		}
+		return errors.ErrDatabase("Failed to create client").WithCause(err)
+	}
+	return nil
+}
+
+func (s *IdentityProviderStore) GetClient(ctx context.Context, clientID string) (*lucia.OAuthClient, error) {
+	var row struct {
+		ID           string         `db:"id"`
+		Name         string         `db:"name"`
+		SecretHash   sql.NullString `db:"secret_hash"`
+		RedirectURIs pq.StringArray `db:"redirect_uris"`
+		Public       bool           `db:"public"`
+		CreatedAt    time.Time      `db:"created_at"`
+	}
+	query := `SELECT id, name, secret_hash, redirect_uris, public, created_at FROM auth_oauth_clients WHERE id = $1`
+	if err := s.db.GetContext(ctx, &row, query, clientID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.ErrNotFound("Client not found")
+		}
+		return nil, errors.ErrDatabase("Failed to get client").WithCause(err)
+	}
+	return &lucia.OAuthClient{
+		ID:           row.ID,
+		Name:         row.Name,
+		SecretHash:   row.SecretHash.String,
+		RedirectURIs: row.RedirectURIs,
+		Public:       row.Public,
+		CreatedAt:    row.CreatedAt,
+	}, nil
+}
+
+func (s *IdentityProviderStore) DeleteClient(ctx context.Context, clientID string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM auth_oauth_clients WHERE id = $1`, clientID)
+	if err != nil {
+		return errors.ErrDatabase("Failed to delete client").WithCause(err)
+	}
+	return nil
+}
+
+func (s *IdentityProviderStore) CreateAuthorizationCode(ctx context.Context, code *lucia.AuthorizationCode) error {
+	query := `INSERT INTO auth_oauth_codes
+		(code_hash, client_id, user_id, session_id, redirect_uri, scopes, nonce, code_challenge, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	_, err := s.db.ExecContext(ctx, query, code.CodeHash, code.ClientID, code.UserID, code.SessionID, code.RedirectURI,
+		strings.Join(code.Scopes, " "), nullString(code.Nonce), code.CodeChallenge, code.ExpiresAt)
+	if err != nil {
+		return errors.ErrDatabase("Failed to create authorization code").WithCause(err)
+	}
+	return nil
+}
+
+func (s *IdentityProviderStore) ConsumeAuthorizationCode(ctx context.Context, codeHash string) (*lucia.AuthorizationCode, error) {
+	var row struct {
+		CodeHash      string         `db:"code_hash"`
+		ClientID      string         `db:"client_id"`
+		UserID        string         `db:"user_id"`
+		SessionID     string         `db:"session_id"`
+		RedirectURI   string         `db:"redirect_uri"`
+		Scopes        string         `db:"scopes"`
+		Nonce         sql.NullString `db:"nonce"`
+		CodeChallenge string         `db:"code_challenge"`
+		ExpiresAt     time.Time      `db:"expires_at"`
+	}
+	// Deleting and returning in one statement makes concurrent redemptions of the same code fail
+	query := `DELETE FROM auth_oauth_codes WHERE code_hash = $1
+		RETURNING code_hash, client_id, user_id, session_id, redirect_uri, scopes, nonce, code_challenge, expires_at`
+	if err := s.db.GetContext(ctx, &row, query, codeHash); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.ErrNotFound("Authorization code not found")
+		}
+		return nil, errors.ErrDatabase("Failed to consume authorization code").WithCause(err)
+	}
+	return &lucia.AuthorizationCode{
+		CodeHash:      row.CodeHash,
+		ClientID:      row.ClientID,
+		UserID:        row.UserID,
+		SessionID:     row.SessionID,
+		RedirectURI:   row.RedirectURI,
+		Scopes:        strings.Fields(row.Scopes),
+		Nonce:         row.Nonce.String,
+		CodeChallenge: row.CodeChallenge,
+		ExpiresAt:     row.ExpiresAt,
+	}, nil
+}
+
+// DeleteExpiredCodes removes authorization codes that were never redeemed
+func (s *IdentityProviderStore) DeleteExpiredCodes(ctx context.Context) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM auth_oauth_codes WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, errors.ErrDatabase("Failed to delete expired authorization codes").WithCause(err)
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,232 @@
+package luciastore
+
+import (
+	"context"
+	"database/sql/driver"
+	"sync/atomic"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+	"github.com/jmoiron/sqlx"
+)
+
+// janitorLockKey is the pg advisory lock key shared by every replica running the janitor ("LuciaSjn" in ASCII)
+const janitorLockKey int64 = 0x4c75636961536a6e
+
+const (
+	defaultJanitorBatchSize = 1000
+	defaultJanitorInterval  = 5 * time.Minute
+)
+
+// JanitorRun describes the outcome of a single janitor pass
+type JanitorRun struct {
+	Deleted  int64
+	Batches  int
+	Duration time.Duration
+	// Skipped is true when another replica held the advisory lock
+	Skipped bool
+	Err     error
+}
+
+// JanitorMetrics receives the result of every janitor pass
+type JanitorMetrics interface {
+	ObserveJanitorRun(run JanitorRun)
+}
+
+// JanitorMetricsFunc adapts a function to the JanitorMetrics interface
+type JanitorMetricsFunc func(run JanitorRun)
+
+func (f JanitorMetricsFunc) ObserveJanitorRun(run JanitorRun) {
+	f(run)
+}
+
+// JanitorStats holds the cumulative counters of a running janitor
+type JanitorStats struct {
+	Runs    int64
+	Skipped int64
+	Failed  int64
+	Deleted int64
+}
+
+// JanitorOption configures the janitor started by StartJanitor
+type JanitorOption func(*Janitor)
+
+// WithJanitorBatchSize sets how many expired sessions are deleted per statement
+func WithJanitorBatchSize(size int) JanitorOption {
+	return func(j *Janitor) {
+		if size > 0 {
+			j.batchSize = size
+		}
+	}
+}
+
+// WithJanitorMetrics sets the metrics sink notified after every pass
+func WithJanitorMetrics(metrics JanitorMetrics) JanitorOption {
+	return func(j *Janitor) {
+		j.metrics = metrics
+	}
+}
+
+// WithJanitorLockKey overrides the advisory lock key, useful when several apps share a database
+func WithJanitorLockKey(key int64) JanitorOption {
+	return func(j *Janitor) {
+		j.lockKey = key
+	}
+}
+
+// Janitor periodically deletes expired sessions from the sessions table
+type Janitor struct {
+	db        *sqlx.DB
+	interval  time.Duration
+	batchSize int
+	lockKey   int64
+	metrics   JanitorMetrics
+	done      chan struct{}
+
+	runs    atomic.Int64
+	skipped atomic.Int64
+	failed  atomic.Int64
+	deleted atomic.Int64
+}
+
+// StartJanitor starts a background job that deletes expired sessions every interval.
+// Only the replica holding the pg advisory lock deletes rows on a given pass; the others skip.
+// The job stops when ctx is cancelled, use Done to wait for it to finish.
+func (s *PostgresStore[ID]) StartJanitor(ctx context.Context, interval time.Duration, opts ...JanitorOption) *Janitor {
+	j := &Janitor{
+		db:        s.db,
+		interval:  interval,
+		batchSize: defaultJanitorBatchSize,
+		lockKey:   janitorLockKey,
+		done:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(j)
+	}
+	if j.interval <= 0 {
+		j.interval = defaultJanitorInterval
+	}
+
+	go j.loop(ctx)
+	return j
+}
+
+// Done returns a channel that is closed once the janitor has stopped
+func (j *Janitor) Done() <-chan struct{} {
+	return j.done
+}
+
+// Stats returns the cumulative counters since the janitor started
+func (j *Janitor) Stats() JanitorStats {
+	return JanitorStats{
+		Runs:    j.runs.Load(),
+		Skipped: j.skipped.Load(),
+		Failed:  j.failed.Load(),
+		Deleted: j.deleted.Load(),
+	}
+}
+
+func (j *Janitor) loop(ctx context.Context) {
+	defer close(j.done)
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		run := j.RunOnce(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		j.observe(run)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (j *Janitor) observe(run JanitorRun) {
+	j.runs.Add(1)
+	j.deleted.Add(run.Deleted)
+	if run.Skipped {
+		j.skipped.Add(1)
+	}
+	if run.Err != nil {
+		j.failed.Add(1)
+	}
+	if j.metrics != nil {
+		j.metrics.ObserveJanitorRun(run)
+	}
+}
+
+// RunOnce performs a single cleanup pass, deleting expired sessions in batches until none are left
+func (j *Janitor) RunOnce(ctx context.Context) (run JanitorRun) {
+	start := time.Now()
+	defer func() {
+		run.Duration = time.Since(start)
+	}()
+
+	if ctx.Err() != nil {
+		return run
+	}
+
+	// Advisory locks are held per connection, so pin one for the whole pass
+	conn, err := j.db.Connx(ctx)
+	if err != nil {
+		run.Err = errors.ErrDatabase("Failed to acquire connection").WithCause(err)
+		return run
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.GetContext(ctx, &locked, `SELECT pg_try_advisory_lock($1)`, j.lockKey); err != nil {
+		run.Err = errors.ErrDatabase("Failed to acquire janitor lock").WithCause(err)
+		return run
+	}
+	if !locked {
+		run.Skipped = true
+		return run
+	}
+	defer func() {
+		// Use a fresh context, ctx may already be cancelled and the lock must be released
+		unlockCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		var unlocked bool
+		err := conn.GetContext(unlockCtx, &unlocked, `SELECT pg_advisory_unlock($1)`, j.lockKey)
+		if err == nil && unlocked {
+			return
+		}
+		if err != nil && run.Err == nil {
+			run.Err = errors.ErrDatabase("Failed to release janitor lock").WithCause(err)
+		}
+		// The lock belongs to the connection, back in the pool it would make every replica skip every pass
+		conn.Raw(func(any) error { return driver.ErrBadConn })
+	}()
+
+	query := `DELETE FROM sessions WHERE id IN (
+		SELECT id FROM sessions WHERE expires_at < NOW() LIMIT $1 FOR UPDATE SKIP LOCKED
+	)`
+	for ctx.Err() == nil {
+		result, err := conn.ExecContext(ctx, query, j.batchSize)
+		if err != nil {
+			if ctx.Err() == nil {
+				run.Err = errors.ErrDatabase("Failed to delete expired sessions").WithCause(err)
+			}
+			return run
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			run.Err = errors.ErrDatabase("Failed to get rows affected").WithCause(err)
+			return run
+		}
+		run.Batches++
+		run.Deleted += rowsAffected
+		if rowsAffected < int64(j.batchSize) {
+			return run
+		}
+	}
+
+	return run
+}
@@ -0,0 +1,204 @@
+package luciastore
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// janitorDB is a database/sql driver answering the janitor's statements from memory
+type janitorDB struct {
+	mu sync.Mutex
+	// expired is the number of expired sessions left to delete
+	expired int
+	// heldElsewhere makes pg_try_advisory_lock fail as if another replica held the lock
+	heldElsewhere bool
+	locked        bool
+	deleteErr     error
+	unlockErr     error
+	deletes       int
+	closedConns   int
+}
+
+func newJanitorDB(expired int) (*janitorDB, *sqlx.DB) {
+	db := &janitorDB{expired: expired}
+	return db, sqlx.NewDb(sql.OpenDB(db), "postgres")
+}
+
+func (db *janitorDB) Connect(ctx context.Context) (driver.Conn, error) {
+	return &janitorConn{db: db}, nil
+}
+
+func (db *janitorDB) Driver() driver.Driver {
+	return nil
+}
+
+type janitorConn struct {
+	db *janitorDB
+}
+
+func (c *janitorConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("janitorDB: prepared statements are not supported")
+}
+
+func (c *janitorConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("janitorDB: transactions are not supported")
+}
+
+func (c *janitorConn) Close() error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.closedConns++
+	return nil
+}
+
+func (c *janitorConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	db := c.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if !strings.HasPrefix(query, "DELETE FROM sessions") {
+		return nil, errors.New("janitorDB: unexpected statement " + query)
+	}
+	if !db.locked {
+		return nil, errors.New("janitorDB: delete without the advisory lock")
+	}
+	if db.deleteErr != nil {
+		return nil, db.deleteErr
+	}
+	db.deletes++
+	n := min(db.expired, int(args[0].Value.(int64)))
+	db.expired -= n
+	return driver.RowsAffected(n), nil
+}
+
+func (c *janitorConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	db := c.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	switch {
+	case strings.Contains(query, "pg_try_advisory_lock"):
+		db.locked = !db.heldElsewhere
+		return &boolRows{value: db.locked}, nil
+	case strings.Contains(query, "pg_advisory_unlock"):
+		if db.unlockErr != nil {
+			return nil, db.unlockErr
+		}
+		unlocked := db.locked
+		db.locked = false
+		return &boolRows{value: unlocked}, nil
+	}
+	return nil, errors.New("janitorDB: unexpected query " + query)
+}
+
+// boolRows is a single row with a single boolean column
+type boolRows struct {
+	value bool
+	read  bool
+}
+
+func (r *boolRows) Columns() []string { return []string{"result"} }
+
+func (r *boolRows) Close() error { return nil }
+
+func (r *boolRows) Next(dest []driver.Value) error {
+	if r.read {
+		return io.EOF
+	}
+	r.read = true
+	dest[0] = r.value
+	return nil
+}
+
+func TestJanitorRunOnce(t *testing.T) {
+	tests := []struct {
+		name          string
+		expired       int
+		heldElsewhere bool
+		deleteErr     error
+		wantDeleted   int64
+		wantBatches   int
+		wantSkipped   bool
+		wantErr       bool
+	}{
+		{name: "nothing to delete", expired: 0, wantBatches: 1},
+		{name: "single batch", expired: 7, wantDeleted: 7, wantBatches: 1},
+		{name: "several batches", expired: 25, wantDeleted: 25, wantBatches: 3},
+		{name: "full last batch", expired: 20, wantDeleted: 20, wantBatches: 3},
+		{name: "locked by another replica", expired: 25, heldElsewhere: true, wantSkipped: true},
+		{name: "delete fails", expired: 25, deleteErr: errors.New("connection reset"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, sqlDB := newJanitorDB(tt.expired)
+			defer sqlDB.Close()
+			db.heldElsewhere = tt.heldElsewhere
+			db.deleteErr = tt.deleteErr
+			j := &Janitor{db: sqlDB, batchSize: 10, lockKey: janitorLockKey}
+
+			run := j.RunOnce(context.Background())
+			if (run.Err != nil) != tt.wantErr {
+				t.Fatalf("RunOnce() error = %v, wantErr %v", run.Err, tt.wantErr)
+			}
+			if run.Deleted != tt.wantDeleted || run.Batches != tt.wantBatches || run.Skipped != tt.wantSkipped {
+				t.Errorf("RunOnce() = %d deleted in %d batches, skipped %v, want %d in %d, skipped %v",
+					run.Deleted, run.Batches, run.Skipped, tt.wantDeleted, tt.wantBatches, tt.wantSkipped)
+			}
+			if db.locked {
+				t.Error("the advisory lock was not released")
+			}
+			if db.closedConns != 0 {
+				t.Errorf("%d connections closed, want the connection back in the pool", db.closedConns)
+			}
+		})
+	}
+}
+
+func TestJanitorDiscardsConnectionWhenUnlockFails(t *testing.T) {
+	db, sqlDB := newJanitorDB(5)
+	defer sqlDB.Close()
+	db.unlockErr = errors.New("connection reset")
+	j := &Janitor{db: sqlDB, batchSize: 10, lockKey: janitorLockKey}
+
+	run := j.RunOnce(context.Background())
+	if run.Err == nil || run.Deleted != 5 {
+		t.Errorf("RunOnce() = %d deleted, error %v, want 5 deleted and the unlock error", run.Deleted, run.Err)
+	}
+	if db.closedConns != 1 {
+		t.Errorf("%d connections closed, want the connection still holding the lock discarded", db.closedConns)
+	}
+}
+
+func TestStartJanitor(t *testing.T) {
+	_, sqlDB := newJanitorDB(15)
+	defer sqlDB.Close()
+	store := newPostgresStore[string](sqlDB)
+
+	runs := make(chan JanitorRun, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	j := store.StartJanitor(ctx, time.Hour, WithJanitorBatchSize(10), WithJanitorMetrics(JanitorMetricsFunc(func(run JanitorRun) {
+		runs <- run
+	})))
+
+	select {
+	case run := <-runs:
+		if run.Deleted != 15 || run.Batches != 2 {
+			t.Errorf("first pass = %d deleted in %d batches, want 15 in 2", run.Deleted, run.Batches)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the janitor did not run")
+	}
+	cancel()
+	<-j.Done()
+
+	if stats := j.Stats(); stats.Runs != 1 || stats.Deleted != 15 || stats.Failed != 0 || stats.Skipped != 0 {
+		t.Errorf("Stats() = %+v, want one run deleting 15", stats)
+	}
+}
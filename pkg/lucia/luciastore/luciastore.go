@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/Abraxas-365/toolkit/pkg/errors"
@@ -13,46 +14,70 @@ import (
 	_ "github.com/lib/pq"
 )
 
-type PostgresStore struct {
-	db *sqlx.DB
+// PostgresStore is a SessionStore backed by Postgres, user IDs are persisted in their canonical string form
+type PostgresStore[ID lucia.UserID] struct {
+	db    *sqlx.DB
+	codec lucia.IDCodec[ID]
+}
+
+func newPostgresStore[ID lucia.UserID](db *sqlx.DB) *PostgresStore[ID] {
+	return &PostgresStore[ID]{db: db, codec: lucia.NewIDCodec[ID]()}
+}
+
+// SetIDCodec replaces the codec user IDs are stored with, it must be the codec set on the AuthService (see
+// AuthService.SetIDCodec)
+func (s *PostgresStore[ID]) SetIDCodec(codec lucia.IDCodec[ID]) {
+	s.codec = codec
 }
 
 // NewStoreFromConnection creates a new PostgresStore from an existing sqlx.DB connection
-func NewStoreFromConnection(db *sqlx.DB) *PostgresStore {
-	return &PostgresStore{db: db}
+func NewStoreFromConnection[ID lucia.UserID](db *sqlx.DB) *PostgresStore[ID] {
+	return newPostgresStore[ID](db)
 }
 
 // NewStoreFromConnectionString creates a new PostgresStore from a connection string
-func NewStoreFromConnectionString(connectionString string) (*PostgresStore, error) {
+func NewStoreFromConnectionString[ID lucia.UserID](connectionString string) (*PostgresStore[ID], error) {
 	db, err := sqlx.Connect("postgres", connectionString)
 	if err != nil {
-		return nil, errors.ErrDatabase(fmt.Sprintf("failed to connect to database: %v", err))
+		return nil, errors.ErrDatabase("failed to connect to database").WithCause(err)
 	}
-	return &PostgresStore{db: db}, nil
+	return newPostgresStore[ID](db), nil
 }
 
 // NewStoreFromConnectionStringAndDB creates a new PostgresStore from a connection string and database name
-func NewStoreFromConnectionStringAndDB(connectionString, dbName string) (*PostgresStore, error) {
+func NewStoreFromConnectionStringAndDB[ID lucia.UserID](connectionString, dbName string) (*PostgresStore[ID], error) {
 	db, err := sqlx.Connect("postgres", connectionString)
 	if err != nil {
-		return nil, errors.ErrDatabase(fmt.Sprintf("failed to connect to database: %v", err))
+		return nil, errors.ErrDatabase("failed to connect to database").WithCause(err)
 	}
 
 	// Switch to the specified database
 	_, err = db.Exec(fmt.Sprintf("USE %s", dbName))
 	if err != nil {
 		db.Close()
-		return nil, errors.ErrDatabase(fmt.Sprintf("failed to switch to database %s: %v", dbName, err))
+		return nil, errors.ErrDatabase(fmt.Sprintf("failed to switch to database %s", dbName)).WithCause(err)
 	}
 
-	return &PostgresStore{db: db}, nil
+	return newPostgresStore[ID](db), nil
 }
 
 // SessionStore implementation
 
-func (s *PostgresStore) CreateSession(ctx context.Context, session *lucia.Session) error {
-	query := `INSERT INTO sessions (id, user_id, expires_at) VALUES ($1, $2, $3)`
-	_, err := s.db.ExecContext(ctx, query, session.ID, session.UserID, time.Unix(session.ExpiresAt, 0))
+func (s *PostgresStore[ID]) CreateSession(ctx context.Context, session *lucia.Session[ID]) error {
+	var impersonatorID sql.NullString
+	if session.ImpersonatorID != nil {
+		impersonatorID = sql.NullString{String: s.codec.Encode(*session.ImpersonatorID), Valid: true}
+	}
+	var authTime sql.NullTime
+	if session.AuthTime > 0 {
+		authTime = sql.NullTime{Time: time.Unix(session.AuthTime, 0), Valid: true}
+	}
+	query := `INSERT INTO sessions (id, user_id, expires_at, impersonator_id, parent_session_id, scopes, active_org_id,
+		auth_time, auth_provider, mfa)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+	_, err := s.db.ExecContext(ctx, query, session.ID, s.codec.Encode(session.UserID), time.Unix(session.ExpiresAt, 0),
+		impersonatorID, nullString(session.ParentSessionID), strings.Join(session.Scopes, " "), nullString(session.ActiveOrgID),
+		authTime, session.AuthProvider, session.MFA)
 	if err != nil {
 		pqErr, ok := err.(*pq.Error)
 		if ok {
@@ -63,20 +88,31 @@ func (s *PostgresStore) CreateSession(ctx context.Context, session *lucia.Sessio
 				return errors.ErrBadRequest("Invalid user ID")
 			}
 		}
-		return errors.ErrDatabase(fmt.Sprintf("Failed to create session: %v", err))
+		return errors.ErrDatabase("Failed to create session").WithCause(err)
 	}
 	return nil
 }
 
-func (s *PostgresStore) GetSession(ctx context.Context, sessionID string) (*lucia.Session, error) {
+func (s *PostgresStore[ID]) GetSession(ctx context.Context, sessionID string) (*lucia.Session[ID], error) {
 	// Create a temporary struct to handle the scanning
 	type dbSession struct {
 		ID        string  `db:"id"`
-		UserID    string  `db:"user_id"`    // Specify the exact type you're using in your database
+		UserID    string  `db:"user_id"`    // Scanned as text whatever the column type, the codec decodes it
 		ExpiresAt float64 `db:"expires_at"` // EXTRACT(EPOCH FROM ...) returns a float
+
+		ImpersonatorID  sql.NullString `db:"impersonator_id"`
+		ParentSessionID sql.NullString `db:"parent_session_id"`
+		Scopes          string         `db:"scopes"`
+		ActiveOrgID     sql.NullString `db:"active_org_id"`
+
+		AuthTime     sql.NullFloat64 `db:"auth_time"`
+		AuthProvider string          `db:"auth_provider"`
+		MFA          bool            `db:"mfa"`
 	}
 
-	query := `SELECT id, user_id, EXTRACT(EPOCH FROM expires_at) as expires_at FROM sessions WHERE id = $1`
+	query := `SELECT id, user_id::text AS user_id, EXTRACT(EPOCH FROM expires_at) as expires_at,
+		impersonator_id, parent_session_id, scopes, active_org_id,
+		EXTRACT(EPOCH FROM auth_time) AS auth_time, auth_provider, mfa FROM sessions WHERE id = $1`
 	var dbSess dbSession
 
 	err := s.db.GetContext(ctx, &dbSess, query, sessionID)
@@ -84,14 +120,32 @@ func (s *PostgresStore) GetSession(ctx context.Context, sessionID string) (*luci
 		if err == sql.ErrNoRows {
 			return nil, errors.ErrNotFound("Session not found")
 		}
-		return nil, errors.ErrDatabase(fmt.Sprintf("Failed to get session: %v", err))
+		return nil, errors.ErrDatabase("Failed to get session").WithCause(err)
+	}
+
+	userID, err := s.codec.Decode(dbSess.UserID)
+	if err != nil {
+		return nil, err
 	}
 
 	// Convert to lucia.Session
-	session := &lucia.Session{
-		ID:        dbSess.ID,
-		UserID:    dbSess.UserID, // This will be stored as interface{}
-		ExpiresAt: int64(dbSess.ExpiresAt),
+	session := &lucia.Session[ID]{
+		ID:              dbSess.ID,
+		UserID:          userID,
+		ExpiresAt:       int64(dbSess.ExpiresAt),
+		ParentSessionID: dbSess.ParentSessionID.String,
+		Scopes:          strings.Fields(dbSess.Scopes),
+		ActiveOrgID:     dbSess.ActiveOrgID.String,
+		AuthTime:        int64(dbSess.AuthTime.Float64),
+		AuthProvider:    dbSess.AuthProvider,
+		MFA:             dbSess.MFA,
+	}
+	if dbSess.ImpersonatorID.Valid {
+		impersonatorID, err := s.codec.Decode(dbSess.ImpersonatorID.String)
+		if err != nil {
+			return nil, err
+		}
+		session.ImpersonatorID = &impersonatorID
 	}
 
 	if time.Unix(session.ExpiresAt, 0).Before(time.Now()) {
@@ -101,15 +155,32 @@ func (s *PostgresStore) GetSession(ctx context.Context, sessionID string) (*luci
 	return session, nil
 }
 
-func (s *PostgresStore) DeleteSession(ctx context.Context, sessionID string) error {
+// UpdateSession saves the expiry, scopes and active organization of a session
+func (s *PostgresStore[ID]) UpdateSession(ctx context.Context, session *lucia.Session[ID]) error {
+	query := `UPDATE sessions SET expires_at = $2, scopes = $3, active_org_id = $4 WHERE id = $1`
+	result, err := s.db.ExecContext(ctx, query, session.ID, time.Unix(session.ExpiresAt, 0), strings.Join(session.Scopes, " "), nullString(session.ActiveOrgID))
+	if err != nil {
+		return errors.ErrDatabase("Failed to update session").WithCause(err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return errors.ErrDatabase("Failed to get rows affected").WithCause(err)
+	}
+	if rowsAffected == 0 {
+		return errors.ErrNotFound("Session not found")
+	}
+	return nil
+}
+
+func (s *PostgresStore[ID]) DeleteSession(ctx context.Context, sessionID string) error {
 	query := `DELETE FROM sessions WHERE id = $1`
 	result, err := s.db.ExecContext(ctx, query, sessionID)
 	if err != nil {
-		return errors.ErrDatabase(fmt.Sprintf("Failed to delete session: %v", err))
+		return errors.ErrDatabase("Failed to delete session").WithCause(err)
 	}
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return errors.ErrDatabase(fmt.Sprintf("Failed to get rows affected: %v", err))
+		return errors.ErrDatabase("Failed to get rows affected").WithCause(err)
 	}
 	if rowsAffected == 0 {
 		return errors.ErrNotFound("Session not found")
@@ -118,6 +189,6 @@ func (s *PostgresStore) DeleteSession(ctx context.Context, sessionID string) err
 }
 
 // Close closes the database connection
-func (s *PostgresStore) Close() error {
+func (s *PostgresStore[ID]) Close() error {
 	return s.db.Close()
 }
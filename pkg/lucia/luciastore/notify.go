@@ -0,0 +1,101 @@
+package luciastore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// DefaultNotifyChannel is the Postgres channel session invalidations are sent on
+const DefaultNotifyChannel = "lucia_session_invalidated"
+
+// NotifyBus is a lucia.InvalidationBus over Postgres LISTEN/NOTIFY, so that every replica sharing the database
+// drops the sessions revoked on any of them. Notifications carry hashed session keys, never session IDs,
+// since any role allowed to LISTEN and the server logs can read them.
+type NotifyBus struct {
+	db       *sqlx.DB
+	listener *pq.Listener
+	channel  string
+
+	mu          sync.RWMutex
+	subscribers []func(sessionKey string)
+	done        chan struct{}
+	closeOnce   sync.Once
+	closeErr    error
+}
+
+// NewNotifyBus starts listening on channel, DefaultNotifyChannel if empty. LISTEN needs a dedicated connection,
+// dsn is the connection string used to open it.
+func NewNotifyBus(db *sqlx.DB, dsn, channel string) (*NotifyBus, error) {
+	if channel == "" {
+		channel = DefaultNotifyChannel
+	}
+	bus := &NotifyBus{
+		db:      db,
+		channel: channel,
+		done:    make(chan struct{}),
+	}
+	bus.listener = pq.NewListener(dsn, time.Second, time.Minute, nil)
+	if err := bus.listener.Listen(channel); err != nil {
+		bus.listener.Close()
+		return nil, errors.ErrDatabase("Failed to listen for session invalidations").WithCause(err)
+	}
+	go bus.run()
+	return bus, nil
+}
+
+// Publish notifies every replica, sessionKey is the lucia.AuditSessionID of the session
+func (b *NotifyBus) Publish(ctx context.Context, sessionKey string) error {
+	if _, err := b.db.ExecContext(ctx, `SELECT pg_notify($1, $2)`, b.channel, sessionKey); err != nil {
+		return errors.ErrDatabase("Failed to publish session invalidation").WithCause(err)
+	}
+	return nil
+}
+
+func (b *NotifyBus) Subscribe(fn func(sessionKey string)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers = append(b.subscribers, fn)
+}
+
+func (b *NotifyBus) run() {
+	ping := time.NewTicker(90 * time.Second)
+	defer ping.Stop()
+	for {
+		select {
+		case notification := <-b.listener.Notify:
+			// pq sends a nil notification after reconnecting, anything sent meanwhile was lost
+			sessionKey := ""
+			if notification != nil {
+				sessionKey = notification.Extra
+			}
+			b.deliver(sessionKey)
+		case <-ping.C:
+			// Detects dead connections, the listener reconnects on its own
+			go b.listener.Ping()
+		case <-b.done:
+			return
+		}
+	}
+}
+
+func (b *NotifyBus) deliver(sessionKey string) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, fn := range b.subscribers {
+		fn(sessionKey)
+	}
+}
+
+// Close stops listening, calling it again returns the result of the first call
+func (b *NotifyBus) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.done)
+		b.closeErr = b.listener.Close()
+	})
+	return b.closeErr
+}
@@ -0,0 +1,30 @@
+package luciastore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestNotifyBusClose(t *testing.T) {
+	// Listen blocks until connected, the bus is assembled around a listener that never connects
+	bus := &NotifyBus{
+		listener: pq.NewListener("postgres://lucia@127.0.0.1:1/lucia?sslmode=disable", time.Second, time.Minute, nil),
+		channel:  DefaultNotifyChannel,
+		done:     make(chan struct{}),
+	}
+	go bus.run()
+
+	var keys []string
+	bus.Subscribe(func(sessionKey string) { keys = append(keys, sessionKey) })
+	bus.deliver("key")
+	if len(keys) != 1 || keys[0] != "key" {
+		t.Errorf("subscriber received %v, want [key]", keys)
+	}
+
+	first := bus.Close()
+	if second := bus.Close(); second != first {
+		t.Errorf("second Close() = %v, want the result of the first %v", second, first)
+	}
+}
@@ -0,0 +1,310 @@
+package luciastore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+	"github.com/Abraxas-365/toolkit/pkg/lucia"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// OrganizationStore is a lucia.OrganizationStore backed by Postgres
+type OrganizationStore struct {
+	db *sqlx.DB
+}
+
+// NewOrganizationStore creates a new OrganizationStore from an existing sqlx.DB connection
+func NewOrganizationStore(db *sqlx.DB) *OrganizationStore {
+	return &OrganizationStore{db: db}
+}
+
+type dbOrganization struct {
+	ID        string         `db:"id"`
+	Name      string         `db:"name"`
+	Slug      string         `db:"slug"`
+	Domains   pq.StringArray `db:"domains"`
+	Verified  pq.StringArray `db:"verified_domains"`
+	CreatedAt time.Time      `db:"created_at"`
+}
+
+const organizationQuery = `SELECT o.id, o.name, o.slug, o.created_at,
+	COALESCE(ARRAY(SELECT d.domain FROM auth_organization_domains d WHERE d.org_id = o.id ORDER BY d.domain), '{}') AS domains,
+	COALESCE(ARRAY(SELECT d.domain FROM auth_organization_domains d
+		WHERE d.org_id = o.id AND d.verified_at IS NOT NULL ORDER BY d.domain), '{}') AS verified_domains
+	FROM auth_organizations o`
+
+// CreateOrganization stores the organization, its claimed domains and the owner membership in one transaction
+func (s *OrganizationStore) CreateOrganization(ctx context.Context, org *lucia.Organization, owner *lucia.Membership) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.ErrDatabase("Failed to begin transaction").WithCause(err)
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO auth_organizations (id, name, slug, created_at) VALUES ($1, $2, $3, $4)`
+	if _, err := tx.ExecContext(ctx, query, org.ID, org.Name, org.Slug, org.CreatedAt); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "unique_violation" {
+			return errors.ErrConflict("Organization slug already taken")
+		}
+		return errors.ErrDatabase("Failed to create organization").WithCause(err)
+	}
+	for _, domain := range org.Domains {
+		query := `INSERT INTO auth_organization_domains (domain, org_id) VALUES ($1, $2)`
+		if _, err := tx.ExecContext(ctx, query, domain, org.ID); err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "unique_violation" {
+				return errors.ErrConflict("Domain already claimed by another organization")
+			}
+			return errors.ErrDatabase("Failed to add organization domain").WithCause(err)
+		}
+	}
+	query = `INSERT INTO auth_memberships (org_id, user_id, role, created_at) VALUES ($1, $2, $3, $4)`
+	if _, err := tx.ExecContext(ctx, query, owner.OrgID, owner.UserID, owner.Role, owner.CreatedAt); err != nil {
+		return errors.ErrDatabase("Failed to add owner membership").WithCause(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.ErrDatabase("Failed to commit organization").WithCause(err)
+	}
+	return nil
+}
+
+func (s *OrganizationStore) getOrganization(ctx context.Context, where string, arg interface{}) (*lucia.Organization, error) {
+	var row dbOrganization
+	if err := s.db.GetContext(ctx, &row, organizationQuery+" WHERE "+where, arg); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.ErrNotFound("Organization not found")
+		}
+		return nil, errors.ErrDatabase("Failed to get organization").WithCause(err)
+	}
+	return &lucia.Organization{
+		ID:              row.ID,
+		Name:            row.Name,
+		Slug:            row.Slug,
+		Domains:         []string(row.Domains),
+		VerifiedDomains: []string(row.Verified),
+		CreatedAt:       row.CreatedAt,
+	}, nil
+}
+
+func (s *OrganizationStore) GetOrganization(ctx context.Context, orgID string) (*lucia.Organization, error) {
+	return s.getOrganization(ctx, "o.id = $1", orgID)
+}
+
+func (s *OrganizationStore) GetOrganizationBySlug(ctx context.Context, slug string) (*lucia.Organization, error) {
+	return s.getOrganization(ctx, "o.slug = $1", slug)
+}
+
+func (s *OrganizationStore) GetOrganizationByDomain(ctx context.Context, domain string) (*lucia.Organization, error) {
+	return s.getOrganization(ctx,
+		"o.id = (SELECT org_id FROM auth_organization_domains WHERE domain = $1 AND verified_at IS NOT NULL)", domain)
+}
+
+func (s *OrganizationStore) VerifyDomain(ctx context.Context, orgID, domain string) error {
+	query := `UPDATE auth_organization_domains SET verified_at = COALESCE(verified_at, NOW()) WHERE domain = $1 AND org_id = $2`
+	result, err := s.db.ExecContext(ctx, query, domain, orgID)
+	if err != nil {
+		return errors.ErrDatabase("Failed to verify domain").WithCause(err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return errors.ErrDatabase("Failed to get rows affected").WithCause(err)
+	}
+	if rowsAffected == 0 {
+		return errors.ErrNotFound("Domain not claimed by the organization")
+	}
+	return nil
+}
+
+// DeleteOrganization deletes the organization, its domains, memberships and invitations are removed by cascade
+func (s *OrganizationStore) DeleteOrganization(ctx context.Context, orgID string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM auth_organizations WHERE id = $1`, orgID); err != nil {
+		return errors.ErrDatabase("Failed to delete organization").WithCause(err)
+	}
+	return nil
+}
+
+type dbMembership struct {
+	OrgID     string    `db:"org_id"`
+	UserID    string    `db:"user_id"`
+	Role      string    `db:"role"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+func (m dbMembership) toMembership() lucia.Membership {
+	return lucia.Membership{OrgID: m.OrgID, UserID: m.UserID, Role: m.Role, CreatedAt: m.CreatedAt}
+}
+
+func (s *OrganizationStore) AddMembership(ctx context.Context, membership *lucia.Membership) error {
+	query := `INSERT INTO auth_memberships (org_id, user_id, role, created_at) VALUES ($1, $2, $3, $4)`
+	_, err := s.db.ExecContext(ctx, query, membership.OrgID, membership.UserID, membership.Role, membership.CreatedAt)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			switch pqErr.Code.Name() {
+			case "unique_violation":
+				return errors.ErrConflict("User is already a member")
+			case "foreign_key_violation":
+				return errors.ErrNotFound("Organization not found")
+			}
+		}
+		return errors.ErrDatabase("Failed to add membership").WithCause(err)
+	}
+	return nil
+}
+
+func (s *OrganizationStore) GetMembership(ctx context.Context, orgID, userID string) (*lucia.Membership, error) {
+	var row dbMembership
+	query := `SELECT org_id, user_id, role, created_at FROM auth_memberships WHERE org_id = $1 AND user_id = $2`
+	if err := s.db.GetContext(ctx, &row, query, orgID, userID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.ErrNotFound("Membership not found")
+		}
+		return nil, errors.ErrDatabase("Failed to get membership").WithCause(err)
+	}
+	membership := row.toMembership()
+	return &membership, nil
+}
+
+// UpdateMembershipRole locks the owner rows of the organization so that concurrent demotions cannot remove
+// the last owner
+func (s *OrganizationStore) UpdateMembershipRole(ctx context.Context, orgID, userID, role string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.ErrDatabase("Failed to begin transaction").WithCause(err)
+	}
+	defer tx.Rollback()
+
+	if role != lucia.RoleOwner {
+		if err := checkNotLastOwner(ctx, tx, orgID, userID); err != nil {
+			return err
+		}
+	}
+	query := `UPDATE auth_memberships SET role = $3 WHERE org_id = $1 AND user_id = $2`
+	result, err := tx.ExecContext(ctx, query, orgID, userID, role)
+	if err != nil {
+		return errors.ErrDatabase("Failed to update membership").WithCause(err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return errors.ErrDatabase("Failed to get rows affected").WithCause(err)
+	}
+	if rowsAffected == 0 {
+		return errors.ErrNotFound("Membership not found")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.ErrDatabase("Failed to commit membership").WithCause(err)
+	}
+	return nil
+}
+
+// RemoveMembership locks the owner rows of the organization like UpdateMembershipRole
+func (s *OrganizationStore) RemoveMembership(ctx context.Context, orgID, userID string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.ErrDatabase("Failed to begin transaction").WithCause(err)
+	}
+	defer tx.Rollback()
+
+	if err := checkNotLastOwner(ctx, tx, orgID, userID); err != nil {
+		return err
+	}
+	query := `DELETE FROM auth_memberships WHERE org_id = $1 AND user_id = $2`
+	if _, err := tx.ExecContext(ctx, query, orgID, userID); err != nil {
+		return errors.ErrDatabase("Failed to remove membership").WithCause(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.ErrDatabase("Failed to commit membership").WithCause(err)
+	}
+	return nil
+}
+
+// checkNotLastOwner returns a Conflict error if userID is the only owner of the organization,
+// the owner rows stay locked until tx ends
+func checkNotLastOwner(ctx context.Context, tx *sqlx.Tx, orgID, userID string) error {
+	var owners []string
+	query := `SELECT user_id FROM auth_memberships WHERE org_id = $1 AND role = $2 FOR UPDATE`
+	if err := tx.SelectContext(ctx, &owners, query, orgID, lucia.RoleOwner); err != nil {
+		return errors.ErrDatabase("Failed to list owners").WithCause(err)
+	}
+	if len(owners) == 1 && owners[0] == userID {
+		return errors.ErrConflict("An organization needs at least one owner")
+	}
+	return nil
+}
+
+func (s *OrganizationStore) listMemberships(ctx context.Context, column, value string) ([]lucia.Membership, error) {
+	var rows []dbMembership
+	query := `SELECT org_id, user_id, role, created_at FROM auth_memberships WHERE ` + column + ` = $1 ORDER BY created_at`
+	if err := s.db.SelectContext(ctx, &rows, query, value); err != nil {
+		return nil, errors.ErrDatabase("Failed to list memberships").WithCause(err)
+	}
+	memberships := make([]lucia.Membership, len(rows))
+	for i, row := range rows {
+		memberships[i] = row.toMembership()
+	}
+	return memberships, nil
+}
+
+func (s *OrganizationStore) ListMemberships(ctx context.Context, userID string) ([]lucia.Membership, error) {
+	return s.listMemberships(ctx, "user_id", userID)
+}
+
+func (s *OrganizationStore) ListMembers(ctx context.Context, orgID string) ([]lucia.Membership, error) {
+	return s.listMemberships(ctx, "org_id", orgID)
+}
+
+func (s *OrganizationStore) CreateInvitation(ctx context.Context, invitation *lucia.Invitation) error {
+	query := `INSERT INTO auth_invitations (token_hash, org_id, email, role, invited_by, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err := s.db.ExecContext(ctx, query, invitation.TokenHash, invitation.OrgID, nullString(invitation.Email),
+		invitation.Role, invitation.InvitedBy, invitation.ExpiresAt, invitation.CreatedAt)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "foreign_key_violation" {
+			return errors.ErrNotFound("Organization not found")
+		}
+		return errors.ErrDatabase("Failed to create invitation").WithCause(err)
+	}
+	return nil
+}
+
+func (s *OrganizationStore) ConsumeInvitation(ctx context.Context, tokenHash string) (*lucia.Invitation, error) {
+	var row struct {
+		TokenHash string         `db:"token_hash"`
+		OrgID     string         `db:"org_id"`
+		Email     sql.NullString `db:"email"`
+		Role      string         `db:"role"`
+		InvitedBy string         `db:"invited_by"`
+		ExpiresAt time.Time      `db:"expires_at"`
+		CreatedAt time.Time      `db:"created_at"`
+	}
+	query := `DELETE FROM auth_invitations WHERE token_hash = $1
+		RETURNING token_hash, org_id, email, role, invited_by, expires_at, created_at`
+	if err := s.db.GetContext(ctx, &row, query, tokenHash); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.ErrNotFound("Invitation not found")
+		}
+		return nil, errors.ErrDatabase("Failed to consume invitation").WithCause(err)
+	}
+	return &lucia.Invitation{
+		TokenHash: row.TokenHash,
+		OrgID:     row.OrgID,
+		Email:     row.Email.String,
+		Role:      row.Role,
+		InvitedBy: row.InvitedBy,
+		ExpiresAt: row.ExpiresAt,
+		CreatedAt: row.CreatedAt,
+	}, nil
+}
+
+// DeleteExpiredInvitations removes the invitations that can no longer be accepted
+func (s *OrganizationStore) DeleteExpiredInvitations(ctx context.Context) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM auth_invitations WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, errors.ErrDatabase("Failed to delete expired invitations").WithCause(err)
+	}
+	return result.RowsAffected()
+}
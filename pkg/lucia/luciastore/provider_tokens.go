@@ -0,0 +1,139 @@
+package luciastore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/crypto"
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+	"github.com/Abraxas-365/toolkit/pkg/lucia"
+	"github.com/jmoiron/sqlx"
+)
+
+// ProviderTokenStore is a lucia.ProviderTokenStore backed by Postgres. Tokens are encrypted with associated data
+// naming their column, user and provider, so a ciphertext copied to another row does not decrypt.
+type ProviderTokenStore struct {
+	db      *sqlx.DB
+	keyring *crypto.Keyring
+}
+
+// NewProviderTokenStore creates a new ProviderTokenStore from an existing sqlx.DB connection. A nil keyring uses
+// the default one, crypto.SetDefaultKeyring must then be called before using the store.
+func NewProviderTokenStore(db *sqlx.DB, keyring *crypto.Keyring) *ProviderTokenStore {
+	return &ProviderTokenStore{db: db, keyring: keyring}
+}
+
+type dbProviderToken struct {
+	UserID       string    `db:"user_id"`
+	Provider     string    `db:"provider"`
+	AccessToken  string    `db:"access_token"`
+	RefreshToken string    `db:"refresh_token"`
+	ExpiresAt    int64     `db:"expires_at"`
+	UpdatedAt    time.Time `db:"updated_at"`
+}
+
+func (s *ProviderTokenStore) getKeyring(errorType string) (*crypto.Keyring, error) {
+	if s.keyring != nil {
+		return s.keyring, nil
+	}
+	if k := crypto.DefaultKeyring(); k != nil {
+		return k, nil
+	}
+	return nil, errors.NewLuciaError(errorType, "No keyring configured for provider tokens")
+}
+
+// tokenAD binds a token to its column and row
+func tokenAD(column, userID, provider string) []byte {
+	return crypto.AssociatedData("auth_provider_tokens", column, userID, provider)
+}
+
+func (s *ProviderTokenStore) decrypt(row dbProviderToken) (*lucia.ProviderToken, error) {
+	k, err := s.getKeyring("DecryptionError")
+	if err != nil {
+		return nil, err
+	}
+	accessToken, err := k.DecryptString(row.AccessToken, tokenAD("access_token", row.UserID, row.Provider))
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := k.DecryptString(row.RefreshToken, tokenAD("refresh_token", row.UserID, row.Provider))
+	if err != nil {
+		return nil, err
+	}
+	return &lucia.ProviderToken{
+		UserID:       row.UserID,
+		Provider:     row.Provider,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresAt:    row.ExpiresAt,
+		UpdatedAt:    row.UpdatedAt,
+	}, nil
+}
+
+func (s *ProviderTokenStore) SaveProviderToken(ctx context.Context, token *lucia.ProviderToken) error {
+	k, err := s.getKeyring("EncryptionError")
+	if err != nil {
+		return err
+	}
+	accessToken, err := k.EncryptString(token.AccessToken, tokenAD("access_token", token.UserID, token.Provider))
+	if err != nil {
+		return err
+	}
+	refreshToken, err := k.EncryptString(token.RefreshToken, tokenAD("refresh_token", token.UserID, token.Provider))
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO auth_provider_tokens (user_id, provider, access_token, refresh_token, expires_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id, provider) DO UPDATE SET access_token = EXCLUDED.access_token,
+			refresh_token = EXCLUDED.refresh_token, expires_at = EXCLUDED.expires_at, updated_at = EXCLUDED.updated_at`
+	_, err = s.db.ExecContext(ctx, query, token.UserID, token.Provider, accessToken, refreshToken, token.ExpiresAt, token.UpdatedAt)
+	if err != nil {
+		return errors.ErrDatabase("Failed to save provider token").WithCause(err)
+	}
+	return nil
+}
+
+func (s *ProviderTokenStore) GetProviderToken(ctx context.Context, userID, provider string) (*lucia.ProviderToken, error) {
+	var row dbProviderToken
+	query := `SELECT user_id, provider, access_token, refresh_token, expires_at, updated_at
+		FROM auth_provider_tokens WHERE user_id = $1 AND provider = $2`
+	if err := s.db.GetContext(ctx, &row, query, userID, provider); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.ErrNotFound("Provider token not found")
+		}
+		return nil, errors.ErrDatabase("Failed to get provider token").WithCause(err)
+	}
+	return s.decrypt(row)
+}
+
+func (s *ProviderTokenStore) ListProviderTokens(ctx context.Context, userID string) ([]*lucia.ProviderToken, error) {
+	var rows []dbProviderToken
+	query := `SELECT user_id, provider, access_token, refresh_token, expires_at, updated_at
+		FROM auth_provider_tokens WHERE user_id = $1 ORDER BY provider`
+	if err := s.db.SelectContext(ctx, &rows, query, userID); err != nil {
+		return nil, errors.ErrDatabase("Failed to list provider tokens").WithCause(err)
+	}
+	tokens := make([]*lucia.ProviderToken, len(rows))
+	for i, row := range rows {
+		token, err := s.decrypt(row)
+		if err != nil {
+			return nil, err
+		}
+		tokens[i] = token
+	}
+	return tokens, nil
+}
+
+func (s *ProviderTokenStore) DeleteProviderToken(ctx context.Context, userID, provider string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM auth_provider_tokens WHERE user_id = $1 AND provider = $2`, userID, provider)
+	if err != nil {
+		return errors.ErrDatabase("Failed to delete provider token").WithCause(err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errors.ErrNotFound("Provider token not found")
+	}
+	return nil
+}
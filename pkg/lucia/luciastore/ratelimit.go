@@ -0,0 +1,97 @@
+package luciastore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+	"github.com/Abraxas-365/toolkit/pkg/lucia"
+	"github.com/jmoiron/sqlx"
+)
+
+// RateLimitStore is a lucia.RateLimitStore backed by Postgres so counters are shared across replicas
+type RateLimitStore struct {
+	db *sqlx.DB
+}
+
+// NewRateLimitStore creates a new RateLimitStore from an existing sqlx.DB connection
+func NewRateLimitStore(db *sqlx.DB) *RateLimitStore {
+	return &RateLimitStore{db: db}
+}
+
+func (s *RateLimitStore) Update(ctx context.Context, key string, fn func(state *lucia.RateLimitState) error) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.ErrDatabase("Failed to begin transaction").WithCause(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO auth_rate_limits (key) VALUES ($1) ON CONFLICT (key) DO NOTHING`, key)
+	if err != nil {
+		return errors.ErrDatabase("Failed to create rate limit").WithCause(err)
+	}
+
+	var row struct {
+		Tokens      float64      `db:"tokens"`
+		UpdatedAt   sql.NullTime `db:"updated_at"`
+		Failures    int          `db:"failures"`
+		LastFailure sql.NullTime `db:"last_failure"`
+		LockedUntil sql.NullTime `db:"locked_until"`
+	}
+	query := `SELECT tokens, updated_at, failures, last_failure, locked_until FROM auth_rate_limits WHERE key = $1 FOR UPDATE`
+	if err := tx.GetContext(ctx, &row, query, key); err != nil {
+		return errors.ErrDatabase("Failed to get rate limit").WithCause(err)
+	}
+
+	state := &lucia.RateLimitState{
+		Tokens:      row.Tokens,
+		UpdatedAt:   row.UpdatedAt.Time,
+		Failures:    row.Failures,
+		LastFailure: row.LastFailure.Time,
+		LockedUntil: row.LockedUntil.Time,
+	}
+	if err := fn(state); err != nil {
+		return err
+	}
+
+	query = `UPDATE auth_rate_limits SET tokens = $2, updated_at = $3, failures = $4, last_failure = $5, locked_until = $6 WHERE key = $1`
+	_, err = tx.ExecContext(ctx, query, key, state.Tokens, nullTime(state.UpdatedAt), state.Failures, nullTime(state.LastFailure), nullTime(state.LockedUntil))
+	if err != nil {
+		return errors.ErrDatabase("Failed to update rate limit").WithCause(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.ErrDatabase("Failed to commit rate limit").WithCause(err)
+	}
+	return nil
+}
+
+func (s *RateLimitStore) Delete(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM auth_rate_limits WHERE key = $1`, key)
+	if err != nil {
+		return errors.ErrDatabase("Failed to delete rate limit").WithCause(err)
+	}
+	return nil
+}
+
+// DeleteIdle removes counters that have not been touched for longer than idle and are not locked out.
+// Rows without any timestamp hold no state and are removed as well.
+func (s *RateLimitStore) DeleteIdle(ctx context.Context, idle time.Duration) (int64, error) {
+	query := `DELETE FROM auth_rate_limits
+		WHERE COALESCE(GREATEST(updated_at, last_failure), '-infinity') < $1 AND (locked_until IS NULL OR locked_until < NOW())`
+	result, err := s.db.ExecContext(ctx, query, time.Now().Add(-idle))
+	if err != nil {
+		return 0, errors.ErrDatabase("Failed to delete idle rate limits").WithCause(err)
+	}
+	return result.RowsAffected()
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// nullUnix stores a Unix time as a timestamp, zero is NULL
+func nullUnix(t int64) sql.NullTime {
+	return sql.NullTime{Time: time.Unix(t, 0), Valid: t > 0}
+}
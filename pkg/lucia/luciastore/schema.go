@@ -0,0 +1,155 @@
+package luciastore
+
+// SessionsSchema creates the table used by PostgresStore.
+// user_id may be changed to UUID or BIGINT to match the ID type of the store, it is always read back as text.
+const SessionsSchema = `
+CREATE TABLE IF NOT EXISTS sessions (
+	id         TEXT PRIMARY KEY,
+	user_id    TEXT NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS sessions_expires_at_idx ON sessions (expires_at);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS impersonator_id TEXT;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS parent_session_id TEXT;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS scopes TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS active_org_id TEXT;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS auth_time TIMESTAMPTZ;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS auth_provider TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS mfa BOOLEAN NOT NULL DEFAULT FALSE;
+`
+
+// RateLimitsSchema creates the table used by RateLimitStore
+const RateLimitsSchema = `
+CREATE TABLE IF NOT EXISTS auth_rate_limits (
+	key          TEXT PRIMARY KEY,
+	tokens       DOUBLE PRECISION NOT NULL DEFAULT 0,
+	updated_at   TIMESTAMPTZ,
+	failures     INTEGER NOT NULL DEFAULT 0,
+	last_failure TIMESTAMPTZ,
+	locked_until TIMESTAMPTZ
+);
+`
+
+// AuditLogSchema creates the table used by AuditStore.
+// The table is append-only, grant the application role INSERT and SELECT but not UPDATE or DELETE on it.
+const AuditLogSchema = `
+CREATE TABLE IF NOT EXISTS auth_audit_log (
+	id          TEXT PRIMARY KEY,
+	type        TEXT NOT NULL,
+	user_id     TEXT,
+	actor_id    TEXT,
+	session_id  TEXT,
+	provider    TEXT,
+	ip          TEXT,
+	user_agent  TEXT,
+	error_type  TEXT,
+	error       TEXT,
+	metadata    JSONB,
+	occurred_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS auth_audit_log_user_idx ON auth_audit_log (user_id, occurred_at);
+CREATE INDEX IF NOT EXISTS auth_audit_log_occurred_at_idx ON auth_audit_log (occurred_at);
+ALTER TABLE auth_audit_log ADD COLUMN IF NOT EXISTS actor_id TEXT;
+CREATE INDEX IF NOT EXISTS auth_audit_log_actor_idx ON auth_audit_log (actor_id, occurred_at) WHERE actor_id IS NOT NULL;
+`
+
+// DeviceAuthorizationsSchema creates the table used by DeviceStore
+const DeviceAuthorizationsSchema = `
+CREATE TABLE IF NOT EXISTS auth_device_authorizations (
+	device_code_hash TEXT PRIMARY KEY,
+	user_code        TEXT NOT NULL UNIQUE,
+	client_id        TEXT NOT NULL,
+	scopes           TEXT NOT NULL DEFAULT '',
+	status           TEXT NOT NULL,
+	user_id          TEXT,
+	interval_seconds INTEGER NOT NULL,
+	last_polled_at   TIMESTAMPTZ,
+	expires_at       TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS auth_device_authorizations_expires_at_idx ON auth_device_authorizations (expires_at);
+ALTER TABLE auth_device_authorizations ADD COLUMN IF NOT EXISTS auth_time TIMESTAMPTZ;
+ALTER TABLE auth_device_authorizations ADD COLUMN IF NOT EXISTS auth_provider TEXT NOT NULL DEFAULT '';
+ALTER TABLE auth_device_authorizations ADD COLUMN IF NOT EXISTS mfa BOOLEAN NOT NULL DEFAULT FALSE;
+`
+
+// IdentityProviderSchema creates the tables used by IdentityProviderStore
+const IdentityProviderSchema = `
+CREATE TABLE IF NOT EXISTS auth_oauth_clients (
+	id            TEXT PRIMARY KEY,
+	name          TEXT NOT NULL,
+	secret_hash   TEXT,
+	redirect_uris TEXT[] NOT NULL,
+	public        BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at    TIMESTAMPTZ NOT NULL
+);
+CREATE TABLE IF NOT EXISTS auth_oauth_codes (
+	code_hash      TEXT PRIMARY KEY,
+	client_id      TEXT NOT NULL REFERENCES auth_oauth_clients (id) ON DELETE CASCADE,
+	user_id        TEXT NOT NULL,
+	session_id     TEXT NOT NULL,
+	redirect_uri   TEXT NOT NULL,
+	scopes         TEXT NOT NULL DEFAULT '',
+	nonce          TEXT,
+	code_challenge TEXT NOT NULL,
+	expires_at     TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS auth_oauth_codes_expires_at_idx ON auth_oauth_codes (expires_at);
+`
+
+// OrganizationsSchema creates the tables used by OrganizationStore
+const OrganizationsSchema = `
+CREATE TABLE IF NOT EXISTS auth_organizations (
+	id         TEXT PRIMARY KEY,
+	name       TEXT NOT NULL,
+	slug       TEXT NOT NULL UNIQUE,
+	created_at TIMESTAMPTZ NOT NULL
+);
+CREATE TABLE IF NOT EXISTS auth_organization_domains (
+	domain TEXT PRIMARY KEY,
+	org_id TEXT NOT NULL REFERENCES auth_organizations (id) ON DELETE CASCADE
+);
+ALTER TABLE auth_organization_domains ADD COLUMN IF NOT EXISTS verified_at TIMESTAMPTZ;
+CREATE TABLE IF NOT EXISTS auth_memberships (
+	org_id     TEXT NOT NULL REFERENCES auth_organizations (id) ON DELETE CASCADE,
+	user_id    TEXT NOT NULL,
+	role       TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (org_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS auth_memberships_user_id_idx ON auth_memberships (user_id);
+CREATE TABLE IF NOT EXISTS auth_invitations (
+	token_hash TEXT PRIMARY KEY,
+	org_id     TEXT NOT NULL REFERENCES auth_organizations (id) ON DELETE CASCADE,
+	email      TEXT,
+	role       TEXT NOT NULL,
+	invited_by TEXT NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS auth_invitations_expires_at_idx ON auth_invitations (expires_at);
+`
+
+// EmailVerificationsSchema creates the table used by EmailVerificationStore
+const EmailVerificationsSchema = `
+CREATE TABLE IF NOT EXISTS auth_email_verifications (
+	token_hash TEXT PRIMARY KEY,
+	user_id    TEXT NOT NULL,
+	email      TEXT NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS auth_email_verifications_expires_at_idx ON auth_email_verifications (expires_at);
+`
+
+// ProviderTokensSchema creates the table used by ProviderTokenStore, the tokens are encrypted bound to their row
+const ProviderTokensSchema = `
+CREATE TABLE IF NOT EXISTS auth_provider_tokens (
+	user_id       TEXT NOT NULL,
+	provider      TEXT NOT NULL,
+	access_token  TEXT NOT NULL,
+	refresh_token TEXT NOT NULL,
+	expires_at    BIGINT NOT NULL,
+	updated_at    TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (user_id, provider)
+);
+`
@@ -0,0 +1,239 @@
+package luciatest
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+	"github.com/Abraxas-365/toolkit/pkg/lucia"
+)
+
+// Operations of FakeProvider that Fail can script
+const (
+	OpExchangeCode = "ExchangeCode"
+	OpGetUserInfo  = "GetUserInfo"
+	OpRefreshToken = "RefreshToken"
+	OpRevokeToken  = "RevokeToken"
+)
+
+// FakeProvider is an in-process OAuthProvider. Code scripts which user an authorization code logs in, Fail
+// scripts failures and ExpireTokens revokes the issued access tokens, as a provider ending a grant would.
+type FakeProvider struct {
+	name     string
+	tokenTTL time.Duration
+
+	mu            sync.Mutex
+	codes         map[string]lucia.UserInfo
+	accessTokens  map[string]fakeGrant
+	refreshTokens map[string]lucia.UserInfo
+	failures      map[string][]error
+	authURLs      []string
+	revoked       map[string]bool
+}
+
+type fakeGrant struct {
+	user      lucia.UserInfo
+	expiresAt time.Time
+}
+
+// NewFakeProvider creates a fake provider, name is the Provider of the users it returns and should be the
+// name it is registered under
+func NewFakeProvider(name string) *FakeProvider {
+	return &FakeProvider{
+		name:          name,
+		tokenTTL:      time.Hour,
+		codes:         make(map[string]lucia.UserInfo),
+		accessTokens:  make(map[string]fakeGrant),
+		refreshTokens: make(map[string]lucia.UserInfo),
+		failures:      make(map[string][]error),
+		revoked:       make(map[string]bool),
+	}
+}
+
+// SetTokenTTL sets how long issued access tokens are valid, an hour by default. Below five minutes
+// tokens need a refresh right away, see OAuthToken.NeedsRefresh.
+func (p *FakeProvider) SetTokenTTL(ttl time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tokenTTL = ttl
+}
+
+// Code returns a single use authorization code logging in user. An empty ID is generated and the Provider
+// is always the name of the fake.
+func (p *FakeProvider) Code(user lucia.UserInfo) string {
+	if user.ID == "" {
+		user.ID = lucia.GenerateID()
+	}
+	user.Provider = p.name
+	code := lucia.GenerateID()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.codes[code] = user
+	delete(p.revoked, user.ID)
+	return code
+}
+
+// Fail makes the next call of op, one of the Op constants, return err. Failures queue up per operation.
+func (p *FakeProvider) Fail(op string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failures[op] = append(p.failures[op], err)
+}
+
+// Revoked reports whether the grant of the user with the provider ID userID was revoked with RevokeToken
+// since their last authorization code was issued
+func (p *FakeProvider) Revoked(userID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.revoked[userID]
+}
+
+// ExpireTokens makes every issued access token invalid, refresh tokens keep working
+func (p *FakeProvider) ExpireTokens() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for token, grant := range p.accessTokens {
+		grant.expiresAt = time.Now()
+		p.accessTokens[token] = grant
+	}
+}
+
+// AuthURLs returns the authorization URLs built so far
+func (p *FakeProvider) AuthURLs() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.authURLs...)
+}
+
+// Authorizer returns the Authorizer logging in user, see Login
+func (p *FakeProvider) Authorizer(user lucia.UserInfo) Authorizer {
+	return func(authURL string) (url.Values, error) {
+		u, err := url.Parse(authURL)
+		if err != nil {
+			return nil, err
+		}
+		return url.Values{"code": {p.Code(user)}, "state": {u.Query().Get("state")}}, nil
+	}
+}
+
+// GetAuthURL returns a URL on the unresolvable host <name>.fake carrying the state, the options are ignored
+func (p *FakeProvider) GetAuthURL(state string, opts ...lucia.AuthURLOption) string {
+	authURL := "https://" + p.name + ".fake/authorize?" + url.Values{"state": {state}}.Encode()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.authURLs = append(p.authURLs, authURL)
+	return authURL
+}
+
+func (p *FakeProvider) ExchangeCode(ctx context.Context, code string) (*lucia.OAuthToken, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.failure(OpExchangeCode); err != nil {
+		return nil, err
+	}
+	user, ok := p.codes[code]
+	if !ok {
+		return nil, errors.ErrUnauthorized("Invalid authorization code").WithProvider(p.name)
+	}
+	delete(p.codes, code)
+
+	refreshToken := lucia.GenerateID()
+	p.refreshTokens[refreshToken] = user
+	return p.issue(user, refreshToken), nil
+}
+
+func (p *FakeProvider) GetUserInfo(ctx context.Context, token *lucia.OAuthToken) (*lucia.UserInfo, error) {
+	// Refresh like the real providers do before using a token about to expire
+	if err := token.RefreshIfNeeded(ctx, p); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.failure(OpGetUserInfo); err != nil {
+		return nil, err
+	}
+	grant, ok := p.accessTokens[token.AccessToken]
+	if !ok {
+		return nil, errors.ErrUnauthorized("Invalid access token").WithProvider(p.name)
+	}
+	if !time.Now().Before(grant.expiresAt) {
+		return nil, errors.ErrUnauthorized("Access token has expired").WithProvider(p.name)
+	}
+	user := grant.user
+	user.Token = token
+	return &user, nil
+}
+
+func (p *FakeProvider) RefreshToken(ctx context.Context, refreshToken string) (*lucia.OAuthToken, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.failure(OpRefreshToken); err != nil {
+		return nil, err
+	}
+	user, ok := p.refreshTokens[refreshToken]
+	if !ok {
+		return nil, errors.ErrUnauthorized("Invalid refresh token").WithProvider(p.name)
+	}
+	return p.issue(user, refreshToken), nil
+}
+
+// ReportsMFA is true, the AuthMethods of the users passed to Code are returned as they are
+func (p *FakeProvider) ReportsMFA() bool {
+	return true
+}
+
+// RevokeToken ends the grant of the user of token, all their access and refresh tokens stop working
+func (p *FakeProvider) RevokeToken(ctx context.Context, token *lucia.OAuthToken) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.failure(OpRevokeToken); err != nil {
+		return err
+	}
+	user, ok := p.refreshTokens[token.RefreshToken]
+	if !ok {
+		grant, found := p.accessTokens[token.AccessToken]
+		if !found {
+			// Unknown tokens count as revoked, as with RFC 7009
+			return nil
+		}
+		user = grant.user
+	}
+	for t, grant := range p.accessTokens {
+		if grant.user.ID == user.ID {
+			delete(p.accessTokens, t)
+		}
+	}
+	for t, u := range p.refreshTokens {
+		if u.ID == user.ID {
+			delete(p.refreshTokens, t)
+		}
+	}
+	p.revoked[user.ID] = true
+	return nil
+}
+
+// issue creates an access token for user, it must be called with the lock held
+func (p *FakeProvider) issue(user lucia.UserInfo, refreshToken string) *lucia.OAuthToken {
+	expiresAt := time.Now().Add(p.tokenTTL)
+	accessToken := lucia.GenerateID()
+	p.accessTokens[accessToken] = fakeGrant{user: user, expiresAt: expiresAt}
+	return &lucia.OAuthToken{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    expiresAt.Unix(),
+	}
+}
+
+// failure pops the next scripted failure of op, it must be called with the lock held
+func (p *FakeProvider) failure(op string) error {
+	queue := p.failures[op]
+	if len(queue) == 0 {
+		return nil
+	}
+	p.failures[op] = queue[1:]
+	return queue[0]
+}
@@ -0,0 +1,62 @@
+package luciatest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/Abraxas-365/toolkit/pkg/lucia"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Authorizer plays the provider side of a login: given the authorization URL the app redirected to, it
+// returns the query the provider redirects back to the callback with, the code and state or an error
+type Authorizer func(authURL string) (url.Values, error)
+
+// Login logs in through the routes of lucia.AuthHandlers on app: it requests loginPath, e.g.
+// "/login/google", lets authorize play the provider and requests the callback with the login cookies.
+// The callback path is taken from the redirect_uri of the authorization URL, loginPath + "/callback" if it
+// has none. It returns the session cookie to add to app.Test requests.
+func Login(app *fiber.App, loginPath string, authorize Authorizer) (*http.Cookie, error) {
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, loginPath, nil), -1)
+	if err != nil {
+		return nil, err
+	}
+	authURL, err := resp.Location()
+	if err != nil {
+		return nil, unexpectedResponse("login", resp)
+	}
+	loginCookies := resp.Cookies()
+
+	params, err := authorize(authURL.String())
+	if err != nil {
+		return nil, err
+	}
+
+	callbackPath := loginPath + "/callback"
+	if redirectURI, err := url.Parse(authURL.Query().Get("redirect_uri")); err == nil && redirectURI.Path != "" {
+		callbackPath = redirectURI.Path
+	}
+	req := httptest.NewRequest(http.MethodGet, callbackPath+"?"+params.Encode(), nil)
+	for _, cookie := range loginCookies {
+		req.AddCookie(cookie)
+	}
+	resp, err = app.Test(req, -1)
+	if err != nil {
+		return nil, err
+	}
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == lucia.SessionCookieName && cookie.Value != "" {
+			return cookie, nil
+		}
+	}
+	return nil, unexpectedResponse("callback", resp)
+}
+
+// unexpectedResponse reports a step of Login that did not answer as a successful login would
+func unexpectedResponse(step string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("luciatest: %s answered %d: %s", step, resp.StatusCode, body)
+}
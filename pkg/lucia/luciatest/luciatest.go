@@ -0,0 +1,155 @@
+// Package luciatest helps testing applications built on lucia without a real identity provider.
+// FakeProvider is an in-process OAuthProvider, Server a mock Google, GitHub and Microsoft on an httptest
+// server for the real providers, and Login walks the login routes of a Fiber app to get a session cookie.
+package luciatest
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+	"github.com/Abraxas-365/toolkit/pkg/lucia"
+)
+
+// User is the user type of NewService
+type User struct {
+	ID         string
+	Email      string
+	Name       string
+	Provider   string
+	ProviderID string
+}
+
+func (u *User) GetID() string {
+	return u.ID
+}
+
+// UserStore is an in-memory AuthUserStore of Users
+type UserStore struct {
+	mu    sync.RWMutex
+	users map[string]*User
+}
+
+func NewUserStore() *UserStore {
+	return &UserStore{users: make(map[string]*User)}
+}
+
+func (s *UserStore) GetUserByProviderID(ctx context.Context, provider, providerID string) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, user := range s.users {
+		if user.Provider == provider && user.ProviderID == providerID {
+			return user, nil
+		}
+	}
+	return nil, errors.ErrNotFound("User not found")
+}
+
+func (s *UserStore) CreateUser(ctx context.Context, userInfo *lucia.UserInfo) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user := &User{
+		ID:         lucia.GenerateID(),
+		Email:      userInfo.Email,
+		Name:       userInfo.Name,
+		Provider:   userInfo.Provider,
+		ProviderID: userInfo.ID,
+	}
+	s.users[user.ID] = user
+	return user, nil
+}
+
+// Users returns the created users, in no particular order
+func (s *UserStore) Users() []*User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	users := make([]*User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, user)
+	}
+	return users
+}
+
+// SessionStore is an in-memory SessionStore
+type SessionStore[ID lucia.UserID] struct {
+	mu       sync.RWMutex
+	sessions map[string]*lucia.Session[ID]
+}
+
+func NewSessionStore[ID lucia.UserID]() *SessionStore[ID] {
+	return &SessionStore[ID]{sessions: make(map[string]*lucia.Session[ID])}
+}
+
+func (s *SessionStore[ID]) CreateSession(ctx context.Context, session *lucia.Session[ID]) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := *session
+	s.sessions[session.ID] = &stored
+	return nil
+}
+
+func (s *SessionStore[ID]) GetSession(ctx context.Context, sessionID string) (*lucia.Session[ID], error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	session, ok := s.sessions[sessionID]
+	if !ok {
+		return nil, errors.ErrNotFound("Session not found")
+	}
+	found := *session
+	return &found, nil
+}
+
+func (s *SessionStore[ID]) UpdateSession(ctx context.Context, session *lucia.Session[ID]) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sessions[session.ID]; !ok {
+		return errors.ErrNotFound("Session not found")
+	}
+	stored := *session
+	s.sessions[session.ID] = &stored
+	return nil
+}
+
+func (s *SessionStore[ID]) DeleteSession(ctx context.Context, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sessions[sessionID]; !ok {
+		return errors.ErrNotFound("Session not found")
+	}
+	delete(s.sessions, sessionID)
+	return nil
+}
+
+// Expire makes a stored session expire, to test what happens to a user whose session ran out
+func (s *SessionStore[ID]) Expire(sessionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if session, ok := s.sessions[sessionID]; ok {
+		session.ExpiresAt = time.Now().Add(-time.Second).Unix()
+	}
+}
+
+// NewService returns an AuthService on an in-memory UserStore and SessionStore
+func NewService() *lucia.AuthService[*User, string] {
+	return lucia.NewAuthService[*User, string](NewUserStore(), NewSessionStore[string]())
+}
+
+// SessionCookie creates a session for user directly, without a login, and returns its cookie
+func SessionCookie[U lucia.AuthUser[ID], ID lucia.UserID](ctx context.Context, service *lucia.AuthService[U, ID], user U) (*http.Cookie, error) {
+	session, err := service.CreateSession(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	return Cookie(session), nil
+}
+
+// Cookie returns the session cookie of session, to add to requests with AddCookie
+func Cookie[ID lucia.UserID](session *lucia.Session[ID]) *http.Cookie {
+	return &http.Cookie{
+		Name:    lucia.SessionCookieName,
+		Value:   session.ID,
+		Expires: time.Unix(session.ExpiresAt, 0),
+	}
+}
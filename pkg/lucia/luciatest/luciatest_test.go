@@ -0,0 +1,241 @@
+package luciatest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+	"github.com/Abraxas-365/toolkit/pkg/lucia"
+	"github.com/Abraxas-365/toolkit/pkg/lucia/luciatest"
+	"github.com/gofiber/fiber/v2"
+)
+
+// newApp mounts the auth handlers of service and a /me route requiring a session with MFA when mfa is set
+func newApp(service *lucia.AuthService[*luciatest.User, string], mfa bool) *fiber.App {
+	app := fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+	lucia.NewAuthHandlers(service, lucia.HandlersConfig{}).Mount(app)
+	am := lucia.NewAuthMiddleware(service)
+	handlers := []fiber.Handler{am.SessionMiddleware(), am.RequireAuth()}
+	if mfa {
+		handlers = append(handlers, am.RequireMFA())
+	}
+	handlers = append(handlers, func(c *fiber.Ctx) error {
+		return c.SendString(lucia.GetSession[string](c).UserID)
+	})
+	app.Get("/me", handlers...)
+	return app
+}
+
+// get requests path on app with cookie and returns the status
+func get(t *testing.T, app *fiber.App, path string, cookie *http.Cookie) int {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode
+}
+
+func TestServerLogin(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider string
+		// register registers the provider and scripts the server before the login
+		register func(service *lucia.AuthService[*luciatest.User, string], server *luciatest.Server)
+		mfa      bool
+		// providerID is the expected provider user ID, DefaultIdentity.ID when empty
+		providerID string
+		wantErr    bool
+	}{
+		{
+			name:     "google",
+			provider: "google",
+			register: func(service *lucia.AuthService[*luciatest.User, string], server *luciatest.Server) {
+				service.RegisterProvider("google", server.GoogleProvider("https://app.example.com/login/google/callback"))
+			},
+		},
+		{
+			name:     "github",
+			provider: "github",
+			register: func(service *lucia.AuthService[*luciatest.User, string], server *luciatest.Server) {
+				service.RegisterProvider("github", server.GitHubProvider("https://app.example.com/login/github/callback"))
+			},
+		},
+		{
+			name:     "microsoft with mfa",
+			provider: "microsoft",
+			register: func(service *lucia.AuthService[*luciatest.User, string], server *luciatest.Server) {
+				identity := luciatest.DefaultIdentity
+				identity.AuthMethods = []string{"pwd", "mfa"}
+				server.SetIdentity(identity)
+				service.RegisterProvider("microsoft", server.MicrosoftProvider(lucia.MicrosoftConfig{RedirectURI: "https://app.example.com/login/microsoft/callback"}))
+			},
+			mfa:        true,
+			providerID: luciatest.DefaultTenantID + ":" + luciatest.DefaultIdentity.ID,
+		},
+		{
+			name:     "microsoft tenant not allowed",
+			provider: "microsoft",
+			register: func(service *lucia.AuthService[*luciatest.User, string], server *luciatest.Server) {
+				service.RegisterProvider("microsoft", server.MicrosoftProvider(lucia.MicrosoftConfig{
+					RedirectURI:    "https://app.example.com/login/microsoft/callback",
+					AllowedTenants: []string{"00000000-0000-4000-8000-000000000002"},
+				}))
+			},
+			wantErr: true,
+		},
+		{
+			name:     "microsoft tenant by domain without allowed tenants",
+			provider: "microsoft",
+			register: func(service *lucia.AuthService[*luciatest.User, string], server *luciatest.Server) {
+				service.RegisterProvider("microsoft", server.MicrosoftProvider(lucia.MicrosoftConfig{
+					RedirectURI: "https://app.example.com/login/microsoft/callback",
+					Tenant:      "example.com",
+				}))
+			},
+			wantErr: true,
+		},
+		{
+			name:     "microsoft tenant by domain with allowed tenants",
+			provider: "microsoft",
+			register: func(service *lucia.AuthService[*luciatest.User, string], server *luciatest.Server) {
+				service.RegisterProvider("microsoft", server.MicrosoftProvider(lucia.MicrosoftConfig{
+					RedirectURI:    "https://app.example.com/login/microsoft/callback",
+					Tenant:         "example.com",
+					AllowedTenants: []string{luciatest.DefaultTenantID},
+				}))
+			},
+			providerID: luciatest.DefaultTenantID + ":" + luciatest.DefaultIdentity.ID,
+		},
+		{
+			name:     "user denies the authorization",
+			provider: "google",
+			register: func(service *lucia.AuthService[*luciatest.User, string], server *luciatest.Server) {
+				service.RegisterProvider("google", server.GoogleProvider("https://app.example.com/login/google/callback"))
+				server.DenyNext()
+			},
+			wantErr: true,
+		},
+		{
+			name:     "token endpoint fails",
+			provider: "github",
+			register: func(service *lucia.AuthService[*luciatest.User, string], server *luciatest.Server) {
+				service.RegisterProvider("github", server.GitHubProvider("https://app.example.com/login/github/callback"))
+				server.FailNext("/login/oauth/access_token", http.StatusServiceUnavailable, "unavailable")
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := luciatest.NewServer()
+			defer server.Close()
+			users := luciatest.NewUserStore()
+			service := lucia.NewAuthService[*luciatest.User, string](users, luciatest.NewSessionStore[string]())
+			tt.register(service, server)
+			app := newApp(service, tt.mfa)
+
+			cookie, err := luciatest.Login(app, "/login/"+tt.provider, server.Authorizer())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Login() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if len(users.Users()) != 0 {
+					t.Errorf("users = %v, want none after a failed login", users.Users())
+				}
+				return
+			}
+
+			if len(users.Users()) != 1 {
+				t.Fatalf("users = %v, want one", users.Users())
+			}
+			providerID := tt.providerID
+			if providerID == "" {
+				providerID = luciatest.DefaultIdentity.ID
+			}
+			user := users.Users()[0]
+			if user.Provider != tt.provider || user.ProviderID != providerID || user.Email != luciatest.DefaultIdentity.Email {
+				t.Errorf("user = %+v, want %s identity %s", user, tt.provider, providerID)
+			}
+			if status := get(t, app, "/me", cookie); status != http.StatusOK {
+				t.Errorf("GET /me with the session status = %d, want %d", status, http.StatusOK)
+			}
+		})
+	}
+}
+
+func TestFakeProviderLogin(t *testing.T) {
+	alice := lucia.UserInfo{ID: "alice", Email: "alice@example.com", Name: "Alice"}
+	tests := []struct {
+		name    string
+		fail    string
+		wantErr bool
+	}{
+		{name: "login"},
+		{name: "code exchange fails", fail: luciatest.OpExchangeCode, wantErr: true},
+		{name: "user info fails", fail: luciatest.OpGetUserInfo, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := luciatest.NewUserStore()
+			sessions := luciatest.NewSessionStore[string]()
+			service := lucia.NewAuthService[*luciatest.User, string](users, sessions)
+			provider := luciatest.NewFakeProvider("fake")
+			service.RegisterProvider("fake", provider)
+			if tt.fail != "" {
+				provider.Fail(tt.fail, errors.ErrServiceUnavailable("provider down"))
+			}
+			app := newApp(service, false)
+
+			cookie, err := luciatest.Login(app, "/login/fake", provider.Authorizer(alice))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Login() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(provider.AuthURLs()) != 1 {
+				t.Errorf("AuthURLs() = %v, want one", provider.AuthURLs())
+			}
+			if tt.wantErr {
+				return
+			}
+			if users := users.Users(); len(users) != 1 || users[0].Email != alice.Email || users[0].Provider != "fake" {
+				t.Fatalf("users = %v, want alice from the fake provider", users)
+			}
+
+			if status := get(t, app, "/me", cookie); status != http.StatusOK {
+				t.Errorf("GET /me status = %d, want %d", status, http.StatusOK)
+			}
+			sessions.Expire(cookie.Value)
+			if status := get(t, app, "/me", cookie); status != http.StatusUnauthorized {
+				t.Errorf("GET /me with an expired session status = %d, want %d", status, http.StatusUnauthorized)
+			}
+		})
+	}
+}
+
+func TestSessionCookie(t *testing.T) {
+	ctx := context.Background()
+	users := luciatest.NewUserStore()
+	service := lucia.NewAuthService[*luciatest.User, string](users, luciatest.NewSessionStore[string]())
+	user, err := users.CreateUser(ctx, &lucia.UserInfo{ID: "bob", Provider: "fake"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie, err := luciatest.SessionCookie(ctx, service, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := newApp(service, false)
+	if status := get(t, app, "/me", cookie); status != http.StatusOK {
+		t.Errorf("GET /me status = %d, want %d", status, http.StatusOK)
+	}
+	if status := get(t, app, "/me", nil); status != http.StatusUnauthorized {
+		t.Errorf("GET /me without a cookie status = %d, want %d", status, http.StatusUnauthorized)
+	}
+}
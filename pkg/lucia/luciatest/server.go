@@ -0,0 +1,532 @@
+package luciatest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/lucia"
+)
+
+// DefaultTenantID is the Microsoft tenant of identities that do not set one
+const DefaultTenantID = "00000000-0000-4000-8000-000000000001"
+
+// Client credentials of the providers returned by Server
+const (
+	ClientID     = "luciatest-client"
+	ClientSecret = "luciatest-secret"
+)
+
+// Identity is the user the mock identity provider logs in
+type Identity struct {
+	// ID is the provider user ID, GitHub IDs are numbers
+	ID            string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Picture       string
+	// Groups and TenantID are returned by Microsoft only
+	Groups   []string
+	TenantID string
+	// AuthMethods is the amr claim of the ID tokens, e.g. "pwd" and "mfa"
+	AuthMethods []string
+}
+
+// DefaultIdentity is the identity a new Server logs in
+var DefaultIdentity = Identity{
+	ID:            "1001",
+	Email:         "test@example.com",
+	EmailVerified: true,
+	Name:          "Test User",
+}
+
+// Server is a mock identity provider on an httptest server. It serves the endpoints of Google, GitHub and
+// Microsoft (OpenID Connect with signed ID tokens) under the paths the real providers derive from
+// WithBaseURL, and approves every authorization request for the current Identity.
+type Server struct {
+	*httptest.Server
+
+	key *rsa.PrivateKey
+	kid string
+
+	mu       sync.Mutex
+	identity Identity
+	tokenTTL time.Duration
+	denyNext bool
+	codes    map[string]serverGrant
+	tokens   map[string]serverGrant
+	refresh  map[string]serverGrant
+	failures map[string][]serverFailure
+	revoked  map[string]bool
+}
+
+// serverGrant is what an authorization code or token was issued for
+type serverGrant struct {
+	identity Identity
+	clientID string
+	// tenantID is set for Microsoft grants, their ID tokens are issued by the tenant
+	tenantID  string
+	nonce     string
+	authTime  int64
+	expiresAt time.Time
+}
+
+type serverFailure struct {
+	status int
+	body   string
+}
+
+// NewServer starts a mock identity provider logging in DefaultIdentity, Close it when done
+func NewServer() *Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic("luciatest: failed to generate signing key: " + err.Error())
+	}
+	s := &Server{
+		key:      key,
+		kid:      lucia.GenerateID(),
+		identity: DefaultIdentity,
+		tokenTTL: time.Hour,
+		codes:    make(map[string]serverGrant),
+		tokens:   make(map[string]serverGrant),
+		refresh:  make(map[string]serverGrant),
+		failures: make(map[string][]serverFailure),
+		revoked:  make(map[string]bool),
+	}
+
+	mux := http.NewServeMux()
+	// Google
+	mux.HandleFunc("GET /o/oauth2/auth", s.authorize)
+	mux.HandleFunc("POST /token", s.token)
+	mux.HandleFunc("GET /oauth2/v2/userinfo", s.googleUserInfo)
+	mux.HandleFunc("POST /revoke", s.revoke)
+	// GitHub
+	mux.HandleFunc("GET /login/oauth/authorize", s.authorize)
+	mux.HandleFunc("POST /login/oauth/access_token", s.token)
+	mux.HandleFunc("GET /api/v3/user", s.githubUser)
+	mux.HandleFunc("GET /api/v3/user/emails", s.githubEmails)
+	mux.HandleFunc("DELETE /api/v3/applications/{client_id}/grant", s.githubDeleteGrant)
+	// Microsoft
+	mux.HandleFunc("GET /{tenant}/oauth2/v2.0/authorize", s.authorize)
+	mux.HandleFunc("POST /{tenant}/oauth2/v2.0/token", s.token)
+	mux.HandleFunc("GET /{tenant}/discovery/v2.0/keys", s.jwks)
+	mux.HandleFunc("GET /graph/me/transitiveMemberOf/microsoft.graph.group", s.graphGroups)
+
+	s.Server = httptest.NewServer(s.withFailures(mux))
+	return s
+}
+
+// SetIdentity sets the identity the next authorizations log in
+func (s *Server) SetIdentity(identity Identity) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.identity = identity
+}
+
+// SetTokenTTL sets how long issued access tokens are valid, an hour by default
+func (s *Server) SetTokenTTL(ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokenTTL = ttl
+}
+
+// DenyNext makes the next authorization redirect back with error=access_denied, as if the user declined
+func (s *Server) DenyNext() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.denyNext = true
+}
+
+// FailNext makes the next request to path, e.g. "/token" or "/api/v3/user", answer status with body.
+// Failures queue up per path.
+func (s *Server) FailNext(path string, status int, body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[path] = append(s.failures[path], serverFailure{status: status, body: body})
+}
+
+// Revoked reports whether the grant of the identity with ID identityID was revoked, through Google's revocation
+// endpoint or GitHub's grant deletion, since it last authorized
+func (s *Server) Revoked(identityID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.revoked[identityID]
+}
+
+// ExpireTokens makes every issued access token invalid, refresh tokens keep working
+func (s *Server) ExpireTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for token, grant := range s.tokens {
+		grant.expiresAt = time.Now()
+		s.tokens[token] = grant
+	}
+}
+
+// ProviderOptions point a real provider at the server
+func (s *Server) ProviderOptions() []lucia.ProviderOption {
+	return []lucia.ProviderOption{
+		lucia.WithBaseURL(s.URL),
+		lucia.WithHTTPClient(s.Client()),
+	}
+}
+
+// GoogleProvider returns a Google provider using the server
+func (s *Server) GoogleProvider(redirectURI string) *lucia.GoogleProvider {
+	return lucia.NewGoogleProvider(ClientID, ClientSecret, redirectURI, []string{"openid", "email", "profile"}, s.ProviderOptions()...)
+}
+
+// GitHubProvider returns a GitHub provider using the server
+func (s *Server) GitHubProvider(redirectURI string) *lucia.GitHubProvider {
+	return lucia.NewGitHubProvider(ClientID, ClientSecret, redirectURI, s.ProviderOptions()...)
+}
+
+// MicrosoftProvider returns a Microsoft provider using the server, ClientID and ClientSecret default to the
+// server's
+func (s *Server) MicrosoftProvider(cfg lucia.MicrosoftConfig) *lucia.MicrosoftProvider {
+	if cfg.ClientID == "" {
+		cfg.ClientID, cfg.ClientSecret = ClientID, ClientSecret
+	}
+	opts := append(s.ProviderOptions(), lucia.WithAPIURL(s.URL+"/graph"))
+	return lucia.NewMicrosoftProvider(cfg, opts...)
+}
+
+// Authorizer returns the Authorizer going through the authorization endpoint of the server, see Login
+func (s *Server) Authorizer() Authorizer {
+	return func(authURL string) (url.Values, error) {
+		client := *s.Client()
+		client.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+		resp, err := client.Get(authURL)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		location, err := resp.Location()
+		if err != nil {
+			return nil, err
+		}
+		return location.Query(), nil
+	}
+}
+
+func (s *Server) withFailures(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		queue := s.failures[r.URL.Path]
+		if len(queue) > 0 {
+			s.failures[r.URL.Path] = queue[1:]
+		}
+		s.mu.Unlock()
+		if len(queue) > 0 {
+			http.Error(w, queue[0].body, queue[0].status)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authorize approves the request for the current identity and redirects back with a code
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || redirectURI.Scheme == "" || query.Get("client_id") == "" {
+		http.Error(w, "missing client_id or redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	deny := s.denyNext
+	s.denyNext = false
+	code := lucia.GenerateID()
+	if !deny {
+		delete(s.revoked, s.identity.ID)
+		s.codes[code] = serverGrant{
+			identity: s.identity,
+			clientID: query.Get("client_id"),
+			tenantID: s.tenantID(r),
+			nonce:    query.Get("nonce"),
+			authTime: time.Now().Unix(),
+		}
+	}
+	s.mu.Unlock()
+
+	params := redirectURI.Query()
+	if deny {
+		params.Set("error", "access_denied")
+		params.Set("error_description", "The user denied the request")
+	} else {
+		params.Set("code", code)
+	}
+	params.Set("state", query.Get("state"))
+	redirectURI.RawQuery = params.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+// tenantID returns the tenant of the current identity for the Microsoft endpoints, "" for the others.
+// It must be called with the lock held.
+func (s *Server) tenantID(r *http.Request) string {
+	if r.PathValue("tenant") == "" {
+		return ""
+	}
+	if s.identity.TenantID == "" {
+		return DefaultTenantID
+	}
+	return s.identity.TenantID
+}
+
+// token redeems authorization codes and refresh tokens
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var grant serverGrant
+	var ok bool
+	refreshToken := r.PostForm.Get("refresh_token")
+	if r.PostForm.Get("grant_type") == "refresh_token" {
+		grant, ok = s.refresh[refreshToken]
+	} else {
+		code := r.PostForm.Get("code")
+		grant, ok = s.codes[code]
+		delete(s.codes, code)
+		refreshToken = lucia.GenerateID()
+	}
+	if !ok {
+		tokenError(w, "invalid_grant", "The code or refresh token is invalid or expired")
+		return
+	}
+
+	grant.expiresAt = time.Now().Add(s.tokenTTL)
+	accessToken := lucia.GenerateID()
+	s.tokens[accessToken] = grant
+	s.refresh[refreshToken] = grant
+
+	idToken, err := s.idToken(grant)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"access_token":  accessToken,
+		"token_type":    "Bearer",
+		"expires_in":    int64(s.tokenTTL / time.Second),
+		"refresh_token": refreshToken,
+		"id_token":      idToken,
+	})
+}
+
+// tokenError answers an RFC 6749 error response
+func tokenError(w http.ResponseWriter, code, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"error": code, "error_description": description})
+}
+
+// idToken signs the ID token of a grant
+func (s *Server) idToken(grant serverGrant) (string, error) {
+	identity := grant.identity
+	issuer := s.URL
+	if grant.tenantID != "" {
+		issuer = s.URL + "/" + grant.tenantID + "/v2.0"
+	}
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":            issuer,
+		"sub":            identity.ID,
+		"aud":            grant.clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(s.tokenTTL).Unix(),
+		"auth_time":      grant.authTime,
+		"email":          identity.Email,
+		"email_verified": identity.EmailVerified,
+		"name":           identity.Name,
+		"oid":            identity.ID,
+		"tid":            grant.tenantID,
+		"xms_edov":       identity.EmailVerified,
+	}
+	if grant.nonce != "" {
+		claims["nonce"] = grant.nonce
+	}
+	if len(identity.AuthMethods) > 0 {
+		claims["amr"] = identity.AuthMethods
+	}
+	if len(identity.Groups) > 0 {
+		claims["groups"] = identity.Groups
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": s.kid, "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	key, err := lucia.NewJWK(s.kid, &s.key.PublicKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, lucia.JWKSet{Keys: []lucia.JWK{key}})
+}
+
+// bearer returns the identity of the access token of r, answering 401 if there is none
+func (s *Server) bearer(w http.ResponseWriter, r *http.Request) (Identity, bool) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	s.mu.Lock()
+	grant, ok := s.tokens[token]
+	s.mu.Unlock()
+	if !ok || !time.Now().Before(grant.expiresAt) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "invalid or expired access token", http.StatusUnauthorized)
+		return Identity{}, false
+	}
+	return grant.identity, true
+}
+
+func (s *Server) googleUserInfo(w http.ResponseWriter, r *http.Request) {
+	identity, ok := s.bearer(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"id":             identity.ID,
+		"email":          identity.Email,
+		"verified_email": identity.EmailVerified,
+		"name":           identity.Name,
+		"picture":        identity.Picture,
+	})
+}
+
+func (s *Server) githubUser(w http.ResponseWriter, r *http.Request) {
+	identity, ok := s.bearer(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(identity.ID, 10, 64)
+	if err != nil {
+		http.Error(w, "luciatest: GitHub user IDs must be numbers", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"id":         id,
+		"login":      strings.Split(identity.Email, "@")[0],
+		"name":       identity.Name,
+		"avatar_url": identity.Picture,
+	})
+}
+
+func (s *Server) githubEmails(w http.ResponseWriter, r *http.Request) {
+	identity, ok := s.bearer(w, r)
+	if !ok {
+		return
+	}
+	emails := []map[string]interface{}{}
+	if identity.Email != "" {
+		emails = append(emails, map[string]interface{}{
+			"email":    identity.Email,
+			"primary":  true,
+			"verified": identity.EmailVerified,
+		})
+	}
+	writeJSON(w, emails)
+}
+
+func (s *Server) graphGroups(w http.ResponseWriter, r *http.Request) {
+	identity, ok := s.bearer(w, r)
+	if !ok {
+		return
+	}
+	groups := []map[string]string{}
+	for _, id := range identity.Groups {
+		groups = append(groups, map[string]string{"id": id})
+	}
+	writeJSON(w, map[string]interface{}{"value": groups})
+}
+
+// revoke serves Google's RFC 7009 revocation endpoint
+func (s *Server) revoke(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	grant, ok := s.refresh[token]
+	if !ok {
+		grant, ok = s.tokens[token]
+	}
+	if !ok {
+		tokenError(w, "invalid_token", "Token expired or revoked")
+		return
+	}
+	s.revokeGrant(grant)
+}
+
+// githubDeleteGrant serves GitHub's deletion of an app authorization, authenticated by the client credentials
+func (s *Server) githubDeleteGrant(w http.ResponseWriter, r *http.Request) {
+	clientID, _, ok := r.BasicAuth()
+	if !ok || clientID != r.PathValue("client_id") {
+		http.Error(w, "requires client authentication", http.StatusUnauthorized)
+		return
+	}
+	var body struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Like GitHub, expired tokens are rejected as invalid ones are
+	grant, found := s.tokens[body.AccessToken]
+	if !found || grant.clientID != clientID || !time.Now().Before(grant.expiresAt) {
+		http.Error(w, "Validation Failed", http.StatusUnprocessableEntity)
+		return
+	}
+	s.revokeGrant(grant)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeGrant deletes every token of the identity and client of grant, it must be called with the lock held
+func (s *Server) revokeGrant(grant serverGrant) {
+	for token, g := range s.tokens {
+		if g.identity.ID == grant.identity.ID && g.clientID == grant.clientID {
+			delete(s.tokens, token)
+		}
+	}
+	for token, g := range s.refresh {
+		if g.identity.ID == grant.identity.ID && g.clientID == grant.clientID {
+			delete(s.refresh, token)
+		}
+	}
+	s.revoked[grant.identity.ID] = true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
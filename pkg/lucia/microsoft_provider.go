@@ -0,0 +1,282 @@
+package lucia
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+// Microsoft identity platform tenant modes
+const (
+	// MicrosoftTenantCommon accepts work, school and personal accounts
+	MicrosoftTenantCommon = "common"
+	// MicrosoftTenantOrganizations accepts work and school accounts from any tenant
+	MicrosoftTenantOrganizations = "organizations"
+	// MicrosoftTenantConsumers accepts personal Microsoft accounts only
+	MicrosoftTenantConsumers = "consumers"
+
+	// microsoftPersonalTenantID is the tid of every personal Microsoft account
+	microsoftPersonalTenantID = "9188040d-6c67-4c5b-b112-36a304b66dad"
+)
+
+// MicrosoftConfig configures a MicrosoftProvider
+type MicrosoftConfig struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURI  string
+	// Tenant is a tenant ID for single-tenant apps, or one of the MicrosoftTenant modes.
+	// A tenant domain name only selects the sign-in page, logins are then rejected unless AllowedTenants is set.
+	Tenant string
+	// AllowedTenants restricts logins to these tenant IDs (tid claim), empty allows every tenant accepted by Tenant
+	AllowedTenants []string
+	// Scopes defaults to openid, profile, email, offline_access and User.Read
+	Scopes []string
+	// FetchGroups reads the group membership of the user from Microsoft Graph, it requires the GroupMember.Read.All scope
+	FetchGroups bool
+}
+
+// MicrosoftProvider signs users in with Microsoft Entra ID (Azure AD) and personal Microsoft accounts
+type MicrosoftProvider struct {
+	cfg      MicrosoftConfig
+	config   *oauth2.Config
+	opts     providerOptions
+	graphURL string
+	issuer   string
+	jwks     *jwksCache
+}
+
+func NewMicrosoftProvider(cfg MicrosoftConfig, opts ...ProviderOption) *MicrosoftProvider {
+	o := newProviderOptions(opts)
+	if cfg.Tenant == "" {
+		cfg.Tenant = MicrosoftTenantCommon
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "profile", "email", "offline_access", "User.Read"}
+	}
+
+	authority := strings.TrimSuffix(or(o.baseURL, "https://login.microsoftonline.com"), "/")
+	tenantURL := authority + "/" + cfg.Tenant
+
+	return &MicrosoftProvider{
+		cfg: cfg,
+		config: &oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURI,
+			Scopes:       cfg.Scopes,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  or(o.authURL, tenantURL+"/oauth2/v2.0/authorize"),
+				TokenURL: or(o.tokenURL, tenantURL+"/oauth2/v2.0/token"),
+			},
+		},
+		opts:     o,
+		graphURL: strings.TrimSuffix(or(o.apiURL, "https://graph.microsoft.com/v1.0"), "/"),
+		// {tenantid} is replaced with the tid claim, multi-tenant tokens are issued by the user's own tenant
+		issuer: or(o.issuer, authority+"/{tenantid}/v2.0"),
+		jwks:   newJWKSCache(or(o.jwksURL, tenantURL+"/discovery/v2.0/keys"), o.httpClient),
+	}
+}
+
+func (p *MicrosoftProvider) GetAuthURL(state string, opts ...AuthURLOption) string {
+	o := newAuthURLOptions(opts)
+	if o.offline {
+		// Refresh tokens are granted through the offline_access scope
+		o.scopes = append(o.scopes, "offline_access")
+	}
+	var params []oauth2.AuthCodeOption
+	if o.domain != "" {
+		params = append(params, oauth2.SetAuthURLParam("domain_hint", o.domain))
+	}
+	return o.authCodeURL(p.config, state, params...)
+}
+
+func (p *MicrosoftProvider) ExchangeCode(ctx context.Context, code string) (*OAuthToken, error) {
+	token, err := p.config.Exchange(p.opts.oauth2Context(ctx), code)
+	if err != nil {
+		return nil, errors.ErrUnauthorized("Failed to exchange code").WithProvider("microsoft").WithCause(err)
+	}
+
+	idToken, _ := token.Extra("id_token").(string)
+	return &OAuthToken{
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		ExpiresIn:    token.Expiry.Unix(),
+		IDToken:      idToken,
+	}, nil
+}
+
+type microsoftClaims struct {
+	TenantID          string   `json:"tid"`
+	ObjectID          string   `json:"oid"`
+	Name              string   `json:"name"`
+	Email             string   `json:"email"`
+	PreferredUsername string   `json:"preferred_username"`
+	Groups            []string `json:"groups"`
+	// EmailDomainOwnerVerified is the optional xms_edov claim, Entra ID does not otherwise verify the email claim
+	EmailDomainOwnerVerified bool `json:"xms_edov"`
+}
+
+func (p *MicrosoftProvider) GetUserInfo(ctx context.Context, token *OAuthToken) (*UserInfo, error) {
+	if token.IDToken == "" {
+		return nil, errors.NewLuciaError("InvalidToken", "Microsoft did not return an ID token, is the openid scope configured?")
+	}
+
+	// The signature is checked before any claim is trusted
+	var claims microsoftClaims
+	registered, err := verifyJWT(token.IDToken, func(header jwtHeader) (crypto.PublicKey, error) {
+		return p.jwks.key(ctx, header.Kid)
+	}, &claims)
+	if err != nil {
+		return nil, err
+	}
+	if err := registered.validate(strings.ReplaceAll(p.issuer, "{tenantid}", claims.TenantID), p.cfg.ClientID); err != nil {
+		return nil, err
+	}
+	if err := p.checkTenant(claims.TenantID); err != nil {
+		return nil, err
+	}
+
+	// Refresh only after the ID token has been validated, it is not returned again on refresh
+	if token.NeedsRefresh() && token.RefreshToken != "" {
+		if err := token.RefreshIfNeeded(ctx, p); err != nil {
+			return nil, err
+		}
+	}
+
+	// preferred_username is a display hint that tenant admins can set freely, it is never treated as verified
+	email, verified := claims.Email, claims.EmailDomainOwnerVerified
+	if email == "" {
+		email, verified = claims.PreferredUsername, false
+	}
+	// oid is only unique within its tenant, personal accounts all share the same tid
+	userInfo := &UserInfo{
+		ID:            claims.TenantID + ":" + claims.ObjectID,
+		Email:         email,
+		EmailVerified: verified,
+		Name:          claims.Name,
+		Provider:      "microsoft",
+		Groups:        claims.Groups,
+		AuthTime:      registered.AuthTime,
+		AuthMethods:   registered.AuthMethods,
+		Raw:           registered.Raw,
+		Token:         token,
+	}
+
+	if p.cfg.FetchGroups && len(userInfo.Groups) == 0 && claims.TenantID != microsoftPersonalTenantID {
+		groups, err := p.fetchGroups(ctx, token.AccessToken)
+		if err != nil {
+			return nil, err
+		}
+		userInfo.Groups = groups
+	}
+
+	return userInfo, nil
+}
+
+// ReportsMFA is true, Microsoft ID tokens carry the amr claim
+func (p *MicrosoftProvider) ReportsMFA() bool {
+	return true
+}
+
+// checkTenant enforces the tenant mode and the tenant allowlist against the verified tid claim
+func (p *MicrosoftProvider) checkTenant(tenantID string) error {
+	switch p.cfg.Tenant {
+	case MicrosoftTenantCommon:
+	case MicrosoftTenantConsumers:
+		if tenantID != microsoftPersonalTenantID {
+			return errors.ErrForbidden("Only personal Microsoft accounts are allowed").WithProvider("microsoft")
+		}
+	case MicrosoftTenantOrganizations:
+		if tenantID == microsoftPersonalTenantID {
+			return errors.ErrForbidden("Personal Microsoft accounts are not allowed").WithProvider("microsoft")
+		}
+	default:
+		// Single-tenant apps configured with a tenant ID. The issuer is built from the token's own tid,
+		// so nothing ties a tenant configured by domain to its tenant ID and only AllowedTenants can check it.
+		if isGUID(p.cfg.Tenant) {
+			if !strings.EqualFold(p.cfg.Tenant, tenantID) {
+				return errors.ErrForbidden("Tenant is not allowed").WithProvider("microsoft")
+			}
+		} else if len(p.cfg.AllowedTenants) == 0 {
+			return errors.ErrForbidden("Tenant configured by domain name requires AllowedTenants").WithProvider("microsoft")
+		}
+	}
+
+	if len(p.cfg.AllowedTenants) == 0 {
+		return nil
+	}
+	for _, allowed := range p.cfg.AllowedTenants {
+		if strings.EqualFold(allowed, tenantID) {
+			return nil
+		}
+	}
+	return errors.ErrForbidden("Tenant is not allowed").WithProvider("microsoft")
+}
+
+// fetchGroups lists the groups the user is a transitive member of, following Graph pagination
+func (p *MicrosoftProvider) fetchGroups(ctx context.Context, accessToken string) ([]string, error) {
+	var groups []string
+	next := p.graphURL + "/me/transitiveMemberOf/microsoft.graph.group?$select=id"
+	for next != "" {
+		req, err := http.NewRequestWithContext(ctx, "GET", next, nil)
+		if err != nil {
+			return nil, errors.ErrUnexpected("Failed to create request").WithCause(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+
+		resp, err := p.opts.httpClient.Do(req)
+		if err != nil {
+			return nil, errors.ErrUnexpected("Failed to get groups").WithProvider("microsoft").WithCause(err)
+		}
+
+		var page struct {
+			Value []struct {
+				ID string `json:"id"`
+			} `json:"value"`
+			NextLink string `json:"@odata.nextLink"`
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := statusError("Failed to get groups", "microsoft", resp)
+			resp.Body.Close()
+			return nil, err
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, errors.ErrUnexpected("Failed to decode groups").WithCause(err)
+		}
+
+		for _, g := range page.Value {
+			groups = append(groups, g.ID)
+		}
+		next = page.NextLink
+	}
+	return groups, nil
+}
+
+func (p *MicrosoftProvider) RefreshToken(ctx context.Context, refreshToken string) (*OAuthToken, error) {
+	tokenSource := p.config.TokenSource(p.opts.oauth2Context(ctx), &oauth2.Token{RefreshToken: refreshToken})
+	newToken, err := tokenSource.Token()
+	if err != nil {
+		return nil, errors.ErrUnauthorized("Failed to refresh token").WithProvider("microsoft").WithCause(err)
+	}
+
+	idToken, _ := newToken.Extra("id_token").(string)
+	return &OAuthToken{
+		AccessToken:  newToken.AccessToken,
+		RefreshToken: newToken.RefreshToken,
+		ExpiresIn:    newToken.Expiry.Unix(),
+		IDToken:      idToken,
+	}, nil
+}
+
+// isGUID reports whether s looks like a tenant ID rather than a domain name
+func isGUID(s string) bool {
+	_, err := ParseUUID(s)
+	return err == nil
+}
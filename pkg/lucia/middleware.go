@@ -1,6 +1,10 @@
 package lucia
 
 import (
+	"context"
+	stderrors "errors"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/Abraxas-365/toolkit/pkg/errors"
@@ -9,65 +13,246 @@ import (
 
 const SessionCookieName = "auth_session"
 
+// InvalidSessionMode is what the session middleware does with a request whose session was not found,
+// expired or was revoked
+type InvalidSessionMode int
+
+const (
+	// RejectInvalidSession answers 401 with a WWW-Authenticate challenge, it is the default
+	RejectInvalidSession InvalidSessionMode = iota
+	// IgnoreInvalidSession handles the request without a session
+	IgnoreInvalidSession
+	// RedirectInvalidSession sends browser navigations to the login URL with the requested path as return_to,
+	// API clients are still rejected with a 401
+	RedirectInvalidSession
+)
+
+// middlewareOptions holds the behavior of AuthMiddleware
+type middlewareOptions struct {
+	invalidSession InvalidSessionMode
+	loginURL       string
+	realm          string
+}
+
+// MiddlewareOption configures an AuthMiddleware
+type MiddlewareOption func(*middlewareOptions)
+
+// WithInvalidSession sets what happens to requests carrying an invalid session, see InvalidSessionMode.
+// The session cookie is cleared in every mode.
+func WithInvalidSession(mode InvalidSessionMode) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.invalidSession = mode
+	}
+}
+
+// WithLoginURL sets the login page browsers are redirected to, e.g. "/login/google" of AuthHandlers.
+// It also makes RequireAuth redirect browser navigations there instead of answering 401.
+func WithLoginURL(loginURL string) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.loginURL = loginURL
+	}
+}
+
+// WithRealm sets the realm of the WWW-Authenticate challenge
+func WithRealm(realm string) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.realm = realm
+	}
+}
+
 // AuthMiddleware creates a middleware that handles session validation and authentication
-type AuthMiddleware[U AuthUser] struct {
-	service *AuthService[U]
+type AuthMiddleware[U AuthUser[ID], ID UserID] struct {
+	service *AuthService[U, ID]
+	opts    middlewareOptions
 }
 
 // NewAuthMiddleware creates a new instance of AuthMiddleware
-func NewAuthMiddleware[U AuthUser](service *AuthService[U]) *AuthMiddleware[U] {
-	return &AuthMiddleware[U]{service: service}
+func NewAuthMiddleware[U AuthUser[ID], ID UserID](service *AuthService[U, ID], opts ...MiddlewareOption) *AuthMiddleware[U, ID] {
+	am := &AuthMiddleware[U, ID]{service: service}
+	for _, opt := range opts {
+		opt(&am.opts)
+	}
+	return am
 }
 
-// SessionMiddleware creates a middleware that validates the session
-func (am *AuthMiddleware[U]) SessionMiddleware() fiber.Handler {
+// SessionMiddleware is the Fiber adapter of the session middleware, see Handler for net/http.
+// Sessions that were not found, expired or were revoked are handled as configured with WithInvalidSession,
+// a failing session store answers 503 and keeps the cookie.
+func (am *AuthMiddleware[U, ID]) SessionMiddleware() fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		// Get the session ID from the cookie
-		sessionID := c.Cookies(SessionCookieName)
-		if sessionID == "" {
-			// If no session ID is provided, continue without setting the session
+		c.SetUserContext(WithRequestMeta(c.UserContext(), RequestMeta{
+			IP:        c.IP(),
+			UserAgent: c.Get(fiber.HeaderUserAgent),
+		}))
+
+		session, failure := am.authenticate(c.UserContext(), sessionRequest{
+			cookie:        c.Cookies(SessionCookieName),
+			authorization: c.Get(fiber.HeaderAuthorization),
+			accept:        c.Get(fiber.HeaderAccept),
+			requestedWith: c.Get(fiber.HeaderXRequestedWith),
+			requestURI:    c.OriginalURL(),
+		})
+		if session != nil {
+			// Store the valid session in the context for later use
+			c.Locals("session", session)
+			c.SetUserContext(ContextWithSession(c.UserContext(), session))
 			return c.Next()
 		}
 
-		// Validate the session
-		session, err := am.service.GetSession(c.Context(), sessionID)
-		if err != nil {
-			// If there's an error, clear the invalid session cookie
+		if failure.clearCookie {
 			c.ClearCookie(SessionCookieName)
-
-			// Check if it's a "not found" error and return ErrUnauthorized
-			if errors.IsLuciaError(err) {
-				luciaErr := err.(errors.LuciaError)
-				if luciaErr.Type == "UserSessionNotFound" {
-					return errors.ErrUnauthorized("Session not found")
-				}
+		}
+		switch {
+		case failure.redirect != "":
+			return c.Redirect(failure.redirect)
+		case failure.err != nil:
+			if failure.challenge != "" {
+				c.Set(fiber.HeaderWWWAuthenticate, failure.challenge)
 			}
-
-			// For other errors, continue without setting the session
-			return c.Next()
+			return failure.err
 		}
+		// Continue without setting the session
+		return c.Next()
+	}
+}
 
-		// If the session is valid, store it in the context for later use
-		c.Locals("session", session)
+// sessionRequest is what the session middleware reads from a request, every framework adapter fills it in
+type sessionRequest struct {
+	cookie        string
+	authorization string
+	accept        string
+	requestedWith string
+	requestURI    string
+}
 
+// authenticate is the session lookup shared by every framework adapter. It resolves the cookie or bearer
+// credential of req to a session, or else returns how to answer the request; a zero sessionFailure
+// continues without a session.
+func (am *AuthMiddleware[U, ID]) authenticate(ctx context.Context, req sessionRequest) (*Session[ID], sessionFailure) {
+	sessionID, fromCookie := sessionCredential(req.cookie, req.authorization)
+	if sessionID == "" {
+		return nil, sessionFailure{}
+	}
+	session, err := am.service.GetSession(ctx, sessionID)
+	if err != nil {
+		browser := isBrowserRequest(req.accept, req.requestedWith)
+		return nil, am.invalidSession(err, fromCookie, browser, req.requestURI)
+	}
+	return session, sessionFailure{}
+}
+
+// sessionCredential returns the session ID of the cookie, or else of an "Authorization: Bearer" header
+// as sent by devices logged in through the device flow
+func sessionCredential(cookie, authorization string) (sessionID string, fromCookie bool) {
+	if cookie != "" {
+		return cookie, true
+	}
+	if len(authorization) > 7 && strings.EqualFold(authorization[:7], "Bearer ") {
+		return strings.TrimSpace(authorization[7:]), false
+	}
+	return "", false
+}
+
+// sessionFailure is how an adapter answers a request whose session failed validation,
+// it continues without a session when neither redirect nor err is set
+type sessionFailure struct {
+	clearCookie bool
+	redirect    string
+	err         error
+	challenge   string
+}
+
+// invalidSession decides the answer to a GetSession error for every framework adapter
+func (am *AuthMiddleware[U, ID]) invalidSession(err error, fromCookie, browser bool, requestURI string) sessionFailure {
+	outcome := SessionErrorType(err)
+	switch {
+	case outcome == SessionNotFound && !fromCookie:
+		// Unknown bearer tokens may belong to another scheme (e.g. IdentityProvider access tokens)
+		return sessionFailure{}
+	case outcome == SessionStoreUnavailable || outcome == "":
+		// The session may well be valid, keep the cookie and let the client retry
+		return sessionFailure{err: errors.NewLuciaError(SessionStoreUnavailable, "Session store unavailable").WithCause(err)}
+	}
+
+	failure := sessionFailure{clearCookie: fromCookie}
+	switch {
+	case am.opts.invalidSession == IgnoreInvalidSession:
+	case am.opts.invalidSession == RedirectInvalidSession && fromCookie && browser && am.opts.loginURL != "":
+		failure.redirect = loginRedirect(am.opts.loginURL, requestURI, nil)
+	default:
+		var le errors.LuciaError
+		stderrors.As(err, &le)
+		failure.err = errors.ErrUnauthorized(le.Message).WithCause(err)
+		failure.challenge = am.challenge("invalid_token", le.Message)
+	}
+	return failure
+}
+
+// challenge builds an RFC 6750 WWW-Authenticate header, errorCode and description are optional and extra
+// holds further key="value" parameters
+func (am *AuthMiddleware[U, ID]) challenge(errorCode, description string, extra ...string) string {
+	var params []string
+	if am.opts.realm != "" {
+		params = append(params, `realm="`+am.opts.realm+`"`)
+	}
+	if errorCode != "" {
+		params = append(params, `error="`+errorCode+`"`, `error_description="`+description+`"`)
+	}
+	params = append(params, extra...)
+	if len(params) == 0 {
+		return "Bearer"
+	}
+	return "Bearer " + strings.Join(params, ", ")
+}
+
+// loginRedirect appends the path to come back to as the return_to parameter of loginURL, along with params
+// such as the prompt and max_age of a step-up
+func loginRedirect(loginURL, returnTo string, params url.Values) string {
+	query := url.Values{"return_to": {returnTo}}
+	for key, values := range params {
+		query[key] = values
+	}
+	separator := "?"
+	if strings.Contains(loginURL, "?") {
+		separator = "&"
+	}
+	return loginURL + separator + query.Encode()
+}
+
+// RequireAuth is a middleware that ensures a valid session exists. Without one, browser navigations are
+// redirected to the login URL when WithLoginURL is set, other requests get a 401.
+func (am *AuthMiddleware[U, ID]) RequireAuth() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		session := GetSession[ID](c)
+		if session == nil {
+			if am.opts.loginURL != "" && isBrowserRequest(c.Get(fiber.HeaderAccept), c.Get(fiber.HeaderXRequestedWith)) {
+				return c.Redirect(loginRedirect(am.opts.loginURL, c.OriginalURL(), nil))
+			}
+			c.Set(fiber.HeaderWWWAuthenticate, am.challenge("", ""))
+			return errors.ErrUnauthorized("Authentication required")
+		}
 		return c.Next()
 	}
 }
 
-// RequireAuth is a middleware that ensures a valid session exists
-func (am *AuthMiddleware[U]) RequireAuth() fiber.Handler {
+// RequireScope is a middleware that ensures the session may use scope, e.g. to keep impersonation sessions
+// away from sensitive routes. It must run after SessionMiddleware.
+func (am *AuthMiddleware[U, ID]) RequireScope(scope string) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		session := GetSession(c)
+		session := GetSession[ID](c)
 		if session == nil {
 			return errors.ErrUnauthorized("Authentication required")
 		}
+		if !session.HasScope(scope) {
+			return errors.ErrForbidden("Insufficient scope")
+		}
 		return c.Next()
 	}
 }
 
 // GetSession retrieves the validated session from the context
-func GetSession(c *fiber.Ctx) *Session {
-	session, ok := c.Locals("session").(*Session)
+func GetSession[ID UserID](c *fiber.Ctx) *Session[ID] {
+	session, ok := c.Locals("session").(*Session[ID])
 	if !ok {
 		return nil
 	}
@@ -75,7 +260,7 @@ func GetSession(c *fiber.Ctx) *Session {
 }
 
 // SetSessionCookie sets the session cookie
-func SetSessionCookie(c *fiber.Ctx, session *Session) {
+func SetSessionCookie[ID UserID](c *fiber.Ctx, session *Session[ID]) {
 	c.Cookie(&fiber.Cookie{
 		Name:     SessionCookieName,
 		Value:    session.ID,
@@ -0,0 +1,52 @@
+package lucia
+
+import (
+	stderrors "errors"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// oauthErrorCodes maps the LuciaError types of the OAuth endpoints to their RFC 6749 and RFC 8628 error codes
+var oauthErrorCodes = map[string]string{
+	"InvalidRequest":       "invalid_request",
+	"InvalidClient":        "invalid_client",
+	"InvalidGrant":         "invalid_grant",
+	"UnsupportedGrantType": "unsupported_grant_type",
+	"AuthorizationPending": "authorization_pending",
+	"SlowDown":             "slow_down",
+	"AccessDenied":         "access_denied",
+	"ExpiredToken":         "expired_token",
+}
+
+// oauthError creates the LuciaError of an RFC 6749 error code, unknown codes keep the code as type
+func oauthError(code, description string) errors.LuciaError {
+	for errType, c := range oauthErrorCodes {
+		if c == code {
+			return errors.NewLuciaError(errType, description)
+		}
+	}
+	return errors.NewLuciaError(code, description)
+}
+
+// writeOAuthError writes err as an RFC 6749 error response, errors that are not OAuth errors go to the error handler
+func writeOAuthError(c *fiber.Ctx, err error) error {
+	var luciaErr errors.LuciaError
+	if !stderrors.As(err, &luciaErr) {
+		return err
+	}
+	code, ok := oauthErrorCodes[luciaErr.Type]
+	if !ok {
+		return err
+	}
+
+	status := fiber.StatusBadRequest
+	if code == "invalid_client" {
+		status = fiber.StatusUnauthorized
+	}
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Status(status).JSON(fiber.Map{
+		"error":             code,
+		"error_description": luciaErr.Message,
+	})
+}
@@ -0,0 +1,733 @@
+package lucia
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Built-in organization roles, applications may use any other role name
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// Organization is a tenant, Slug doubles as its subdomain
+type Organization struct {
+	ID   string
+	Name string
+	Slug string
+	// Domains are the email domains claimed by the organization
+	Domains []string
+	// VerifiedDomains are the claimed domains an administrator confirmed the organization owns, see VerifyDomain.
+	// Only their verified users join automatically, see EnableDomainAutoJoin.
+	VerifiedDomains []string
+	CreatedAt       time.Time
+}
+
+// Membership gives a user a role in an organization
+type Membership struct {
+	OrgID string
+	// UserID is the encoded ID (see IDCodec) of the member
+	UserID    string
+	Role      string
+	CreatedAt time.Time
+}
+
+// Invitation lets the holder of its token join an organization, the token itself is only stored hashed
+type Invitation struct {
+	TokenHash string
+	OrgID     string
+	// Email restricts the invitation to one address, empty lets anyone holding the token accept it
+	Email string
+	Role  string
+	// InvitedBy is the encoded ID of the inviting user
+	InvitedBy string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// OrganizationStore persists organizations, memberships and invitations
+type OrganizationStore interface {
+	// CreateOrganization stores the organization together with the membership of its owner, both or neither
+	CreateOrganization(ctx context.Context, org *Organization, owner *Membership) error
+	GetOrganization(ctx context.Context, orgID string) (*Organization, error)
+	GetOrganizationBySlug(ctx context.Context, slug string) (*Organization, error)
+	// GetOrganizationByDomain returns the organization that verified domain, claims alone do not match
+	GetOrganizationByDomain(ctx context.Context, domain string) (*Organization, error)
+	// VerifyDomain marks a domain claimed by the organization as verified, it returns a NotFound error if the
+	// organization did not claim the domain
+	VerifyDomain(ctx context.Context, orgID, domain string) error
+	DeleteOrganization(ctx context.Context, orgID string) error
+
+	// AddMembership returns a Conflict error if the user is already a member
+	AddMembership(ctx context.Context, membership *Membership) error
+	GetMembership(ctx context.Context, orgID, userID string) (*Membership, error)
+	// UpdateMembershipRole and RemoveMembership return a Conflict error instead of leaving the organization
+	// without an owner, the check and the change must be atomic
+	UpdateMembershipRole(ctx context.Context, orgID, userID, role string) error
+	RemoveMembership(ctx context.Context, orgID, userID string) error
+	// ListMemberships returns the memberships of a user
+	ListMemberships(ctx context.Context, userID string) ([]Membership, error)
+	// ListMembers returns the memberships of an organization
+	ListMembers(ctx context.Context, orgID string) ([]Membership, error)
+
+	CreateInvitation(ctx context.Context, invitation *Invitation) error
+	// ConsumeInvitation deletes and returns the invitation, so a token can only be accepted once
+	ConsumeInvitation(ctx context.Context, tokenHash string) (*Invitation, error)
+}
+
+// Organizations manages the organizations of an AuthService and resolves the tenant of requests
+type Organizations[U AuthUser[ID], ID UserID] struct {
+	service *AuthService[U, ID]
+	store   OrganizationStore
+}
+
+func NewOrganizations[U AuthUser[ID], ID UserID](service *AuthService[U, ID], store OrganizationStore) *Organizations[U, ID] {
+	return &Organizations[U, ID]{
+		service: service,
+		store:   store,
+	}
+}
+
+// CreateOrganization creates an organization owned by ownerID. The domains are only claimed,
+// users of a domain join automatically once it is verified with VerifyDomain.
+func (o *Organizations[U, ID]) CreateOrganization(ctx context.Context, ownerID ID, name, slug string, domains []string) (*Organization, error) {
+	slug = strings.ToLower(slug)
+	if !slugPattern.MatchString(slug) {
+		return nil, errors.ErrBadRequest("Slug must be a valid subdomain label")
+	}
+	claimed := make([]string, len(domains))
+	for i, domain := range domains {
+		claimed[i] = strings.ToLower(domain)
+	}
+
+	now := time.Now()
+	org := &Organization{
+		ID:        NewULID().String(),
+		Name:      name,
+		Slug:      slug,
+		Domains:   claimed,
+		CreatedAt: now,
+	}
+	owner := &Membership{
+		OrgID:     org.ID,
+		UserID:    o.service.codec.Encode(ownerID),
+		Role:      RoleOwner,
+		CreatedAt: now,
+	}
+	if err := o.store.CreateOrganization(ctx, org, owner); err != nil {
+		return nil, err
+	}
+	return org, nil
+}
+
+// VerifyDomain confirms that the organization owns a domain it claimed, e.g. after an administrator checked
+// a DNS TXT record, so that EnableDomainAutoJoin applies to it. A domain can only be verified by one organization.
+func (o *Organizations[U, ID]) VerifyDomain(ctx context.Context, orgID, domain string) error {
+	return o.store.VerifyDomain(ctx, orgID, strings.ToLower(domain))
+}
+
+func (o *Organizations[U, ID]) GetOrganization(ctx context.Context, orgID string) (*Organization, error) {
+	return o.store.GetOrganization(ctx, orgID)
+}
+
+// DeleteOrganization deletes an organization with its memberships and invitations
+func (o *Organizations[U, ID]) DeleteOrganization(ctx context.Context, orgID string) error {
+	return o.store.DeleteOrganization(ctx, orgID)
+}
+
+// AddMember gives userID role in the organization
+func (o *Organizations[U, ID]) AddMember(ctx context.Context, orgID string, userID ID, role string) (*Membership, error) {
+	if role == "" {
+		return nil, errors.ErrBadRequest("Role is required")
+	}
+	membership := &Membership{
+		OrgID:     orgID,
+		UserID:    o.service.codec.Encode(userID),
+		Role:      role,
+		CreatedAt: time.Now(),
+	}
+	if err := o.store.AddMembership(ctx, membership); err != nil {
+		return nil, err
+	}
+	return membership, nil
+}
+
+// GetMembership returns the membership of userID, or a NotFound error if the user is not a member
+func (o *Organizations[U, ID]) GetMembership(ctx context.Context, orgID string, userID ID) (*Membership, error) {
+	return o.store.GetMembership(ctx, orgID, o.service.codec.Encode(userID))
+}
+
+// ListMemberships returns the organizations userID belongs to
+func (o *Organizations[U, ID]) ListMemberships(ctx context.Context, userID ID) ([]Membership, error) {
+	return o.store.ListMemberships(ctx, o.service.codec.Encode(userID))
+}
+
+func (o *Organizations[U, ID]) ListMembers(ctx context.Context, orgID string) ([]Membership, error) {
+	return o.store.ListMembers(ctx, orgID)
+}
+
+// SetRole changes the role of a member, the last owner cannot be demoted
+func (o *Organizations[U, ID]) SetRole(ctx context.Context, orgID string, userID ID, role string) error {
+	if role == "" {
+		return errors.ErrBadRequest("Role is required")
+	}
+	return o.store.UpdateMembershipRole(ctx, orgID, o.service.codec.Encode(userID), role)
+}
+
+// RemoveMember removes userID from the organization, the last owner cannot be removed
+func (o *Organizations[U, ID]) RemoveMember(ctx context.Context, orgID string, userID ID) error {
+	return o.store.RemoveMembership(ctx, orgID, o.service.codec.Encode(userID))
+}
+
+// Invite creates an invitation to join the organization with role and returns its token, to be sent to email.
+// The invitation expires after ttl, 7 days by default.
+func (o *Organizations[U, ID]) Invite(ctx context.Context, orgID string, invitedBy ID, email, role string, ttl time.Duration) (string, *Invitation, error) {
+	if role == "" {
+		return "", nil, errors.ErrBadRequest("Role is required")
+	}
+	if ttl <= 0 {
+		ttl = 7 * 24 * time.Hour
+	}
+
+	token := GenerateID() + GenerateID()
+	invitation := &Invitation{
+		TokenHash: hashToken(token),
+		OrgID:     orgID,
+		Email:     strings.ToLower(email),
+		Role:      role,
+		InvitedBy: o.service.codec.Encode(invitedBy),
+		ExpiresAt: time.Now().Add(ttl),
+		CreatedAt: time.Now(),
+	}
+	if err := o.store.CreateInvitation(ctx, invitation); err != nil {
+		return "", nil, err
+	}
+	return token, invitation, nil
+}
+
+// AcceptInvitation makes userID a member through an invitation token. email is the verified address of the user,
+// it must match the invitation unless the invitation was not sent to a specific address.
+// Tokens are single use, a rejected attempt consumes the invitation too.
+func (o *Organizations[U, ID]) AcceptInvitation(ctx context.Context, token string, userID ID, email string) (*Membership, error) {
+	invitation, err := o.store.ConsumeInvitation(ctx, hashToken(token))
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, errors.ErrNotFound("Invitation not found")
+		}
+		return nil, err
+	}
+	if time.Now().After(invitation.ExpiresAt) {
+		return nil, errors.ErrBadRequest("Invitation has expired")
+	}
+	if invitation.Email != "" && !strings.EqualFold(invitation.Email, email) {
+		return nil, errors.ErrForbidden("Invitation was sent to another email address")
+	}
+	return o.AddMember(ctx, invitation.OrgID, userID, invitation.Role)
+}
+
+// SetActiveOrganization switches the organization of a session, an empty orgID clears it
+func (o *Organizations[U, ID]) SetActiveOrganization(ctx context.Context, sessionID, orgID string) (*Session[ID], error) {
+	session, err := o.service.GetSession(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if orgID != "" {
+		if _, err := o.GetMembership(ctx, orgID, session.UserID); err != nil {
+			if errors.IsNotFound(err) {
+				return nil, errors.ErrForbidden("Not a member of the organization")
+			}
+			return nil, err
+		}
+	}
+
+	session.ActiveOrgID = orgID
+	if err := o.service.sessionStore.UpdateSession(ctx, session); err != nil {
+		return nil, errors.NewLuciaError("DatabaseError", "Failed to update session").WithCause(err).WithOp("UpdateSession")
+	}
+	return session, nil
+}
+
+// EnableDomainAutoJoin adds users logging in with a verified email to the organization that verified the email's
+// domain, with role. The organization becomes the active one of the new session unless another was set.
+// Failures do not fail the login, they are passed to onError which may be nil.
+func (o *Organizations[U, ID]) EnableDomainAutoJoin(role string, onError func(ctx context.Context, err error)) {
+	o.service.Hooks().OnLogin(func(ctx context.Context, event LoginEvent[U, ID]) {
+		if err := o.autoJoin(ctx, event, role); err != nil && onError != nil {
+			onError(ctx, err)
+		}
+	})
+}
+
+func (o *Organizations[U, ID]) autoJoin(ctx context.Context, event LoginEvent[U, ID], role string) error {
+	if event.UserInfo == nil || !event.UserInfo.EmailVerified {
+		return nil
+	}
+	at := strings.LastIndexByte(event.UserInfo.Email, '@')
+	if at < 0 {
+		return nil
+	}
+	org, err := o.store.GetOrganizationByDomain(ctx, strings.ToLower(event.UserInfo.Email[at+1:]))
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	userID := event.User.GetID()
+	if _, err := o.GetMembership(ctx, org.ID, userID); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		if _, err := o.AddMember(ctx, org.ID, userID, role); err != nil && !errors.IsConflict(err) {
+			return err
+		}
+	}
+
+	if event.Session != nil && event.Session.ActiveOrgID == "" {
+		event.Session.ActiveOrgID = org.ID
+		return o.service.sessionStore.UpdateSession(ctx, event.Session)
+	}
+	return nil
+}
+
+// TenantSource is a place the tenant of a request is read from
+type TenantSource string
+
+const (
+	// TenantFromSubdomain reads the organization slug from the subdomain of TenantConfig.BaseDomain
+	TenantFromSubdomain TenantSource = "subdomain"
+	// TenantFromHeader reads the organization ID from TenantConfig.Header
+	TenantFromHeader TenantSource = "header"
+	// TenantFromSession uses the active organization of the session
+	TenantFromSession TenantSource = "session"
+)
+
+// TenantConfig configures the tenant middleware
+type TenantConfig struct {
+	// Sources are tried in order, the first one naming an organization wins.
+	// It defaults to subdomain, header, then session.
+	Sources []TenantSource
+	// BaseDomain is the domain the organization subdomains live under, e.g. "example.com"
+	BaseDomain string
+	// Header defaults to X-Organization-ID
+	Header string
+	// Required rejects requests without an organization
+	Required bool
+}
+
+type tenantContextKey struct{}
+
+type tenant struct {
+	org        *Organization
+	membership *Membership
+}
+
+// TenantMiddleware resolves the organization of the request and stores it in Locals, see GetOrganization.
+// When the request has a session the user must be a member, their membership is stored too.
+// It must run after SessionMiddleware.
+func (o *Organizations[U, ID]) TenantMiddleware(cfg TenantConfig) fiber.Handler {
+	cfg = cfg.withDefaults()
+	return func(c *fiber.Ctx) error {
+		t, err := o.resolveTenant(c.UserContext(), cfg, c.Hostname(), c.Get(cfg.Header), GetSession[ID](c))
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			return c.Next()
+		}
+		c.Locals("organization", t.org)
+		c.Locals("membership", t.membership)
+		c.SetUserContext(context.WithValue(c.UserContext(), tenantContextKey{}, t))
+		return c.Next()
+	}
+}
+
+// TenantHandler is the net/http counterpart of TenantMiddleware, it must run after Handler
+func (o *Organizations[U, ID]) TenantHandler(cfg TenantConfig) func(http.Handler) http.Handler {
+	cfg = cfg.withDefaults()
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host := r.Host
+			if i := strings.LastIndexByte(host, ':'); i >= 0 && !strings.HasSuffix(host, "]") {
+				host = host[:i]
+			}
+			t, err := o.resolveTenant(r.Context(), cfg, host, r.Header.Get(cfg.Header), SessionFromContext[ID](r.Context()))
+			if err != nil {
+				errors.HTTPErrorHandler(w, r, err)
+				return
+			}
+			if t != nil {
+				r = r.WithContext(context.WithValue(r.Context(), tenantContextKey{}, t))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (cfg TenantConfig) withDefaults() TenantConfig {
+	if len(cfg.Sources) == 0 {
+		cfg.Sources = []TenantSource{TenantFromSubdomain, TenantFromHeader, TenantFromSession}
+	}
+	if cfg.Header == "" {
+		cfg.Header = "X-Organization-ID"
+	}
+	cfg.BaseDomain = strings.ToLower(strings.TrimPrefix(cfg.BaseDomain, "."))
+	return cfg
+}
+
+// resolveTenant returns a nil tenant when no source names an organization and none is required
+func (o *Organizations[U, ID]) resolveTenant(ctx context.Context, cfg TenantConfig, host, header string, session *Session[ID]) (*tenant, error) {
+	var org *Organization
+	var err error
+	for _, source := range cfg.Sources {
+		switch source {
+		case TenantFromSubdomain:
+			if slug := subdomain(strings.ToLower(host), cfg.BaseDomain); slug != "" {
+				org, err = o.store.GetOrganizationBySlug(ctx, slug)
+			}
+		case TenantFromHeader:
+			if header != "" {
+				org, err = o.store.GetOrganization(ctx, header)
+			}
+		case TenantFromSession:
+			if session != nil && session.ActiveOrgID != "" {
+				org, err = o.store.GetOrganization(ctx, session.ActiveOrgID)
+			}
+		}
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return nil, errors.ErrNotFound("Organization not found")
+			}
+			return nil, err
+		}
+		if org != nil {
+			break
+		}
+	}
+
+	if org == nil {
+		if cfg.Required {
+			return nil, errors.ErrBadRequest("Organization required")
+		}
+		return nil, nil
+	}
+
+	t := &tenant{org: org}
+	if session != nil {
+		t.membership, err = o.GetMembership(ctx, org.ID, session.UserID)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return nil, errors.ErrForbidden("Not a member of the organization")
+			}
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// subdomain returns the single label host has under baseDomain, "" if host is not such a subdomain
+func subdomain(host, baseDomain string) string {
+	if baseDomain == "" || !strings.HasSuffix(host, "."+baseDomain) {
+		return ""
+	}
+	label := strings.TrimSuffix(host, "."+baseDomain)
+	if strings.Contains(label, ".") {
+		return ""
+	}
+	return label
+}
+
+// RequireRole is a middleware that ensures the user has one of roles in the organization of the request.
+// It must run after TenantMiddleware.
+func (o *Organizations[U, ID]) RequireRole(roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := checkRole(GetMembership(c), roles); err != nil {
+			return err
+		}
+		return c.Next()
+	}
+}
+
+// RequireRoleHandler is the net/http counterpart of RequireRole
+func (o *Organizations[U, ID]) RequireRoleHandler(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := checkRole(MembershipFromContext(r.Context()), roles); err != nil {
+				errors.HTTPErrorHandler(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func checkRole(membership *Membership, roles []string) error {
+	if membership == nil {
+		return errors.ErrForbidden("Organization membership required")
+	}
+	for _, role := range roles {
+		if membership.Role == role {
+			return nil
+		}
+	}
+	return errors.ErrForbidden("Insufficient role")
+}
+
+// GetOrganization retrieves the organization resolved by TenantMiddleware
+func GetOrganization(c *fiber.Ctx) *Organization {
+	org, _ := c.Locals("organization").(*Organization)
+	return org
+}
+
+// GetMembership retrieves the membership resolved by TenantMiddleware, nil for anonymous requests
+func GetMembership(c *fiber.Ctx) *Membership {
+	membership, _ := c.Locals("membership").(*Membership)
+	return membership
+}
+
+// OrganizationFromContext retrieves the organization resolved by the tenant middleware from ctx
+func OrganizationFromContext(ctx context.Context) *Organization {
+	t, ok := ctx.Value(tenantContextKey{}).(*tenant)
+	if !ok {
+		return nil
+	}
+	return t.org
+}
+
+// MembershipFromContext retrieves the membership resolved by the tenant middleware from ctx
+func MembershipFromContext(ctx context.Context) *Membership {
+	t, ok := ctx.Value(tenantContextKey{}).(*tenant)
+	if !ok {
+		return nil
+	}
+	return t.membership
+}
+
+// MemoryOrganizationStore is an in-process OrganizationStore
+type MemoryOrganizationStore struct {
+	mu          sync.Mutex
+	orgs        map[string]*Organization
+	memberships map[string]map[string]*Membership
+	invitations map[string]*Invitation
+}
+
+func NewMemoryOrganizationStore() *MemoryOrganizationStore {
+	return &MemoryOrganizationStore{
+		orgs:        make(map[string]*Organization),
+		memberships: make(map[string]map[string]*Membership),
+		invitations: make(map[string]*Invitation),
+	}
+}
+
+func (s *MemoryOrganizationStore) CreateOrganization(ctx context.Context, org *Organization, owner *Membership) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, o := range s.orgs {
+		if o.Slug == org.Slug {
+			return errors.ErrConflict("Organization slug already taken")
+		}
+		for _, domain := range o.Domains {
+			for _, d := range org.Domains {
+				if domain == d {
+					return errors.ErrConflict("Domain already claimed by another organization")
+				}
+			}
+		}
+	}
+	stored := *org
+	stored.Domains = append([]string(nil), org.Domains...)
+	stored.VerifiedDomains = nil
+	storedOwner := *owner
+	s.orgs[org.ID] = &stored
+	s.memberships[org.ID] = map[string]*Membership{owner.UserID: &storedOwner}
+	return nil
+}
+
+func (s *MemoryOrganizationStore) GetOrganization(ctx context.Context, orgID string) (*Organization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	org, ok := s.orgs[orgID]
+	if !ok {
+		return nil, errors.ErrNotFound("Organization not found")
+	}
+	o := *org
+	return &o, nil
+}
+
+func (s *MemoryOrganizationStore) GetOrganizationBySlug(ctx context.Context, slug string) (*Organization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, org := range s.orgs {
+		if org.Slug == slug {
+			o := *org
+			return &o, nil
+		}
+	}
+	return nil, errors.ErrNotFound("Organization not found")
+}
+
+func (s *MemoryOrganizationStore) GetOrganizationByDomain(ctx context.Context, domain string) (*Organization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, org := range s.orgs {
+		for _, d := range org.VerifiedDomains {
+			if d == domain {
+				o := *org
+				return &o, nil
+			}
+		}
+	}
+	return nil, errors.ErrNotFound("Organization not found")
+}
+
+func (s *MemoryOrganizationStore) VerifyDomain(ctx context.Context, orgID, domain string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	org, ok := s.orgs[orgID]
+	if !ok || !containsDomain(org.Domains, domain) {
+		return errors.ErrNotFound("Domain not claimed by the organization")
+	}
+	if !containsDomain(org.VerifiedDomains, domain) {
+		org.VerifiedDomains = append(append([]string(nil), org.VerifiedDomains...), domain)
+	}
+	return nil
+}
+
+func (s *MemoryOrganizationStore) DeleteOrganization(ctx context.Context, orgID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.orgs, orgID)
+	delete(s.memberships, orgID)
+	for hash, inv := range s.invitations {
+		if inv.OrgID == orgID {
+			delete(s.invitations, hash)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryOrganizationStore) AddMembership(ctx context.Context, membership *Membership) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	members, ok := s.memberships[membership.OrgID]
+	if !ok {
+		return errors.ErrNotFound("Organization not found")
+	}
+	if _, exists := members[membership.UserID]; exists {
+		return errors.ErrConflict("User is already a member")
+	}
+	stored := *membership
+	members[membership.UserID] = &stored
+	return nil
+}
+
+func (s *MemoryOrganizationStore) GetMembership(ctx context.Context, orgID, userID string) (*Membership, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	membership, ok := s.memberships[orgID][userID]
+	if !ok {
+		return nil, errors.ErrNotFound("Membership not found")
+	}
+	m := *membership
+	return &m, nil
+}
+
+func (s *MemoryOrganizationStore) UpdateMembershipRole(ctx context.Context, orgID, userID, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	membership, ok := s.memberships[orgID][userID]
+	if !ok {
+		return errors.ErrNotFound("Membership not found")
+	}
+	if role != RoleOwner {
+		if err := s.checkNotLastOwner(orgID, userID); err != nil {
+			return err
+		}
+	}
+	membership.Role = role
+	return nil
+}
+
+func (s *MemoryOrganizationStore) RemoveMembership(ctx context.Context, orgID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkNotLastOwner(orgID, userID); err != nil {
+		return err
+	}
+	delete(s.memberships[orgID], userID)
+	return nil
+}
+
+// checkNotLastOwner must be called with mu held
+func (s *MemoryOrganizationStore) checkNotLastOwner(orgID, userID string) error {
+	owners, isOwner := 0, false
+	for _, m := range s.memberships[orgID] {
+		if m.Role == RoleOwner {
+			owners++
+			isOwner = isOwner || m.UserID == userID
+		}
+	}
+	if isOwner && owners == 1 {
+		return errors.ErrConflict("An organization needs at least one owner")
+	}
+	return nil
+}
+
+func (s *MemoryOrganizationStore) ListMemberships(ctx context.Context, userID string) ([]Membership, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var memberships []Membership
+	for _, members := range s.memberships {
+		if m, ok := members[userID]; ok {
+			memberships = append(memberships, *m)
+		}
+	}
+	return memberships, nil
+}
+
+func (s *MemoryOrganizationStore) ListMembers(ctx context.Context, orgID string) ([]Membership, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	members := make([]Membership, 0, len(s.memberships[orgID]))
+	for _, m := range s.memberships[orgID] {
+		members = append(members, *m)
+	}
+	return members, nil
+}
+
+func (s *MemoryOrganizationStore) CreateInvitation(ctx context.Context, invitation *Invitation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for hash, inv := range s.invitations {
+		if now.After(inv.ExpiresAt) {
+			delete(s.invitations, hash)
+		}
+	}
+	stored := *invitation
+	s.invitations[invitation.TokenHash] = &stored
+	return nil
+}
+
+func (s *MemoryOrganizationStore) ConsumeInvitation(ctx context.Context, tokenHash string) (*Invitation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	invitation, ok := s.invitations[tokenHash]
+	if !ok {
+		return nil, errors.ErrNotFound("Invitation not found")
+	}
+	delete(s.invitations, tokenHash)
+	return invitation, nil
+}
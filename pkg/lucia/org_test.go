@@ -0,0 +1,112 @@
+package lucia
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+)
+
+func TestCreateOrganization(t *testing.T) {
+	ctx := context.Background()
+	orgs := NewOrganizations(newTestService(), NewMemoryOrganizationStore())
+
+	domains := []string{"Example.COM"}
+	org, err := orgs.CreateOrganization(ctx, "owner", "Example", "example", domains)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if domains[0] != "Example.COM" {
+		t.Errorf("caller's domains = %v, want them untouched", domains)
+	}
+	if len(org.Domains) != 1 || org.Domains[0] != "example.com" {
+		t.Errorf("Domains = %v, want [example.com]", org.Domains)
+	}
+	membership, err := orgs.GetMembership(ctx, org.ID, "owner")
+	if err != nil || membership.Role != RoleOwner {
+		t.Errorf("owner membership = %+v, %v, want role %q", membership, err, RoleOwner)
+	}
+
+	// A failed creation leaves neither an organization nor a membership behind
+	if _, err := orgs.CreateOrganization(ctx, "other", "Taken", "example", nil); !errors.IsConflict(err) {
+		t.Fatalf("duplicate slug: error = %v, want Conflict", err)
+	}
+	memberships, err := orgs.ListMemberships(ctx, "other")
+	if err != nil || len(memberships) != 0 {
+		t.Errorf("memberships after failed creation = %v, %v, want none", memberships, err)
+	}
+}
+
+func TestDomainAutoJoinRequiresVerifiedDomain(t *testing.T) {
+	ctx := context.Background()
+	service := newTestService()
+	orgs := NewOrganizations(service, NewMemoryOrganizationStore())
+	orgs.EnableDomainAutoJoin(RoleMember, func(ctx context.Context, err error) { t.Error(err) })
+
+	org, err := orgs.CreateOrganization(ctx, "owner", "Example", "example", []string{"example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	login := func(id string) *Session[string] {
+		session, err := service.HandleIdentity(ctx, "saml", &UserInfo{ID: id, Provider: "saml", Email: id + "@example.com", EmailVerified: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return session
+	}
+
+	if session := login("early"); session.ActiveOrgID != "" {
+		t.Errorf("unverified domain: ActiveOrgID = %q, want none", session.ActiveOrgID)
+	}
+	if _, err := orgs.GetMembership(ctx, org.ID, "saml:early"); !errors.IsNotFound(err) {
+		t.Errorf("unverified domain: membership error = %v, want NotFound", err)
+	}
+
+	if err := orgs.VerifyDomain(ctx, org.ID, "other.com"); !errors.IsNotFound(err) {
+		t.Errorf("VerifyDomain() of an unclaimed domain: error = %v, want NotFound", err)
+	}
+	if err := orgs.VerifyDomain(ctx, org.ID, "EXAMPLE.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if session := login("late"); session.ActiveOrgID != org.ID {
+		t.Errorf("verified domain: ActiveOrgID = %q, want %q", session.ActiveOrgID, org.ID)
+	}
+	if membership, err := orgs.GetMembership(ctx, org.ID, "saml:late"); err != nil || membership.Role != RoleMember {
+		t.Errorf("verified domain: membership = %+v, %v, want role %q", membership, err, RoleMember)
+	}
+}
+
+func TestLastOwnerIsKept(t *testing.T) {
+	ctx := context.Background()
+	orgs := NewOrganizations(newTestService(), NewMemoryOrganizationStore())
+	org, err := orgs.CreateOrganization(ctx, "owner", "Example", "example", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := orgs.AddMember(ctx, org.ID, "member", RoleMember); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		change  func() error
+		wantErr bool
+	}{
+		{name: "demote last owner", change: func() error { return orgs.SetRole(ctx, org.ID, "owner", RoleAdmin) }, wantErr: true},
+		{name: "remove last owner", change: func() error { return orgs.RemoveMember(ctx, org.ID, "owner") }, wantErr: true},
+		{name: "promote member", change: func() error { return orgs.SetRole(ctx, org.ID, "member", RoleOwner) }},
+		{name: "demote one of two owners", change: func() error { return orgs.SetRole(ctx, org.ID, "owner", RoleAdmin) }},
+		{name: "remove the remaining owner", change: func() error { return orgs.RemoveMember(ctx, org.ID, "member") }, wantErr: true},
+		{name: "remove non owner", change: func() error { return orgs.RemoveMember(ctx, org.ID, "owner") }},
+	}
+	for _, tt := range tests {
+		err := tt.change()
+		if tt.wantErr && !errors.IsConflict(err) {
+			t.Errorf("%s: error = %v, want Conflict", tt.name, err)
+		}
+		if !tt.wantErr && err != nil {
+			t.Errorf("%s: error = %v, want none", tt.name, err)
+		}
+	}
+}
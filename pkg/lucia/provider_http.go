@@ -0,0 +1,52 @@
+package lucia
+
+import (
+	"bytes"
+	"context"
+	stderrors "errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+)
+
+// maxErrorBody caps how much of an upstream error response is kept as the error cause
+const maxErrorBody = 1024
+
+// statusError builds the error for an unexpected upstream response, the response body becomes the cause
+func statusError(message, provider string, resp *http.Response) errors.ApiError {
+	err := errors.ErrUnauthorized(message).WithProvider(provider).WithStatusCode(resp.StatusCode)
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if detail := strings.TrimSpace(string(body)); detail != "" {
+		err = err.WithCause(stderrors.New(detail))
+	}
+	return err
+}
+
+// revokeToken posts an RFC 7009 revocation request. A token the provider rejects as invalid_token has expired
+// or was revoked already, which counts as revoked.
+func revokeToken(ctx context.Context, client *http.Client, revokeURL, provider string, form url.Values) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", revokeURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return errors.ErrUnexpected("Failed to create revocation request").WithCause(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.ErrUnexpected("Failed to revoke token").WithProvider(provider).WithCause(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if resp.StatusCode == http.StatusBadRequest && bytes.Contains(body, []byte(`"invalid_token"`)) {
+		return nil
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return statusError("Failed to revoke token", provider, resp)
+}
@@ -0,0 +1,109 @@
+package lucia
+
+import (
+	"context"
+	"net/http"
+
+	"golang.org/x/oauth2"
+)
+
+// providerOptions holds the overridable endpoints and HTTP client of an OAuth provider, empty fields use the provider defaults
+type providerOptions struct {
+	httpClient  *http.Client
+	baseURL     string
+	authURL     string
+	tokenURL    string
+	userInfoURL string
+	revokeURL   string
+	apiURL      string
+	jwksURL     string
+	issuer      string
+}
+
+// ProviderOption configures the endpoints and HTTP client of an OAuth provider
+type ProviderOption func(*providerOptions)
+
+// WithHTTPClient sets the HTTP client used for every call to the provider, e.g. to configure timeouts or a proxy
+func WithHTTPClient(client *http.Client) ProviderOption {
+	return func(o *providerOptions) {
+		o.httpClient = client
+	}
+}
+
+// WithBaseURL sets the host every default endpoint is derived from, e.g. a GitHub Enterprise Server,
+// a Microsoft authority or an httptest server
+func WithBaseURL(baseURL string) ProviderOption {
+	return func(o *providerOptions) {
+		o.baseURL = baseURL
+	}
+}
+
+// WithAuthURL overrides the authorization endpoint
+func WithAuthURL(authURL string) ProviderOption {
+	return func(o *providerOptions) {
+		o.authURL = authURL
+	}
+}
+
+// WithTokenURL overrides the token endpoint
+func WithTokenURL(tokenURL string) ProviderOption {
+	return func(o *providerOptions) {
+		o.tokenURL = tokenURL
+	}
+}
+
+// WithUserInfoURL overrides the user info endpoint
+func WithUserInfoURL(userInfoURL string) ProviderOption {
+	return func(o *providerOptions) {
+		o.userInfoURL = userInfoURL
+	}
+}
+
+// WithRevocationURL overrides the token revocation endpoint
+func WithRevocationURL(revokeURL string) ProviderOption {
+	return func(o *providerOptions) {
+		o.revokeURL = revokeURL
+	}
+}
+
+// WithAPIURL overrides the base URL of the provider REST API
+func WithAPIURL(apiURL string) ProviderOption {
+	return func(o *providerOptions) {
+		o.apiURL = apiURL
+	}
+}
+
+// WithJWKSURL overrides the endpoint serving the keys ID tokens are signed with
+func WithJWKSURL(jwksURL string) ProviderOption {
+	return func(o *providerOptions) {
+		o.jwksURL = jwksURL
+	}
+}
+
+// WithIssuer overrides the expected issuer of ID tokens
+func WithIssuer(issuer string) ProviderOption {
+	return func(o *providerOptions) {
+		o.issuer = issuer
+	}
+}
+
+func newProviderOptions(opts []ProviderOption) providerOptions {
+	o := providerOptions{httpClient: http.DefaultClient}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// or returns value unless it is empty, in which case it returns fallback
+func or(value, fallback string) string {
+	if value != "" {
+		return value
+	}
+	return fallback
+}
+
+// oauth2Context makes golang.org/x/oauth2 use the configured HTTP client
+func (o providerOptions) oauth2Context(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, o.httpClient)
+}
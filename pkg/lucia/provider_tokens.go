@@ -0,0 +1,199 @@
+package lucia
+
+import (
+	"context"
+	stderrors "errors"
+	"sync"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+)
+
+// ProviderToken is the latest token a user was granted by a provider. A provider grants an application once
+// per user, so there is one token per user and provider whatever the number of sessions.
+type ProviderToken struct {
+	// UserID is the encoded ID (see IDCodec) of the user
+	UserID       string
+	Provider     string
+	AccessToken  string
+	RefreshToken string
+	// ExpiresAt is when the access token expires as a Unix time, like OAuthToken.ExpiresIn
+	ExpiresAt int64
+	UpdatedAt time.Time
+}
+
+// ProviderTokenStore persists provider tokens, implementations should encrypt them at rest
+type ProviderTokenStore interface {
+	// SaveProviderToken stores the token of a user at a provider, replacing the previous one
+	SaveProviderToken(ctx context.Context, token *ProviderToken) error
+	GetProviderToken(ctx context.Context, userID, provider string) (*ProviderToken, error)
+	ListProviderTokens(ctx context.Context, userID string) ([]*ProviderToken, error)
+	DeleteProviderToken(ctx context.Context, userID, provider string) error
+}
+
+// ProviderTokenConfig configures how the AuthService keeps provider tokens
+type ProviderTokenConfig struct {
+	// Store receives the token of every OAuth login
+	Store ProviderTokenStore
+	// RevokeOnLogout revokes the grant of the provider a session logged in with when it logs out. The grant is
+	// shared by every session of the user at that provider, their provider tokens stop working too.
+	RevokeOnLogout bool
+}
+
+// SetProviderTokens keeps the provider token of every OAuth login, so that it can be revoked by Logout and
+// RevokeProviderTokens
+func (s *AuthService[U, ID]) SetProviderTokens(cfg ProviderTokenConfig) {
+	s.providerTokens = cfg
+}
+
+// saveProviderToken stores the token of a login, when a store is configured
+func (s *AuthService[U, ID]) saveProviderToken(ctx context.Context, userID ID, provider string, token *OAuthToken) error {
+	if s.providerTokens.Store == nil || token == nil {
+		return nil
+	}
+	err := s.providerTokens.Store.SaveProviderToken(ctx, &ProviderToken{
+		UserID:       s.codec.Encode(userID),
+		Provider:     provider,
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		ExpiresAt:    token.ExpiresIn,
+		UpdatedAt:    time.Now(),
+	})
+	if err != nil {
+		return errors.NewLuciaError("DatabaseError", "Failed to store provider token").WithCause(err).WithProvider(provider).WithOp("SaveProviderToken")
+	}
+	return nil
+}
+
+// RevokeProviderTokens revokes the grants of every provider the user logged in with and deletes their stored
+// tokens, e.g. when the user is deprovisioned. Providers that cannot revoke tokens, such as Microsoft, only
+// have the stored token deleted. Tokens that failed to be revoked are kept, so the call can be retried.
+func (s *AuthService[U, ID]) RevokeProviderTokens(ctx context.Context, userID ID) error {
+	if s.providerTokens.Store == nil {
+		return nil
+	}
+	tokens, err := s.providerTokens.Store.ListProviderTokens(ctx, s.codec.Encode(userID))
+	if err != nil {
+		return errors.NewLuciaError("DatabaseError", "Failed to list provider tokens").WithCause(err).WithOp("ListProviderTokens")
+	}
+	var errs []error
+	for _, token := range tokens {
+		if err := s.revokeProviderToken(ctx, userID, token); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errors.NewLuciaError("TokenRevocationError", "Failed to revoke provider tokens").WithCause(stderrors.Join(errs...))
+	}
+	return nil
+}
+
+// revokeLoggedOutToken revokes the grant of the provider a logged out session authenticated with, a failure
+// does not fail the logout and leaves the token for RevokeProviderTokens
+func (s *AuthService[U, ID]) revokeLoggedOutToken(ctx context.Context, session *Session[ID]) {
+	// An impersonation session carries the provider of the impersonator, not a grant of the user
+	if !s.providerTokens.RevokeOnLogout || s.providerTokens.Store == nil || session == nil || session.IsImpersonation() || session.AuthProvider == "" {
+		return
+	}
+	token, err := s.providerTokens.Store.GetProviderToken(ctx, s.codec.Encode(session.UserID), session.AuthProvider)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			runAfter(ctx, &s.hooks.mu, &s.hooks.onProviderTokenRevoked, ProviderTokenRevokedEvent[ID]{
+				UserID:   session.UserID,
+				Provider: session.AuthProvider,
+				Err:      errors.NewLuciaError("DatabaseError", "Failed to fetch provider token").WithCause(err).WithOp("GetProviderToken"),
+			})
+		}
+		return
+	}
+	_ = s.revokeProviderToken(ctx, session.UserID, token)
+}
+
+// revokeProviderToken revokes a stored token at its provider and deletes it once revoked
+func (s *AuthService[U, ID]) revokeProviderToken(ctx context.Context, userID ID, token *ProviderToken) error {
+	err := s.revokeAtProvider(ctx, token)
+	if err == nil {
+		if deleteErr := s.providerTokens.Store.DeleteProviderToken(ctx, token.UserID, token.Provider); deleteErr != nil && !errors.IsNotFound(deleteErr) {
+			err = errors.NewLuciaError("DatabaseError", "Failed to delete provider token").WithCause(deleteErr).WithProvider(token.Provider).WithOp("DeleteProviderToken")
+		}
+	}
+	runAfter(ctx, &s.hooks.mu, &s.hooks.onProviderTokenRevoked, ProviderTokenRevokedEvent[ID]{
+		UserID:   userID,
+		Provider: token.Provider,
+		Err:      err,
+	})
+	return err
+}
+
+func (s *AuthService[U, ID]) revokeAtProvider(ctx context.Context, token *ProviderToken) error {
+	p, ok := s.providers[token.Provider]
+	if !ok {
+		return errors.NewLuciaError("UnknownProvider", "Unknown OAuth provider "+token.Provider)
+	}
+	revoker, ok := p.(TokenRevoker)
+	if !ok {
+		return nil
+	}
+	err := revoker.RevokeToken(ctx, &OAuthToken{
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		ExpiresIn:    token.ExpiresAt,
+	})
+	if err != nil {
+		return providerError("TokenRevocationError", "Failed to revoke provider token", token.Provider, "RevokeToken", err)
+	}
+	return nil
+}
+
+// MemoryProviderTokenStore is an in-process ProviderTokenStore, tokens are kept in plain text in memory
+type MemoryProviderTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]map[string]*ProviderToken
+}
+
+func NewMemoryProviderTokenStore() *MemoryProviderTokenStore {
+	return &MemoryProviderTokenStore{tokens: make(map[string]map[string]*ProviderToken)}
+}
+
+func (s *MemoryProviderTokenStore) SaveProviderToken(ctx context.Context, token *ProviderToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tokens[token.UserID] == nil {
+		s.tokens[token.UserID] = make(map[string]*ProviderToken)
+	}
+	stored := *token
+	s.tokens[token.UserID][token.Provider] = &stored
+	return nil
+}
+
+func (s *MemoryProviderTokenStore) GetProviderToken(ctx context.Context, userID, provider string) (*ProviderToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.tokens[userID][provider]
+	if !ok {
+		return nil, errors.ErrNotFound("Provider token not found")
+	}
+	found := *token
+	return &found, nil
+}
+
+func (s *MemoryProviderTokenStore) ListProviderTokens(ctx context.Context, userID string) ([]*ProviderToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens := make([]*ProviderToken, 0, len(s.tokens[userID]))
+	for _, token := range s.tokens[userID] {
+		found := *token
+		tokens = append(tokens, &found)
+	}
+	return tokens, nil
+}
+
+func (s *MemoryProviderTokenStore) DeleteProviderToken(ctx context.Context, userID, provider string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tokens[userID][provider]; !ok {
+		return errors.ErrNotFound("Provider token not found")
+	}
+	delete(s.tokens[userID], provider)
+	return nil
+}
@@ -0,0 +1,103 @@
+package lucia_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+	"github.com/Abraxas-365/toolkit/pkg/lucia"
+	"github.com/Abraxas-365/toolkit/pkg/lucia/luciatest"
+	"github.com/gofiber/fiber/v2"
+)
+
+// githubLogin logs DefaultIdentity in with GitHub through the mock server and returns the service and user
+func githubLogin(t *testing.T, server *luciatest.Server, tokens lucia.ProviderTokenStore) (*lucia.AuthService[*luciatest.User, string], *luciatest.User) {
+	t.Helper()
+	users := luciatest.NewUserStore()
+	service := lucia.NewAuthService[*luciatest.User, string](users, luciatest.NewSessionStore[string]())
+	service.RegisterProvider("github", server.GitHubProvider("https://app.example.com/login/github/callback"))
+	service.SetProviderTokens(lucia.ProviderTokenConfig{Store: tokens})
+
+	app := fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+	lucia.NewAuthHandlers(service, lucia.HandlersConfig{}).Mount(app)
+	if _, err := luciatest.Login(app, "/login/github", server.Authorizer()); err != nil {
+		t.Fatal(err)
+	}
+	if len(users.Users()) != 1 {
+		t.Fatalf("users = %v, want one", users.Users())
+	}
+	return service, users.Users()[0]
+}
+
+func TestRevokeGitHubProviderTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		// prepare runs between the login and the revocation
+		prepare     func(server *luciatest.Server)
+		wantErr     bool
+		wantRevoked bool
+	}{
+		{
+			name:        "live token",
+			prepare:     func(server *luciatest.Server) {},
+			wantRevoked: true,
+		},
+		{
+			name:        "expired access token is refreshed",
+			prepare:     func(server *luciatest.Server) { server.ExpireTokens() },
+			wantRevoked: true,
+		},
+		{
+			name: "expired access token that cannot be refreshed",
+			prepare: func(server *luciatest.Server) {
+				server.ExpireTokens()
+				server.FailNext("/login/oauth/access_token", http.StatusInternalServerError, "unavailable")
+			},
+			wantErr: true,
+		},
+		{
+			name: "refreshed token rejected too",
+			prepare: func(server *luciatest.Server) {
+				server.ExpireTokens()
+				server.FailNext("/api/v3/applications/"+luciatest.ClientID+"/grant", http.StatusUnprocessableEntity, "Validation Failed")
+				server.FailNext("/api/v3/applications/"+luciatest.ClientID+"/grant", http.StatusUnprocessableEntity, "Validation Failed")
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			server := luciatest.NewServer()
+			defer server.Close()
+			tokens := lucia.NewMemoryProviderTokenStore()
+			service, user := githubLogin(t, server, tokens)
+
+			tt.prepare(server)
+			err := service.RevokeProviderTokens(ctx, user.ID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RevokeProviderTokens() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if revoked := server.Revoked(luciatest.DefaultIdentity.ID); revoked != tt.wantRevoked {
+				t.Errorf("grant revoked = %v, want %v", revoked, tt.wantRevoked)
+			}
+			// A failed revocation keeps the token so that it can be retried
+			_, getErr := tokens.GetProviderToken(ctx, user.ID, "github")
+			if kept := getErr == nil; kept != tt.wantErr {
+				t.Errorf("token kept = %v, want %v", kept, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRevokeGitHubTokenWithoutRefreshToken(t *testing.T) {
+	server := luciatest.NewServer()
+	defer server.Close()
+	provider := server.GitHubProvider("https://app.example.com/login/github/callback")
+
+	// OAuth app tokens do not expire, GitHub rejecting one means its grant is gone already
+	if err := provider.RevokeToken(context.Background(), &lucia.OAuthToken{AccessToken: "revoked"}); err != nil {
+		t.Errorf("RevokeToken() error = %v, want none", err)
+	}
+}
@@ -0,0 +1,263 @@
+package lucia
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RateLimitScope selects which policy a rate limit key is checked against
+type RateLimitScope string
+
+const (
+	RateLimitIP      RateLimitScope = "ip"
+	RateLimitAccount RateLimitScope = "account"
+)
+
+// RateLimitPolicy configures the token bucket and the lockout applied to a scope
+type RateLimitPolicy struct {
+	// Capacity is the number of attempts allowed in a burst
+	Capacity float64
+	// RefillEvery is how long it takes for one attempt to be given back
+	RefillEvery time.Duration
+	// MaxFailures is the number of consecutive failures before the key is locked out, 0 disables lockouts
+	MaxFailures int
+	// BaseLockout is doubled for every failure past MaxFailures, up to MaxLockout
+	BaseLockout time.Duration
+	MaxLockout  time.Duration
+	// FailureWindow resets the failure count once no failure has been recorded for that long
+	FailureWindow time.Duration
+}
+
+var (
+	DefaultIPRateLimitPolicy = RateLimitPolicy{
+		Capacity:      20,
+		RefillEvery:   3 * time.Second,
+		MaxFailures:   10,
+		BaseLockout:   time.Minute,
+		MaxLockout:    time.Hour,
+		FailureWindow: time.Hour,
+	}
+	DefaultAccountRateLimitPolicy = RateLimitPolicy{
+		Capacity:      10,
+		RefillEvery:   6 * time.Second,
+		MaxFailures:   5,
+		BaseLockout:   time.Minute,
+		MaxLockout:    time.Hour,
+		FailureWindow: time.Hour,
+	}
+)
+
+// RateLimitState is the persisted state of a single rate limit key
+type RateLimitState struct {
+	Tokens      float64
+	UpdatedAt   time.Time
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+// RateLimitStore persists rate limit counters, Update must apply fn atomically per key
+type RateLimitStore interface {
+	// Update loads the state for key (zero value if none), calls fn and persists the result when fn returns nil
+	Update(ctx context.Context, key string, fn func(state *RateLimitState) error) error
+	Delete(ctx context.Context, key string) error
+}
+
+// RateLimiter throttles auth attempts per IP and per account with a token bucket and exponential lockouts
+type RateLimiter struct {
+	store    RateLimitStore
+	policies map[RateLimitScope]RateLimitPolicy
+	now      func() time.Time
+}
+
+// RateLimiterOption configures a RateLimiter
+type RateLimiterOption func(*RateLimiter)
+
+// WithRateLimitPolicy overrides the policy of a scope
+func WithRateLimitPolicy(scope RateLimitScope, policy RateLimitPolicy) RateLimiterOption {
+	return func(rl *RateLimiter) {
+		rl.policies[scope] = policy
+	}
+}
+
+// NewRateLimiter creates a RateLimiter using the default IP and account policies unless overridden
+func NewRateLimiter(store RateLimitStore, opts ...RateLimiterOption) *RateLimiter {
+	rl := &RateLimiter{
+		store: store,
+		policies: map[RateLimitScope]RateLimitPolicy{
+			RateLimitIP:      DefaultIPRateLimitPolicy,
+			RateLimitAccount: DefaultAccountRateLimitPolicy,
+		},
+		now: time.Now,
+	}
+	for _, opt := range opts {
+		opt(rl)
+	}
+	return rl
+}
+
+func rateLimitKey(scope RateLimitScope, key string) string {
+	return string(scope) + ":" + key
+}
+
+// Allow consumes one attempt for key, it returns a TooManyRequests error if the key is locked out or out of attempts
+func (rl *RateLimiter) Allow(ctx context.Context, scope RateLimitScope, key string) error {
+	policy := rl.policies[scope]
+	now := rl.now()
+
+	var limited error
+	err := rl.store.Update(ctx, rateLimitKey(scope, key), func(state *RateLimitState) error {
+		limited = nil
+		if now.Before(state.LockedUntil) {
+			limited = errors.ErrTooManyRequests("Too many failed attempts, try again later", state.LockedUntil.Sub(now))
+			return nil
+		}
+
+		if state.UpdatedAt.IsZero() {
+			state.Tokens = policy.Capacity
+		} else if policy.RefillEvery > 0 {
+			state.Tokens += float64(now.Sub(state.UpdatedAt)) / float64(policy.RefillEvery)
+			if state.Tokens > policy.Capacity {
+				state.Tokens = policy.Capacity
+			}
+		}
+		state.UpdatedAt = now
+
+		if state.Tokens < 1 {
+			wait := time.Duration((1 - state.Tokens) * float64(policy.RefillEvery))
+			limited = errors.ErrTooManyRequests("Too many requests, try again later", wait)
+			return nil
+		}
+		state.Tokens--
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return limited
+}
+
+// RecordFailure counts a failed attempt for key and locks it out once the policy's failure budget is exhausted
+func (rl *RateLimiter) RecordFailure(ctx context.Context, scope RateLimitScope, key string) error {
+	policy := rl.policies[scope]
+	now := rl.now()
+
+	return rl.store.Update(ctx, rateLimitKey(scope, key), func(state *RateLimitState) error {
+		if policy.FailureWindow > 0 && now.Sub(state.LastFailure) > policy.FailureWindow {
+			state.Failures = 0
+		}
+		state.Failures++
+		state.LastFailure = now
+
+		if policy.MaxFailures > 0 && state.Failures >= policy.MaxFailures {
+			lockout := policy.BaseLockout
+			for i := policy.MaxFailures; i < state.Failures && lockout < policy.MaxLockout; i++ {
+				lockout *= 2
+			}
+			if policy.MaxLockout > 0 && lockout > policy.MaxLockout {
+				lockout = policy.MaxLockout
+			}
+			state.LockedUntil = now.Add(lockout)
+		}
+		return nil
+	})
+}
+
+// RecordSuccess clears the failure count and any lockout for key
+func (rl *RateLimiter) RecordSuccess(ctx context.Context, scope RateLimitScope, key string) error {
+	return rl.store.Update(ctx, rateLimitKey(scope, key), func(state *RateLimitState) error {
+		state.Failures = 0
+		state.LockedUntil = time.Time{}
+		return nil
+	})
+}
+
+// Reset removes every counter for key, e.g. after an admin unlocks an account
+func (rl *RateLimiter) Reset(ctx context.Context, scope RateLimitScope, key string) error {
+	return rl.store.Delete(ctx, rateLimitKey(scope, key))
+}
+
+// RateLimitMiddleware throttles a Fiber route per client IP, use it on login, callback and other auth endpoints
+func RateLimitMiddleware(rl *RateLimiter) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := rl.Allow(c.Context(), RateLimitIP, c.IP()); err != nil {
+			return err
+		}
+		return c.Next()
+	}
+}
+
+// RateLimitHandler is the net/http counterpart of RateLimitMiddleware
+func RateLimitHandler(rl *RateLimiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := rl.Allow(r.Context(), RateLimitIP, requestMetaFromHTTP(r).IP); err != nil {
+				errors.HTTPErrorHandler(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// MemoryRateLimitStore is an in-process RateLimitStore, counters are not shared across replicas
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	states  map[string]*RateLimitState
+	ttl     time.Duration
+	updates int
+}
+
+// NewMemoryRateLimitStore creates a MemoryRateLimitStore, keys idle for longer than ttl are evicted
+func NewMemoryRateLimitStore(ttl time.Duration) *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		states: make(map[string]*RateLimitState),
+		ttl:    ttl,
+	}
+}
+
+func (s *MemoryRateLimitStore) Update(ctx context.Context, key string, fn func(state *RateLimitState) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.updates++
+	if s.ttl > 0 && s.updates%1024 == 0 {
+		s.evict(time.Now())
+	}
+
+	state, ok := s.states[key]
+	if !ok {
+		state = &RateLimitState{}
+	}
+	updated := *state
+	if err := fn(&updated); err != nil {
+		return err
+	}
+	s.states[key] = &updated
+	return nil
+}
+
+func (s *MemoryRateLimitStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.states, key)
+	return nil
+}
+
+// evict drops idle keys that are not locked out, it must be called with the lock held
+func (s *MemoryRateLimitStore) evict(now time.Time) {
+	for key, state := range s.states {
+		last := state.UpdatedAt
+		if state.LastFailure.After(last) {
+			last = state.LastFailure
+		}
+		if now.Sub(last) > s.ttl && now.After(state.LockedUntil) {
+			delete(s.states, key)
+		}
+	}
+}
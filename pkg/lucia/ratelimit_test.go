@@ -0,0 +1,193 @@
+package lucia
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// newTestRateLimiter returns a RateLimiter whose clock only moves through the returned advance func
+func newTestRateLimiter(policy RateLimitPolicy) (*RateLimiter, func(time.Duration)) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rl := NewRateLimiter(NewMemoryRateLimitStore(time.Hour), WithRateLimitPolicy(RateLimitAccount, policy))
+	rl.now = func() time.Time { return now }
+	return rl, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestRateLimiterTokenBucket(t *testing.T) {
+	ctx := context.Background()
+	rl, advance := newTestRateLimiter(RateLimitPolicy{Capacity: 3, RefillEvery: time.Second})
+
+	for i := 0; i < 3; i++ {
+		if err := rl.Allow(ctx, RateLimitAccount, "alice"); err != nil {
+			t.Fatalf("attempt %d: Allow() error = %v", i+1, err)
+		}
+	}
+	err := rl.Allow(ctx, RateLimitAccount, "alice")
+	if !errors.IsTooManyRequests(err) {
+		t.Fatalf("Allow() past capacity error = %v, want TooManyRequests", err)
+	}
+	if retryAfter := err.(errors.ApiError).RetryAfter; retryAfter != time.Second {
+		t.Errorf("RetryAfter = %v, want %v", retryAfter, time.Second)
+	}
+	if err := rl.Allow(ctx, RateLimitAccount, "bob"); err != nil {
+		t.Errorf("Allow() for another key error = %v", err)
+	}
+
+	advance(time.Second)
+	if err := rl.Allow(ctx, RateLimitAccount, "alice"); err != nil {
+		t.Errorf("Allow() after a refill error = %v", err)
+	}
+	if err := rl.Allow(ctx, RateLimitAccount, "alice"); !errors.IsTooManyRequests(err) {
+		t.Errorf("Allow() after the refilled attempt error = %v, want TooManyRequests", err)
+	}
+
+	// The bucket never holds more than its capacity however long the key was idle
+	advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		rl.Allow(ctx, RateLimitAccount, "alice")
+	}
+	if err := rl.Allow(ctx, RateLimitAccount, "alice"); !errors.IsTooManyRequests(err) {
+		t.Errorf("Allow() after an idle hour error = %v, want TooManyRequests", err)
+	}
+}
+
+func TestRateLimiterLockout(t *testing.T) {
+	policy := RateLimitPolicy{
+		Capacity:      100,
+		RefillEvery:   time.Second,
+		MaxFailures:   3,
+		BaseLockout:   time.Minute,
+		MaxLockout:    5 * time.Minute,
+		FailureWindow: time.Hour,
+	}
+	tests := []struct {
+		name     string
+		failures int
+		// between runs once the failures are recorded
+		between     func(ctx context.Context, rl *RateLimiter, advance func(time.Duration))
+		wantLockout time.Duration
+	}{
+		{name: "under the budget", failures: 2},
+		{name: "budget exhausted", failures: 3, wantLockout: time.Minute},
+		{name: "lockout doubles", failures: 4, wantLockout: 2 * time.Minute},
+		{name: "lockout is capped", failures: 10, wantLockout: 5 * time.Minute},
+		{
+			name:     "success clears the failures",
+			failures: 3,
+			between: func(ctx context.Context, rl *RateLimiter, advance func(time.Duration)) {
+				rl.RecordSuccess(ctx, RateLimitAccount, "alice")
+				rl.RecordFailure(ctx, RateLimitAccount, "alice")
+			},
+		},
+		{
+			name:     "failures outside the window are forgotten",
+			failures: 2,
+			between: func(ctx context.Context, rl *RateLimiter, advance func(time.Duration)) {
+				advance(2 * time.Hour)
+				rl.RecordFailure(ctx, RateLimitAccount, "alice")
+			},
+		},
+		{
+			name:     "reset unlocks",
+			failures: 5,
+			between: func(ctx context.Context, rl *RateLimiter, advance func(time.Duration)) {
+				rl.Reset(ctx, RateLimitAccount, "alice")
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			rl, advance := newTestRateLimiter(policy)
+			for i := 0; i < tt.failures; i++ {
+				if err := rl.RecordFailure(ctx, RateLimitAccount, "alice"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.between != nil {
+				tt.between(ctx, rl, advance)
+			}
+
+			err := rl.Allow(ctx, RateLimitAccount, "alice")
+			if tt.wantLockout == 0 {
+				if err != nil {
+					t.Fatalf("Allow() error = %v, want none", err)
+				}
+				return
+			}
+			if !errors.IsTooManyRequests(err) {
+				t.Fatalf("Allow() error = %v, want TooManyRequests", err)
+			}
+			if retryAfter := err.(errors.ApiError).RetryAfter; retryAfter != tt.wantLockout {
+				t.Errorf("RetryAfter = %v, want %v", retryAfter, tt.wantLockout)
+			}
+			advance(tt.wantLockout)
+			if err := rl.Allow(ctx, RateLimitAccount, "alice"); err != nil {
+				t.Errorf("Allow() after the lockout error = %v", err)
+			}
+		})
+	}
+}
+
+func TestHandleIdentityRateLimit(t *testing.T) {
+	ctx := context.Background()
+	service := newTestService()
+	rl, _ := newTestRateLimiter(RateLimitPolicy{Capacity: 2, RefillEvery: time.Minute})
+	service.SetRateLimiter(rl)
+
+	for i := 0; i < 2; i++ {
+		if _, err := service.HandleIdentity(ctx, "stub", &UserInfo{ID: "alice", Provider: "stub"}); err != nil {
+			t.Fatalf("login %d: HandleIdentity() error = %v", i+1, err)
+		}
+	}
+	session, err := service.HandleIdentity(ctx, "stub", &UserInfo{ID: "alice", Provider: "stub"})
+	if !errors.IsTooManyRequests(err) || session != nil {
+		t.Fatalf("third login HandleIdentity() = %v, %v, want TooManyRequests", session, err)
+	}
+	if _, err := service.HandleIdentity(ctx, "stub", &UserInfo{ID: "bob", Provider: "stub"}); err != nil {
+		t.Errorf("login of another account HandleIdentity() error = %v", err)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	rl := NewRateLimiter(NewMemoryRateLimitStore(time.Hour), WithRateLimitPolicy(RateLimitIP, RateLimitPolicy{Capacity: 1, RefillEvery: time.Minute}))
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+
+	t.Run("fiber", func(t *testing.T) {
+		app := fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+		app.Get("/login", RateLimitMiddleware(rl), func(c *fiber.Ctx) error {
+			return c.SendStatus(http.StatusOK)
+		})
+		for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+			resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/login", nil), -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != want {
+				t.Errorf("request %d: status = %d, want %d", i+1, resp.StatusCode, want)
+			}
+			if want == http.StatusTooManyRequests && resp.Header.Get(fiber.HeaderRetryAfter) == "" {
+				t.Error("Retry-After header missing")
+			}
+		}
+	})
+
+	t.Run("net/http", func(t *testing.T) {
+		handler := RateLimitHandler(rl)(http.HandlerFunc(ok))
+		for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+			req := httptest.NewRequest(http.MethodGet, "/login", nil)
+			req.RemoteAddr = "203.0.113.7:1234"
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != want {
+				t.Errorf("request %d: status = %d, want %d", i+1, rec.Code, want)
+			}
+		}
+	})
+}
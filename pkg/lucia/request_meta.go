@@ -0,0 +1,50 @@
+package lucia
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// RequestMeta describes the client behind an auth request, it is used for rate limiting and auditing
+type RequestMeta struct {
+	IP        string
+	UserAgent string
+}
+
+type requestMetaContextKey struct{}
+
+// WithRequestMeta returns a copy of ctx carrying the request metadata
+func WithRequestMeta(ctx context.Context, meta RequestMeta) context.Context {
+	return context.WithValue(ctx, requestMetaContextKey{}, meta)
+}
+
+// RequestMetaFromContext retrieves the request metadata from ctx, it is empty if none was set
+func RequestMetaFromContext(ctx context.Context) RequestMeta {
+	meta, _ := ctx.Value(requestMetaContextKey{}).(RequestMeta)
+	return meta
+}
+
+// requestMetaFromHTTP builds the request metadata of a net/http request, proxy headers are not trusted
+func requestMetaFromHTTP(r *http.Request) RequestMeta {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return RequestMeta{IP: ip, UserAgent: r.UserAgent()}
+}
+
+type callbackFormContextKey struct{}
+
+// WithCallbackForm returns a copy of ctx carrying the form of a form_post OAuth callback.
+// Providers that only send some fields in the callback body, such as Apple's first-login name, read them from it.
+func WithCallbackForm(ctx context.Context, form url.Values) context.Context {
+	return context.WithValue(ctx, callbackFormContextKey{}, form)
+}
+
+// callbackFormFromContext retrieves the callback form from ctx, it is nil if none was set
+func callbackFormFromContext(ctx context.Context) url.Values {
+	form, _ := ctx.Value(callbackFormContextKey{}).(url.Values)
+	return form
+}
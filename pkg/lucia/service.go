@@ -4,105 +4,377 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
+	stderrors "errors"
 	"time"
 
 	"github.com/Abraxas-365/toolkit/pkg/errors"
 )
 
 // AuthUser is an interface that any user type must implement
-type AuthUser interface {
-	GetID() string
+type AuthUser[ID UserID] interface {
+	GetID() ID
 }
 
-type AuthService[U AuthUser] struct {
+type AuthService[U AuthUser[ID], ID UserID] struct {
 	providers    map[string]OAuthProvider
-	userStore    AuthUserStore[U]
-	sessionStore SessionStore
+	userStore    AuthUserStore[U, ID]
+	sessionStore SessionStore[ID]
+	hooks        *Hooks[U, ID]
+	rateLimiter  *RateLimiter
+	codec        IDCodec[ID]
+
+	impersonationPolicy func(ctx context.Context, actorID, targetID ID) error
+	signupPolicy        SignupPolicy
+	providerTokens      ProviderTokenConfig
 }
 
-func NewAuthService[U AuthUser](userStore AuthUserStore[U], sessionStore SessionStore) *AuthService[U] {
-	return &AuthService[U]{
+func NewAuthService[U AuthUser[ID], ID UserID](userStore AuthUserStore[U, ID], sessionStore SessionStore[ID]) *AuthService[U, ID] {
+	return &AuthService[U, ID]{
 		providers:    make(map[string]OAuthProvider),
 		userStore:    userStore,
 		sessionStore: sessionStore,
+		hooks:        &Hooks[U, ID]{},
+		codec:        NewIDCodec[ID](),
 	}
 }
 
-func (s *AuthService[U]) RegisterProvider(name string, provider OAuthProvider) {
+// Hooks returns the lifecycle hooks of the service, used to register before and after hooks
+func (s *AuthService[U, ID]) Hooks() *Hooks[U, ID] {
+	return s.hooks
+}
+
+// SetRateLimiter enables brute-force protection on HandleCallback, per client IP (see RequestMeta) and per account
+func (s *AuthService[U, ID]) SetRateLimiter(rateLimiter *RateLimiter) {
+	s.rateLimiter = rateLimiter
+}
+
+// SetIDCodec replaces the codec user IDs are encoded with in tokens, memberships, audit events and the other
+// records kept as strings. The session store must encode them the same way, see PostgresStore.SetIDCodec in
+// luciastore.
+func (s *AuthService[U, ID]) SetIDCodec(codec IDCodec[ID]) {
+	s.codec = codec
+}
+
+// IDCodec returns the codec user IDs are encoded with
+func (s *AuthService[U, ID]) IDCodec() IDCodec[ID] {
+	return s.codec
+}
+
+func (s *AuthService[U, ID]) RegisterProvider(name string, provider OAuthProvider) {
 	s.providers[name] = provider
 }
 
-func (s *AuthService[U]) GetAuthURL(provider string) (string, string, error) {
+// GetAuthURL returns the authorization URL of provider and the state to check on the callback
+func (s *AuthService[U, ID]) GetAuthURL(provider string, opts ...AuthURLOption) (string, string, error) {
 	p, ok := s.providers[provider]
 	if !ok {
 		return "", "", errors.NewLuciaError("UnknownProvider", "Unknown OAuth provider")
 	}
 	state := generateState()
-	url := p.GetAuthURL(state)
+	url := p.GetAuthURL(state, opts...)
 	return url, state, nil
 }
 
-func (s *AuthService[U]) HandleCallback(ctx context.Context, provider, code string) (*Session, error) {
+func (s *AuthService[U, ID]) HandleCallback(ctx context.Context, provider, code string) (*Session[ID], error) {
+	session, userInfo, err := s.handleCallback(ctx, provider, code)
+	s.recordAttempt(ctx, provider, userInfo, err)
+	if err != nil {
+		runAfter(ctx, &s.hooks.mu, &s.hooks.onLoginFailed, LoginFailedEvent{
+			Provider: provider,
+			UserInfo: userInfo,
+			Err:      err,
+		})
+		return nil, err
+	}
+	return session, nil
+}
+
+// allowAttempt checks the rate limiter for the client IP and, once known, the provider account
+func (s *AuthService[U, ID]) allowAttempt(ctx context.Context, provider string, userInfo *UserInfo) error {
+	if s.rateLimiter == nil {
+		return nil
+	}
+	if userInfo != nil {
+		return s.rateLimiter.Allow(ctx, RateLimitAccount, provider+":"+userInfo.ID)
+	}
+	if ip := RequestMetaFromContext(ctx).IP; ip != "" {
+		return s.rateLimiter.Allow(ctx, RateLimitIP, ip)
+	}
+	return nil
+}
+
+// recordAttempt feeds the outcome of a login to the rate limiter, throttled attempts are not counted as failures
+func (s *AuthService[U, ID]) recordAttempt(ctx context.Context, provider string, userInfo *UserInfo, err error) {
+	if s.rateLimiter == nil || errors.IsTooManyRequests(err) {
+		return
+	}
+	record := s.rateLimiter.RecordSuccess
+	if err != nil {
+		record = s.rateLimiter.RecordFailure
+	}
+	if ip := RequestMetaFromContext(ctx).IP; ip != "" {
+		record(ctx, RateLimitIP, ip)
+	}
+	if userInfo != nil {
+		record(ctx, RateLimitAccount, provider+":"+userInfo.ID)
+	}
+}
+
+func (s *AuthService[U, ID]) handleCallback(ctx context.Context, provider, code string) (*Session[ID], *UserInfo, error) {
 	p, ok := s.providers[provider]
 	if !ok {
-		return nil, errors.NewLuciaError("UnknownProvider", "Unknown OAuth provider")
+		return nil, nil, errors.NewLuciaError("UnknownProvider", "Unknown OAuth provider")
+	}
+	if err := s.allowAttempt(ctx, provider, nil); err != nil {
+		return nil, nil, err
 	}
 
 	token, err := p.ExchangeCode(ctx, code)
 	if err != nil {
-		return nil, errors.NewLuciaError("TokenExchangeError", "Failed to exchange code for token")
+		return nil, nil, providerError("TokenExchangeError", "Failed to exchange code for token", provider, "ExchangeCode", err)
 	}
 
 	userInfo, err := p.GetUserInfo(ctx, token)
 	if err != nil {
-		return nil, errors.NewLuciaError("UserInfoError", "Failed to get user info")
+		return nil, nil, providerError("UserInfoError", "Failed to get user info", provider, "GetUserInfo", err)
 	}
 	userInfo.Token = token
+	session, err := s.login(ctx, provider, userInfo)
+	return session, userInfo, err
+}
+
+// HandleIdentity logs in the user of an identity established without an OAuth provider, such as a SAML assertion.
+// The caller must have verified userInfo, it then goes through the same rate limiting, user creation and hooks
+// as HandleCallback.
+func (s *AuthService[U, ID]) HandleIdentity(ctx context.Context, provider string, userInfo *UserInfo) (*Session[ID], error) {
+	err := s.allowAttempt(ctx, provider, nil)
+	var session *Session[ID]
+	if err == nil {
+		session, err = s.login(ctx, provider, userInfo)
+	}
+	s.recordAttempt(ctx, provider, userInfo, err)
+	if err != nil {
+		runAfter(ctx, &s.hooks.mu, &s.hooks.onLoginFailed, LoginFailedEvent{
+			Provider: provider,
+			UserInfo: userInfo,
+			Err:      err,
+		})
+		return nil, err
+	}
+	return session, nil
+}
+
+// login finds or creates the user of a verified identity and creates their session
+func (s *AuthService[U, ID]) login(ctx context.Context, provider string, userInfo *UserInfo) (*Session[ID], error) {
+	if err := s.allowAttempt(ctx, provider, userInfo); err != nil {
+		return nil, err
+	}
+	if err := s.signupPolicy.checkLogin(userInfo); err != nil {
+		return nil, err
+	}
 
+	newUser := false
 	user, err := s.userStore.GetUserByProviderID(ctx, provider, userInfo.ID)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			// If user doesn't exist, create a new one
-			user, err = s.userStore.CreateUser(ctx, userInfo)
+			if err := s.signupPolicy.checkSignup(ctx, provider, userInfo); err != nil {
+				return nil, err
+			}
+			user, err = s.createUser(ctx, provider, userInfo)
 			if err != nil {
-				return nil, errors.NewLuciaError("UserCreationFailed", "Failed to create user")
+				return nil, err
 			}
+			newUser = true
 		} else {
-			return nil, errors.NewLuciaError("DatabaseError", "Failed to fetch user")
+			return nil, errors.NewLuciaError("DatabaseError", "Failed to fetch user").WithCause(err).WithProvider(provider).WithOp("GetUserByProviderID")
 		}
 	}
 
-	session := &Session{
-		ID:        GenerateID(),
-		UserID:    user.GetID(),
-		ExpiresAt: time.Now().Add(24 * time.Hour).Unix(),
+	event := LoginEvent[U, ID]{
+		Provider: provider,
+		UserInfo: userInfo,
+		User:     user,
+		NewUser:  newUser,
 	}
-	if err := s.sessionStore.CreateSession(ctx, session); err != nil {
-		return nil, errors.NewLuciaError("SessionCreationFailed", "Failed to create session")
+	if err := runBefore(ctx, &s.hooks.mu, &s.hooks.beforeLogin, event); err != nil {
+		return nil, err
+	}
+
+	// Without its token the grant could not be revoked later, the login fails instead
+	if err := s.saveProviderToken(ctx, user.GetID(), provider, userInfo.Token); err != nil {
+		return nil, err
+	}
+	session, err := s.createSession(ctx, user.GetID(), provider, userInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	event.Session = session
+	runAfter(ctx, &s.hooks.mu, &s.hooks.onLogin, event)
+
+	return session, nil
+}
+
+func (s *AuthService[U, ID]) createUser(ctx context.Context, provider string, userInfo *UserInfo) (U, error) {
+	var zero U
+	if err := runBefore(ctx, &s.hooks.mu, &s.hooks.beforeUserCreated, userInfo); err != nil {
+		return zero, err
 	}
 
+	user, err := s.userStore.CreateUser(ctx, userInfo)
+	if err != nil {
+		return zero, errors.NewLuciaError("UserCreationFailed", "Failed to create user").WithCause(err).WithProvider(provider).WithOp("CreateUser")
+	}
+
+	runAfter(ctx, &s.hooks.mu, &s.hooks.onUserCreated, UserCreatedEvent[U]{
+		Provider: provider,
+		UserInfo: userInfo,
+		User:     user,
+	})
+	return user, nil
+}
+
+// createSession creates the session of a user who just authenticated with provider, userInfo is the identity
+// the provider returned if any
+func (s *AuthService[U, ID]) createSession(ctx context.Context, userID ID, provider string, userInfo *UserInfo) (*Session[ID], error) {
+	now := time.Now()
+	session := &Session[ID]{
+		ID:           GenerateID(),
+		UserID:       userID,
+		ExpiresAt:    now.Add(24 * time.Hour).Unix(),
+		AuthTime:     now.Unix(),
+		AuthProvider: provider,
+	}
+	if userInfo != nil {
+		// A provider may reuse an earlier authentication of the user, e.g. without prompt=login
+		if userInfo.AuthTime > 0 && userInfo.AuthTime < session.AuthTime {
+			session.AuthTime = userInfo.AuthTime
+		}
+		session.MFA = containsScope(userInfo.AuthMethods, AuthMethodMFA)
+	}
+	return s.storeSession(ctx, session)
+}
+
+// storeSession runs the session creation hooks around storing a prepared session
+func (s *AuthService[U, ID]) storeSession(ctx context.Context, session *Session[ID]) (*Session[ID], error) {
+	if err := runBefore(ctx, &s.hooks.mu, &s.hooks.beforeSessionCreated, SessionEvent[ID]{Session: session}); err != nil {
+		return nil, err
+	}
+	if err := s.sessionStore.CreateSession(ctx, session); err != nil {
+		return nil, errors.NewLuciaError("SessionCreationFailed", "Failed to create session").WithCause(err).WithOp("CreateSession")
+	}
+	runAfter(ctx, &s.hooks.mu, &s.hooks.onSessionCreated, SessionEvent[ID]{Session: session})
 	return session, nil
 }
 
-func (s *AuthService[U]) GetSession(ctx context.Context, sessionID string) (*Session, error) {
+// Session outcomes GetSession reports as the Type of a LuciaError, see SessionErrorType
+const (
+	// SessionNotFound means the session never existed or was deleted
+	SessionNotFound = "UserSessionNotFound"
+	// SessionExpired means the session outlived its expiry
+	SessionExpired = "SessionExpired"
+	// SessionRevoked means the session was ended by another one, e.g. the impersonator logged out
+	SessionRevoked = "SessionRevoked"
+	// SessionStoreUnavailable means the session could not be checked, which must not be mistaken for a logout
+	SessionStoreUnavailable = "SessionStoreUnavailable"
+)
+
+// SessionErrorType returns the session outcome of an error of GetSession, or "" for any other error
+func SessionErrorType(err error) string {
+	var le errors.LuciaError
+	if !stderrors.As(err, &le) {
+		return ""
+	}
+	switch le.Type {
+	case SessionNotFound, SessionExpired, SessionRevoked, SessionStoreUnavailable:
+		return le.Type
+	}
+	return ""
+}
+
+// GetSession returns a valid session, or a LuciaError whose Type is one of the session outcomes
+func (s *AuthService[U, ID]) GetSession(ctx context.Context, sessionID string) (*Session[ID], error) {
 	session, err := s.sessionStore.GetSession(ctx, sessionID)
 	if err != nil {
-		if errors.IsNotFound(err) {
-			return nil, errors.NewLuciaError("UserSessionNotFound", "Session not found")
+		return nil, sessionLookupError(err)
+	}
+	// Stores are not required to filter expired sessions
+	if session.IsExpired() {
+		return nil, errors.NewLuciaError(SessionExpired, "Session expired")
+	}
+
+	// An impersonation ends with the impersonator's own session
+	if session.ParentSessionID != "" {
+		parent, err := s.sessionStore.GetSession(ctx, session.ParentSessionID)
+		if err == nil && parent.IsExpired() {
+			err = errors.ErrNotFound("Session expired")
+		}
+		if err != nil {
+			if err := sessionLookupError(err); SessionErrorType(err) == SessionStoreUnavailable {
+				return nil, err
+			}
+			return nil, errors.NewLuciaError(SessionRevoked, "Impersonator session has ended").WithCause(err)
 		}
-		return nil, errors.NewLuciaError("DatabaseError", "Failed to fetch session")
 	}
 	return session, nil
 }
 
-func (s *AuthService[U]) Logout(ctx context.Context, sessionID string) error {
-	err := s.sessionStore.DeleteSession(ctx, sessionID)
+// sessionLookupError turns an error of SessionStore.GetSession into a session outcome.
+// Stores report missing sessions as NotFound and expired ones as NotFound or Unauthorized (see PostgresStore),
+// anything else is a failure of the store.
+func sessionLookupError(err error) error {
+	if SessionErrorType(err) != "" {
+		return err
+	}
+	switch {
+	case errors.IsNotFound(err):
+		return errors.NewLuciaError(SessionNotFound, "Session not found").WithCause(err)
+	case errors.IsUnauthorized(err):
+		return errors.NewLuciaError(SessionExpired, "Session expired").WithCause(err)
+	}
+	return errors.NewLuciaError(SessionStoreUnavailable, "Session store unavailable").WithCause(err).WithOp("GetSession")
+}
+
+// Logout deletes the session, and revokes the grant of its provider when ProviderTokenConfig.RevokeOnLogout is set
+func (s *AuthService[U, ID]) Logout(ctx context.Context, sessionID string) error {
+	session, err := s.deleteSession(ctx, sessionID, RevokeReasonLogout)
 	if err != nil {
-		return errors.NewLuciaError("SessionDeletionFailed", "Failed to delete session")
+		return err
+	}
+	s.revokeLoggedOutToken(ctx, session)
+	return nil
+}
+
+// RefreshToken refreshes the token in place through the given provider if it is about to expire
+func (s *AuthService[U, ID]) RefreshToken(ctx context.Context, provider string, token *OAuthToken) error {
+	p, ok := s.providers[provider]
+	if !ok {
+		return errors.NewLuciaError("UnknownProvider", "Unknown OAuth provider")
+	}
+	if !token.NeedsRefresh() || token.RefreshToken == "" {
+		return nil
+	}
+	if err := token.RefreshIfNeeded(ctx, p); err != nil {
+		return providerError("TokenExpired", "Failed to refresh token", provider, "RefreshToken", err)
 	}
+	runAfter(ctx, &s.hooks.mu, &s.hooks.onProviderTokenRefreshed, TokenRefreshedEvent{
+		Provider: provider,
+		Token:    token,
+	})
 	return nil
 }
 
+// providerError wraps a provider failure, keeping the upstream status code when the provider reported one
+func providerError(errType, message, provider, op string, err error) errors.LuciaError {
+	luciaErr := errors.NewLuciaError(errType, message).WithCause(err).WithProvider(provider).WithOp(op)
+	var apiErr errors.ApiError
+	if stderrors.As(err, &apiErr) && apiErr.StatusCode != 0 {
+		luciaErr = luciaErr.WithStatusCode(apiErr.StatusCode)
+	}
+	return luciaErr
+}
+
 func generateState() string {
 	b := make([]byte, 16)
 	rand.Read(b)
@@ -115,22 +387,28 @@ func GenerateID() string {
 	return base64.URLEncoding.EncodeToString(b)
 }
 
-func (s *AuthService[U]) CreateSession(ctx context.Context, user U) (*Session, error) {
-	session := &Session{
-		ID:        GenerateID(),
-		UserID:    user.GetID(),
-		ExpiresAt: time.Now().Add(24 * time.Hour).Unix(),
-	}
-	if err := s.sessionStore.CreateSession(ctx, session); err != nil {
-		return nil, errors.NewLuciaError("SessionCreationFailed", "Failed to create session")
-	}
-	return session, nil
+func (s *AuthService[U, ID]) CreateSession(ctx context.Context, user U) (*Session[ID], error) {
+	return s.createSession(ctx, user.GetID(), "", nil)
+}
+
+func (s *AuthService[U, ID]) DeleteSession(ctx context.Context, sessionID string) error {
+	_, err := s.deleteSession(ctx, sessionID, RevokeReasonRevoked)
+	return err
 }
 
-func (s *AuthService[U]) DeleteSession(ctx context.Context, sessionID string) error {
+// deleteSession deletes a session and returns it as it was, nil if it could not be loaded
+func (s *AuthService[U, ID]) deleteSession(ctx context.Context, sessionID, reason string) (*Session[ID], error) {
+	// Load the session first so revocation hooks know whose session it was
+	session, _ := s.sessionStore.GetSession(ctx, sessionID)
+
 	err := s.sessionStore.DeleteSession(ctx, sessionID)
 	if err != nil {
-		return errors.NewLuciaError("SessionDeletionFailed", "Failed to delete session")
+		return nil, errors.NewLuciaError("SessionDeletionFailed", "Failed to delete session").WithCause(err).WithOp("DeleteSession")
 	}
-	return nil
+	runAfter(ctx, &s.hooks.mu, &s.hooks.onSessionRevoked, SessionRevokedEvent[ID]{
+		SessionID: sessionID,
+		Session:   session,
+		Reason:    reason,
+	})
+	return session, nil
 }
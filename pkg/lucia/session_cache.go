@@ -0,0 +1,234 @@
+package lucia
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// InvalidationBus carries session invalidations between the CachedSessionStores of several replicas.
+// Sessions are identified by their AuditSessionID hash, bearer session IDs never go on the bus.
+type InvalidationBus interface {
+	Publish(ctx context.Context, sessionKey string) error
+	// Subscribe registers fn to receive the session keys invalidated by any replica. An empty key asks to flush
+	// the whole cache, e.g. after the bus reconnected and may have missed invalidations.
+	Subscribe(fn func(sessionKey string))
+}
+
+// SessionCacheOptions configures a CachedSessionStore
+type SessionCacheOptions struct {
+	// Size is the maximum number of cached sessions, it defaults to 10000
+	Size int
+	// TTL bounds how long a cached session is trusted, it defaults to 30 seconds. Without a Bus it is how long
+	// a session deleted on another replica stays valid here.
+	TTL time.Duration
+	// LoadTimeout bounds a lookup in the underlying store, it defaults to 5 seconds
+	LoadTimeout time.Duration
+	// Bus propagates invalidations to other replicas, see luciastore.NotifyBus
+	Bus InvalidationBus
+	// OnError receives the errors of publishing invalidations, which do not fail the store operation
+	OnError func(err error)
+}
+
+// CachedSessionStore is a SessionStore caching the sessions of another one in process.
+// Lookups are served from an LRU cache for up to TTL, concurrent lookups of the same session share a single
+// store call, and updates and deletions invalidate the cache of every replica on the Bus.
+// Entries and in-flight lookups are keyed by the AuditSessionID of the session.
+type CachedSessionStore[ID UserID] struct {
+	store SessionStore[ID]
+	opts  SessionCacheOptions
+	now   func() time.Time
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	calls   map[string]*sessionCall[ID]
+}
+
+type sessionCacheEntry[ID UserID] struct {
+	key       string
+	session   *Session[ID]
+	expiresAt time.Time
+}
+
+// sessionCall is an in-flight store lookup shared by concurrent GetSession calls
+type sessionCall[ID UserID] struct {
+	done    chan struct{}
+	session *Session[ID]
+	err     error
+	// stale is set when the session is invalidated during the lookup, its result must not be cached
+	stale bool
+}
+
+func NewCachedSessionStore[ID UserID](store SessionStore[ID], opts SessionCacheOptions) *CachedSessionStore[ID] {
+	if opts.Size <= 0 {
+		opts.Size = 10000
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = 30 * time.Second
+	}
+	if opts.LoadTimeout <= 0 {
+		opts.LoadTimeout = 5 * time.Second
+	}
+	c := &CachedSessionStore[ID]{
+		store:   store,
+		opts:    opts,
+		now:     time.Now,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		calls:   make(map[string]*sessionCall[ID]),
+	}
+	if opts.Bus != nil {
+		opts.Bus.Subscribe(func(sessionKey string) {
+			if sessionKey == "" {
+				c.Flush()
+				return
+			}
+			c.invalidate(sessionKey)
+		})
+	}
+	return c
+}
+
+func (c *CachedSessionStore[ID]) CreateSession(ctx context.Context, session *Session[ID]) error {
+	if err := c.store.CreateSession(ctx, session); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.put(AuditSessionID(session.ID), session)
+	return nil
+}
+
+func (c *CachedSessionStore[ID]) GetSession(ctx context.Context, sessionID string) (*Session[ID], error) {
+	key := AuditSessionID(sessionID)
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*sessionCacheEntry[ID])
+		if c.now().Before(entry.expiresAt) {
+			c.lru.MoveToFront(elem)
+			c.mu.Unlock()
+			return cloneSession(entry.session), nil
+		}
+		c.remove(key)
+	}
+
+	call, ok := c.calls[key]
+	if !ok {
+		call = &sessionCall[ID]{done: make(chan struct{})}
+		c.calls[key] = call
+		go c.load(key, sessionID, call)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if call.err != nil {
+		return nil, call.err
+	}
+	return cloneSession(call.session), nil
+}
+
+// load runs the shared lookup detached from the context of the first caller, so that caller going away
+// does not fail the others waiting on it. LoadTimeout bounds it instead, a hung store must not keep the
+// lookup registered and block the session forever.
+func (c *CachedSessionStore[ID]) load(key, sessionID string, call *sessionCall[ID]) {
+	defer func() {
+		c.mu.Lock()
+		delete(c.calls, key)
+		if call.err == nil && call.session != nil && !call.stale {
+			c.put(key, call.session)
+		}
+		c.mu.Unlock()
+		close(call.done)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.opts.LoadTimeout)
+	defer cancel()
+	call.session, call.err = c.store.GetSession(ctx, sessionID)
+}
+
+func (c *CachedSessionStore[ID]) UpdateSession(ctx context.Context, session *Session[ID]) error {
+	err := c.store.UpdateSession(ctx, session)
+	c.Invalidate(ctx, session.ID)
+	return err
+}
+
+func (c *CachedSessionStore[ID]) DeleteSession(ctx context.Context, sessionID string) error {
+	err := c.store.DeleteSession(ctx, sessionID)
+	c.Invalidate(ctx, sessionID)
+	return err
+}
+
+// Invalidate drops a session from the cache of every replica, for sessions changed behind the store's back
+func (c *CachedSessionStore[ID]) Invalidate(ctx context.Context, sessionID string) {
+	key := AuditSessionID(sessionID)
+	c.invalidate(key)
+	if c.opts.Bus != nil {
+		if err := c.opts.Bus.Publish(ctx, key); err != nil && c.opts.OnError != nil {
+			c.opts.OnError(err)
+		}
+	}
+}
+
+// Flush empties the local cache
+func (c *CachedSessionStore[ID]) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+	for _, call := range c.calls {
+		call.stale = true
+	}
+}
+
+func (c *CachedSessionStore[ID]) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(key)
+	if call, ok := c.calls[key]; ok {
+		call.stale = true
+	}
+}
+
+// put caches a copy of session under key until the TTL or its expiry, whichever comes first; c.mu must be held
+func (c *CachedSessionStore[ID]) put(key string, session *Session[ID]) {
+	expiresAt := c.now().Add(c.opts.TTL)
+	if sessionExpiry := time.Unix(session.ExpiresAt, 0); sessionExpiry.Before(expiresAt) {
+		expiresAt = sessionExpiry
+	}
+	entry := &sessionCacheEntry[ID]{key: key, session: cloneSession(session), expiresAt: expiresAt}
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.opts.Size {
+		c.remove(c.lru.Back().Value.(*sessionCacheEntry[ID]).key)
+	}
+}
+
+// remove drops a cached session; c.mu must be held
+func (c *CachedSessionStore[ID]) remove(key string) {
+	if elem, ok := c.entries[key]; ok {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+	}
+}
+
+// cloneSession copies a session so callers never mutate a cached one
+func cloneSession[ID UserID](session *Session[ID]) *Session[ID] {
+	clone := *session
+	if session.ImpersonatorID != nil {
+		impersonatorID := *session.ImpersonatorID
+		clone.ImpersonatorID = &impersonatorID
+	}
+	clone.Scopes = append([]string(nil), session.Scopes...)
+	return &clone
+}
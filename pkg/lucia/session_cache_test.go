@@ -0,0 +1,261 @@
+package lucia
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+)
+
+// countingSessionStore counts the lookups reaching the store, and holds them while gate is set
+type countingSessionStore struct {
+	*testSessionStore
+	gets atomic.Int32
+	gate chan struct{}
+}
+
+func (s *countingSessionStore) GetSession(ctx context.Context, sessionID string) (*Session[string], error) {
+	s.gets.Add(1)
+	if s.gate != nil {
+		select {
+		case <-s.gate:
+		case <-ctx.Done():
+			return nil, errors.ErrServiceUnavailable("Session store timed out").WithCause(ctx.Err())
+		}
+	}
+	return s.testSessionStore.GetSession(ctx, sessionID)
+}
+
+// memoryBus is an in-process InvalidationBus delivering to every subscriber, the publisher included
+type memoryBus struct {
+	mu          sync.Mutex
+	subscribers []func(sessionKey string)
+	published   []string
+}
+
+func (b *memoryBus) Publish(ctx context.Context, sessionKey string) error {
+	b.mu.Lock()
+	b.published = append(b.published, sessionKey)
+	subscribers := append([]func(string){}, b.subscribers...)
+	b.mu.Unlock()
+	for _, fn := range subscribers {
+		fn(sessionKey)
+	}
+	return nil
+}
+
+func (b *memoryBus) Subscribe(fn func(sessionKey string)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers = append(b.subscribers, fn)
+}
+
+// newTestSessionCache returns a cache over a counting store, with a clock only moving through advance
+func newTestSessionCache(opts SessionCacheOptions) (*CachedSessionStore[string], *countingSessionStore, func(time.Duration)) {
+	store := &countingSessionStore{testSessionStore: newTestSessionStore()}
+	cache := NewCachedSessionStore[string](store, opts)
+	now := time.Now()
+	var mu sync.Mutex
+	cache.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	return cache, store, func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(d)
+	}
+}
+
+func testSession(id string) *Session[string] {
+	return &Session[string]{ID: id, UserID: "user-" + id, ExpiresAt: time.Now().Add(time.Hour).Unix()}
+}
+
+func TestCachedSessionStoreLRU(t *testing.T) {
+	ctx := context.Background()
+	cache, store, _ := newTestSessionCache(SessionCacheOptions{Size: 2})
+	for _, id := range []string{"a", "b"} {
+		if err := cache.CreateSession(ctx, testSession(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Reading a makes b the least recently used session, creating c evicts it
+	if _, err := cache.GetSession(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.CreateSession(ctx, testSession("c")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"a", "c"} {
+		if _, err := cache.GetSession(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if gets := store.gets.Load(); gets != 0 {
+		t.Errorf("%d store lookups for cached sessions, want 0", gets)
+	}
+	if session, err := cache.GetSession(ctx, "b"); err != nil || session.UserID != "user-b" {
+		t.Fatalf("GetSession(b) = %v, %v", session, err)
+	}
+	if gets := store.gets.Load(); gets != 1 {
+		t.Errorf("%d store lookups, want 1 for the evicted session", gets)
+	}
+	if len(cache.entries) != 2 || cache.lru.Len() != 2 {
+		t.Errorf("%d entries and %d LRU elements, want the size of 2", len(cache.entries), cache.lru.Len())
+	}
+}
+
+func TestCachedSessionStoreTTL(t *testing.T) {
+	ctx := context.Background()
+	cache, store, advance := newTestSessionCache(SessionCacheOptions{TTL: 30 * time.Second})
+	session := testSession("a")
+	if err := cache.CreateSession(ctx, session); err != nil {
+		t.Fatal(err)
+	}
+
+	// A change behind the cache's back is served stale for at most the TTL
+	changed := *session
+	changed.UserID = "changed"
+	store.testSessionStore.UpdateSession(ctx, &changed)
+	advance(29 * time.Second)
+	if got, _ := cache.GetSession(ctx, "a"); got.UserID != "user-a" {
+		t.Errorf("GetSession() within the TTL = %q, want the cached session", got.UserID)
+	}
+	advance(time.Second)
+	if got, _ := cache.GetSession(ctx, "a"); got.UserID != "changed" {
+		t.Errorf("GetSession() after the TTL = %q, want the stored session", got.UserID)
+	}
+
+	// A session expiring before the TTL is only cached until it expires
+	short := testSession("b")
+	short.ExpiresAt = cache.now().Add(10 * time.Second).Unix()
+	cache.CreateSession(ctx, short)
+	cache.mu.Lock()
+	expiresAt := cache.entries[AuditSessionID("b")].Value.(*sessionCacheEntry[string]).expiresAt
+	cache.mu.Unlock()
+	if !expiresAt.Equal(time.Unix(short.ExpiresAt, 0)) {
+		t.Errorf("cached until %v, want the session expiry %v", expiresAt, time.Unix(short.ExpiresAt, 0))
+	}
+}
+
+func TestCachedSessionStoreSingleflight(t *testing.T) {
+	ctx := context.Background()
+	cache, store, _ := newTestSessionCache(SessionCacheOptions{})
+	store.testSessionStore.CreateSession(ctx, testSession("a"))
+	store.gate = make(chan struct{})
+
+	var started, done sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		started.Add(1)
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			started.Done()
+			if session, err := cache.GetSession(ctx, "a"); err != nil || session.ID != "a" {
+				t.Errorf("GetSession() = %v, %v", session, err)
+			}
+		}()
+	}
+	started.Wait()
+	time.Sleep(20 * time.Millisecond)
+	close(store.gate)
+	done.Wait()
+
+	if gets := store.gets.Load(); gets != 1 {
+		t.Errorf("%d store lookups, want concurrent lookups collapsed into 1", gets)
+	}
+}
+
+func TestCachedSessionStoreLoadTimeout(t *testing.T) {
+	ctx := context.Background()
+	cache, store, _ := newTestSessionCache(SessionCacheOptions{LoadTimeout: 20 * time.Millisecond})
+	store.testSessionStore.CreateSession(ctx, testSession("a"))
+	store.gate = make(chan struct{})
+
+	if _, err := cache.GetSession(ctx, "a"); err == nil {
+		t.Fatal("GetSession() on a hung store succeeded")
+	}
+	cache.mu.Lock()
+	calls := len(cache.calls)
+	cache.mu.Unlock()
+	if calls != 0 {
+		t.Fatalf("%d lookups still registered after the timeout, want 0", calls)
+	}
+
+	close(store.gate)
+	if session, err := cache.GetSession(ctx, "a"); err != nil || session.ID != "a" {
+		t.Errorf("GetSession() once the store answers = %v, %v", session, err)
+	}
+}
+
+func TestCachedSessionStoreInvalidation(t *testing.T) {
+	ctx := context.Background()
+	bus := &memoryBus{}
+	store := newTestSessionStore()
+	first := NewCachedSessionStore[string](store, SessionCacheOptions{Bus: bus})
+	second := NewCachedSessionStore[string](store, SessionCacheOptions{Bus: bus})
+
+	session := testSession("secret-session-id")
+	if err := first.CreateSession(ctx, session); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.GetSession(ctx, session.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	updated := *session
+	updated.Scopes = []string{"admin"}
+	if err := first.UpdateSession(ctx, &updated); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := second.GetSession(ctx, session.ID); err != nil || len(got.Scopes) != 1 {
+		t.Errorf("GetSession() on the other replica after an update = %v, %v, want the update", got, err)
+	}
+
+	if err := first.DeleteSession(ctx, session.ID); err != nil {
+		t.Fatal(err)
+	}
+	for name, cache := range map[string]*CachedSessionStore[string]{"first": first, "second": second} {
+		if _, err := cache.GetSession(ctx, session.ID); !errors.IsNotFound(err) {
+			t.Errorf("GetSession() on the %s replica after a delete error = %v, want NotFound", name, err)
+		}
+	}
+
+	// The bus only carries hashes, anyone reading it must not learn a bearer session ID
+	for _, key := range bus.published {
+		if key != AuditSessionID(session.ID) || strings.Contains(key, session.ID) {
+			t.Errorf("published %q, want the hashed session ID", key)
+		}
+	}
+}
+
+func TestCachedSessionStoreInvalidationDuringLoad(t *testing.T) {
+	ctx := context.Background()
+	cache, store, _ := newTestSessionCache(SessionCacheOptions{})
+	store.testSessionStore.CreateSession(ctx, testSession("a"))
+	store.gate = make(chan struct{})
+
+	loaded := make(chan struct{})
+	go func() {
+		defer close(loaded)
+		cache.GetSession(ctx, "a")
+	}()
+	for store.gets.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cache.Invalidate(ctx, "a")
+	close(store.gate)
+	<-loaded
+
+	// The lookup started before the invalidation, its result must not be cached
+	cache.GetSession(ctx, "a")
+	if gets := store.gets.Load(); gets != 2 {
+		t.Errorf("%d store lookups, want 2", gets)
+	}
+}
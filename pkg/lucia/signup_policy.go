@@ -0,0 +1,90 @@
+package lucia
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+)
+
+// SignupPolicy restricts who may log in and who may sign up. The email rules apply to every login, so that
+// tightening them also locks out existing users, the signup rules only to identities without a user yet.
+type SignupPolicy struct {
+	// AllowedDomains restricts logins to verified emails of these domains, e.g. the corporate one.
+	// Subdomains must be listed on their own. An unverified email is rejected, it could claim any domain.
+	AllowedDomains []string
+	// BlockedDomains rejects emails of these domains whether verified or not, e.g. disposable email services
+	BlockedDomains []string
+	// RequireVerifiedEmail rejects identities whose provider does not vouch for their email, addresses
+	// verified later through EmailVerifier do not count
+	RequireVerifiedEmail bool
+
+	// InviteOnly refuses new users unless IsInvited accepts them, existing users still log in
+	InviteOnly bool
+	// IsInvited decides whether a new user may sign up in InviteOnly mode, e.g. by looking up a pending
+	// invitation for their verified email. Without it nobody can sign up.
+	IsInvited func(ctx context.Context, userInfo *UserInfo) (bool, error)
+	// DisabledProviders still log their existing users in but cannot create new ones
+	DisabledProviders []string
+}
+
+// SetSignupPolicy restricts the logins and signups of HandleCallback and HandleIdentity
+func (s *AuthService[U, ID]) SetSignupPolicy(policy SignupPolicy) {
+	s.signupPolicy = policy
+}
+
+// checkLogin applies the email rules to the identity of any login
+func (p SignupPolicy) checkLogin(userInfo *UserInfo) error {
+	domain := emailDomain(userInfo.Email)
+	if domain != "" && containsDomain(p.BlockedDomains, domain) {
+		return errors.ErrForbidden("Email domain is not allowed")
+	}
+	if len(p.AllowedDomains) > 0 && (!userInfo.EmailVerified || !containsDomain(p.AllowedDomains, domain)) {
+		return errors.ErrForbidden("Email domain is not allowed")
+	}
+	if p.RequireVerifiedEmail && !userInfo.EmailVerified {
+		return errors.ErrForbidden("Email address is not verified")
+	}
+	return nil
+}
+
+// checkSignup applies the signup rules to an identity about to become a new user
+func (p SignupPolicy) checkSignup(ctx context.Context, provider string, userInfo *UserInfo) error {
+	for _, disabled := range p.DisabledProviders {
+		if disabled == provider {
+			return errors.ErrForbidden("Signup is disabled for this provider")
+		}
+	}
+	if !p.InviteOnly {
+		return nil
+	}
+	if p.IsInvited == nil {
+		return errors.ErrForbidden("Signup is by invitation only")
+	}
+	invited, err := p.IsInvited(ctx, userInfo)
+	if err != nil {
+		return err
+	}
+	if !invited {
+		return errors.ErrForbidden("Signup is by invitation only")
+	}
+	return nil
+}
+
+// emailDomain returns the lower-cased domain of email, or "" if it has none
+func emailDomain(email string) string {
+	at := strings.LastIndexByte(email, '@')
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(email[at+1:])
+}
+
+func containsDomain(domains []string, domain string) bool {
+	for _, d := range domains {
+		if strings.EqualFold(strings.TrimPrefix(d, "@"), domain) {
+			return true
+		}
+	}
+	return false
+}
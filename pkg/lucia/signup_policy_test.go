@@ -0,0 +1,121 @@
+package lucia
+
+import (
+	"context"
+	stderrors "errors"
+	"testing"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+)
+
+func TestSignupPolicyCheckLogin(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   SignupPolicy
+		email    string
+		verified bool
+		wantErr  bool
+	}{
+		{name: "no rules", email: "jane@gmail.com"},
+		{name: "blocked domain", policy: SignupPolicy{BlockedDomains: []string{"mailinator.com"}}, email: "jane@Mailinator.com", verified: true, wantErr: true},
+		{name: "blocked domain with @", policy: SignupPolicy{BlockedDomains: []string{"@mailinator.com"}}, email: "jane@mailinator.com", wantErr: true},
+		{name: "other domain than blocked", policy: SignupPolicy{BlockedDomains: []string{"mailinator.com"}}, email: "jane@example.com"},
+		{name: "allowed domain, verified", policy: SignupPolicy{AllowedDomains: []string{"example.com"}}, email: "jane@EXAMPLE.com", verified: true},
+		{name: "allowed domain, unverified", policy: SignupPolicy{AllowedDomains: []string{"example.com"}}, email: "jane@example.com", wantErr: true},
+		{name: "subdomain of an allowed domain", policy: SignupPolicy{AllowedDomains: []string{"example.com"}}, email: "jane@eu.example.com", verified: true, wantErr: true},
+		{name: "other domain than allowed", policy: SignupPolicy{AllowedDomains: []string{"example.com"}}, email: "jane@gmail.com", verified: true, wantErr: true},
+		{name: "no email, allowed domains", policy: SignupPolicy{AllowedDomains: []string{"example.com"}}, verified: true, wantErr: true},
+		{name: "verified email required, verified", policy: SignupPolicy{RequireVerifiedEmail: true}, email: "jane@gmail.com", verified: true},
+		{name: "verified email required, unverified", policy: SignupPolicy{RequireVerifiedEmail: true}, email: "jane@gmail.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.checkLogin(&UserInfo{ID: "jane", Email: tt.email, EmailVerified: tt.verified})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkLogin() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.IsForbidden(err) {
+				t.Errorf("checkLogin() error = %v, want Forbidden", err)
+			}
+		})
+	}
+}
+
+func TestSignupPolicyCheckSignup(t *testing.T) {
+	lookupFailed := errors.ErrServiceUnavailable("invitations unavailable")
+	invited := func(ctx context.Context, userInfo *UserInfo) (bool, error) {
+		return userInfo.Email == "invited@example.com", nil
+	}
+	tests := []struct {
+		name     string
+		policy   SignupPolicy
+		provider string
+		email    string
+		// wantErr is the error expected as is, wantForbidden any Forbidden error
+		wantErr       error
+		wantForbidden bool
+	}{
+		{name: "open signup", provider: "google", email: "jane@example.com"},
+		{name: "disabled provider", policy: SignupPolicy{DisabledProviders: []string{"github"}}, provider: "github", email: "jane@example.com", wantForbidden: true},
+		{name: "other provider than disabled", policy: SignupPolicy{DisabledProviders: []string{"github"}}, provider: "google", email: "jane@example.com"},
+		{name: "invite only without IsInvited", policy: SignupPolicy{InviteOnly: true}, provider: "google", email: "invited@example.com", wantForbidden: true},
+		{name: "invite only, invited", policy: SignupPolicy{InviteOnly: true, IsInvited: invited}, provider: "google", email: "invited@example.com"},
+		{name: "invite only, not invited", policy: SignupPolicy{InviteOnly: true, IsInvited: invited}, provider: "google", email: "jane@example.com", wantForbidden: true},
+		{
+			name: "invite lookup fails",
+			policy: SignupPolicy{InviteOnly: true, IsInvited: func(context.Context, *UserInfo) (bool, error) {
+				return false, lookupFailed
+			}},
+			provider: "google",
+			email:    "invited@example.com",
+			wantErr:  lookupFailed,
+		},
+		{
+			name:          "disabled provider before the invitation",
+			policy:        SignupPolicy{InviteOnly: true, IsInvited: invited, DisabledProviders: []string{"github"}},
+			provider:      "github",
+			email:         "invited@example.com",
+			wantForbidden: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.checkSignup(context.Background(), tt.provider, &UserInfo{ID: "jane", Email: tt.email, EmailVerified: true})
+			switch {
+			case tt.wantErr != nil:
+				if !stderrors.Is(err, tt.wantErr) {
+					t.Errorf("checkSignup() error = %v, want %v", err, tt.wantErr)
+				}
+			case tt.wantForbidden:
+				if !errors.IsForbidden(err) {
+					t.Errorf("checkSignup() error = %v, want Forbidden", err)
+				}
+			case err != nil:
+				t.Errorf("checkSignup() error = %v, want none", err)
+			}
+		})
+	}
+}
+
+func TestSignupPolicyExistingUsers(t *testing.T) {
+	ctx := context.Background()
+	service := newTestService()
+	existing := &UserInfo{ID: "jane", Provider: "github", Email: "jane@example.com", EmailVerified: true}
+	if _, err := service.HandleIdentity(ctx, "github", existing); err != nil {
+		t.Fatal(err)
+	}
+
+	// Signup rules leave existing users alone, email rules apply to every login
+	service.SetSignupPolicy(SignupPolicy{InviteOnly: true, DisabledProviders: []string{"github"}})
+	if _, err := service.HandleIdentity(ctx, "github", existing); err != nil {
+		t.Errorf("login of an existing user error = %v, want none", err)
+	}
+	if _, err := service.HandleIdentity(ctx, "github", &UserInfo{ID: "john", Provider: "github", Email: "john@example.com", EmailVerified: true}); !errors.IsForbidden(err) {
+		t.Errorf("signup through a disabled provider error = %v, want Forbidden", err)
+	}
+
+	service.SetSignupPolicy(SignupPolicy{BlockedDomains: []string{"example.com"}})
+	if _, err := service.HandleIdentity(ctx, "github", existing); !errors.IsForbidden(err) {
+		t.Errorf("login of an existing user of a blocked domain error = %v, want Forbidden", err)
+	}
+}
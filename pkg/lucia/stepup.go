@@ -0,0 +1,119 @@
+package lucia
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequireRecentAuth is a middleware that ensures the user authenticated less than maxAge ago, for sensitive
+// routes such as billing or API key creation. Browser navigations are sent to the login URL with prompt=login
+// and max_age, see AuthHandlers, other requests get a 401 with an RFC 9470 step-up challenge.
+// Providers that cannot force a new authentication, such as GitHub, satisfy it with any login round trip.
+func (am *AuthMiddleware[U, ID]) RequireRecentAuth(maxAge time.Duration) fiber.Handler {
+	return am.requireStepUp(maxAge, false)
+}
+
+// RequireMFA is a middleware that ensures the user authenticated with a second factor, as reported by the
+// provider in the amr claim. The provider decides whether to ask for one, e.g. through conditional access.
+// Sessions of providers that never report it (see MFAReporter), such as Google and GitHub, get a 403.
+func (am *AuthMiddleware[U, ID]) RequireMFA() fiber.Handler {
+	return am.requireStepUp(0, true)
+}
+
+func (am *AuthMiddleware[U, ID]) requireStepUp(maxAge time.Duration, mfa bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		session := GetSession[ID](c)
+		if session == nil {
+			return am.RequireAuth()(c)
+		}
+		if mfa && am.mfaUnavailable(session) {
+			c.Set(fiber.HeaderWWWAuthenticate, am.challenge("insufficient_user_authentication", mfaUnavailableReason))
+			return errors.ErrForbidden(mfaUnavailableReason)
+		}
+		params, reason := stepUp(session, maxAge, mfa)
+		if params == nil {
+			return c.Next()
+		}
+		if am.opts.loginURL != "" && isBrowserRequest(c.Get(fiber.HeaderAccept), c.Get(fiber.HeaderXRequestedWith)) {
+			return c.Redirect(loginRedirect(am.opts.loginURL, c.OriginalURL(), params))
+		}
+		c.Set(fiber.HeaderWWWAuthenticate, am.stepUpChallenge(reason, maxAge))
+		return errors.ErrUnauthorized(reason)
+	}
+}
+
+// RequireRecentAuthHandler is the net/http counterpart of RequireRecentAuth
+func (am *AuthMiddleware[U, ID]) RequireRecentAuthHandler(maxAge time.Duration) func(http.Handler) http.Handler {
+	return am.requireStepUpHandler(maxAge, false)
+}
+
+// RequireMFAHandler is the net/http counterpart of RequireMFA
+func (am *AuthMiddleware[U, ID]) RequireMFAHandler(next http.Handler) http.Handler {
+	return am.requireStepUpHandler(0, true)(next)
+}
+
+func (am *AuthMiddleware[U, ID]) requireStepUpHandler(maxAge time.Duration, mfa bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		stepUpHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			session := SessionFromContext[ID](r.Context())
+			if mfa && am.mfaUnavailable(session) {
+				w.Header().Set("WWW-Authenticate", am.challenge("insufficient_user_authentication", mfaUnavailableReason))
+				errors.HTTPErrorHandler(w, r, errors.ErrForbidden(mfaUnavailableReason))
+				return
+			}
+			params, reason := stepUp(session, maxAge, mfa)
+			if params == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if am.opts.loginURL != "" && isBrowserRequest(r.Header.Get("Accept"), r.Header.Get("X-Requested-With")) {
+				http.Redirect(w, r, loginRedirect(am.opts.loginURL, r.URL.RequestURI(), params), http.StatusFound)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", am.stepUpChallenge(reason, maxAge))
+			errors.HTTPErrorHandler(w, r, errors.ErrUnauthorized(reason))
+		})
+		return am.RequireAuthHandler(stepUpHandler)
+	}
+}
+
+const mfaUnavailableReason = "Multi-factor authentication cannot be verified for this provider"
+
+// mfaUnavailable reports whether session lacks MFA and its provider cannot report it, so that sending the user
+// to log in again would only loop
+func (am *AuthMiddleware[U, ID]) mfaUnavailable(session *Session[ID]) bool {
+	if session.MFA {
+		return false
+	}
+	reporter, ok := am.service.providers[session.AuthProvider].(MFAReporter)
+	return !ok || !reporter.ReportsMFA()
+}
+
+// stepUp checks the authentication of session, it returns nil if it is recent enough and carried a second
+// factor when mfa is set, or else the login parameters asking for a new authentication and the reason
+func stepUp[ID UserID](session *Session[ID], maxAge time.Duration, mfa bool) (url.Values, string) {
+	if mfa && !session.MFA {
+		return url.Values{"prompt": {PromptLogin}}, "Multi-factor authentication required"
+	}
+	if maxAge > 0 && !session.AuthenticatedWithin(maxAge) {
+		return url.Values{
+			"prompt":  {PromptLogin},
+			"max_age": {strconv.FormatInt(int64(maxAge/time.Second), 10)},
+		}, "Recent authentication required"
+	}
+	return nil, ""
+}
+
+// stepUpChallenge builds the RFC 9470 challenge asking the client to authenticate the user again
+func (am *AuthMiddleware[U, ID]) stepUpChallenge(reason string, maxAge time.Duration) string {
+	var extra []string
+	if maxAge > 0 {
+		extra = append(extra, `max_age="`+strconv.FormatInt(int64(maxAge/time.Second), 10)+`"`)
+	}
+	return am.challenge("insufficient_user_authentication", reason, extra...)
+}
@@ -0,0 +1,182 @@
+package lucia
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Abraxas-365/toolkit/pkg/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// mfaProvider is a stubProvider that reports the amr claim
+type mfaProvider struct {
+	stubProvider
+}
+
+func (mfaProvider) ReportsMFA() bool {
+	return true
+}
+
+func TestRequireMFA(t *testing.T) {
+	ctx := context.Background()
+	service := newTestService()
+	service.RegisterProvider("stub", stubProvider{})
+	service.RegisterProvider("entra", mfaProvider{})
+	am := NewAuthMiddleware(service, WithLoginURL("/login/entra"))
+
+	login := func(provider string, methods ...string) *Session[string] {
+		session, err := service.HandleIdentity(ctx, provider, &UserInfo{ID: "user", Provider: provider, AuthMethods: methods})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return session
+	}
+
+	tests := []struct {
+		name       string
+		session    *Session[string]
+		browser    bool
+		wantStatus int
+		wantError  string
+	}{
+		{name: "mfa reported", session: login("entra", "pwd", AuthMethodMFA), wantStatus: http.StatusOK},
+		{name: "no mfa, browser", session: login("entra", "pwd"), browser: true, wantStatus: http.StatusFound},
+		{name: "no mfa, api", session: login("entra", "pwd"), wantStatus: http.StatusUnauthorized, wantError: "insufficient_user_authentication"},
+		{name: "provider without amr, browser", session: login("stub"), browser: true, wantStatus: http.StatusForbidden, wantError: "insufficient_user_authentication"},
+		{name: "provider without amr, api", session: login("stub"), wantStatus: http.StatusForbidden, wantError: "insufficient_user_authentication"},
+		{name: "provider without amr, claimed mfa", session: login("stub", AuthMethodMFA), wantStatus: http.StatusOK},
+		{name: "unregistered provider", session: login("saml"), wantStatus: http.StatusForbidden, wantError: "insufficient_user_authentication"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+			app.Get("/billing", withSession(tt.session, nil), am.RequireMFA(), func(c *fiber.Ctx) error {
+				return c.SendStatus(http.StatusOK)
+			})
+			req := httptest.NewRequest(http.MethodGet, "/billing", nil)
+			if tt.browser {
+				req.Header.Set(fiber.HeaderAccept, "text/html")
+			}
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if challenge := resp.Header.Get(fiber.HeaderWWWAuthenticate); !strings.Contains(challenge, tt.wantError) {
+				t.Errorf("WWW-Authenticate = %q, want error %q", challenge, tt.wantError)
+			}
+		})
+	}
+
+	t.Run("net/http", func(t *testing.T) {
+		handler := am.RequireMFAHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/billing", nil)
+		req.Header.Set(fiber.HeaderAccept, "text/html")
+		req = req.WithContext(ContextWithSession(req.Context(), login("stub")))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusForbidden)
+		}
+	})
+}
+
+func TestRequireRecentAuth(t *testing.T) {
+	ctx := context.Background()
+	service := newTestService()
+	service.RegisterProvider("entra", mfaProvider{})
+	login := func(authTime time.Time) *Session[string] {
+		session, err := service.HandleIdentity(ctx, "entra", &UserInfo{ID: "user", Provider: "entra", AuthTime: authTime.Unix()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return session
+	}
+	fresh := login(time.Now().Add(-time.Minute))
+	stale := login(time.Now().Add(-time.Hour))
+	unknown := &Session[string]{ID: "unknown", UserID: "user", AuthProvider: "entra", ExpiresAt: time.Now().Add(time.Hour).Unix()}
+
+	type recentAuthCase struct {
+		name       string
+		session    *Session[string]
+		loginURL   string
+		browser    bool
+		wantStatus int
+		// wantLogin is the query of the login redirect
+		wantLogin url.Values
+	}
+	tests := []recentAuthCase{
+		{name: "recent", session: fresh, loginURL: "/login/entra", browser: true, wantStatus: http.StatusOK},
+		{
+			name: "stale, browser", session: stale, loginURL: "/login/entra", browser: true, wantStatus: http.StatusFound,
+			wantLogin: url.Values{"prompt": {PromptLogin}, "max_age": {"600"}, "return_to": {"/billing?tab=1"}},
+		},
+		{name: "stale, api", session: stale, loginURL: "/login/entra", wantStatus: http.StatusUnauthorized},
+		{name: "stale, browser without a login URL", session: stale, browser: true, wantStatus: http.StatusUnauthorized},
+		{name: "unknown auth time, api", session: unknown, loginURL: "/login/entra", wantStatus: http.StatusUnauthorized},
+	}
+	check := func(t *testing.T, tt recentAuthCase, status int, header http.Header) {
+		t.Helper()
+		if status != tt.wantStatus {
+			t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+		}
+		switch status {
+		case http.StatusFound:
+			location, err := url.Parse(header.Get("Location"))
+			if err != nil || location.Path != "/login/entra" || !reflect.DeepEqual(location.Query(), tt.wantLogin) {
+				t.Errorf("Location = %q, want the login URL with %v", header.Get("Location"), tt.wantLogin)
+			}
+		case http.StatusUnauthorized:
+			challenge := header.Get("WWW-Authenticate")
+			if !strings.Contains(challenge, `error="insufficient_user_authentication"`) || !strings.Contains(challenge, `max_age="600"`) {
+				t.Errorf("WWW-Authenticate = %q, want a step-up challenge with max_age 600", challenge)
+			}
+		}
+	}
+	request := func(browser bool) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/billing?tab=1", nil)
+		if browser {
+			req.Header.Set(fiber.HeaderAccept, "text/html")
+		}
+		return req
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts []MiddlewareOption
+			if tt.loginURL != "" {
+				opts = append(opts, WithLoginURL(tt.loginURL))
+			}
+			am := NewAuthMiddleware(service, opts...)
+
+			app := fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+			app.Get("/billing", withSession(tt.session, nil), am.RequireRecentAuth(10*time.Minute), func(c *fiber.Ctx) error {
+				return c.SendStatus(http.StatusOK)
+			})
+			resp, err := app.Test(request(tt.browser), -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			check(t, tt, resp.StatusCode, resp.Header)
+
+			handler := am.RequireRecentAuthHandler(10 * time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			req := request(tt.browser)
+			req = req.WithContext(ContextWithSession(req.Context(), tt.session))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			check(t, tt, rec.Code, rec.Header())
+		})
+	}
+}